@@ -2,11 +2,10 @@ package bilibili
 
 import (
 	"UniBarrage/bilibili/gifts"
-	"UniBarrage/services/proxy"
-	ws "UniBarrage/services/websockets"
 	uni "UniBarrage/universal"
 	log "UniBarrage/utils/trace"
 	"context"
+	"log/slog"
 	"strconv"
 
 	"github.com/xifan2333/blivedm-go/client"
@@ -16,20 +15,20 @@ import (
 )
 
 // StartListen 启动哔哩哔哩直播监听
-func StartListen(room int, cookie string, stopChan chan struct{}) {
+func StartListen(room int, cookie string, stopChan chan struct{}, pub uni.Sink) {
 	id := strconv.Itoa(room)
 
 	// 先验证房间是否存在和获取房间信息
 	roomInfo, err := FetchRoomInfo(room)
 	if err != nil {
-		log.Printf("ERROR", "获取 B 站房间信息失败: %v", err)
+		log.For(pub).Log("ERROR", "获取 B 站房间信息失败", attrs(id, "room_info", err)...)
 		close(stopChan)
 		return
 	}
 
 	// 检查房间是否存在
 	if roomInfo.RoomID == 0 {
-		log.Print("ERROR", "B 站房间不存在或已关闭")
+		log.For(pub).Log("ERROR", "B 站房间不存在或已关闭", attrs(id, "room_info", nil)...)
 		close(stopChan)
 		return
 	}
@@ -51,7 +50,7 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 		cancel() // 取消context
 		if c != nil {
 			c.Stop() // 立即停止WebSocket客户端
-			log.Print("INFO", "已停止哔哩哔哩直播监听")
+			log.For(pub).Log("BILIBILI", "已停止哔哩哔哩直播监听", log.RID(id), log.Event("listen_stop"))
 		}
 	}()
 
@@ -67,35 +66,45 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 			&uni.ChatMessage{
 				Name:     d.Sender.Uname,
 				Avatar:   avatar,
+				UID:      strconv.Itoa(d.Sender.Uid),
 				Content:  d.Content,
 				Emoticon: ExtractEmoticonURLs(d.Raw),
 				Raw:      d,
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理礼物事件
 	handleGift := func(event interface{}) {
 		g := event.(*message.Gift)
-		icon, _ := gifts.GetGiftDetailsByName(g.GiftName)
-		avatar, _ := proxy.GenerateImageURL(g.Face)
+		gift, _ := gifts.Lookup(roomInfo.RoomID, g.GiftId)
+		avatar := g.Face
+		// 金瓜子礼物的单价以 1/1000 元计，银瓜子礼物没有人民币价值
+		var price float64
+		if g.CoinType == "gold" {
+			price = float64(g.Num*g.Price) / 1000
+		}
 
 		data, _ := uni.CreateUniMessage(
 			id,
 			uni.BiliBili,
 			uni.GiftMessageType,
 			&uni.GiftMessage{
-				Name:     g.Uname,
-				Avatar:   avatar,
-				Item:     g.GiftName,
-				Num:      g.Num,
-				Price:    float64(g.Num*g.Price) / 1000,
-				GiftIcon: icon.ImgBasic,
-				Raw:      g,
+				Name:            g.Uname,
+				Avatar:          avatar,
+				UID:             strconv.Itoa(g.Uid),
+				Item:            g.GiftName,
+				Num:             g.Num,
+				Price:           price,
+				GiftIcon:        gift.ImgBasic,
+				GiftIconDynamic: gift.ImgDynamic,
+				GiftIconGif:     gift.Gif,
+				GiftIconWebp:    gift.Webp,
+				Raw:             g,
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理上舰事件
@@ -104,7 +113,7 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 		user, _ := FetchUserData(gb.Uid)
 		var avatar string
 		if user != nil {
-			avatar, _ = proxy.GenerateImageURL(user.Card.Face)
+			avatar = user.Card.Face
 		}
 
 		data, _ := uni.CreateUniMessage(
@@ -120,13 +129,13 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 				Raw:    gb,
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理醒目留言事件
 	handleSuperChat := func(event interface{}) {
 		sc := event.(*message.SuperChat)
-		avatar, _ := proxy.GenerateImageURL(sc.UserInfo.Face)
+		avatar := sc.UserInfo.Face
 
 		data, _ := uni.CreateUniMessage(
 			id,
@@ -135,12 +144,13 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 			&uni.SuperChatMessage{
 				Name:    sc.UserInfo.Uname,
 				Avatar:  avatar,
+				UID:     strconv.Itoa(sc.Uid),
 				Content: sc.Message,
 				Price:   float64(sc.Price),
 				Raw:     sc,
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理点赞事件（LIKE_INFO_V3_CLICK data JSON）
@@ -153,9 +163,9 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 		uid := int(gjson.Get(s, "uid").Int())
 		avatar := ""
 		if face := gjson.Get(s, "uinfo.base.face").String(); face != "" {
-			avatar, _ = proxy.GenerateImageURL(face)
+			avatar = face
 		} else if user, _ := FetchUserData(uid); user != nil {
-			avatar, _ = proxy.GenerateImageURL(user.Card.Face)
+			avatar = user.Card.Face
 		}
 
 		data, _ := uni.CreateUniMessage(
@@ -169,7 +179,7 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 				Raw:    json.RawMessage(s),
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理进入房间等互动（库内已解析 INTERACT_WORD_V2）
@@ -183,23 +193,23 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 			if e.MsgType == message.InteractMsgTypeLike {
 				avatar := ""
 				if e.Face != "" {
-					avatar, _ = proxy.GenerateImageURL(e.Face)
+					avatar = e.Face
 				} else if user, _ := FetchUserData(e.Uid); user != nil {
-					avatar, _ = proxy.GenerateImageURL(user.Card.Face)
+					avatar = user.Card.Face
 				}
 				data, _ := uni.CreateUniMessage(
 					id, uni.BiliBili, uni.LikeMessageType,
 					&uni.LikeMessage{Name: e.Uname, Avatar: avatar, Count: 1, Raw: e},
 				)
-				ws.BroadcastToClients(data)
+				pub.Publish(data)
 			}
 			return
 		}
 		avatar := ""
 		if e.Face != "" {
-			avatar, _ = proxy.GenerateImageURL(e.Face)
+			avatar = e.Face
 		} else if user, _ := FetchUserData(e.Uid); user != nil {
-			avatar, _ = proxy.GenerateImageURL(user.Card.Face)
+			avatar = user.Card.Face
 		}
 
 		data, _ := uni.CreateUniMessage(
@@ -212,7 +222,7 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 				Raw:    e,
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理下播事件
@@ -228,12 +238,14 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 				Raw: p,
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
-	err = gifts.InitGiftMap(room)
+	// 获取直播间礼物目录，失败时使用全平台目录
+	err = gifts.LoadRoom(roomInfo.RoomID)
+	defer gifts.ReleaseRoom(roomInfo.RoomID)
 	if err != nil {
-		log.Print("WARN", "哔哩哔哩直播间礼物获取失败")
+		log.For(pub).Log("WARN", "哔哩哔哩直播间礼物获取失败", attrs(id, "gift_list", err)...)
 	}
 
 	// 定义事件处理函数映射
@@ -247,6 +259,14 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 		"preparing": handlePreparing,
 	}
 
+	// blivedm 不暴露原始帧，不支持抓包（见 capture.Supported），以收到的事件作为上游活跃的依据
+	for name, handler := range eventHandlers {
+		eventHandlers[name] = func(event interface{}) {
+			pub.Touch()
+			handler(event)
+		}
+	}
+
 	c.OnDanmaku(func(d *message.Danmaku) {
 		invokeHandler(eventHandlers["danmaku"], d)
 	})
@@ -273,23 +293,44 @@ func StartListen(room int, cookie string, stopChan chan struct{}) {
 		invokeHandler(eventHandlers["interact"], w)
 	})
 
+	// 在线人数，仅用于房间统计
+	c.RegisterCustomEventHandler("ONLINE_RANK_COUNT", func(s string) {
+		pub.Touch()
+		data := gjson.Get(s, "data")
+		count := data.Get("online_count")
+		if !count.Exists() {
+			count = data.Get("count")
+		}
+		pub.Viewers(count.Int())
+	})
+
 	c.RegisterCustomEventHandler("PREPARING", func(s string) {
 		data := gjson.Get(s, "data").String()
 		invokeHandler(eventHandlers["preparing"], data)
 	})
 
-	err = c.Start()
-	if err != nil {
-		log.Print("ERROR", "哔哩哔哩直播监听启动失败")
-		close(stopChan)
+	// blivedm 在连接建立后断线会自行重连且不提供回调，这部分重连无法计入指标
+	if err = c.Start(); err != nil {
+		log.For(pub).Log("ERROR", "哔哩哔哩直播监听启动失败", attrs(id, "listen_start", err)...)
+		// 连接期间房间可能已被停止，此时停止通道已关闭
+		select {
+		case <-stopChan:
+		default:
+			close(stopChan)
+		}
 		return
 	}
-	log.Print("BILIBILI", "已启动哔哩哔哩直播监听")
+	log.For(pub).Log("BILIBILI", "已启动哔哩哔哩直播监听", log.RID(id), log.Event("listen_start"))
 
 	// 添加阻塞等待，确保在停止信号到来前不会退出
 	<-ctx.Done()
 }
 
+// 日志字段：平台、房间 ID、事件名和错误
+func attrs(id string, event string, err error) []slog.Attr {
+	return []slog.Attr{log.Platform(string(uni.BiliBili)), log.RID(id), log.Event(event), log.Err(err)}
+}
+
 // invokeHandler 通用的事件处理器调用函数
 func invokeHandler(handler func(interface{}), event interface{}) {
 	if handler != nil {
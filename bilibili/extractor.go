@@ -1,7 +1,6 @@
 package bilibili
 
 import (
-	"UniBarrage/services/proxy"
 	"fmt"
 	regexp "github.com/wasilibs/go-re2"
 )
@@ -22,10 +21,8 @@ func ExtractFaceURL(input string) (string, error) {
 
 	// 检查是否找到匹配
 	if len(matches) > 1 {
-		// 生成代理 URL
-		proxyURL, _ := proxy.GenerateImageURL(matches[1])
 		// 返回提取的 face URL
-		return proxyURL, nil
+		return matches[1], nil
 	} else {
 		// 如果未找到 URL，则返回错误
 		return "", fmt.Errorf("no face URL found")
@@ -37,18 +34,17 @@ func ExtractEmoticonURLs(input string) []string {
 	// 使用预编译的正则表达式查找所有符合条件的匹配
 	matches := emoticonURLPattern.FindAllStringSubmatch(input, -1)
 
-	// 存储转换后的代理 URL
-	var proxyURLs []string
+	// 存储提取的表情 URL
+	var urls []string
 
-	// 遍历匹配结果并生成代理 URL
+	// 遍历匹配结果
 	for _, match := range matches {
 		if len(match) > 1 {
-			proxyURL, _ := proxy.GenerateImageURL(match[1])
-			proxyURLs = append(proxyURLs, proxyURL)
+			urls = append(urls, match[1])
 		}
 	}
 
-	return proxyURLs
+	return urls
 }
 
 // ExtractGuardLevel 根据输入的整数返回对应的等级名称
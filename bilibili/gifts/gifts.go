@@ -1,17 +1,22 @@
 package gifts
 
 import (
-	"UniBarrage/services/proxy"
-	"errors"
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/assets"
+	log "UniBarrage/utils/trace"
 	"fmt"
 	"github.com/goccy/go-json"
 	"io"
 	"net/http"
+	"strconv"
 	"sync"
 )
 
 type Gift struct {
+	ID             int    `json:"id"`
 	GiftName       string `json:"name"`
+	Price          int    `json:"price"`     // 金瓜子时 1000 为 1 元
+	CoinType       string `json:"coin_type"` // gold 金瓜子，silver 银瓜子
 	ImgBasic       string `json:"img_basic"`
 	ImgDynamic     string `json:"img_dynamic"`
 	FrameAnimation string `json:"frame_animation"`
@@ -19,34 +24,42 @@ type Gift struct {
 	Webp           string `json:"webp"`
 }
 
-type GiftDetails struct {
-	ImgBasic       string
-	ImgDynamic     string
-	FrameAnimation string
-	Gif            string
-	Webp           string
+// 直播间礼物目录，同一直播间被多个服务监听时共用
+type roomCatalog struct {
+	refs  int
+	gifts map[int]Gift
 }
 
 var (
-	giftMap = make(map[string]GiftDetails) // 使用普通 map
-	rwLock  sync.RWMutex                   // 使用 sync.RWMutex 来实现读写锁
+	shared = make(map[int]Gift)         // 全平台通用礼物，按礼物 ID 索引，作为直播间目录的兜底
+	rooms  = make(map[int]*roomCatalog) // 直播间礼物，按房间 ID 索引
+	rwLock sync.RWMutex                 // 使用 sync.RWMutex 来实现读写锁
 )
 
-func InitGiftMap(roomID int) error {
-	url := fmt.Sprintf("https://api.live.bilibili.com/xlive/web-room/v1/giftPanel/giftConfig?platform=pc&room_id=%d", roomID)
+// 注册全平台礼物目录，由 assets.Run 在启动时加载并定期刷新，同时刷新所有直播间目录
+func init() {
+	assets.Register(uni.BiliBili, assets.Gifts, loadCatalog)
+}
+
+// 获取礼物配置，roomID 为 0 时获取全平台通用的礼物
+func fetchGiftConfig(roomID int) ([]Gift, error) {
+	url := "https://api.live.bilibili.com/xlive/web-room/v1/giftPanel/giftConfig?platform=pc"
+	if roomID != 0 {
+		url += fmt.Sprintf("&room_id=%d", roomID)
+	}
 	resp, err := http.Get(url)
 	if err != nil {
-		return fmt.Errorf("HTTP 请求出错: %w", err)
+		return nil, fmt.Errorf("HTTP 请求出错: %w", err)
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("收到非 200 状态码: %d", resp.StatusCode)
+		return nil, fmt.Errorf("收到非 200 状态码: %d", resp.StatusCode)
 	}
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return fmt.Errorf("读取响应体出错: %w", err)
+		return nil, fmt.Errorf("读取响应体出错: %w", err)
 	}
 
 	var apiResponse struct {
@@ -56,38 +69,124 @@ func InitGiftMap(roomID int) error {
 	}
 	err = json.Unmarshal(body, &apiResponse)
 	if err != nil {
-		return fmt.Errorf("解析 JSON 出错: %w", err)
+		return nil, fmt.Errorf("解析 JSON 出错: %w", err)
 	}
+	return apiResponse.Data.List, nil
+}
 
-	rwLock.Lock() // 加锁进行写操作
-	defer rwLock.Unlock()
-	for _, gift := range apiResponse.Data.List {
-		// 检查礼物是否已经存在于 map 中
-		if _, exists := giftMap[gift.GiftName]; !exists {
-			imgBasic, _ := proxy.GenerateImageURL(gift.ImgBasic)
-			imgDynamic, _ := proxy.GenerateImageURL(gift.ImgDynamic)
-			frameAnimation, _ := proxy.GenerateImageURL(gift.FrameAnimation)
-			gif, _ := proxy.GenerateImageURL(gift.Gif)
-			webp, _ := proxy.GenerateImageURL(gift.Webp)
-
-			giftMap[gift.GiftName] = GiftDetails{
-				ImgBasic:       imgBasic,
-				ImgDynamic:     imgDynamic,
-				FrameAnimation: frameAnimation,
-				Gif:            gif,
-				Webp:           webp,
-			}
+// 按礼物 ID 建立索引
+func index(list []Gift) map[int]Gift {
+	gifts := make(map[int]Gift, len(list))
+	for _, gift := range list {
+		gifts[gift.ID] = gift
+	}
+	return gifts
+}
+
+// 加载全平台礼物目录并刷新所有直播间目录，返回按接口顺序排列的全平台目录
+func loadCatalog() ([]assets.Asset, error) {
+	list, err := fetchGiftConfig(0)
+	if err != nil {
+		return nil, err
+	}
+	gifts := index(list)
+	rwLock.Lock()
+	shared = gifts
+	ids := make([]int, 0, len(rooms))
+	for roomID := range rooms {
+		ids = append(ids, roomID)
+	}
+	rwLock.Unlock()
+
+	// 直播间目录刷新失败时保留上次的结果
+	for _, roomID := range ids {
+		if err := refreshRoom(roomID); err != nil {
+			log.Printf("WARN", "刷新哔哩哔哩直播间 %d 礼物目录失败: %v", roomID, err)
 		}
 	}
 
+	catalog := make([]assets.Asset, 0, len(list))
+	for _, gift := range list {
+		catalog = append(catalog, gift.asset())
+	}
+	return catalog, nil
+}
+
+// 重新获取直播间礼物目录，直播间已释放时丢弃结果
+func refreshRoom(roomID int) error {
+	list, err := fetchGiftConfig(roomID)
+	if err != nil {
+		return err
+	}
+	gifts := index(list)
+	rwLock.Lock()
+	if room, ok := rooms[roomID]; ok {
+		room.gifts = gifts
+	}
+	rwLock.Unlock()
 	return nil
 }
 
-func GetGiftDetailsByName(giftName string) (GiftDetails, error) {
-	rwLock.RLock() // 加锁进行读操作
+// 转换为 assets 目录项，金瓜子换算为人民币元
+func (g Gift) asset() assets.Asset {
+	a := assets.Asset{
+		ID:   strconv.Itoa(g.ID),
+		Name: g.GiftName,
+		Images: assets.Images{
+			Icon:    g.ImgBasic,
+			Dynamic: g.ImgDynamic,
+			Gif:     g.Gif,
+			Webp:    g.Webp,
+		},
+	}
+	switch g.CoinType {
+	case "gold":
+		a.Price, a.Unit = float64(g.Price)/1000, assets.UnitCNY
+	case "silver":
+		a.Price, a.Unit = float64(g.Price), assets.UnitSilver
+	}
+	return a
+}
+
+// LoadRoom 获取直播间礼物目录，之后随全平台目录定期刷新，直到 ReleaseRoom；
+// 获取失败时直播间仍被登记，查找时使用全平台目录并在下次刷新时重试
+func LoadRoom(roomID int) error {
+	rwLock.Lock()
+	room, ok := rooms[roomID]
+	if !ok {
+		room = &roomCatalog{}
+		rooms[roomID] = room
+	}
+	room.refs++
+	loaded := room.gifts != nil
+	rwLock.Unlock()
+
+	if loaded {
+		return nil
+	}
+	return refreshRoom(roomID)
+}
+
+// ReleaseRoom 停止监听直播间后释放其礼物目录
+func ReleaseRoom(roomID int) {
+	rwLock.Lock()
+	defer rwLock.Unlock()
+	if room, ok := rooms[roomID]; ok {
+		if room.refs--; room.refs <= 0 {
+			delete(rooms, roomID)
+		}
+	}
+}
+
+// Lookup 根据礼物 ID 查找礼物，先查直播间目录，再查全平台目录
+func Lookup(roomID int, giftID int) (Gift, bool) {
+	rwLock.RLock()
 	defer rwLock.RUnlock()
-	if details, found := giftMap[giftName]; found {
-		return details, nil
+	if room, ok := rooms[roomID]; ok {
+		if gift, ok := room.gifts[giftID]; ok {
+			return gift, true
+		}
 	}
-	return GiftDetails{}, errors.New("找不到匹配的礼物")
+	gift, ok := shared[giftID]
+	return gift, ok
 }
@@ -0,0 +1,49 @@
+package gifts
+
+import "testing"
+
+func TestLookupPrefersRoomCatalog(t *testing.T) {
+	rwLock.Lock()
+	shared = map[int]Gift{
+		1: {ID: 1, GiftName: "小花花", ImgBasic: "shared-1.png"},
+		2: {ID: 2, GiftName: "辣条", ImgBasic: "shared-2.png"},
+	}
+	// 直播间自定义礼物与通用礼物同名但 ID 不同，同 ID 的礼物使用直播间配置
+	rooms = map[int]*roomCatalog{100: {refs: 2, gifts: map[int]Gift{
+		1: {ID: 1, GiftName: "小花花", ImgBasic: "room-1.png", Webp: "room-1.webp"},
+		9: {ID: 9, GiftName: "辣条", ImgBasic: "room-9.png"},
+	}}}
+	rwLock.Unlock()
+	t.Cleanup(func() {
+		rwLock.Lock()
+		shared, rooms = make(map[int]Gift), make(map[int]*roomCatalog)
+		rwLock.Unlock()
+	})
+
+	cases := []struct {
+		room, gift int
+		icon       string
+	}{
+		{100, 1, "room-1.png"},
+		{100, 9, "room-9.png"},
+		{100, 2, "shared-2.png"},
+		{200, 1, "shared-1.png"},
+		{200, 9, ""},
+	}
+	for _, c := range cases {
+		gift, ok := Lookup(c.room, c.gift)
+		if gift.ImgBasic != c.icon || ok != (c.icon != "") {
+			t.Errorf("Lookup(%d, %d) = %q %v, want %q", c.room, c.gift, gift.ImgBasic, ok, c.icon)
+		}
+	}
+
+	// 最后一个监听者释放后才移除直播间目录
+	ReleaseRoom(100)
+	if gift, _ := Lookup(100, 9); gift.ImgBasic != "room-9.png" {
+		t.Fatal("room catalog released while still referenced")
+	}
+	ReleaseRoom(100)
+	if _, ok := Lookup(100, 9); ok {
+		t.Fatal("room catalog should be released")
+	}
+}
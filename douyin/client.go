@@ -4,6 +4,8 @@ import (
 	"UniBarrage/douyin/generated/douyin"
 	"UniBarrage/douyin/jsScript"
 	"UniBarrage/douyin/utils"
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/metrics"
 	"UniBarrage/utils/trace"
 	"bytes"
 	"compress/gzip"
@@ -13,7 +15,7 @@ import (
 	"github.com/spf13/cast"
 	"google.golang.org/protobuf/proto"
 	"io"
-	"log"
+	"log/slog"
 	"net/http"
 	"regexp"
 	"strings"
@@ -29,8 +31,8 @@ var (
 
 // DouyinLive 结构体表示一个抖音直播连接
 
-// NewDouyinLive 创建一个新的 DouyinLive 实例
-func NewDouyinLive(liveid string) (*DouyinLive, error) {
+// NewDouyinLive 创建一个新的 DouyinLive 实例，上游原始帧写入 sink
+func NewDouyinLive(liveid string, sink uni.Sink) (*DouyinLive, error) {
 	ua := utils.RandomUserAgent()
 	c := req.C().SetUserAgent(ua)
 	d := &DouyinLive{
@@ -46,6 +48,7 @@ func NewDouyinLive(liveid string) (*DouyinLive, error) {
 			}},
 		startedCh: make(chan struct{}),
 		stopCh:    make(chan struct{}),
+		sink:      sink,
 	}
 
 	// 获取 ttwid
@@ -103,7 +106,7 @@ func (d *DouyinLive) fetchRoomID() string {
 	}
 	res, err := d.c.R().SetCookies(ttwid, acNonce).Get(d.liveurl + d.liveid)
 	if err != nil {
-		log.Printf("获取房间 ID 失败: %v", err)
+		trace.For(d.sink).Log("ERROR", "获取房间 ID 失败", d.attrs("room_id", err)...)
 		return ""
 	}
 
@@ -172,12 +175,12 @@ func (d *DouyinLive) Start() error {
 	//d.Conn, response, err = websocket.DefaultDialer.Dial(d.wssurl, d.headers)
 	d.Conn, _, err = websocket.DefaultDialer.Dial(d.wssurl, d.headers)
 	if err != nil {
-		trace.Print("ERROR", "与抖音服务链接失败")
+		trace.For(d.sink).Log("ERROR", "与抖音服务链接失败", d.attrs("connect", err)...)
 		//log.Printf("链接失败: err:%v\nroomid:%v\n ttwid:%v\nwssurl:----%v\nresponse:%v\n", err, d.roomid, d.ttwid, d.wssurl, response)
 		return err
 	}
 	//log.Println("链接成功")
-	trace.Print("INFO", "与抖音服务链接成功")
+	trace.For(d.sink).Log("DOUYIN", "与抖音服务链接成功", trace.RID(d.liveid), trace.Event("connect"))
 	d.isLiveClosed = true
 	defer func() {
 		if d.gzip != nil {
@@ -222,22 +225,23 @@ func (d *DouyinLive) Start() error {
 				}
 			} else {
 				if message != nil {
+					d.sink.Raw(message)
 					err := proto.Unmarshal(message, pbPac)
 					if err != nil {
-						log.Println("解析消息失败：", err)
+						trace.For(d.sink).Log("WARN", "解析消息失败", d.attrs("decode", err)...)
 						continue
 					}
 					n := utils.HasGzipEncoding(pbPac.HeadersList)
 					if n && pbPac.PayloadType == "msg" {
 						uncompressedData, err := d.GzipUnzipReset(pbPac.Payload)
 						if err != nil {
-							log.Println("Gzip解压失败:", err)
+							trace.For(d.sink).Log("WARN", "Gzip 解压失败", d.attrs("decode", err)...)
 							continue
 						}
 
 						err = proto.Unmarshal(uncompressedData, pbResp)
 						if err != nil {
-							log.Println("解析消息失败：", err)
+							trace.For(d.sink).Log("WARN", "解析消息失败", d.attrs("decode", err)...)
 							continue
 						}
 						if pbResp.NeedAck {
@@ -248,12 +252,12 @@ func (d *DouyinLive) Start() error {
 
 							serializedAck, err := proto.Marshal(pbAck)
 							if err != nil {
-								log.Println("proto心跳包序列化失败:", err)
+								trace.For(d.sink).Log("WARN", "proto 心跳包序列化失败", d.attrs("ack", err)...)
 								continue
 							}
 							err = d.Conn.WriteMessage(websocket.BinaryMessage, serializedAck)
 							if err != nil {
-								log.Println("心跳包发送失败：", err)
+								trace.For(d.sink).Log("WARN", "心跳包发送失败", d.attrs("ack", err)...)
 								continue
 							}
 						}
@@ -272,6 +276,7 @@ func (d *DouyinLive) reconnect(i int) bool {
 	//log.Println("尝试重新连接...")
 	//trace.Print("INFO", "尝试重新连接...")
 	for attempt := 0; attempt < i; attempt++ {
+		metrics.UpstreamReconnects.WithLabelValues(string(uni.DouYin), d.liveid).Inc()
 		if d.Conn != nil {
 			err := d.Conn.Close()
 			if err != nil {
@@ -423,3 +428,8 @@ func (d *DouyinLive) Stop() {
 
 	//log.Println("DouyinLive 对象已停止并销毁")
 }
+
+// 生成带平台、房间号、事件和错误的日志字段
+func (d *DouyinLive) attrs(event string, err error) []slog.Attr {
+	return []slog.Attr{trace.Platform(string(uni.DouYin)), trace.RID(d.liveid), trace.Event(event), trace.Err(err)}
+}
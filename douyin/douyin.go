@@ -4,19 +4,19 @@ import (
 	"UniBarrage/douyin/emojis"
 	"UniBarrage/douyin/generated/douyin"
 	"UniBarrage/douyin/utils"
-	ws "UniBarrage/services/websockets"
 	uni "UniBarrage/universal"
 	log "UniBarrage/utils/trace"
 	"fmt"
+	"github.com/goccy/go-json"
 	"google.golang.org/protobuf/proto"
 	"strconv"
 )
 
 // StartListen 启动抖音直播监听
-func StartListen(room int, stopChan chan struct{}) {
-	d, err := NewDouyinLive(strconv.Itoa(room))
+func StartListen(room int, stopChan chan struct{}, pub uni.Sink) {
+	d, err := NewDouyinLive(strconv.Itoa(room), pub)
 	if err != nil {
-		log.Printf("ERROR", "抖音直播监听启动失败: %v", err)
+		log.For(pub).Log("ERROR", "抖音直播监听启动失败", log.Platform(string(uni.DouYin)), log.RID(strconv.Itoa(room)), log.Event("listen_start"), log.Err(err))
 		close(stopChan)
 		return
 	}
@@ -33,8 +33,8 @@ func StartListen(room int, stopChan chan struct{}) {
 		d.Stop()
 	}()
 
-	log.Print("DOUYIN", "已启动抖音直播监听")
-	d.Subscribe(func(eventData *douyin.Message) { SubscribeDouYin(eventData, room) })
+	log.For(pub).Log("DOUYIN", "已启动抖音直播监听", log.RID(d.liveid), log.Event("listen_start"))
+	d.Subscribe(func(eventData *douyin.Message) { SubscribeDouYin(eventData, room, pub) })
 	err = d.Start()
 	if err != nil {
 		close(stopChan)
@@ -42,7 +42,7 @@ func StartListen(room int, stopChan chan struct{}) {
 }
 
 // SubscribeDouYin 处理抖音的事件
-func SubscribeDouYin(eventData *douyin.Message, room int) {
+func SubscribeDouYin(eventData *douyin.Message, room int, pub uni.Sink) {
 	id := strconv.Itoa(room)
 
 	// 处理聊天消息事件
@@ -55,12 +55,13 @@ func SubscribeDouYin(eventData *douyin.Message, room int) {
 			&uni.ChatMessage{
 				Name:     m.User.NickName,
 				Avatar:   m.User.AvatarThumb.UrlList[0],
+				UID:      strconv.FormatUint(m.User.Id, 10),
 				Content:  m.Content,
 				Emoticon: emojis.ParseEmojiURL(m.Content),
 				Raw:      SafeJSON(m),
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理表情消息事件
@@ -74,12 +75,13 @@ func SubscribeDouYin(eventData *douyin.Message, room int) {
 			&uni.ChatMessage{
 				Name:     m.User.NickName,
 				Avatar:   m.User.AvatarThumb.UrlList[0],
+				UID:      strconv.FormatUint(m.User.Id, 10),
 				Content:  m.DefaultContent,
 				Emoticon: emoticon,
 				Raw:      SafeJSON(m),
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理礼物消息事件
@@ -97,14 +99,15 @@ func SubscribeDouYin(eventData *douyin.Message, room int) {
 				&uni.GiftMessage{
 					Name:     m.User.NickName,
 					Avatar:   m.User.AvatarThumb.UrlList[0],
+					UID:      strconv.FormatUint(m.User.Id, 10),
 					Item:     m.Gift.Name,
 					Num:      num,
-					Price:    float64(m.Gift.DiamondCount) * 0.1 * float64(num),
+					Price:    float64(m.Gift.DiamondCount) * 0.1 * float64(num), // 1 抖币 = 0.1 元
 					GiftIcon: m.Gift.Image.UrlList[0],
 					Raw:      SafeJSON(m),
 				},
 			)
-			ws.BroadcastToClients(data)
+			pub.Publish(data)
 		}
 		// 不符合 combo 和 repeat_end 条件的消息将被跳过
 	}
@@ -129,7 +132,7 @@ func SubscribeDouYin(eventData *douyin.Message, room int) {
 				Raw:    SafeJSON(m),
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理点赞消息事件
@@ -146,7 +149,7 @@ func SubscribeDouYin(eventData *douyin.Message, room int) {
 				Raw:    SafeJSON(l),
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理进入房间消息事件
@@ -162,7 +165,7 @@ func SubscribeDouYin(eventData *douyin.Message, room int) {
 				Raw:    SafeJSON(e),
 			},
 		)
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 	}
 
 	// 处理结束直播消息事件
@@ -177,36 +180,50 @@ func SubscribeDouYin(eventData *douyin.Message, room int) {
 					Raw: SafeJSON(e),
 				},
 			)
-			ws.BroadcastToClients(data)
+			pub.Publish(data)
 		}
 	}
 
+	// 处理在线人数消息，仅用于房间统计
+	handleRoomUserSeqMessage := func(msg interface{}) {
+		m := msg.(*douyin.RoomUserSeqMessage)
+		pub.Viewers(m.Total)
+	}
+
 	// 匹配消息方法
 	msg, err := utils.MatchMethod(eventData.Method)
 	if err != nil {
 		//log.Printf("DOUYIN", "未实现的事件: %s", eventData.Method)
+		pub.Unhandled(eventData.Method, eventData.Payload)
 		return
 	}
 
 	// 反序列化 Payload
 	if err := proto.Unmarshal(eventData.Payload, msg); err != nil {
-		log.Printf("ERROR", "反序列化失败: %v", err)
+		log.For(pub).Log("ERROR", "反序列化失败", log.Platform(string(uni.DouYin)), log.RID(id), log.Event("decode"), log.Err(err))
+		pub.Unhandled(eventData.Method, eventData.Payload)
 		return
 	}
+	if pub.Capturing() {
+		pub.Decoded(eventData.Method, json.RawMessage(SafeJSON(msg)))
+	}
 
 	// 消息处理函数映射
 	messageHandlers := map[string]func(interface{}){
-		"*douyin.ChatMessage":      handleChatMessage,
-		"*douyin.EmojiChatMessage": handleEmojiChatMessage,
-		"*douyin.GiftMessage":      handleGiftMessage,
-		"*douyin.RoomMessage":      handleRoomMessage,
-		"*douyin.LikeMessage":      handleLikeMessage,
-		"*douyin.MemberMessage":    handleMemberMessage,
-		"*douyin.ControlMessage":   handleControlMessage,
+		"*douyin.ChatMessage":        handleChatMessage,
+		"*douyin.EmojiChatMessage":   handleEmojiChatMessage,
+		"*douyin.GiftMessage":        handleGiftMessage,
+		"*douyin.RoomMessage":        handleRoomMessage,
+		"*douyin.LikeMessage":        handleLikeMessage,
+		"*douyin.MemberMessage":      handleMemberMessage,
+		"*douyin.ControlMessage":     handleControlMessage,
+		"*douyin.RoomUserSeqMessage": handleRoomUserSeqMessage,
 	}
 
 	// 根据消息类型调用相应处理函数
 	if handler, ok := messageHandlers[fmt.Sprintf("%T", msg)]; ok {
 		handler(msg)
+	} else {
+		pub.Unhandled(eventData.Method, eventData.Payload)
 	}
 }
@@ -1,7 +1,8 @@
 package emojis
 
 import (
-	log "UniBarrage/utils/trace"
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/assets"
 	"github.com/goccy/go-json"
 	regexp "github.com/wasilibs/go-re2"
 	"io"
@@ -32,43 +33,45 @@ var (
 	rwLock   sync.RWMutex
 )
 
-// init 函数在包导入时自动执行
+// 注册表情目录，由 assets.Run 在启动时加载并定期刷新
 func init() {
-	go func() {
-		if err := fetchEmojiList(); err != nil {
-			log.Printf("WARN", "获取抖音表情列表失败: %v", err)
-		}
-	}()
+	assets.Register(uni.DouYin, assets.Emotes, fetchEmojiList)
 }
 
-// 从 API 获取 emoji 数据并保存到内存
-func fetchEmojiList() error {
+// 从 API 获取 emoji 数据并保存到内存，返回按接口顺序排列的表情目录
+func fetchEmojiList() ([]assets.Asset, error) {
 	resp, err := http.Get("https://www.douyin.com/aweme/v1/web/emoji/list")
 	if err != nil {
-		return err
+		return nil, err
 	}
 	defer resp.Body.Close()
 
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	var response Response
 	if err := json.Unmarshal(body, &response); err != nil {
-		return err
+		return nil, err
 	}
 
 	// 写入时加写锁
+	list := make([]assets.Asset, 0, len(response.EmojiList))
 	rwLock.Lock()
 	defer rwLock.Unlock()
 	for _, emoji := range response.EmojiList {
 		if len(emoji.EmojiUrl.UrlList) > 0 {
 			emojiMap[emoji.DisplayName] = emoji.EmojiUrl.UrlList[0]
+			list = append(list, assets.Asset{
+				ID:     emoji.OriginUri,
+				Name:   emoji.DisplayName,
+				Images: assets.Images{Icon: emoji.EmojiUrl.UrlList[0]},
+			})
 		}
 	}
 
-	return nil
+	return list, nil
 }
 
 // ParseEmojiURL 匹配字符串中的 emoji 标签并转换为 URL
@@ -1,9 +1,11 @@
 package jsScript
 
 import (
+	"UniBarrage/utils/metrics"
 	_ "embed"
 	"github.com/dop251/goja"
 	"sync"
+	"time"
 )
 
 // 嵌入的 JavaScript 文件来源于开源项目，感谢贡献者们的努力
@@ -42,9 +44,17 @@ func LoadGoja(ua string) error {
 	return vm.ExportTo(vm.Get("get_sign"), &fGetSign)
 }
 
+// Loaded 判断签名脚本是否已加载
+func Loaded() bool {
+	mu.Lock()
+	defer mu.Unlock()
+	return fGetSign != nil
+}
+
 // ExecuteJS 执行 JavaScript 中的 get_sign 函数
 func ExecuteJS(signature string) string {
 	mu.Lock()
 	defer mu.Unlock()
+	defer metrics.Since(metrics.SignatureDuration, time.Now())
 	return fGetSign(signature)
 }
@@ -2,6 +2,7 @@ package douyin
 
 import (
 	"UniBarrage/douyin/generated/douyin"
+	uni "UniBarrage/universal"
 	"compress/gzip"
 	"github.com/gorilla/websocket"
 	"github.com/imroc/req/v3"
@@ -46,4 +47,5 @@ type DouyinLive struct {
 	startWg       sync.WaitGroup
 	startedCh    chan struct{}
 	stopCh       chan struct{}
+	sink         uni.Sink // 房间输出，用于抓包
 }
@@ -2,22 +2,20 @@ package douyu
 
 import (
 	"UniBarrage/douyu/gifts"
-	ws "UniBarrage/services/websockets"
 	uni "UniBarrage/universal"
 	"UniBarrage/utils/node"
-	"UniBarrage/utils/ports"
 	log "UniBarrage/utils/trace"
 	"context"
 	"embed"
+	"fmt"
 	"github.com/goccy/go-json"
 	"github.com/gorilla/websocket"
 	"io/fs"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -114,13 +112,25 @@ type RoomStreamStartMessage struct {
 var clientFiles embed.FS
 
 // StartListen 启动监听指定房间的弹幕和礼物消息
-func StartListen(roomId int, stopChan chan struct{}) {
+func StartListen(roomId int, stopChan chan struct{}, pub uni.Sink) {
 	id := strconv.Itoa(roomId)
 
 	// 创建 context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// 关闭 stopChan，读取协程超时和子进程退出都可能关闭，需避免重复关闭
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			select {
+			case <-stopChan:
+			default:
+				close(stopChan)
+			}
+		})
+	}
+
 	// readWebSocketData 从指定端口读取 WebSocket 数据并解析
 	readWebSocketData := func(port int) {
 		u := url.URL{Scheme: "ws", Host: "127.0.0.1:" + strconv.Itoa(port), Path: "/"}
@@ -136,14 +146,14 @@ func StartListen(roomId int, stopChan chan struct{}) {
 			select {
 			case <-timeout:
 				//log.Print("ERROR", "WebSocket connection timed out")
-				close(stopChan)
-				log.Print("ERROR", "斗鱼直播监听启动失败")
+				stop()
+				log.For(pub).Log("ERROR", "斗鱼直播监听启动失败")
 				return
 			case <-ticker.C:
 				// 尝试连接 WebSocket
 				conn, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
 				if err == nil {
-					log.Print("DOUYU", "已启动斗鱼直播监听")
+					log.For(pub).Log("DOUYU", "已启动斗鱼直播监听")
 					break
 				} else {
 					//log.Print("WARN", "WebSocket connection attempt failed, retrying...")
@@ -156,7 +166,7 @@ func StartListen(roomId int, stopChan chan struct{}) {
 		}
 
 		if conn == nil {
-			log.Print("ERROR", "WebSocket connection could not be established within the timeout period")
+			log.For(pub).Log("ERROR", "WebSocket connection could not be established within the timeout period")
 			return
 		}
 		defer conn.Close()
@@ -175,6 +185,7 @@ func StartListen(roomId int, stopChan chan struct{}) {
 				//log.Print("ERROR", "WebSocket read error:")
 				break
 			}
+			pub.Raw(message)
 
 			// 解析消息
 			var chatMsg ChatMessage
@@ -186,12 +197,13 @@ func StartListen(roomId int, stopChan chan struct{}) {
 					&uni.ChatMessage{
 						Name:     chatMsg.Nn,
 						Avatar:   BuildAvatarURL(chatMsg.Ic),
+						UID:      chatMsg.Uid,
 						Content:  chatMsg.Txt,
 						Emoticon: []string{},
 						Raw:      chatMsg,
 					},
 				)
-				ws.BroadcastToClients(data)
+				pub.Publish(data)
 				continue
 			}
 
@@ -205,14 +217,15 @@ func StartListen(roomId int, stopChan chan struct{}) {
 					&uni.GiftMessage{
 						Name:     giftMsg.Nn,
 						Avatar:   BuildAvatarURL(chatMsg.Ic),
+						UID:      giftMsg.Uid,
 						Item:     gift.Name,
 						Num:      giftMsg.Gfcnt,
-						Price:    float64(giftMsg.Dc),
+						Price:    0, // 礼物消息和礼物列表均不含价格
 						GiftIcon: gift.ImageURL,
 						Raw:      giftMsg,
 					},
 				)
-				ws.BroadcastToClients(data)
+				pub.Publish(data)
 				continue
 			}
 
@@ -228,7 +241,7 @@ func StartListen(roomId int, stopChan chan struct{}) {
 						Raw:    enterMsg,
 					},
 				)
-				ws.BroadcastToClients(data)
+				pub.Publish(data)
 				continue
 			}
 
@@ -243,17 +256,24 @@ func StartListen(roomId int, stopChan chan struct{}) {
 							Raw: roomMsg,
 						},
 					)
-					ws.BroadcastToClients(data)
+					pub.Publish(data)
 				}
 				continue
 			}
+
+			// 未映射的消息类型
+			var unknown struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(message, &unknown)
+			pub.Unhandled(unknown.Type, json.RawMessage(message))
 		}
 	}
 
 	// 获取 Node.js 可执行文件路径
 	nodePath := node.EnsureNodeInstalled(os.TempDir())
 	if nodePath == "" {
-		log.Print("ERROR", "Node.js not found or failed to install")
+		log.For(pub).Log("ERROR", "Node.js not found or failed to install")
 		return
 	}
 
@@ -266,7 +286,7 @@ func StartListen(roomId int, stopChan chan struct{}) {
 	// 提取 client 目录到临时目录
 	tmpDir, err := os.MkdirTemp("", "client-*")
 	if err != nil {
-		log.Print("ERROR", "Error creating temp directory")
+		log.For(pub).Log("ERROR", "Error creating temp directory")
 		return
 	}
 	defer os.RemoveAll(tmpDir) // 在执行结束后删除临时目录
@@ -294,33 +314,20 @@ func StartListen(roomId int, stopChan chan struct{}) {
 	})
 
 	if err != nil {
-		log.Print("ERROR", "Error extracting client files")
+		log.For(pub).Log("ERROR", "Error extracting client files")
 		return
 	}
 
 	indexJsPath := filepath.Join(tmpDir, "index.js")
-	port, _ := ports.GetAvailablePort() // 获取一个系统可用的端口 (port: int)
-
-	// 创建命令
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(ctx, "cmd", "/C", nodePath, indexJsPath, strconv.Itoa(roomId), strconv.Itoa(port))
-	} else {
-		cmd = exec.CommandContext(ctx, nodePath, indexJsPath, strconv.Itoa(roomId), strconv.Itoa(port))
-	}
 
-	// 启动命令
-	if err := cmd.Start(); err != nil {
-		log.Print("ERROR", "Error starting command")
-		return
+	// 启动 Node.js 子进程并读取其 WebSocket 数据，直到 stopChan 关闭或子进程退出
+	args := func(port int) []string {
+		return []string{indexJsPath, strconv.Itoa(roomId), strconv.Itoa(port)}
 	}
-
-	// 启动 WebSocket 数据读取
-	go readWebSocketData(port)
-
-	// 等待命令完成
-	if err := cmd.Wait(); err != nil {
-		return
-		//log.Printf("ERROR", "Error waiting for command: %v", err)
+	if err := node.RunSidecar(ctx, string(uni.DouYu), id, nodePath, args, func(port int) {
+		go readWebSocketData(port)
+	}); err != nil {
+		log.For(pub).Log("ERROR", fmt.Sprintf("斗鱼直播监听异常退出: %v", err))
+		stop()
 	}
 }
@@ -1,7 +1,8 @@
 package gifts
 
 import (
-	log "UniBarrage/utils/trace"
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/assets"
 	"fmt"
 	"github.com/goccy/go-json"
 	"io"
@@ -43,13 +44,34 @@ var (
 	rwLock sync.RWMutex
 )
 
-// init 函数在包导入时自动执行，用于初始化礼物列表
+// 注册礼物目录，由 assets.Run 在启动时加载并定期刷新
 func init() {
-	go func() {
-		if err := InitGiftList(); err != nil {
-			log.Printf("WARN", "获取斗鱼礼物列表失败: %v", err)
-		}
-	}()
+	assets.Register(uni.DouYu, assets.Gifts, loadCatalog)
+}
+
+// 加载礼物列表并转换为目录，按 ID 升序排列
+func loadCatalog() ([]assets.Asset, error) {
+	if err := InitGiftList(); err != nil {
+		return nil, err
+	}
+
+	rwLock.RLock()
+	ids := make([]int, 0, len(giftMap))
+	for id := range giftMap {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	list := make([]assets.Asset, 0, len(ids))
+	for _, id := range ids {
+		gift := giftMap[id]
+		list = append(list, assets.Asset{
+			ID:     strconv.Itoa(id),
+			Name:   gift.Name,
+			Images: assets.Images{Icon: gift.ImageURL},
+		})
+	}
+	rwLock.RUnlock()
+	return list, nil
 }
 
 // InitGiftList 初始化并填充 giftMap 数据
@@ -1,22 +1,20 @@
 package huya
 
 import (
-	ws "UniBarrage/services/websockets"
 	uni "UniBarrage/universal"
 	"UniBarrage/utils/node"
-	"UniBarrage/utils/ports"
 	log "UniBarrage/utils/trace"
 	"context"
 	"embed"
 	"github.com/goccy/go-json"
 	"github.com/gorilla/websocket"
 	"io/fs"
+	"log/slog"
 	"net/url"
 	"os"
-	"os/exec"
 	"path/filepath"
-	"runtime"
 	"strconv"
+	"sync"
 	"time"
 )
 
@@ -26,7 +24,7 @@ type ChatMessage struct {
 	Time int64  `json:"time"`
 	From struct {
 		Name string `json:"name"`
-		Rid  string `json:"rid"`
+		Rid  string `json:"rid"` // 发送者的用户 ID
 	} `json:"from"`
 	ID      string `json:"id"`
 	Content string `json:"content"`
@@ -41,23 +39,35 @@ type GiftMessage struct {
 		Name string `json:"name"`
 		Rid  string `json:"rid"`
 	} `json:"from"`
-	ID    string `json:"id"`
-	Count int    `json:"count"`
-	Price int    `json:"price"`
-	Earn  int    `json:"earn"`
+	ID    string  `json:"id"`
+	Count int     `json:"count"`
+	Price float64 `json:"price"` // 礼物总价值（元），由 Node.js 客户端按数量乘以单价计算
+	Earn  float64 `json:"earn"`
 }
 
 //go:embed client/*
 var clientFiles embed.FS
 
 // StartListen 启动监听指定房间的弹幕和礼物消息
-func StartListen(roomId string, stopChan chan struct{}) {
+func StartListen(roomId string, stopChan chan struct{}, pub uni.Sink) {
 	id := roomId
 
 	// 创建 context
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// 关闭 stopChan，读取协程超时和子进程退出都可能关闭，需避免重复关闭
+	var stopOnce sync.Once
+	stop := func() {
+		stopOnce.Do(func() {
+			select {
+			case <-stopChan:
+			default:
+				close(stopChan)
+			}
+		})
+	}
+
 	// readWebSocketData 从指定端口读取 WebSocket 数据并解析
 	readWebSocketData := func(port int) {
 		u := url.URL{Scheme: "ws", Host: "127.0.0.1:" + strconv.Itoa(port), Path: "/"}
@@ -72,17 +82,17 @@ func StartListen(roomId string, stopChan chan struct{}) {
 			select {
 			case <-timeout:
 				//log.Print("ERROR", "WebSocket connection timed out")
-				log.Print("ERROR", "虎牙直播监听启动失败")
-				close(stopChan)
+				log.For(pub).Log("ERROR", "虎牙直播监听启动失败，连接 Node.js 子进程超时", attrs(id, "listen_start", nil)...)
+				stop()
 				return
 			case <-ticker.C:
 				conn, _, err = websocket.DefaultDialer.Dial(u.String(), nil)
 				if err == nil {
 					//log.Print("INFO", "WebSocket connection established")
-					log.Print("HUYA", "已启动虎牙直播监听")
+					log.For(pub).Log("HUYA", "已启动虎牙直播监听", log.RID(id), log.Event("listen_start"))
 					break
 				} else {
-					log.Print("WARN", "WebSocket connection attempt failed, retrying...")
+					log.For(pub).Log("WARN", "连接 Node.js 子进程失败，正在重试", attrs(id, "connect", err)...)
 				}
 			}
 
@@ -92,7 +102,7 @@ func StartListen(roomId string, stopChan chan struct{}) {
 		}
 
 		if conn == nil {
-			log.Print("ERROR", "WebSocket connection could not be established within the timeout period")
+			log.For(pub).Log("ERROR", "未能在超时时间内连接 Node.js 子进程", attrs(id, "connect", nil)...)
 			return
 		}
 		defer conn.Close()
@@ -111,6 +121,7 @@ func StartListen(roomId string, stopChan chan struct{}) {
 				//log.Print("ERROR", "WebSocket read error:")
 				break
 			}
+			pub.Raw(message)
 
 			// 解析消息
 			var chatMsg ChatMessage
@@ -123,12 +134,13 @@ func StartListen(roomId string, stopChan chan struct{}) {
 					&uni.ChatMessage{
 						Name:     chatMsg.From.Name,
 						Avatar:   avatar,
+						UID:      chatMsg.From.Rid,
 						Content:  chatMsg.Content,
 						Emoticon: []string{},
 						Raw:      chatMsg,
 					},
 				)
-				ws.BroadcastToClients(data)
+				pub.Publish(data)
 				continue
 			}
 
@@ -142,30 +154,38 @@ func StartListen(roomId string, stopChan chan struct{}) {
 					&uni.GiftMessage{
 						Name:     giftMsg.From.Name,
 						Avatar:   avatar,
+						UID:      giftMsg.From.Rid,
 						Item:     giftMsg.Name,
 						Num:      giftMsg.Count,
-						Price:    float64(giftMsg.Earn) / 100,
+						Price:    giftMsg.Price,
 						GiftIcon: "",
 						Raw:      giftMsg,
 					},
 				)
-				ws.BroadcastToClients(data)
+				pub.Publish(data)
 				continue
 			}
+
+			// 未映射的消息类型
+			var unknown struct {
+				Type string `json:"type"`
+			}
+			_ = json.Unmarshal(message, &unknown)
+			pub.Unhandled(unknown.Type, json.RawMessage(message))
 		}
 	}
 
 	// 获取 Node.js 可执行文件路径
 	nodePath := node.EnsureNodeInstalled(os.TempDir())
 	if nodePath == "" {
-		log.Print("ERROR", "Node.js not found or failed to install")
+		log.For(pub).Log("ERROR", "未找到 Node.js 或安装失败", attrs(id, "node", nil)...)
 		return
 	}
 
 	// 提取 client 目录到临时目录
 	tmpDir, err := os.MkdirTemp("", "client-*")
 	if err != nil {
-		log.Print("ERROR", "Error creating temp directory")
+		log.For(pub).Log("ERROR", "创建临时目录失败", attrs(id, "extract", err)...)
 		return
 	}
 	defer os.RemoveAll(tmpDir) // 在执行结束后删除临时目录
@@ -193,29 +213,11 @@ func StartListen(roomId string, stopChan chan struct{}) {
 	})
 
 	if err != nil {
-		log.Print("ERROR", "Error extracting client files")
+		log.For(pub).Log("ERROR", "解压客户端文件失败", attrs(id, "extract", err)...)
 		return
 	}
 
 	indexJsPath := filepath.Join(tmpDir, "index.js")
-	port, _ := ports.GetAvailablePort() // 获取一个系统可用的端口 (port: int)
-
-	// 创建命令
-	var cmd *exec.Cmd
-	if runtime.GOOS == "windows" {
-		cmd = exec.CommandContext(ctx, "cmd", "/C", nodePath, indexJsPath, roomId, strconv.Itoa(port))
-	} else {
-		cmd = exec.CommandContext(ctx, nodePath, indexJsPath, roomId, strconv.Itoa(port))
-	}
-
-	// 启动命令
-	if err := cmd.Start(); err != nil {
-		log.Print("ERROR", "Error starting command")
-		return
-	}
-
-	// 启动 WebSocket 数据读取
-	go readWebSocketData(port)
 
 	// 监听 stopChan
 	go func() {
@@ -223,9 +225,19 @@ func StartListen(roomId string, stopChan chan struct{}) {
 		cancel()
 	}()
 
-	// 等待命令完成
-	if err := cmd.Wait(); err != nil {
-		return
-		//log.Printf("ERROR", "Error waiting for command: %v", err)
+	// 启动 Node.js 子进程并读取其 WebSocket 数据，直到 stopChan 关闭或子进程退出
+	args := func(port int) []string {
+		return []string{indexJsPath, roomId, strconv.Itoa(port)}
+	}
+	if err := node.RunSidecar(ctx, string(uni.HuYa), id, nodePath, args, func(port int) {
+		go readWebSocketData(port)
+	}); err != nil {
+		log.For(pub).Log("ERROR", "虎牙直播监听异常退出", attrs(id, "listen", err)...)
+		stop()
 	}
 }
+
+// 日志字段：平台、房间 ID、事件名和错误
+func attrs(id string, event string, err error) []slog.Attr {
+	return []slog.Attr{log.Platform(string(uni.HuYa)), log.RID(id), log.Event(event), log.Err(err)}
+}
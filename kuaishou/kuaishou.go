@@ -1,8 +1,9 @@
 package kuaishou
 
 import (
-	ws "UniBarrage/services/websockets"
 	uni "UniBarrage/universal"
+	"UniBarrage/utils/assets"
+	"UniBarrage/utils/metrics"
 	log "UniBarrage/utils/trace"
 	"bytes"
 	"compress/gzip"
@@ -10,9 +11,11 @@ import (
 	"fmt"
 	"github.com/goccy/go-json"
 	"io"
+	"log/slog"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 
 	"UniBarrage/kuaishou/protobuf/proto"
 	"UniBarrage/kuaishou/utils"
@@ -26,6 +29,7 @@ import (
 
 type KuaiShouLive struct {
 	CK                       string
+	rid                      string // 服务房间 ID，用于抓包和消息的 rid
 	address                  string
 	eid                      string
 	uid                      string
@@ -37,8 +41,8 @@ type KuaiShouLive struct {
 	isFirstComputedLikeCount bool // @#@ 是否是第一次计算点赞数量 @#@
 	timer                    *time.Ticker
 	ws                       *webs.Socket
-	giftList                 []KuaiShouGiftItem
 	giftMapTimer             map[string]int64
+	pub                      uni.Sink // 房间输出
 }
 
 // MyRequestBody @#@ 定义请求体的结构@#@
@@ -71,6 +75,56 @@ type KuaiShouGiftItem struct {
 	Type int    `json:"type"`
 }
 
+// 礼物目录，按礼物 ID 索引，由 assets.Run 定期刷新
+var (
+	giftMu    sync.RWMutex
+	giftsByID = make(map[uint32]KuaiShouGiftItem)
+)
+
+func init() {
+	assets.Register(uni.KuaiShou, assets.Gifts, loadGiftCatalog)
+}
+
+// 加载礼物列表，替换礼物目录并转换为 assets 目录
+func loadGiftCatalog() ([]assets.Asset, error) {
+	gifts, err := GetKuaiShouGiftsList()
+	if err != nil {
+		return nil, err
+	}
+	byID := make(map[uint32]KuaiShouGiftItem, len(gifts))
+	list := make([]assets.Asset, 0, len(gifts))
+	for _, gift := range gifts {
+		byID[gift.ID] = gift
+		list = append(list, assets.Asset{
+			ID:     strconv.FormatUint(uint64(gift.ID), 10),
+			Name:   gift.Name,
+			Price:  float64(gift.UnitPrice),
+			Unit:   assets.UnitKuaiBi,
+			Images: assets.Images{Icon: gift.icon()},
+		})
+	}
+	giftMu.Lock()
+	giftsByID = byID
+	giftMu.Unlock()
+	return list, nil
+}
+
+// 根据礼物 ID 查找礼物
+func giftByID(id uint32) (KuaiShouGiftItem, bool) {
+	giftMu.RLock()
+	defer giftMu.RUnlock()
+	gift, ok := giftsByID[id]
+	return gift, ok
+}
+
+// 礼物图标，没有图片时为空
+func (g KuaiShouGiftItem) icon() string {
+	if len(g.PicUrl) == 0 {
+		return ""
+	}
+	return g.PicUrl[0].Url
+}
+
 func NewKuaiShouLive() *KuaiShouLive {
 	return &KuaiShouLive{
 		address:                  "",
@@ -85,12 +139,16 @@ func NewKuaiShouLive() *KuaiShouLive {
 // ConnectKuaiShouLiveByAddress @#@ 连接直播间 @#@
 func (l *KuaiShouLive) ConnectKuaiShouLiveByAddress(address string) error {
 	l.address = strings.TrimSpace(address)
-	gifts, err := GetKuaiShouGiftsList()
-	if err != nil {
-		return err
+	// @#@ 礼物目录尚未加载时立即加载 @#@
+	giftMu.RLock()
+	loaded := len(giftsByID) > 0
+	giftMu.RUnlock()
+	if !loaded {
+		if err := assets.Refresh(uni.KuaiShou, assets.Gifts); err != nil {
+			return err
+		}
 	}
-	l.giftList = gifts
-	err = l.getEid()
+	err := l.getEid()
 	if err != nil {
 		return err
 	}
@@ -270,6 +328,7 @@ func (l *KuaiShouLive) ConnectWss() error {
 
 	socket.OnConnectError = func(err error, socket webs.Socket) {
 		//println("Received connect error ", err.Error())
+		metrics.UpstreamReconnects.WithLabelValues(string(uni.KuaiShou), l.rid).Inc()
 		l.ConnectKuaiShouLiveByAddress(l.address)
 	}
 
@@ -291,13 +350,14 @@ func (l *KuaiShouLive) ConnectWss() error {
 
 	socket.OnDisconnected = func(err error, socket webs.Socket) {
 		//println("Disconnected from server：", err.Error())
-		log.Print("WARN", "与快手服务器断开连接, 尝试重新连接")
+		log.For(l.pub).Log("WARN", "与快手服务器断开连接, 尝试重新连接", l.attrs("disconnect", err)...)
 		// @#@ 直播间连接中断，正在重连... @#@
 		if strings.Contains(err.Error(), "websocket: close 1006 (abnormal closure): unexpected EOF") {
 			//fmt.Print("可能没开播哦......")
-			log.Print("ERROR", "未开启快手直播")
+			log.For(l.pub).Log("ERROR", "未开启快手直播", l.attrs("disconnect", err)...)
 			return
 		}
+		metrics.UpstreamReconnects.WithLabelValues(string(uni.KuaiShou), l.rid).Inc()
 		l.ConnectKuaiShouLiveByAddress(l.address)
 	}
 
@@ -355,13 +415,15 @@ func (l *KuaiShouLive) heartBeat() {
 
 // @#@ 解析消息 @#@
 func (l *KuaiShouLive) parseMsg(data []byte) {
+	l.pub.Raw(data)
 	if len(data) == 0 || data[0] != 0x08 {
+		l.pub.Unhandled("UnknownFrame", data)
 		return
 	}
 	receiveMessage := &proto.SocketMessage{}
 	err := receiveMessage.Unmarshal(data)
 	if err != nil {
-		fmt.Print("解析消息失败:", err.Error())
+		l.pub.Unhandled("SocketMessage", err.Error())
 		return
 	}
 
@@ -371,6 +433,7 @@ func (l *KuaiShouLive) parseMsg(data []byte) {
 	case proto.CompressionType_GZIP:
 		payload, err := utils.GzipDecode(receiveMessage.Payload)
 		if err != nil {
+			l.pub.Unhandled(receiveMessage.PayloadType.String(), err.Error())
 			return
 		}
 		receiveMessage.Payload = payload
@@ -379,28 +442,28 @@ func (l *KuaiShouLive) parseMsg(data []byte) {
 		msg := &proto.SCWebFeedPush{}
 		err := msg.Unmarshal(receiveMessage.Payload)
 		if err != nil {
+			l.pub.Unhandled(receiveMessage.PayloadType.String(), err.Error())
 			return
 		}
+		l.pub.Decoded(receiveMessage.PayloadType.String(), msg)
 		// @#@ 弹幕 @#@
 		if msg.CommentFeeds != nil && len(msg.CommentFeeds) > 0 {
 			for _, c := range msg.CommentFeeds {
 				// 格式化时间为 yyyy-mm-dd hh:mm:ss
 				//t := time.Unix(time.Now().Unix(), 0)
 				//fmt.Print(t.Format("2006-01-02 15:04:05"), " ", "评论消息:", c.User.UserName, "说：", c.Content, "---用户ID：", c.User.PrincipalId, "\n")
-				user, _ := GetUserInfo(c.User.PrincipalId, l.CK)
-				data, _ := uni.CreateUniMessage(
-					l.address[strings.LastIndex(l.address, "/"):],
-					uni.KuaiShou,
+				data := l.message(
 					uni.ChatMessageType,
 					&uni.ChatMessage{
 						Name:     c.User.UserName,
-						Avatar:   user.Data.VisionProfile.UserProfile.Profile.HeadURL,
+						Avatar:   l.avatar(c.User.PrincipalId),
+						UID:      c.User.PrincipalId,
 						Content:  c.Content,
 						Emoticon: []string{},
 						Raw:      c,
 					},
 				)
-				ws.BroadcastToClients(data)
+				l.pub.Publish(data)
 			}
 		}
 		// @#@ 组合弹幕 @#@
@@ -426,20 +489,17 @@ func (l *KuaiShouLive) parseMsg(data []byte) {
 		// @#@ 点亮❤️ 好像同一个人点亮一次以后，就不会触发了 @#@
 		if msg.LikeFeeds != nil && len(msg.LikeFeeds) > 0 {
 			for _, like := range msg.LikeFeeds {
-				fmt.Print("点赞消息:", like.User.UserName, "给主播点了赞", "\n")
-				user, _ := GetUserInfo(like.User.PrincipalId, l.CK)
-				data, _ := uni.CreateUniMessage(
-					l.address[strings.LastIndex(l.address, "/"):],
-					uni.KuaiShou,
+				log.For(l.pub).Log("DEBUG", fmt.Sprintf("点赞消息: %s 给主播点了赞", like.User.UserName))
+				data := l.message(
 					uni.LikeMessageType,
 					&uni.LikeMessage{
 						Name:   like.User.UserName,
-						Avatar: user.Data.VisionProfile.UserProfile.Profile.HeadURL,
+						Avatar: l.avatar(like.User.PrincipalId),
 						Count:  1,
 						Raw:    like,
 					},
 				)
-				ws.BroadcastToClients(data)
+				l.pub.Publish(data)
 			}
 		}
 
@@ -449,34 +509,28 @@ func (l *KuaiShouLive) parseMsg(data []byte) {
 				giftName := ""
 				price := 0
 				giftIcon := ""
-				for i := 0; i < len(l.giftList); i++ {
-					item := l.giftList[i]
-					if item.ID == gift.GiftId {
-						giftName = item.Name
-						price = item.UnitPrice
-						giftIcon = item.PicUrl[0].Url
-						break
-					}
+				if item, ok := giftByID(gift.GiftId); ok {
+					giftName = item.Name
+					price = item.UnitPrice
+					giftIcon = item.icon()
 				}
 				// 格式化时间为 yyyy-mm-dd hh:mm:ss
 				// t := time.Unix(time.Now().Unix(), 0)
 				// fmt.Print(t.Format("2006-01-02 15:04:05"), " ", "礼物消息:", gift.User.UserName, "送给主播【", giftName, "】，共：", gift.ComboCount, "个", "---用户ID：", gift.User.PrincipalId, "\n")
-				user, _ := GetUserInfo(gift.User.PrincipalId, l.CK)
-				data, _ := uni.CreateUniMessage(
-					l.address[strings.LastIndex(l.address, "/"):],
-					uni.KuaiShou,
+				data := l.message(
 					uni.GiftMessageType,
 					&uni.GiftMessage{
 						Name:     gift.User.UserName,
-						Avatar:   user.Data.VisionProfile.UserProfile.Profile.HeadURL,
+						Avatar:   l.avatar(gift.User.PrincipalId),
+						UID:      gift.User.PrincipalId,
 						Item:     giftName,
 						Num:      int(gift.ComboCount),
-						Price:    float64(price),
+						Price:    float64(price) * float64(gift.ComboCount) / 10, // 礼物单价以快币计，1 快币 = 0.1 元
 						GiftIcon: giftIcon,
 						Raw:      gift,
 					},
 				)
-				ws.BroadcastToClients(data)
+				l.pub.Publish(data)
 			}
 		}
 		// if msg.ShareFeeds != nil && len(msg.ShareFeeds) > 0 { {
@@ -490,15 +544,21 @@ func (l *KuaiShouLive) parseMsg(data []byte) {
 	if receiveMessage.PayloadType == proto.PayloadType_SC_LIVE_CHAT_ENDED {
 		// @#@ 直播间状态变更 @#@
 		//println(">>>>>>>>>>>>>>>>>>>>>>直播间已关闭，直播已经结束了<<<<<<<<<<<<<<<<<<<<<<<")
-		data, _ := uni.CreateUniMessage(
-			l.address[strings.LastIndex(l.address, "/"):],
-			uni.KuaiShou,
+		data := l.message(
 			uni.EndLiveMessageType,
 			&uni.EndLiveMessage{
 				Raw: proto.PayloadType_SC_LIVE_CHAT_ENDED,
 			},
 		)
-		ws.BroadcastToClients(data)
+		l.pub.Publish(data)
+	}
+
+	// 其余类型计入未处理统计，便于排查协议变更
+	switch receiveMessage.PayloadType {
+	case proto.PayloadType_SC_FEED_PUSH, proto.PayloadType_SC_LIVE_CHAT_ENDED,
+		proto.PayloadType_SC_HEARTBEAT_ACK, proto.PayloadType_SC_ENTER_ROOM_ACK:
+	default:
+		l.pub.Unhandled(receiveMessage.PayloadType.String(), receiveMessage.Payload)
 	}
 
 	// if receiveMessage.PayloadType == proto.PayloadType_CS_ENTER_ROOM {
@@ -555,7 +615,7 @@ func GetKuaiShouGiftsList() ([]KuaiShouGiftItem, error) {
 	var giftList []KuaiShouGiftItem
 	err = json.Unmarshal([]byte(giftJson), &giftList)
 	if err != nil {
-		fmt.Println("解析JSON时出现错误:", err)
+		log.Log("WARN", "解析礼物列表 JSON 时出现错误", log.Platform(string(uni.KuaiShou)), log.Event("gift_list"), log.Err(err))
 		return nil, errors.Errorf("解析礼物列表JSON时出现错误:%s", err.Error())
 	}
 	var giftMap = make(map[string]bool, 1)
@@ -590,9 +650,6 @@ func (l *KuaiShouLive) Stop() {
 		l.ws = nil
 	}
 
-	// 清空礼物列表
-	l.giftList = nil
-
 	// 清空礼物计时器
 	l.giftMapTimer = nil
 
@@ -600,9 +657,31 @@ func (l *KuaiShouLive) Stop() {
 	//fmt.Println("All resources for KuaiShouLive have been cleaned up.")
 }
 
-func StartListen(liveAddress string, cookie string, stopChan chan struct{}) {
+// 获取用户头像，未设置 cookie 或请求失败时为空
+func (l *KuaiShouLive) avatar(principalId string) string {
+	user, err := GetUserInfo(principalId, l.CK)
+	if err != nil || user == nil {
+		return ""
+	}
+	return user.Data.VisionProfile.UserProfile.Profile.HeadURL
+}
+
+// 日志字段：平台、房间 ID、事件名和错误
+func (l *KuaiShouLive) attrs(event string, err error) []slog.Attr {
+	return []slog.Attr{log.Platform(string(uni.KuaiShou)), log.RID(l.rid), log.Event(event), log.Err(err)}
+}
+
+// 创建统一消息，房间 ID 使用启动服务时的 rid，与统计、房间组和 WebSocket 路由的 key 一致
+func (l *KuaiShouLive) message(msgType uni.MessageType, data uni.MessageData) *uni.UniMessage {
+	msg, _ := uni.CreateUniMessage(l.rid, uni.KuaiShou, msgType, data)
+	return msg
+}
+
+func StartListen(liveAddress string, cookie string, stopChan chan struct{}, pub uni.Sink) {
 	var live = NewKuaiShouLive()
 	live.CK = cookie
+	live.rid = liveAddress
+	live.pub = pub
 
 	// 创建一个 context 用于控制
 	ctx, cancel := context.WithCancel(context.Background())
@@ -620,12 +699,12 @@ func StartListen(liveAddress string, cookie string, stopChan chan struct{}) {
 
 	err := live.ConnectKuaiShouLiveByAddress("https://v.kuaishou.com/" + liveAddress)
 	if err != nil {
-		log.Printf("ERROR", "快手直播监听启动失败: %s", err.Error())
+		log.For(pub).Log("ERROR", "快手直播监听启动失败", live.attrs("listen_start", err)...)
 		close(stopChan)
 		return
 	}
 
-	log.Print("KUAISHOU", "已启动快手直播监听")
+	log.For(pub).Log("KUAISHOU", "已启动快手直播监听", log.RID(live.rid), log.Event("listen_start"))
 
 	// 等待结束信号
 	<-ctx.Done()
@@ -0,0 +1,80 @@
+package kuaishou
+
+import (
+	"UniBarrage/kuaishou/protobuf/proto"
+	"UniBarrage/pkg/group"
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/stats"
+	"testing"
+
+	pb "google.golang.org/protobuf/proto"
+)
+
+// 按上游格式编码一帧弹幕推送
+func feedFrame(t *testing.T, push *proto.SCWebFeedPush) []byte {
+	t.Helper()
+	payload, err := pb.Marshal(push)
+	if err != nil {
+		t.Fatal(err)
+	}
+	frame, err := pb.Marshal(&proto.SocketMessage{PayloadType: proto.PayloadType_SC_FEED_PUSH, Payload: payload})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return frame
+}
+
+func commentFrame(t *testing.T, name string, content string) []byte {
+	return feedFrame(t, &proto.SCWebFeedPush{CommentFeeds: []*proto.WebCommentFeed{{
+		User:    &proto.SimpleUserInfo{PrincipalId: "id-" + name, UserName: name},
+		Content: content,
+	}}})
+}
+
+// 适配器发布的消息 rid 与启动服务时一致，能路由到包含该房间的房间组
+func TestMessagesRouteToGroup(t *testing.T) {
+	r := group.New()
+	var got []*uni.UniMessage
+	r.SetOutput(func(id string, msg *uni.UniMessage) { got = append(got, msg) })
+	if err := r.Load([]group.Config{{ID: "show", Members: []group.Member{{Platform: uni.KuaiShou, RoomID: "3xabc"}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	live := NewKuaiShouLive()
+	live.rid = "3xabc"
+	live.pub = uni.PublishOnly(r)
+	live.parseMsg(commentFrame(t, "a", "hi"))
+
+	if len(got) != 1 {
+		t.Fatalf("group received %d messages, want 1", len(got))
+	}
+	if got[0].RID != "3xabc" || got[0].Data.(*uni.ChatMessage).Content != "hi" {
+		t.Fatalf("unexpected message: %+v", got[0])
+	}
+	if info, _ := r.Get("show"); info.Stats.Members["kuaishou_3xabc"] != 1 {
+		t.Fatalf("member stats: %+v", info.Stats.Members)
+	}
+}
+
+// 适配器发布的消息计入以服务 rid 登记的房间统计
+func TestMessagesCountInRoomStats(t *testing.T) {
+	st := stats.NewStore()
+	st.Track(uni.KuaiShou, "3xabc")
+
+	live := NewKuaiShouLive()
+	live.rid = "3xabc"
+	live.pub = uni.PublishOnly(uni.PublisherFunc(st.Observe))
+	live.parseMsg(commentFrame(t, "a", "hi"))
+	live.parseMsg(commentFrame(t, "b", "hello"))
+	live.parseMsg(feedFrame(t, &proto.SCWebFeedPush{LikeFeeds: []*proto.WebLikeFeed{{
+		User: &proto.SimpleUserInfo{PrincipalId: "u2", UserName: "c"},
+	}}}))
+
+	s, ok := st.Get(uni.KuaiShou, "3xabc")
+	if !ok {
+		t.Fatal("room not tracked")
+	}
+	if s.Chats != 2 || s.UniqueChatters != 2 || s.Likes != 1 {
+		t.Fatalf("unexpected stats: chats=%d chatters=%d likes=%d", s.Chats, s.UniqueChatters, s.Likes)
+	}
+}
@@ -1,13 +1,23 @@
 package main
 
 import (
+	"UniBarrage/pkg/script"
+	"UniBarrage/pkg/unibarrage"
 	"UniBarrage/services/api"
 	"UniBarrage/services/proxy"
-	ws "UniBarrage/services/websockets"
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/assets"
+	"UniBarrage/utils/config"
 	"UniBarrage/utils/cors"
+	"UniBarrage/utils/metrics"
 	"UniBarrage/utils/trace"
+	"context"
 	"github.com/urfave/cli/v2"
+	"log/slog"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
 )
 
 func main() {
@@ -15,6 +25,11 @@ func main() {
 		Name:  "UniBarrage",
 		Usage: "启动 UniBarrage 服务",
 		Flags: []cli.Flag{
+			&cli.StringFlag{
+				Name:    "config",
+				Aliases: []string{"c"},
+				Usage:   "配置文件路径 (YAML)，命令行参数优先于配置文件，收到 SIGHUP 时重新加载",
+			},
 			&cli.StringFlag{
 				Name:    "wsHost",
 				Aliases: []string{"wh"},
@@ -39,6 +54,12 @@ func main() {
 				Value:   8080,
 				Usage:   "API 端口",
 			},
+			&cli.BoolFlag{
+				Name:    "singlePort",
+				Aliases: []string{"sp", "single-port"},
+				Value:   false,
+				Usage:   "在 API 端口上提供 WebSocket (/ws) 和图片代理 (/image)，不再单独监听 WebSocket 和代理端口",
+			},
 			&cli.BoolFlag{
 				Name:    "useProxy",
 				Aliases: []string{"up"},
@@ -57,6 +78,66 @@ func main() {
 				Value:   8888,
 				Usage:   "代理端口",
 			},
+			&cli.StringFlag{
+				Name:    "proxyAllowedHosts",
+				Aliases: []string{"pah", "proxy-allowed-hosts"},
+				Usage:   "代理允许的上游图片域名 (用逗号分隔，同时匹配子域名，* 表示不限制；默认: 各平台图片 CDN)",
+			},
+			&cli.BoolFlag{
+				Name:    "proxyAllowPrivate",
+				Aliases: []string{"pap", "proxy-allow-private"},
+				Usage:   "允许代理访问内网、回环和链路本地地址",
+			},
+			&cli.Int64Flag{
+				Name:    "proxyMaxSize",
+				Aliases: []string{"pms", "proxy-max-size"},
+				Value:   5 << 20,
+				Usage:   "代理单张图片的最大字节数",
+			},
+			&cli.DurationFlag{
+				Name:    "proxyTimeout",
+				Aliases: []string{"pto", "proxy-timeout"},
+				Value:   10 * time.Second,
+				Usage:   "代理下载单张图片的超时时间",
+			},
+			&cli.StringFlag{
+				Name:    "proxyPublicURL",
+				Aliases: []string{"ppu", "proxy-public-url"},
+				Usage:   "生成代理 URL 时使用的外部地址，如 https://example.com (默认: http://proxyHost:proxyPort)",
+			},
+			&cli.StringFlag{
+				Name:    "proxySecret",
+				Aliases: []string{"ps", "proxy-secret"},
+				EnvVars: []string{"UNIBARRAGE_PROXY_SECRET"},
+				Usage:   "代理 URL 的 HMAC 签名密钥，设置后代理拒绝未签名的请求",
+			},
+			&cli.DurationFlag{
+				Name:    "proxyURLTTL",
+				Aliases: []string{"put", "proxy-url-ttl"},
+				Usage:   "签名代理 URL 的有效期，为 0 时不过期",
+			},
+			&cli.StringFlag{
+				Name:    "proxyCacheDir",
+				Aliases: []string{"pcd", "proxy-cache-dir"},
+				Usage:   "代理图片缓存 BadgerDB 目录 (默认: 系统临时目录/CacheBadger)",
+			},
+			&cli.IntFlag{
+				Name:    "proxyCacheSize",
+				Aliases: []string{"pcs", "proxy-cache-size"},
+				Value:   1000,
+				Usage:   "代理内存缓存的图片数量上限",
+			},
+			&cli.Int64Flag{
+				Name:    "proxyCacheBytes",
+				Aliases: []string{"pcb", "proxy-cache-bytes"},
+				Usage:   "代理内存缓存的图片总字节数上限，为 0 时只按数量限制",
+			},
+			&cli.DurationFlag{
+				Name:    "proxyCacheTTL",
+				Aliases: []string{"pct", "proxy-cache-ttl"},
+				Value:   24 * time.Hour,
+				Usage:   "代理图片在磁盘缓存中的保存时长",
+			},
 			&cli.StringFlag{
 				Name:    "certFile",
 				Aliases: []string{"cf"},
@@ -78,53 +159,291 @@ func main() {
 				Aliases: []string{"at"},
 				Usage:   "用于验证的 Bearer Token (仅 API)",
 			},
+			&cli.StringFlag{
+				Name:    "debugDir",
+				Aliases: []string{"dd"},
+				Usage:   "上游帧抓包文件目录 (默认: 系统临时目录/UniBarrageCapture)",
+			},
+			&cli.StringFlag{
+				Name:    "stateFile",
+				Aliases: []string{"sf"},
+				Value:   defaultStateFile(),
+				Usage:   "服务持久化状态文件，重启后自动恢复其中的服务",
+			},
+			&cli.StringFlag{
+				Name:    "stateKey",
+				Aliases: []string{"sk"},
+				EnvVars: []string{"UNIBARRAGE_STATE_KEY"},
+				Usage:   "加密状态文件中 cookie 的密钥 (默认: 状态文件旁自动生成的 .key 文件)",
+			},
+			&cli.DurationFlag{
+				Name:    "staleAfter",
+				Aliases: []string{"sa", "stale-after"},
+				Value:   60 * time.Second,
+				Usage:   "超过该时长未收到上游帧的服务标记为不健康",
+			},
+			&cli.DurationFlag{
+				Name:    "statsInterval",
+				Aliases: []string{"si", "stats-interval"},
+				Value:   10 * time.Second,
+				Usage:   "向 WebSocket 客户端推送房间统计 (Stats) 的间隔，为 0 时不推送",
+			},
+			&cli.DurationFlag{
+				Name:    "assetRefresh",
+				Aliases: []string{"ar", "asset-refresh"},
+				Value:   6 * time.Hour,
+				Usage:   "礼物与表情目录的刷新间隔，为 0 时只在启动时加载",
+			},
+			&cli.DurationFlag{
+				Name:    "shutdownTimeout",
+				Aliases: []string{"st", "shutdown-timeout"},
+				Value:   10 * time.Second,
+				Usage:   "优雅退出的最长等待时间，超时后强制终止",
+			},
+			&cli.StringFlag{
+				Name:    "scriptsDir",
+				Aliases: []string{"scd", "scripts-dir"},
+				Usage:   "消息脚本目录，加载其中定义了 onMessage(msg) 的 .js 文件，收到 SIGHUP 时重新加载",
+			},
+			&cli.DurationFlag{
+				Name:    "scriptTimeout",
+				Aliases: []string{"sto", "script-timeout"},
+				Value:   100 * time.Millisecond,
+				Usage:   "消息脚本单次调用的超时时间",
+			},
+			&cli.StringFlag{
+				Name:    "commandPrefix",
+				Aliases: []string{"cmp", "command-prefix"},
+				Usage:   "弹幕命令前缀（如 !），以此开头的弹幕会额外输出 Command 消息，为空时不解析命令",
+			},
 			&cli.IntFlag{
 				Name:    "logLevel",
 				Aliases: []string{"ll"},
 				Value:   0,
 				Usage:   "日志等级 (0: 默认, 1: 简洁, 2: 静默)",
 			},
+			&cli.StringFlag{
+				Name:    "logFormat",
+				Aliases: []string{"lf", "log-format"},
+				Usage:   "日志格式 (tui / text / json，默认: 等级 0 为 tui，其余为 text)",
+			},
+			&cli.StringFlag{
+				Name:    "logFile",
+				Aliases: []string{"lo", "log-file"},
+				Usage:   "日志文件路径，以 JSON 格式写入并按大小滚动",
+			},
+			&cli.StringFlag{
+				Name:    "logPlatformLevel",
+				Aliases: []string{"lpl", "log-platform-level"},
+				Usage:   "按平台覆盖日志等级，如 douyin=debug,bilibili=warn",
+			},
 		},
 		Action: func(c *cli.Context) error {
+			// 读取配置文件
+			cfg := &config.Config{}
+			if path := c.String("config"); path != "" {
+				loaded, err := config.Load(path)
+				if err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+				cfg = loaded
+			}
+
 			// 初始化 Trace
-			trace.Init(c.Int("logLevel"))
+			logLevel := c.Int("logLevel")
+			if !c.IsSet("logLevel") && cfg.Log.Level != nil {
+				logLevel = *cfg.Log.Level
+			}
+			platformLevels, err := logPlatformLevels(c, cfg)
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			maxSize := int64(cfg.Log.MaxSize) << 20
+			if maxSize <= 0 {
+				maxSize = 10 << 20
+			}
+			maxBackups := cfg.Log.MaxBackups
+			if maxBackups <= 0 {
+				maxBackups = 5
+			}
+			if err := trace.Init(trace.Options{
+				Level:          logLevel,
+				Format:         stringOption(c, "logFormat", cfg.Log.Format),
+				File:           stringOption(c, "logFile", cfg.Log.File),
+				MaxSize:        maxSize,
+				MaxBackups:     maxBackups,
+				PlatformLevels: platformLevels,
+			}); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+
+			// 创建采集引擎，API 服务通过它启动房间
+			engine, err := unibarrage.New(unibarrage.Options{CaptureDir: stringOption(c, "debugDir", cfg.DebugDir)})
+			if err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			server := api.New(engine)
 
 			// 处理允许的来源列表
 			origins := cors.ParseOrigins(c.String("allowedOrigins"))
+			if !c.IsSet("allowedOrigins") && len(cfg.CORS.AllowedOrigins) > 0 {
+				origins = cfg.CORS.AllowedOrigins
+			}
 
-			// 启动 API 服务器
-			go api.StartServer(
-				c.String("apiHost"),
-				c.Int("apiPort"),
-				c.String("certFile"),
-				c.String("keyFile"),
-				c.String("authToken"),
-				origins,
-				c.Int("wsPort"),
-			)
+			server.SetStaleAfter(c.Duration("staleAfter"))
 
-			// 启动 WebSocket 服务器
-			ws.StartServer(
-				c.String("wsHost"),
-				c.Int("wsPort"),
-				c.String("certFile"),
-				c.String("keyFile"),
+			// 加载消息处理链
+			if err := server.Pipeline().Load(cfg.Pipeline); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+
+			// 加载弹幕命令配置
+			cfg.Commands.Prefix = stringOption(c, "commandPrefix", cfg.Commands.Prefix)
+			server.Commands().Load(cfg.Commands)
+
+			// 加载消息脚本，在配置文件中的处理阶段之后执行
+			var scripts *script.Runner
+			if dir := stringOption(c, "scriptsDir", cfg.Scripts.Dir); dir != "" {
+				timeout := c.Duration("scriptTimeout")
+				if !c.IsSet("scriptTimeout") && cfg.Scripts.Timeout > 0 {
+					timeout = cfg.Scripts.Timeout
+				}
+				scripts = script.NewRunner(script.Options{Dir: dir, Timeout: timeout, Out: server.Output()})
+				if err := scripts.Load(); err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+				server.Pipeline().Use(scripts)
+				server.Commands().Use(scripts)
+				scripts.WatchReload()
+			}
+
+			// 加载房间组
+			if err := server.Groups().Load(cfg.Groups); err != nil {
+				return cli.Exit(err.Error(), 1)
+			}
+			hub := engine.WebSocket()
+			server.Groups().SetOutput(hub.BroadcastToGroup)
+
+			// 订阅消息总线：Prometheus 指标、WebSocket 广播与房间组
+			server.Bus().Subscribe(uni.PublisherFunc(metrics.ObserveMessage))
+			server.Bus().Subscribe(uni.PublisherFunc(hub.BroadcastToClients))
+			server.Bus().Subscribe(server.Groups())
+
+			// 定期推送房间统计，随后推送开启去重的房间组的合并统计
+			if interval := c.Duration("statsInterval"); interval > 0 {
+				go engine.Stats().Run(context.Background(), interval, server.Bus(), server.Groups().FlushStats)
+			}
+
+			// 加载礼物与表情目录并定期刷新，加载成功后预取需要代理的图标
+			images := engine.Proxy()
+			assets.OnRefresh(images.PrefetchCatalog)
+			go assets.Run(context.Background(), c.Duration("assetRefresh"))
+
+			wsPort := intOption(c, "wsPort", cfg.WebSocket.Port)
+			apiHost := stringOption(c, "apiHost", cfg.API.Host)
+			apiPort := intOption(c, "apiPort", cfg.API.Port)
+			certFile := stringOption(c, "certFile", cfg.TLS.CertFile)
+			keyFile := stringOption(c, "keyFile", cfg.TLS.KeyFile)
+			singlePort := c.Bool("singlePort")
+			if !c.IsSet("singlePort") && cfg.SinglePort != nil {
+				singlePort = *cfg.SinglePort
+			}
+
+			// 启动 API 服务器，单端口模式下同时提供 WebSocket 和图片代理
+			server.SetSinglePort(singlePort)
+			go server.StartServer(
+				apiHost,
+				apiPort,
+				certFile,
+				keyFile,
+				authTokens(c, cfg),
 				origins,
+				wsPort,
 			)
 
-			// 如果启用代理，则启动代理服务器
-			if c.Bool("useProxy") {
-				go proxy.StartServer(
-					c.String("proxyHost"),
-					c.Int("proxyPort"),
-					c.String("certFile"),
-					c.String("keyFile"),
+			// 启动 WebSocket 服务器
+			if !singlePort {
+				hub.StartServer(
+					stringOption(c, "wsHost", cfg.WebSocket.Host),
+					wsPort,
+					certFile,
+					keyFile,
 					origins,
 				)
 			}
 
+			// 如果启用代理，则启动代理服务器
+			useProxy := c.Bool("useProxy")
+			if !c.IsSet("useProxy") && cfg.Proxy.Enabled != nil {
+				useProxy = *cfg.Proxy.Enabled
+			}
+			if useProxy {
+				images.SetCacheOptions(cacheOptions(c, cfg))
+				images.SetAdminMiddleware(server.AuthMiddleware())
+				images.SetPurgeMiddleware(server.TokenRequiredMiddleware())
+				images.SetUpstreamPolicy(upstreamPolicy(c, cfg))
+				images.SetHeaderProfiles(headerProfiles(cfg))
+				urlTTL := c.Duration("proxyURLTTL")
+				if !c.IsSet("proxyURLTTL") && cfg.Proxy.URLTTL > 0 {
+					urlTTL = cfg.Proxy.URLTTL
+				}
+				images.SetSigning(stringOption(c, "proxySecret", cfg.Proxy.Secret), urlTTL)
+				if err := images.SetPublicURL(stringOption(c, "proxyPublicURL", cfg.Proxy.PublicURL)); err != nil {
+					return cli.Exit(err.Error(), 1)
+				}
+				if singlePort {
+					images.Enable(apiHost, apiPort, certFile != "" && keyFile != "")
+				} else {
+					go images.StartServer(
+						stringOption(c, "proxyHost", cfg.Proxy.Host),
+						intOption(c, "proxyPort", cfg.Proxy.Port),
+						certFile,
+						keyFile,
+						origins,
+					)
+				}
+			}
+
+			// 恢复上次运行时持久化的服务
+			if err := server.EnablePersistence(
+				stringOption(c, "stateFile", cfg.State.File),
+				stringOption(c, "stateKey", cfg.State.Key),
+			); err != nil {
+				trace.Printf("ERROR", "启用服务持久化失败: %v", err)
+			} else if c.String("config") != "" {
+				// 配置文件中的房间由 syncRooms 启动和管理，避免恢复后不受配置文件控制
+				keys := make([]string, 0, len(cfg.Rooms))
+				for _, room := range cfg.Rooms {
+					keys = append(keys, room.Key())
+				}
+				server.RestoreServices(keys...)
+			} else {
+				server.RestoreServices()
+			}
+
+			// 启动配置文件中的房间，并在 SIGHUP 时按差异启停
+			if path := c.String("config"); path != "" {
+				syncRooms(server, cfg.Rooms)
+				config.WatchReload(path, func(next *config.Config) {
+					if !c.IsSet("authToken") {
+						server.SetAuthTokens(next.Auth.Tokens)
+					}
+					if err := server.Pipeline().Load(next.Pipeline); err != nil {
+						trace.Printf("ERROR", "重新加载消息处理链失败: %v", err)
+					}
+					if err := server.Groups().Load(next.Groups); err != nil {
+						trace.Printf("ERROR", "重新加载房间组失败: %v", err)
+					}
+					next.Commands.Prefix = stringOption(c, "commandPrefix", next.Commands.Prefix)
+					server.Commands().Load(next.Commands)
+					syncRooms(server, next.Rooms)
+				})
+			}
+
 			// 处理程序信号以进行优雅退出
-			trace.HandleSignal()
+			trace.HandleSignal(c.Duration("shutdownTimeout"), func(ctx context.Context) {
+				shutdown(ctx, engine, server)
+			})
 
 			return nil
 		},
@@ -132,3 +451,155 @@ func main() {
 
 	_ = app.Run(os.Args)
 }
+
+// shutdown 依次停止接受新连接、停止所有房间并等待 Node 子进程退出、关闭 BadgerDB、断开 WebSocket 客户端
+func shutdown(ctx context.Context, engine *unibarrage.Engine, server *api.Server) {
+	if err := server.Shutdown(ctx); err != nil {
+		trace.Printf("WARN", "关闭 API 服务失败: %v", err)
+	}
+	if err := engine.WebSocket().Shutdown(ctx); err != nil {
+		trace.Printf("WARN", "关闭 WebSocket 服务失败: %v", err)
+	}
+	if err := server.StopAllRooms(ctx); err != nil {
+		trace.Printf("WARN", "等待监听服务退出失败: %v", err)
+	}
+	if err := engine.Proxy().Shutdown(ctx); err != nil {
+		trace.Printf("WARN", "关闭图片代理失败: %v", err)
+	}
+	if err := engine.WebSocket().CloseClients(ctx); err != nil {
+		trace.Printf("WARN", "关闭 WebSocket 客户端失败: %v", err)
+	}
+}
+
+// 由配置文件成功启动的房间，用于重新加载时计算差异；只有其中的房间会因配置变更而被停止
+var configRooms = make(map[string]config.Room)
+
+// syncRooms 将配置文件中的房间列表与当前运行状态对齐：停止被删除或变更的房间，启动新增的房间
+func syncRooms(server *api.Server, rooms []config.Room) {
+	next := make(map[string]config.Room, len(rooms))
+	for _, room := range rooms {
+		next[room.Key()] = room
+	}
+
+	for key, room := range configRooms {
+		if n, ok := next[key]; !ok || n != room {
+			server.StopRoom(room.Platform, room.RoomID)
+			delete(configRooms, key)
+		}
+	}
+
+	// 启动失败的房间（包括与 API 启动或恢复的房间冲突）不记录，既不会被配置文件停止，也会在下次重新加载时重试
+	for key, room := range next {
+		if _, ok := configRooms[key]; ok {
+			continue
+		}
+		if err := server.StartRoom(room.Platform, room.RoomID, api.RoomOptions{
+			Cookie: room.Cookie,
+			Debug:  room.Debug,
+		}); err != nil {
+			trace.Printf("WARN", "自动启动房间 %s 失败: %v", key, err)
+			continue
+		}
+		configRooms[key] = room
+	}
+}
+
+// 默认状态文件位于用户配置目录，避免 cookie 与密钥落在共享的临时目录；无法确定用户配置目录时退回临时目录
+func defaultStateFile() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "UniBarrage", "services.json")
+}
+
+// 命令行显式指定的参数优先，其次为配置文件，最后为参数默认值
+func stringOption(c *cli.Context, name string, value string) string {
+	if c.IsSet(name) || value == "" {
+		return c.String(name)
+	}
+	return value
+}
+
+// 命令行显式指定的参数优先，其次为配置文件，最后为参数默认值
+func intOption(c *cli.Context, name string, value int) int {
+	if c.IsSet(name) || value == 0 {
+		return c.Int(name)
+	}
+	return value
+}
+
+// 合并命令行与配置文件中的图片缓存配置，命令行优先
+func cacheOptions(c *cli.Context, cfg *config.Config) proxy.CacheOptions {
+	opts := proxy.CacheOptions{
+		Dir:      stringOption(c, "proxyCacheDir", cfg.Proxy.CacheDir),
+		Entries:  intOption(c, "proxyCacheSize", cfg.Proxy.CacheSize),
+		MaxBytes: c.Int64("proxyCacheBytes"),
+		TTL:      c.Duration("proxyCacheTTL"),
+	}
+	if !c.IsSet("proxyCacheBytes") && cfg.Proxy.CacheBytes > 0 {
+		opts.MaxBytes = cfg.Proxy.CacheBytes
+	}
+	if !c.IsSet("proxyCacheTTL") && cfg.Proxy.CacheTTL > 0 {
+		opts.TTL = cfg.Proxy.CacheTTL
+	}
+	return opts
+}
+
+// 合并命令行与配置文件中的图片代理上游限制，命令行优先
+func upstreamPolicy(c *cli.Context, cfg *config.Config) proxy.UpstreamPolicy {
+	policy := proxy.UpstreamPolicy{
+		AllowedHosts: cfg.Proxy.AllowedHosts,
+		AllowPrivate: c.Bool("proxyAllowPrivate") || cfg.Proxy.AllowPrivate,
+		MaxSize:      c.Int64("proxyMaxSize"),
+		Timeout:      c.Duration("proxyTimeout"),
+	}
+	if c.IsSet("proxyAllowedHosts") {
+		policy.AllowedHosts = strings.Split(c.String("proxyAllowedHosts"), ",")
+	}
+	if !c.IsSet("proxyMaxSize") && cfg.Proxy.MaxSize > 0 {
+		policy.MaxSize = cfg.Proxy.MaxSize
+	}
+	if !c.IsSet("proxyTimeout") && cfg.Proxy.Timeout > 0 {
+		policy.Timeout = cfg.Proxy.Timeout
+	}
+	return policy
+}
+
+// 将配置文件中的请求头方案转换为图片代理的方案
+func headerProfiles(cfg *config.Config) []proxy.HeaderProfile {
+	profiles := make([]proxy.HeaderProfile, 0, len(cfg.Proxy.Headers))
+	for _, p := range cfg.Proxy.Headers {
+		profiles = append(profiles, proxy.HeaderProfile{Name: p.Name, Hosts: p.Hosts, Headers: p.Headers})
+	}
+	return profiles
+}
+
+// 合并命令行与配置文件中按平台覆盖的日志等级，命令行优先
+func logPlatformLevels(c *cli.Context, cfg *config.Config) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for platform, name := range cfg.Log.Platforms {
+		level, err := trace.ParseLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.ToLower(platform)] = level
+	}
+
+	overrides, err := trace.ParsePlatformLevels(c.String("logPlatformLevel"))
+	if err != nil {
+		return nil, err
+	}
+	for platform, level := range overrides {
+		levels[platform] = level
+	}
+	return levels, nil
+}
+
+// 合并命令行与配置文件中的 Bearer Token
+func authTokens(c *cli.Context, cfg *config.Config) []string {
+	if token := strings.TrimSpace(c.String("authToken")); c.IsSet("authToken") && token != "" {
+		return []string{token}
+	}
+	return cfg.Auth.Tokens
+}
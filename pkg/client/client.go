@@ -0,0 +1,211 @@
+// Package client 是 UniBarrage REST 接口与 WebSocket 推送的 Go 客户端，接口定义见 /api/v1/openapi.json
+package client
+
+import (
+	uni "UniBarrage/universal"
+	"bytes"
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Client UniBarrage API 客户端
+type Client struct {
+	baseURL    string       // API 地址，如 http://127.0.0.1:8080
+	wsURL      string       // WebSocket 地址，为空时通过 /api/v1/config/websocket 推导
+	token      string       // Bearer Token
+	httpClient *http.Client // HTTP 客户端
+}
+
+// Option 客户端选项
+type Option func(*Client)
+
+// WithToken 设置 Bearer Token
+func WithToken(token string) Option {
+	return func(c *Client) {
+		c.token = token
+	}
+}
+
+// WithHTTPClient 设置自定义 HTTP 客户端
+func WithHTTPClient(httpClient *http.Client) Option {
+	return func(c *Client) {
+		c.httpClient = httpClient
+	}
+}
+
+// WithWebSocketURL 设置 WebSocket 地址，如 ws://127.0.0.1:7777
+func WithWebSocketURL(wsURL string) Option {
+	return func(c *Client) {
+		c.wsURL = strings.TrimRight(wsURL, "/")
+	}
+}
+
+// New 创建客户端，baseURL 为 API 服务地址
+func New(baseURL string, opts ...Option) *Client {
+	c := &Client{
+		baseURL:    strings.TrimRight(baseURL, "/"),
+		httpClient: &http.Client{Timeout: 30 * time.Second},
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	return c
+}
+
+// APIError 接口返回的错误
+type APIError struct {
+	StatusCode int    // HTTP 状态码
+	Message    string // 响应中的 message
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("unibarrage: %d %s", e.StatusCode, e.Message)
+}
+
+// ServiceStatus 服务状态
+type ServiceStatus struct {
+	Platform  uni.Platform `json:"platform"`
+	RoomID    string       `json:"rid"`
+	State     string       `json:"state"`           // running / failed
+	Error     string       `json:"error,omitempty"` // 失败原因
+	Debug     bool         `json:"debug"`           // 是否开启上游帧抓包
+	Persist   bool         `json:"persist"`         // 是否持久化
+	StartedAt time.Time    `json:"startedAt"`       // 启动时间
+	Healthy   bool         `json:"healthy"`         // 是否在期限内收到过上游帧
+	LastFrame *time.Time   `json:"lastFrame"`       // 最近一次收到上游帧的时间
+}
+
+// ServiceKey 服务标识
+type ServiceKey struct {
+	Platform uni.Platform `json:"platform"`
+	RoomID   string       `json:"rid"`
+}
+
+// StartOptions 启动服务的可选参数
+type StartOptions struct {
+	Cookie  string // 登录 cookie
+	Debug   bool   // 是否开启上游帧抓包
+	Persist *bool  // 是否持久化，为 nil 时使用服务端默认值 (true)
+}
+
+// 统一响应结构
+type response struct {
+	Code    int             `json:"code"`
+	Message string          `json:"message"`
+	Data    json.RawMessage `json:"data"`
+}
+
+// StartService 启动房间监听服务
+func (c *Client) StartService(ctx context.Context, platform uni.Platform, rid string, opts *StartOptions) (*ServiceKey, error) {
+	body := map[string]interface{}{"rid": rid}
+	if opts != nil {
+		if opts.Cookie != "" {
+			body["cookie"] = opts.Cookie
+		}
+		if opts.Debug {
+			body["debug"] = true
+		}
+		if opts.Persist != nil {
+			body["persist"] = *opts.Persist
+		}
+	}
+
+	var key ServiceKey
+	if err := c.do(ctx, http.MethodPost, "/api/v1/"+url.PathEscape(string(platform)), body, &key); err != nil {
+		return nil, err
+	}
+	return &key, nil
+}
+
+// StopService 停止房间监听服务
+func (c *Client) StopService(ctx context.Context, platform uni.Platform, rid string) error {
+	return c.do(ctx, http.MethodDelete, servicePath(platform, rid), nil, nil)
+}
+
+// GetService 获取单个服务状态
+func (c *Client) GetService(ctx context.Context, platform uni.Platform, rid string) (*ServiceStatus, error) {
+	var status ServiceStatus
+	if err := c.do(ctx, http.MethodGet, servicePath(platform, rid), nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// GetStats 获取房间的实时统计
+func (c *Client) GetStats(ctx context.Context, platform uni.Platform, rid string) (*uni.StatsMessage, error) {
+	var stats uni.StatsMessage
+	if err := c.do(ctx, http.MethodGet, servicePath(platform, rid)+"/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// ListAllServices 获取所有服务状态
+func (c *Client) ListAllServices(ctx context.Context) ([]ServiceStatus, error) {
+	var services []ServiceStatus
+	if err := c.do(ctx, http.MethodGet, "/api/v1/all", nil, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// ListPlatformServices 获取指定平台的所有服务
+func (c *Client) ListPlatformServices(ctx context.Context, platform uni.Platform) ([]ServiceStatus, error) {
+	var services []ServiceStatus
+	if err := c.do(ctx, http.MethodGet, "/api/v1/"+url.PathEscape(string(platform)), nil, &services); err != nil {
+		return nil, err
+	}
+	return services, nil
+}
+
+// 服务路径 /api/v1/{platform}/{rid}
+func servicePath(platform uni.Platform, rid string) string {
+	return "/api/v1/" + url.PathEscape(string(platform)) + "/" + url.PathEscape(rid)
+}
+
+// 发送请求并将响应中的 data 解析到 out，非 2xx 响应返回 *APIError
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var reader io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, reader)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var r response
+	if err := json.NewDecoder(resp.Body).Decode(&r); err != nil {
+		return &APIError{StatusCode: resp.StatusCode, Message: fmt.Sprintf("无法解析响应: %v", err)}
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &APIError{StatusCode: resp.StatusCode, Message: r.Message}
+	}
+	if out == nil || len(r.Data) == 0 {
+		return nil
+	}
+	return json.Unmarshal(r.Data, out)
+}
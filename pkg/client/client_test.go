@@ -0,0 +1,157 @@
+package client
+
+import (
+	uni "UniBarrage/universal"
+	"context"
+	"errors"
+	"github.com/goccy/go-json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 测试用 API 服务：记录收到的请求，按路由返回统一响应结构
+type fakeAPI struct {
+	t        *testing.T
+	requests []*http.Request
+	bodies   []map[string]interface{}
+	routes   map[string]func() (int, string, interface{})
+}
+
+func newFakeAPI(t *testing.T) (*fakeAPI, *httptest.Server) {
+	f := &fakeAPI{t: t, routes: make(map[string]func() (int, string, interface{}))}
+	srv := httptest.NewServer(f)
+	t.Cleanup(srv.Close)
+	return f, srv
+}
+
+// 注册路由，key 为 "METHOD /path"
+func (f *fakeAPI) handle(route string, status int, message string, data interface{}) {
+	f.routes[route] = func() (int, string, interface{}) { return status, message, data }
+}
+
+func (f *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var body map[string]interface{}
+	if r.Body != nil {
+		_ = json.NewDecoder(r.Body).Decode(&body)
+	}
+	f.requests = append(f.requests, r)
+	f.bodies = append(f.bodies, body)
+
+	route, ok := f.routes[r.Method+" "+r.URL.EscapedPath()]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": 404, "message": "未找到"})
+		return
+	}
+	status, message, data := route()
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(map[string]interface{}{"code": status, "message": message, "data": data})
+}
+
+// 最后一次请求
+func (f *fakeAPI) last() (*http.Request, map[string]interface{}) {
+	f.t.Helper()
+	if len(f.requests) == 0 {
+		f.t.Fatal("no request received")
+	}
+	return f.requests[len(f.requests)-1], f.bodies[len(f.bodies)-1]
+}
+
+func TestStartServiceSendsOptions(t *testing.T) {
+	api, srv := newFakeAPI(t)
+	api.handle("POST /api/v1/bilibili", http.StatusCreated, "服务启动成功", map[string]string{"platform": "bilibili", "rid": "123"})
+	c := New(srv.URL+"/", WithToken("secret"))
+
+	persist := false
+	key, err := c.StartService(context.Background(), uni.BiliBili, "123", &StartOptions{Cookie: "SESSDATA=1", Debug: true, Persist: &persist})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key.Platform != uni.BiliBili || key.RoomID != "123" {
+		t.Errorf("key=%+v", key)
+	}
+
+	req, body := api.last()
+	if got := req.Header.Get("Authorization"); got != "Bearer secret" {
+		t.Errorf("Authorization=%q", got)
+	}
+	if got := req.Header.Get("Content-Type"); got != "application/json" {
+		t.Errorf("Content-Type=%q", got)
+	}
+	if body["rid"] != "123" || body["cookie"] != "SESSDATA=1" || body["debug"] != true || body["persist"] != false {
+		t.Errorf("body=%v", body)
+	}
+
+	// 未设置的选项不发送，由服务端使用默认值
+	if _, err := c.StartService(context.Background(), uni.BiliBili, "123", nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, body := api.last(); len(body) != 1 {
+		t.Errorf("body without options=%v", body)
+	}
+}
+
+func TestServiceQueries(t *testing.T) {
+	api, srv := newFakeAPI(t)
+	running := ServiceStatus{Platform: uni.DouYin, RoomID: "a b", State: "running", Healthy: true}
+	api.handle("GET /api/v1/douyin/a%20b", http.StatusOK, "获取成功", running)
+	api.handle("GET /api/v1/douyin/a%20b/stats", http.StatusOK, "获取成功", &uni.StatsMessage{Chats: 42})
+	api.handle("GET /api/v1/all", http.StatusOK, "获取成功", []ServiceStatus{running, {Platform: uni.HuYa, RoomID: "1", State: "failed", Error: "boom"}})
+	api.handle("GET /api/v1/douyin", http.StatusOK, "获取成功", []ServiceStatus{running})
+	api.handle("DELETE /api/v1/douyin/a%20b", http.StatusOK, "服务已停止", nil)
+	c := New(srv.URL)
+	ctx := context.Background()
+
+	status, err := c.GetService(ctx, uni.DouYin, "a b")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.RoomID != "a b" || status.State != "running" || !status.Healthy {
+		t.Errorf("status=%+v", status)
+	}
+	if req, _ := api.last(); req.Header.Get("Authorization") != "" {
+		t.Error("Authorization sent without token")
+	}
+
+	stats, err := c.GetStats(ctx, uni.DouYin, "a b")
+	if err != nil || stats.Chats != 42 {
+		t.Errorf("stats=%+v err=%v", stats, err)
+	}
+
+	all, err := c.ListAllServices(ctx)
+	if err != nil || len(all) != 2 || all[1].Error != "boom" {
+		t.Errorf("all=%+v err=%v", all, err)
+	}
+
+	douyin, err := c.ListPlatformServices(ctx, uni.DouYin)
+	if err != nil || len(douyin) != 1 {
+		t.Errorf("douyin=%+v err=%v", douyin, err)
+	}
+
+	if err := c.StopService(ctx, uni.DouYin, "a b"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestAPIError(t *testing.T) {
+	api, srv := newFakeAPI(t)
+	api.handle("DELETE /api/v1/huya/1", http.StatusNotFound, "服务未找到", nil)
+	c := New(srv.URL)
+
+	err := c.StopService(context.Background(), uni.HuYa, "1")
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound || apiErr.Message != "服务未找到" {
+		t.Fatalf("err=%v", err)
+	}
+
+	// 响应不是 JSON 时同样返回 APIError
+	bad := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "bad gateway", http.StatusBadGateway)
+	}))
+	defer bad.Close()
+	if _, err := New(bad.URL).ListAllServices(context.Background()); !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusBadGateway {
+		t.Fatalf("err=%v", err)
+	}
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	uni "UniBarrage/universal"
+	"context"
+	"fmt"
+	"github.com/goccy/go-json"
+	"github.com/gorilla/websocket"
+	"net/http"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// 客户端心跳间隔
+const pingInterval = 30 * time.Second
+
+// Message WebSocket 推送的消息，Data 保留原始 JSON，可通过 Decode 解析为具体类型
+type Message struct {
+	RID      string          `json:"rid"`
+	Platform uni.Platform    `json:"platform"`
+	Type     uni.MessageType `json:"type"`
+	Data     json.RawMessage `json:"data"`
+}
+
+// Decode 按消息类型解析 Data，返回 *uni.ChatMessage、*uni.GiftMessage 等
+func (m *Message) Decode() (uni.MessageData, error) {
+	data, err := uni.NewMessageData(m.Type)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(m.Data, data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Subscription WebSocket 订阅，Messages 关闭后可通过 Err 获取断开原因
+type Subscription struct {
+	Messages <-chan *Message
+
+	conn      *websocket.Conn
+	err       error
+	done      chan struct{}
+	closeOnce sync.Once
+}
+
+// Subscribe 订阅弹幕推送，platform 为空时订阅所有平台，rid 为空时订阅平台下所有房间；
+// ctx 取消或调用 Close 后连接关闭
+func (c *Client) Subscribe(ctx context.Context, platform uni.Platform, rid string) (*Subscription, error) {
+	path := "/"
+	if platform != "" {
+		path += url.PathEscape(string(platform))
+		if rid != "" {
+			path += "/" + url.PathEscape(rid)
+		}
+	}
+	return c.subscribe(ctx, path)
+}
+
+// SubscribeGroup 订阅房间组的合并消息流
+func (c *Client) SubscribeGroup(ctx context.Context, groupID string) (*Subscription, error) {
+	return c.subscribe(ctx, "/group/"+url.PathEscape(groupID))
+}
+
+// 连接 WebSocket 服务的指定路径
+func (c *Client) subscribe(ctx context.Context, path string) (*Subscription, error) {
+	base, err := c.webSocketURL(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	conn, _, err := websocket.DefaultDialer.DialContext(ctx, base+path, nil)
+	if err != nil {
+		return nil, fmt.Errorf("连接 WebSocket 失败: %w", err)
+	}
+
+	messages := make(chan *Message, 256)
+	sub := &Subscription{
+		Messages: messages,
+		conn:     conn,
+		done:     make(chan struct{}),
+	}
+
+	go sub.keepalive(ctx)
+	go sub.read(ctx, messages)
+	return sub, nil
+}
+
+// Close 关闭订阅
+func (s *Subscription) Close() error {
+	var err error
+	s.closeOnce.Do(func() {
+		close(s.done)
+		_ = s.conn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(websocket.CloseNormalClosure, ""), time.Now().Add(time.Second))
+		err = s.conn.Close()
+	})
+	return err
+}
+
+// Err 连接异常断开的原因，主动关闭或正常关闭时为 nil，需在 Messages 关闭后调用
+func (s *Subscription) Err() error {
+	return s.err
+}
+
+// 读取消息直到连接断开
+func (s *Subscription) read(ctx context.Context, messages chan<- *Message) {
+	defer close(messages)
+	defer s.Close()
+
+	for {
+		_, data, err := s.conn.ReadMessage()
+		if err != nil {
+			select {
+			case <-s.done:
+			default:
+				if !websocket.IsCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+					s.err = err
+				}
+			}
+			return
+		}
+		if string(data) == "pong" {
+			continue
+		}
+
+		var msg Message
+		if err := json.Unmarshal(data, &msg); err != nil {
+			continue
+		}
+		select {
+		case messages <- &msg:
+		case <-ctx.Done():
+			return
+		case <-s.done:
+			return
+		}
+	}
+}
+
+// 定时发送 ping，ctx 取消时关闭连接
+func (s *Subscription) keepalive(ctx context.Context) {
+	ticker := time.NewTicker(pingInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			_ = s.Close()
+			return
+		case <-s.done:
+			return
+		case <-ticker.C:
+			_ = s.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
+			if err := s.conn.WriteMessage(websocket.TextMessage, []byte("ping")); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// 获取 WebSocket 地址，未配置时使用 API 地址的主机和 /api/v1/config/websocket 返回的端口与路径
+func (c *Client) webSocketURL(ctx context.Context) (string, error) {
+	if c.wsURL != "" {
+		return c.wsURL, nil
+	}
+
+	var config struct {
+		Port int    `json:"ws_port"`
+		Path string `json:"ws_path"` // 单端口模式下为 /ws
+	}
+	if err := c.do(ctx, http.MethodGet, "/api/v1/config/websocket", nil, &config); err != nil {
+		return "", err
+	}
+
+	u, err := url.Parse(c.baseURL)
+	if err != nil {
+		return "", err
+	}
+	scheme := "ws"
+	if u.Scheme == "https" {
+		scheme = "wss"
+	}
+	return fmt.Sprintf("%s://%s:%d%s", scheme, u.Hostname(), config.Port, config.Path), nil
+}
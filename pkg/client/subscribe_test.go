@@ -0,0 +1,217 @@
+package client
+
+import (
+	uni "UniBarrage/universal"
+	"context"
+	"github.com/goccy/go-json"
+	"github.com/gorilla/websocket"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用 WebSocket 服务：记录连接路径，连接后推送 frames，客户端关闭后通知 closed
+func wsHandler(t *testing.T, paths chan<- string, closed chan<- struct{}, frames ...string) http.HandlerFunc {
+	upgrader := websocket.Upgrader{}
+	return func(w http.ResponseWriter, r *http.Request) {
+		conn, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			t.Errorf("upgrade: %v", err)
+			return
+		}
+		defer conn.Close()
+		paths <- r.URL.EscapedPath()
+		for _, frame := range frames {
+			if err := conn.WriteMessage(websocket.TextMessage, []byte(frame)); err != nil {
+				return
+			}
+		}
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				if closed != nil {
+					close(closed)
+				}
+				return
+			}
+		}
+	}
+}
+
+func receiveMessage(t *testing.T, sub *Subscription) *Message {
+	t.Helper()
+	select {
+	case msg, ok := <-sub.Messages:
+		if !ok {
+			t.Fatalf("messages closed: %v", sub.Err())
+		}
+		return msg
+	case <-time.After(2 * time.Second):
+		t.Fatal("no message received")
+		return nil
+	}
+}
+
+func waitClosed(t *testing.T, sub *Subscription) {
+	t.Helper()
+	for {
+		select {
+		case _, ok := <-sub.Messages:
+			if !ok {
+				return
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("messages not closed")
+		}
+	}
+}
+
+func TestWebSocketURLDiscovery(t *testing.T) {
+	cases := []struct {
+		base string
+		path string
+		want string
+	}{
+		{"http://127.0.0.1:8080", "", "ws://127.0.0.1:7777"},
+		{"https://barrage.example:8443/", "/ws", "wss://barrage.example:7777/ws"},
+	}
+	for _, tc := range cases {
+		api, srv := newFakeAPI(t)
+		api.handle("GET /api/v1/config/websocket", http.StatusOK, "获取成功", map[string]interface{}{"ws_port": 7777, "ws_path": tc.path})
+		c := New(tc.base, WithHTTPClient(&http.Client{Transport: rewriteHost(srv.URL)}))
+
+		got, err := c.webSocketURL(context.Background())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("webSocketURL(%s, %q) = %s, want %s", tc.base, tc.path, got, tc.want)
+		}
+	}
+
+	// 显式设置的地址不请求接口
+	api, srv := newFakeAPI(t)
+	c := New(srv.URL, WithWebSocketURL("ws://ws.example:9000/"))
+	if got, err := c.webSocketURL(context.Background()); err != nil || got != "ws://ws.example:9000" {
+		t.Errorf("got %s %v", got, err)
+	}
+	if len(api.requests) != 0 {
+		t.Errorf("unexpected requests: %d", len(api.requests))
+	}
+}
+
+// 将请求转发到测试服务，用于模拟任意 API 地址
+type rewriteHost string
+
+func (h rewriteHost) RoundTrip(req *http.Request) (*http.Response, error) {
+	target, _ := url.Parse(string(h))
+	req = req.Clone(req.Context())
+	req.URL.Scheme, req.URL.Host = target.Scheme, target.Host
+	return http.DefaultTransport.RoundTrip(req)
+}
+
+// 单端口模式下 API 与 WebSocket 共用同一端口，WebSocket 挂载在 /ws 下
+func TestSubscribeSinglePort(t *testing.T) {
+	chat, _ := json.Marshal(&uni.UniMessage{RID: "1", Platform: uni.BiliBili, Type: uni.ChatMessageType, Data: &uni.ChatMessage{Name: "a", Content: "hi"}})
+	gift, _ := json.Marshal(&uni.UniMessage{RID: "1", Platform: uni.BiliBili, Type: uni.GiftMessageType, Data: &uni.GiftMessage{Item: "小花花", Num: 2}})
+
+	paths := make(chan string, 1)
+	closed := make(chan struct{})
+	api := &fakeAPI{t: t, routes: make(map[string]func() (int, string, interface{}))}
+	mux := http.NewServeMux()
+	mux.Handle("/api/", api)
+	mux.Handle("/ws/", wsHandler(t, paths, closed, "pong", "not json", string(chat), string(gift)))
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	port := srv.Listener.Addr().(*net.TCPAddr).Port
+	api.handle("GET /api/v1/config/websocket", http.StatusOK, "获取成功", map[string]interface{}{"ws_port": port, "ws_path": "/ws"})
+
+	sub, err := New(srv.URL).Subscribe(context.Background(), uni.BiliBili, "1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-paths; got != "/ws/bilibili/1" {
+		t.Errorf("path=%s", got)
+	}
+
+	// pong 与无法解析的帧被忽略
+	msg := receiveMessage(t, sub)
+	data, err := msg.Decode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c, ok := data.(*uni.ChatMessage); !ok || c.Content != "hi" || msg.Platform != uni.BiliBili || msg.RID != "1" {
+		t.Fatalf("msg=%+v data=%+v", msg, data)
+	}
+	msg = receiveMessage(t, sub)
+	if data, err := msg.Decode(); err != nil || data.(*uni.GiftMessage).Num != 2 {
+		t.Fatalf("gift=%+v err=%v", data, err)
+	}
+
+	// 主动关闭后通道关闭，Err 为 nil，服务端收到关闭帧
+	if err := sub.Close(); err != nil {
+		t.Fatal(err)
+	}
+	waitClosed(t, sub)
+	if sub.Err() != nil {
+		t.Errorf("Err after Close = %v", sub.Err())
+	}
+	select {
+	case <-closed:
+	case <-time.After(2 * time.Second):
+		t.Error("server did not observe close")
+	}
+}
+
+func TestSubscribeGroupAndContextCancel(t *testing.T) {
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(wsHandler(t, paths, nil))
+	defer srv.Close()
+	wsURL := "ws" + strings.TrimPrefix(srv.URL, "http")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	sub, err := New("http://unused", WithWebSocketURL(wsURL)).SubscribeGroup(ctx, "show 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-paths; got != "/group/show%201" {
+		t.Errorf("path=%s", got)
+	}
+
+	// ctx 取消时关闭连接
+	cancel()
+	waitClosed(t, sub)
+	if sub.Err() != nil {
+		t.Errorf("Err after cancel = %v", sub.Err())
+	}
+}
+
+func TestSubscribeServerDisconnect(t *testing.T) {
+	paths := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, err := (&websocket.Upgrader{}).Upgrade(w, r, nil)
+		if err != nil {
+			return
+		}
+		paths <- r.URL.Path
+		// 异常断开：不发送关闭帧直接关闭连接
+		conn.Close()
+	}))
+	defer srv.Close()
+
+	sub, err := New("http://unused", WithWebSocketURL("ws"+strings.TrimPrefix(srv.URL, "http"))).Subscribe(context.Background(), "", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := <-paths; got != "/" {
+		t.Errorf("path=%s", got)
+	}
+	waitClosed(t, sub)
+	if sub.Err() == nil {
+		t.Error("expected error after abnormal disconnect")
+	}
+}
@@ -0,0 +1,198 @@
+// Package command 将以命令前缀开头的弹幕解析为 Command 消息，并分发给注册的处理函数。
+// 各平台的弹幕使用同一套解析规则，例如哔哩哔哩和抖音的 "!vote 2" 都会得到 command=vote、args=["2"]
+package command
+
+import (
+	uni "UniBarrage/universal"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 冷却记录超过该数量时清理已过期的记录
+const cooldownPruneSize = 4096
+
+// Config 命令配置
+type Config struct {
+	Prefix    string                   `yaml:"prefix"`    // 命令前缀，为空时不解析命令
+	Commands  []string                 `yaml:"commands"`  // 仅解析这些命令，为空时解析所有命令
+	Cooldown  time.Duration            `yaml:"cooldown"`  // 同一用户在同一房间重复使用同一命令的冷却时间
+	Cooldowns map[string]time.Duration `yaml:"cooldowns"` // 按命令覆盖冷却时间
+}
+
+// Handler 命令处理函数，返回的消息作为响应发布
+type Handler interface {
+	Handle(cmd *uni.UniMessage) []*uni.UniMessage
+}
+
+// HandlerFunc 函数形式的 Handler
+type HandlerFunc func(cmd *uni.UniMessage) []*uni.UniMessage
+
+// Handle 实现 Handler
+func (f HandlerFunc) Handle(cmd *uni.UniMessage) []*uni.UniMessage {
+	return f(cmd)
+}
+
+// Processor 命令处理器，在弹幕通过消息处理链并发布之后调用，返回解析出的 Command 消息和处理函数的响应
+type Processor struct {
+	mu        sync.RWMutex
+	cfg       Config
+	allowed   map[string]bool      // 为空时允许所有命令
+	handlers  map[string][]Handler // 命令名称 -> 处理函数
+	fallbacks []Handler            // 对所有命令生效的处理函数
+
+	cooldownMu sync.Mutex
+	cooldowns  map[string]time.Time // 冷却键 -> 冷却结束时间
+}
+
+// New 创建命令处理器，需调用 Load 设置命令前缀
+func New() *Processor {
+	return &Processor{
+		handlers:  make(map[string][]Handler),
+		cooldowns: make(map[string]time.Time),
+	}
+}
+
+// Load 更新命令配置，已注册的处理函数和冷却记录保留
+func (p *Processor) Load(cfg Config) {
+	var allowed map[string]bool
+	if len(cfg.Commands) > 0 {
+		allowed = make(map[string]bool, len(cfg.Commands))
+		for _, name := range cfg.Commands {
+			allowed[normalizeName(name)] = true
+		}
+	}
+	cooldowns := make(map[string]time.Duration, len(cfg.Cooldowns))
+	for name, d := range cfg.Cooldowns {
+		cooldowns[normalizeName(name)] = d
+	}
+	cfg.Cooldowns = cooldowns
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.cfg = cfg
+	p.allowed = allowed
+}
+
+// Register 为命令注册处理函数，同一命令可注册多个处理函数，按注册顺序调用
+func (p *Processor) Register(name string, handler Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	name = normalizeName(name)
+	p.handlers[name] = append(p.handlers[name], handler)
+}
+
+// Use 注册对所有命令生效的处理函数，如消息脚本
+func (p *Processor) Use(handler Handler) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fallbacks = append(p.fallbacks, handler)
+}
+
+// Process 解析弹幕中的命令，返回 Command 消息及其后处理函数的响应，调用方应在发布原弹幕之后按顺序发布；
+// 不是命令或命令处于冷却中时返回 nil
+func (p *Processor) Process(msg *uni.UniMessage) []*uni.UniMessage {
+	chat, ok := msg.Data.(*uni.ChatMessage)
+	if !ok {
+		return nil
+	}
+
+	p.mu.RLock()
+	cfg, allowed := p.cfg, p.allowed
+	p.mu.RUnlock()
+	if cfg.Prefix == "" {
+		return nil
+	}
+
+	name, args, ok := Parse(cfg.Prefix, chat.Content)
+	if !ok || (allowed != nil && !allowed[name]) {
+		return nil
+	}
+
+	user := uni.UserKey(msg.Platform, chat.UID, chat.Name)
+	cooldown := cfg.Cooldown
+	if d, ok := cfg.Cooldowns[name]; ok {
+		cooldown = d
+	}
+	if !p.acquire(strings.Join([]string{msg.RID, user, name}, "|"), cooldown, time.Now()) {
+		return nil
+	}
+
+	cmd := &uni.UniMessage{
+		RID:      msg.RID,
+		Platform: msg.Platform,
+		Type:     uni.CommandMessageType,
+		Data: &uni.CommandMessage{
+			Name:    chat.Name,
+			Avatar:  chat.Avatar,
+			User:    user,
+			Command: name,
+			Args:    args,
+			Content: chat.Content,
+			Raw:     chat.Raw,
+		},
+	}
+	return append([]*uni.UniMessage{cmd}, p.dispatch(cmd, name)...)
+}
+
+// 调用命令的处理函数，收集响应
+func (p *Processor) dispatch(cmd *uni.UniMessage, name string) []*uni.UniMessage {
+	p.mu.RLock()
+	handlers := append(append([]Handler(nil), p.handlers[name]...), p.fallbacks...)
+	p.mu.RUnlock()
+
+	var responses []*uni.UniMessage
+	for _, handler := range handlers {
+		for _, response := range handler.Handle(cmd) {
+			if response != nil {
+				responses = append(responses, response)
+			}
+		}
+	}
+	return responses
+}
+
+// 检查并记录冷却，冷却中返回 false
+func (p *Processor) acquire(key string, cooldown time.Duration, now time.Time) bool {
+	if cooldown <= 0 {
+		return true
+	}
+
+	p.cooldownMu.Lock()
+	defer p.cooldownMu.Unlock()
+
+	if until, ok := p.cooldowns[key]; ok && now.Before(until) {
+		return false
+	}
+	if len(p.cooldowns) >= cooldownPruneSize {
+		for k, until := range p.cooldowns {
+			if !now.Before(until) {
+				delete(p.cooldowns, k)
+			}
+		}
+	}
+	p.cooldowns[key] = now.Add(cooldown)
+	return true
+}
+
+// Parse 解析以 prefix 开头的弹幕，返回小写的命令名称和以空白分隔的参数
+func Parse(prefix string, content string) (name string, args []string, ok bool) {
+	content = strings.TrimSpace(content)
+	if prefix == "" || !strings.HasPrefix(content, prefix) {
+		return "", nil, false
+	}
+	fields := strings.Fields(strings.TrimPrefix(content, prefix))
+	if len(fields) == 0 {
+		return "", nil, false
+	}
+	name = normalizeName(fields[0])
+	if strings.HasPrefix(name, prefix) {
+		// "!!!" 之类的弹幕不是命令
+		return "", nil, false
+	}
+	return name, fields[1:], true
+}
+
+func normalizeName(name string) string {
+	return strings.ToLower(strings.TrimSpace(name))
+}
@@ -0,0 +1,99 @@
+package command
+
+import (
+	uni "UniBarrage/universal"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func chat(platform uni.Platform, uid string, name string, content string) *uni.UniMessage {
+	msg, _ := uni.CreateUniMessage("1", platform, uni.ChatMessageType, &uni.ChatMessage{Name: name, UID: uid, Content: content})
+	return msg
+}
+
+func TestParse(t *testing.T) {
+	cases := []struct {
+		content string
+		name    string
+		args    []string
+		ok      bool
+	}{
+		{"!vote 2", "vote", []string{"2"}, true},
+		{"  !Vote   a  b ", "vote", []string{"a", "b"}, true},
+		{"!ping", "ping", []string{}, true},
+		{"vote 2", "", nil, false},
+		{"!", "", nil, false},
+		{"!!!", "", nil, false},
+	}
+	for _, c := range cases {
+		name, args, ok := Parse("!", c.content)
+		if ok != c.ok || name != c.name || (ok && !reflect.DeepEqual(args, c.args)) {
+			t.Errorf("Parse(%q) = %q, %q, %v", c.content, name, args, ok)
+		}
+	}
+}
+
+func TestProcessReturnsCommandAndResponses(t *testing.T) {
+	p := New()
+	p.Load(Config{Prefix: "!", Commands: []string{"vote"}})
+	p.Register("VOTE", HandlerFunc(func(cmd *uni.UniMessage) []*uni.UniMessage {
+		data := cmd.Data.(*uni.CommandMessage)
+		return []*uni.UniMessage{chat(cmd.Platform, "", "bot", data.Name+" voted "+data.Args[0]), nil}
+	}))
+
+	for _, platform := range []uni.Platform{uni.BiliBili, uni.DouYin} {
+		out := p.Process(chat(platform, "42", " Alice ", "!vote 2"))
+		if len(out) != 2 {
+			t.Fatalf("returned %d messages, want 2", len(out))
+		}
+		cmd, response := out[0], out[1]
+		data, ok := cmd.Data.(*uni.CommandMessage)
+		if !ok || cmd.Type != uni.CommandMessageType || cmd.Platform != platform {
+			t.Fatalf("unexpected command message: %+v", cmd)
+		}
+		if data.Command != "vote" || data.User != string(platform)+":42" || data.Name != " Alice " || !reflect.DeepEqual(data.Args, []string{"2"}) {
+			t.Fatalf("unexpected command data: %+v", data)
+		}
+		if got := response.Data.(*uni.ChatMessage).Content; got != " Alice  voted 2" {
+			t.Fatalf("response=%q", got)
+		}
+	}
+	if out := p.Process(chat(uni.BiliBili, "7", "bob", "!other")); out != nil {
+		t.Errorf("disallowed command returned %+v", out)
+	}
+	if out := p.Process(chat(uni.BiliBili, "7", "bob", "hello")); out != nil {
+		t.Errorf("plain chat returned %+v", out)
+	}
+}
+
+// 冷却按平台用户 ID 计算：同一用户改名后仍在冷却中，同名的不同用户互不影响
+func TestCooldownPerUser(t *testing.T) {
+	p := New()
+	p.Load(Config{Prefix: "!", Cooldown: time.Hour, Cooldowns: map[string]time.Duration{"ping": 0}})
+
+	var count int
+	for _, msg := range []*uni.UniMessage{
+		chat(uni.BiliBili, "1", "a", "!vote 1"),
+		chat(uni.BiliBili, "1", "renamed", "!vote 2"),
+		chat(uni.BiliBili, "2", "a", "!vote 1"),
+		chat(uni.DouYin, "1", "a", "!vote 1"),
+		chat(uni.BiliBili, "1", "a", "!ping"),
+		chat(uni.BiliBili, "1", "a", "!ping"),
+		// 未提供用户 ID 时按规范化后的名称计算
+		chat(uni.DouYu, "", "Carol", "!vote 1"),
+		chat(uni.DouYu, "", " carol ", "!vote 1"),
+	} {
+		count += len(p.Process(msg))
+	}
+	if count != 6 {
+		t.Fatalf("returned %d commands, want 6", count)
+	}
+
+	if !p.acquire("k", time.Second, time.Unix(0, 0)) || p.acquire("k", time.Second, time.Unix(0, 0).Add(time.Millisecond)) {
+		t.Fatal("second use within cooldown should be rejected")
+	}
+	if !p.acquire("k", time.Second, time.Unix(1, 0)) {
+		t.Fatal("cooldown should expire")
+	}
+}
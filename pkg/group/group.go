@@ -0,0 +1,357 @@
+// Package group 将多个平台的房间合并为一个逻辑频道（房间组），用于同一场直播在多个平台同时开播的场景。
+// 成员房间的消息原样转发到房间组，并按组汇总统计；可选地对成员之间重复的结束直播事件去重，并将各成员的统计合并为一条
+package group
+
+import (
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/stats"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+// 默认的重复事件去重窗口
+const defaultWindow = 10 * time.Minute
+
+var (
+	ErrInvalidGroup = errors.New("无效的房间组") // 房间组 ID 为空或成员无效
+)
+
+// Member 房间组成员
+type Member struct {
+	Platform uni.Platform `yaml:"platform" json:"platform"` // 平台
+	RoomID   string       `yaml:"rid" json:"rid"`           // 房间 ID
+}
+
+// Key 成员唯一标识，与服务管理器中的 key 保持一致
+func (m Member) Key() string {
+	return fmt.Sprintf("%s_%s", m.Platform, m.RoomID)
+}
+
+// Config 房间组配置
+type Config struct {
+	ID                 string        `yaml:"id" json:"id"`                                 // 房间组 ID
+	Members            []Member      `yaml:"members" json:"members"`                       // 成员房间
+	SuppressDuplicates bool          `yaml:"suppressDuplicates" json:"suppressDuplicates"` // 是否对成员之间重复的结束直播事件去重，并将各成员的统计合并为一条
+	Window             time.Duration `yaml:"window" json:"-"`                              // 结束直播事件的去重窗口，窗口内只转发第一个，默认 10m
+}
+
+// Stats 房间组汇总统计，自房间组创建起累计
+type Stats struct {
+	Messages    map[uni.MessageType]int64 `json:"messages"`    // 各类型消息数
+	Members     map[string]int64          `json:"members"`     // 各成员的消息数
+	GiftValue   float64                   `json:"giftValue"`   // 礼物与醒目留言的总价值
+	Likes       int64                     `json:"likes"`       // 点赞总数
+	Suppressed  int64                     `json:"suppressed"`  // 被去重的事件数
+	LastMessage *time.Time                `json:"lastMessage"` // 最后一条消息的时间
+}
+
+// Info 房间组配置与统计
+type Info struct {
+	Config
+	Window string `json:"window,omitempty"` // 去重窗口，如 10m0s
+	Stats  Stats  `json:"stats"`
+}
+
+// Output 房间组消息的输出函数
+type Output func(groupID string, msg *uni.UniMessage)
+
+// Router 按成员房间将消息路由到房间组，实现 uni.Publisher
+type Router struct {
+	mu       sync.RWMutex
+	config   map[string]*roomGroup   // 配置文件中的房间组
+	custom   map[string]*roomGroup   // 通过 Set 添加的房间组，重新加载配置时保留，ID 相同时优先于配置文件
+	groups   map[string]*roomGroup   // 生效的房间组
+	byMember map[string][]*roomGroup // 成员 key -> 所属房间组
+	out      Output
+}
+
+type roomGroup struct {
+	cfg     Config
+	mu      sync.Mutex
+	stats   Stats
+	emitted map[uni.MessageType]time.Time // 去重类型 -> 窗口内首次转发的时间
+	pending map[string]*uni.StatsMessage  // 开启去重时各成员本轮最新的统计，由 FlushStats 合并推送
+}
+
+// New 创建房间组路由
+func New() *Router {
+	return &Router{
+		config:   make(map[string]*roomGroup),
+		custom:   make(map[string]*roomGroup),
+		groups:   make(map[string]*roomGroup),
+		byMember: make(map[string][]*roomGroup),
+	}
+}
+
+// SetOutput 设置房间组消息的输出函数
+func (r *Router) SetOutput(out Output) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.out = out
+}
+
+// Load 替换配置文件中的房间组，配置无效时保留原配置；通过 Set 添加的房间组不受影响，ID 和成员未变化的房间组保留统计
+func (r *Router) Load(cfgs []Config) error {
+	groups := make(map[string]*roomGroup, len(cfgs))
+	for _, cfg := range cfgs {
+		if err := validate(cfg); err != nil {
+			return err
+		}
+		if _, ok := groups[cfg.ID]; ok {
+			return fmt.Errorf("%w: 重复的 ID %s", ErrInvalidGroup, cfg.ID)
+		}
+		groups[cfg.ID] = nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for _, cfg := range cfgs {
+		groups[cfg.ID] = r.reuse(cfg)
+	}
+	r.config = groups
+	r.index()
+	return nil
+}
+
+// Set 添加或替换房间组，重新加载配置时保留
+func (r *Router) Set(cfg Config) error {
+	if err := validate(cfg); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.custom[cfg.ID] = r.reuse(cfg)
+	r.index()
+	return nil
+}
+
+// Delete 删除房间组，不存在时返回 false；配置文件中的房间组在重新加载配置后恢复
+func (r *Router) Delete(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if _, ok := r.groups[id]; !ok {
+		return false
+	}
+	delete(r.custom, id)
+	delete(r.config, id)
+	r.index()
+	return true
+}
+
+// Get 获取房间组配置与统计
+func (r *Router) Get(id string) (Info, bool) {
+	r.mu.RLock()
+	g, ok := r.groups[id]
+	r.mu.RUnlock()
+	if !ok {
+		return Info{}, false
+	}
+	return g.info(), true
+}
+
+// List 获取所有房间组，按 ID 排序
+func (r *Router) List() []Info {
+	r.mu.RLock()
+	groups := make([]*roomGroup, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	r.mu.RUnlock()
+
+	infos := make([]Info, 0, len(groups))
+	for _, g := range groups {
+		infos = append(infos, g.info())
+	}
+	sort.Slice(infos, func(i, j int) bool { return infos[i].ID < infos[j].ID })
+	return infos
+}
+
+// Groups 获取房间所属的房间组 ID
+func (r *Router) Groups(platform uni.Platform, rid string) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	groups := r.byMember[Member{Platform: platform, RoomID: rid}.Key()]
+	ids := make([]string, 0, len(groups))
+	for _, g := range groups {
+		ids = append(ids, g.cfg.ID)
+	}
+	return ids
+}
+
+// Publish 将成员房间的消息转发到所属的房间组
+func (r *Router) Publish(msg *uni.UniMessage) {
+	if msg == nil {
+		return
+	}
+	r.mu.RLock()
+	groups := r.byMember[Member{Platform: msg.Platform, RoomID: msg.RID}.Key()]
+	out := r.out
+	r.mu.RUnlock()
+
+	now := time.Now()
+	for _, g := range groups {
+		if g.observe(msg, now) && out != nil {
+			out(g.cfg.ID, msg)
+		}
+	}
+}
+
+// FlushStats 将开启去重的房间组本轮收到的成员统计合并为一条 Stats 消息推送，需在每轮统计发布后调用；
+// 合并后的消息 rid 为房间组 ID，platform 为空
+func (r *Router) FlushStats() {
+	r.mu.RLock()
+	groups := make([]*roomGroup, 0, len(r.groups))
+	for _, g := range r.groups {
+		groups = append(groups, g)
+	}
+	out := r.out
+	r.mu.RUnlock()
+
+	for _, g := range groups {
+		if merged := g.flush(); merged != nil && out != nil {
+			out(g.cfg.ID, &uni.UniMessage{RID: g.cfg.ID, Type: uni.StatsMessageType, Data: merged})
+		}
+	}
+}
+
+// 配置未变化时沿用原房间组以保留统计，需持有 r.mu
+func (r *Router) reuse(cfg Config) *roomGroup {
+	if g, ok := r.groups[cfg.ID]; ok && sameConfig(g.cfg, cfg) {
+		return g
+	}
+	return &roomGroup{
+		cfg: cfg,
+		stats: Stats{
+			Messages: make(map[uni.MessageType]int64),
+			Members:  make(map[string]int64),
+		},
+		emitted: make(map[uni.MessageType]time.Time),
+		pending: make(map[string]*uni.StatsMessage),
+	}
+}
+
+// 合并配置文件与 Set 添加的房间组并重建成员索引，需持有 r.mu
+func (r *Router) index() {
+	r.groups = make(map[string]*roomGroup, len(r.config)+len(r.custom))
+	for id, g := range r.config {
+		r.groups[id] = g
+	}
+	for id, g := range r.custom {
+		r.groups[id] = g
+	}
+	r.byMember = make(map[string][]*roomGroup)
+	for _, g := range r.groups {
+		for _, m := range g.cfg.Members {
+			r.byMember[m.Key()] = append(r.byMember[m.Key()], g)
+		}
+	}
+}
+
+// 更新统计并判断是否转发，被去重的消息返回 false
+func (g *roomGroup) observe(msg *uni.UniMessage, now time.Time) bool {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	// 各成员的统计消息在房间组中重复出现，开启去重时暂存，由 FlushStats 合并为一条推送
+	if msg.Type == uni.StatsMessageType {
+		if g.cfg.SuppressDuplicates {
+			if data, ok := msg.Data.(*uni.StatsMessage); ok {
+				g.pending[Member{Platform: msg.Platform, RoomID: msg.RID}.Key()] = data
+			}
+			g.stats.Suppressed++
+			return false
+		}
+		return true
+	}
+
+	if g.cfg.SuppressDuplicates && msg.Type == uni.EndLiveMessageType {
+		window := g.cfg.Window
+		if window <= 0 {
+			window = defaultWindow
+		}
+		if last, ok := g.emitted[msg.Type]; ok && now.Sub(last) < window {
+			g.stats.Suppressed++
+			return false
+		}
+		g.emitted[msg.Type] = now
+	}
+
+	g.stats.Messages[msg.Type]++
+	g.stats.Members[Member{Platform: msg.Platform, RoomID: msg.RID}.Key()]++
+	switch data := msg.Data.(type) {
+	case *uni.GiftMessage:
+		g.stats.GiftValue += data.Price
+	case *uni.SuperChatMessage:
+		g.stats.GiftValue += data.Price
+	case *uni.LikeMessage:
+		g.stats.Likes += int64(data.Count)
+	}
+	g.stats.LastMessage = &now
+	return true
+}
+
+// 合并并清空本轮暂存的成员统计，没有暂存时返回 nil
+func (g *roomGroup) flush() *uni.StatsMessage {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if len(g.pending) == 0 {
+		return nil
+	}
+	list := make([]*uni.StatsMessage, 0, len(g.pending))
+	for _, s := range g.pending {
+		list = append(list, s)
+	}
+	clear(g.pending)
+	return stats.Merge(list...)
+}
+
+// 复制配置与统计
+func (g *roomGroup) info() Info {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	stats := g.stats
+	stats.Messages = make(map[uni.MessageType]int64, len(g.stats.Messages))
+	for k, v := range g.stats.Messages {
+		stats.Messages[k] = v
+	}
+	stats.Members = make(map[string]int64, len(g.stats.Members))
+	for k, v := range g.stats.Members {
+		stats.Members[k] = v
+	}
+	info := Info{Config: g.cfg, Stats: stats}
+	if g.cfg.Window > 0 {
+		info.Window = g.cfg.Window.String()
+	}
+	return info
+}
+
+func validate(cfg Config) error {
+	if cfg.ID == "" {
+		return fmt.Errorf("%w: ID 不能为空", ErrInvalidGroup)
+	}
+	if len(cfg.Members) == 0 {
+		return fmt.Errorf("%w: %s 没有成员", ErrInvalidGroup, cfg.ID)
+	}
+	for _, m := range cfg.Members {
+		if !uni.IsValidPlatform(m.Platform) || m.RoomID == "" {
+			return fmt.Errorf("%w: %s 的成员 %s 无效", ErrInvalidGroup, cfg.ID, m.Key())
+		}
+	}
+	return nil
+}
+
+func sameConfig(a Config, b Config) bool {
+	if a.ID != b.ID || a.SuppressDuplicates != b.SuppressDuplicates || a.Window != b.Window || len(a.Members) != len(b.Members) {
+		return false
+	}
+	for i := range a.Members {
+		if a.Members[i] != b.Members[i] {
+			return false
+		}
+	}
+	return true
+}
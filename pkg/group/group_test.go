@@ -0,0 +1,182 @@
+package group
+
+import (
+	uni "UniBarrage/universal"
+	"testing"
+)
+
+func message(platform uni.Platform, rid string, msgType uni.MessageType, data uni.MessageData) *uni.UniMessage {
+	msg, _ := uni.CreateUniMessage(rid, platform, msgType, data)
+	return msg
+}
+
+func TestRouterMergesMembersAndSuppressesEndLive(t *testing.T) {
+	r := New()
+	got := make(map[string][]*uni.UniMessage)
+	r.SetOutput(func(id string, msg *uni.UniMessage) { got[id] = append(got[id], msg) })
+	err := r.Load([]Config{{
+		ID:                 "show",
+		Members:            []Member{{uni.DouYin, "1"}, {uni.BiliBili, "2"}},
+		SuppressDuplicates: true,
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r.Publish(message(uni.DouYin, "1", uni.ChatMessageType, &uni.ChatMessage{Content: "a"}))
+	r.Publish(message(uni.BiliBili, "2", uni.GiftMessageType, &uni.GiftMessage{Price: 5}))
+	r.Publish(message(uni.BiliBili, "3", uni.ChatMessageType, &uni.ChatMessage{Content: "other room"}))
+	r.Publish(message(uni.DouYin, "1", uni.EndLiveMessageType, &uni.EndLiveMessage{}))
+	r.Publish(message(uni.BiliBili, "2", uni.EndLiveMessageType, &uni.EndLiveMessage{}))
+
+	if len(got["show"]) != 3 {
+		t.Fatalf("forwarded %d messages, want 3", len(got["show"]))
+	}
+	info, ok := r.Get("show")
+	if !ok {
+		t.Fatal("group not found")
+	}
+	if info.Stats.Suppressed != 1 || info.Stats.GiftValue != 5 || info.Stats.Messages[uni.EndLiveMessageType] != 1 {
+		t.Fatalf("unexpected stats: %+v", info.Stats)
+	}
+	if info.Stats.Members["douyin_1"] != 2 || info.Stats.Members["bilibili_2"] != 1 {
+		t.Fatalf("unexpected member stats: %+v", info.Stats.Members)
+	}
+}
+
+func TestLoadKeepsStatsAndRejectsInvalid(t *testing.T) {
+	r := New()
+	cfg := Config{ID: "g", Members: []Member{{uni.HuYa, "1"}}}
+	if err := r.Load([]Config{cfg}); err != nil {
+		t.Fatal(err)
+	}
+	r.Publish(message(uni.HuYa, "1", uni.LikeMessageType, &uni.LikeMessage{Count: 3}))
+
+	invalid := [][]Config{
+		{{ID: "", Members: cfg.Members}},
+		{{ID: "x"}},
+		{{ID: "x", Members: []Member{{"nope", "1"}}}},
+		{cfg, cfg},
+	}
+	for _, cfgs := range invalid {
+		if err := r.Load(cfgs); err == nil {
+			t.Fatalf("expected error for %+v", cfgs)
+		}
+	}
+
+	if err := r.Load([]Config{cfg}); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := r.Get("g"); info.Stats.Likes != 3 {
+		t.Fatalf("stats should survive reload, got %+v", info.Stats)
+	}
+	if ids := r.Groups(uni.HuYa, "1"); len(ids) != 1 || ids[0] != "g" {
+		t.Fatalf("groups=%v", ids)
+	}
+	if !r.Delete("g") || r.Delete("g") {
+		t.Fatal("delete should succeed once")
+	}
+}
+
+func TestStatsFramesSuppressedOnlyWhenEnabled(t *testing.T) {
+	r := New()
+	var forwarded int
+	r.SetOutput(func(string, *uni.UniMessage) { forwarded++ })
+	members := []Member{{uni.DouYin, "1"}}
+	if err := r.Load([]Config{{ID: "a", Members: members}, {ID: "b", Members: members, SuppressDuplicates: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	r.Publish(message(uni.DouYin, "1", uni.StatsMessageType, &uni.StatsMessage{}))
+	if forwarded != 1 {
+		t.Fatalf("forwarded=%d, want 1", forwarded)
+	}
+	a, _ := r.Get("a")
+	b, _ := r.Get("b")
+	if len(a.Stats.Messages) != 0 || b.Stats.Suppressed != 1 {
+		t.Fatalf("stats frames should not be counted: a=%+v b=%+v", a.Stats, b.Stats)
+	}
+}
+
+func TestFlushStatsMergesMembers(t *testing.T) {
+	r := New()
+	var got []*uni.UniMessage
+	r.SetOutput(func(id string, msg *uni.UniMessage) {
+		if id == "b" {
+			got = append(got, msg)
+		}
+	})
+	members := []Member{{uni.DouYin, "1"}, {uni.BiliBili, "2"}}
+	if err := r.Load([]Config{{ID: "a", Members: members}, {ID: "b", Members: members, SuppressDuplicates: true}}); err != nil {
+		t.Fatal(err)
+	}
+
+	viewers := int64(10)
+	r.Publish(message(uni.DouYin, "1", uni.StatsMessageType, &uni.StatsMessage{Chats: 3, GiftValue: 1, Gifters: map[string]uni.StatsGifter{"douyin:x": {User: "douyin:x", Value: 1}}}))
+	r.Publish(message(uni.BiliBili, "2", uni.StatsMessageType, &uni.StatsMessage{Chats: 4, GiftValue: 2, Gifters: map[string]uni.StatsGifter{"douyin:x": {User: "douyin:x", Value: 2}}, Viewers: &viewers}))
+	if len(got) != 0 {
+		t.Fatalf("member stats forwarded before flush: %d", len(got))
+	}
+
+	r.FlushStats()
+	if len(got) != 1 {
+		t.Fatalf("forwarded %d merged stats, want 1", len(got))
+	}
+	merged := got[0].Data.(*uni.StatsMessage)
+	if got[0].RID != "b" || got[0].Type != uni.StatsMessageType || merged.Chats != 7 || merged.GiftValue != 3 ||
+		merged.Gifters["douyin:x"].Value != 3 || merged.Viewers == nil || *merged.Viewers != 10 {
+		t.Fatalf("unexpected merged stats: %+v %+v", got[0], merged)
+	}
+
+	// 本轮未收到成员统计时不推送
+	r.FlushStats()
+	if len(got) != 1 {
+		t.Fatalf("flush without pending stats forwarded %d", len(got))
+	}
+}
+
+func TestGiftValueIncludesSuperChat(t *testing.T) {
+	r := New()
+	if err := r.Load([]Config{{ID: "g", Members: []Member{{uni.BiliBili, "1"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	r.Publish(message(uni.BiliBili, "1", uni.GiftMessageType, &uni.GiftMessage{Price: 5}))
+	r.Publish(message(uni.BiliBili, "1", uni.SuperChatMessageType, &uni.SuperChatMessage{Price: 30}))
+	if info, _ := r.Get("g"); info.Stats.GiftValue != 35 {
+		t.Fatalf("giftValue=%v, want 35", info.Stats.GiftValue)
+	}
+}
+
+func TestLoadKeepsGroupsAddedBySet(t *testing.T) {
+	r := New()
+	if err := r.Load([]Config{{ID: "cfg", Members: []Member{{uni.HuYa, "1"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := r.Set(Config{ID: "api", Members: []Member{{uni.HuYa, "2"}}}); err != nil {
+		t.Fatal(err)
+	}
+	// 与配置文件同 ID 的房间组优先使用 Set 的配置
+	if err := r.Set(Config{ID: "cfg", Members: []Member{{uni.HuYa, "3"}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.Load([]Config{{ID: "cfg", Members: []Member{{uni.HuYa, "1"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := r.Get("api"); !ok {
+		t.Fatal("group added by Set removed on reload")
+	}
+	if ids := r.Groups(uni.HuYa, "3"); len(ids) != 1 || ids[0] != "cfg" {
+		t.Fatalf("groups for huya_3 = %v, want [cfg]", ids)
+	}
+	if ids := r.Groups(uni.HuYa, "1"); len(ids) != 0 {
+		t.Fatalf("config group should be shadowed, got %v", ids)
+	}
+
+	if err := r.Load(nil); err != nil {
+		t.Fatal(err)
+	}
+	if infos := r.List(); len(infos) != 2 {
+		t.Fatalf("got %d groups after loading empty config, want 2", len(infos))
+	}
+}
@@ -0,0 +1,32 @@
+package pipeline
+
+import (
+	uni "UniBarrage/universal"
+	"time"
+)
+
+// Config 处理链配置
+type Config struct {
+	Global []StageConfig `yaml:"global"` // 对所有房间生效的阶段
+	Rooms  []RoomConfig  `yaml:"rooms"`  // 仅对指定房间生效的阶段
+}
+
+// RoomConfig 房间处理链配置
+type RoomConfig struct {
+	Platform string        `yaml:"platform"` // 平台
+	RoomID   string        `yaml:"rid"`      // 房间 ID
+	Stages   []StageConfig `yaml:"stages"`   // 处理阶段
+}
+
+// StageConfig 处理阶段配置，各字段仅对使用它的阶段类型有效
+type StageConfig struct {
+	Type     string            `yaml:"type"`     // 阶段类型 (keywords / dedupe / blacklist / minGift / rateLimit 或自定义类型)
+	Types    []uni.MessageType `yaml:"types"`    // 仅对这些消息类型生效，为空时对所有类型生效
+	Words    []string          `yaml:"words"`    // keywords: 屏蔽词，不区分大小写
+	Users    []string          `yaml:"users"`    // blacklist: 屏蔽的用户名
+	Window   time.Duration     `yaml:"window"`   // dedupe: 去重时间窗口，默认 10s
+	MinValue float64           `yaml:"minValue"` // minGift: 礼物最低总价值（人民币元）
+	Rate     float64           `yaml:"rate"`     // rateLimit: 每个房间每秒允许的消息数
+	Burst    int               `yaml:"burst"`    // rateLimit: 突发容量，默认与 rate 相同
+	Options  map[string]any    `yaml:"options"`  // 自定义阶段的参数
+}
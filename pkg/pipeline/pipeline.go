@@ -0,0 +1,180 @@
+// Package pipeline 消息处理流水线，在消息分发前按顺序执行处理阶段，每个阶段可以修改、补充或丢弃消息
+package pipeline
+
+import (
+	uni "UniBarrage/universal"
+	"fmt"
+	"sync"
+)
+
+// Stage 处理阶段，返回 nil 表示丢弃消息，否则将返回的消息交给下一阶段
+type Stage interface {
+	Process(msg *uni.UniMessage) *uni.UniMessage
+}
+
+// StageFunc 将函数适配为 Stage
+type StageFunc func(msg *uni.UniMessage) *uni.UniMessage
+
+// Process 调用函数本身
+func (f StageFunc) Process(msg *uni.UniMessage) *uni.UniMessage {
+	return f(msg)
+}
+
+// Factory 根据配置创建处理阶段
+type Factory func(cfg StageConfig) (Stage, error)
+
+// 已注册的阶段类型
+var (
+	factories   = make(map[string]Factory)
+	factoriesMu sync.RWMutex
+)
+
+// RegisterStage 注册阶段类型，注册后可在配置文件中通过 type 引用；同名类型会被覆盖
+func RegisterStage(name string, factory Factory) {
+	factoriesMu.Lock()
+	defer factoriesMu.Unlock()
+	factories[name] = factory
+}
+
+// Build 根据配置创建处理阶段，配置了 types 时仅对这些消息类型生效
+func Build(cfg StageConfig) (Stage, error) {
+	factoriesMu.RLock()
+	factory, ok := factories[cfg.Type]
+	factoriesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("未知的处理阶段: %s", cfg.Type)
+	}
+
+	stage, err := factory(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("处理阶段 %s 配置错误: %w", cfg.Type, err)
+	}
+	if len(cfg.Types) > 0 {
+		stage = OnlyTypes(stage, cfg.Types...)
+	}
+	return stage, nil
+}
+
+// OnlyTypes 仅对指定类型的消息执行 stage，其他消息直接放行
+func OnlyTypes(stage Stage, types ...uni.MessageType) Stage {
+	allowed := make(map[uni.MessageType]bool, len(types))
+	for _, t := range types {
+		allowed[t] = true
+	}
+	return StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		if !allowed[msg.Type] {
+			return msg
+		}
+		return stage.Process(msg)
+	})
+}
+
+// Pipeline 按全局和房间组织的处理链：依次执行全局阶段和该房间的阶段，
+// 每组中先执行配置文件创建的阶段，再执行通过 Use/UseRoom 添加的阶段，最后执行通过 UseFinal 添加的阶段
+type Pipeline struct {
+	mu           sync.RWMutex
+	global       []Stage            // 配置文件中的全局阶段
+	rooms        map[string][]Stage // 配置文件中的房间阶段
+	customGlobal []Stage            // Go 代码添加的全局阶段
+	customRooms  map[string][]Stage // Go 代码添加的房间阶段
+	final        []Stage            // 所有全局和房间阶段之后执行的阶段
+}
+
+// New 创建空的处理链
+func New() *Pipeline {
+	return &Pipeline{
+		rooms:       make(map[string][]Stage),
+		customRooms: make(map[string][]Stage),
+	}
+}
+
+// 生成房间唯一标识
+func roomKey(platform uni.Platform, rid string) string {
+	return fmt.Sprintf("%s_%s", platform, rid)
+}
+
+// Use 追加全局阶段，重新加载配置时保留
+func (p *Pipeline) Use(stages ...Stage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.customGlobal = append(p.customGlobal, stages...)
+}
+
+// UseFinal 追加在所有全局和房间阶段之后执行的阶段，只收到未被过滤的消息，重新加载配置时保留
+func (p *Pipeline) UseFinal(stages ...Stage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.final = append(p.final, stages...)
+}
+
+// UseRoom 为指定房间追加阶段，重新加载配置时保留
+func (p *Pipeline) UseRoom(platform uni.Platform, rid string, stages ...Stage) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	key := roomKey(platform, rid)
+	p.customRooms[key] = append(p.customRooms[key], stages...)
+}
+
+// Load 按配置重建配置文件中的阶段，任一阶段创建失败时保留原有配置；
+// 重建后去重记录和限流状态会被清空
+func (p *Pipeline) Load(cfg Config) error {
+	global, err := buildStages(cfg.Global)
+	if err != nil {
+		return err
+	}
+	rooms := make(map[string][]Stage, len(cfg.Rooms))
+	for _, room := range cfg.Rooms {
+		stages, err := buildStages(room.Stages)
+		if err != nil {
+			return fmt.Errorf("房间 %s (%s): %w", room.Platform, room.RoomID, err)
+		}
+		key := roomKey(uni.Platform(room.Platform), room.RoomID)
+		rooms[key] = append(rooms[key], stages...)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.global = global
+	p.rooms = rooms
+	return nil
+}
+
+// 依次创建阶段
+func buildStages(configs []StageConfig) ([]Stage, error) {
+	stages := make([]Stage, 0, len(configs))
+	for _, cfg := range configs {
+		stage, err := Build(cfg)
+		if err != nil {
+			return nil, err
+		}
+		stages = append(stages, stage)
+	}
+	return stages, nil
+}
+
+// Process 依次执行各阶段，任一阶段丢弃消息时返回 nil
+func (p *Pipeline) Process(msg *uni.UniMessage) *uni.UniMessage {
+	key := roomKey(msg.Platform, msg.RID)
+
+	p.mu.RLock()
+	groups := [5][]Stage{p.global, p.customGlobal, p.rooms[key], p.customRooms[key], p.final}
+	p.mu.RUnlock()
+
+	for _, stages := range groups {
+		for _, stage := range stages {
+			if msg = stage.Process(msg); msg == nil {
+				return nil
+			}
+		}
+	}
+	return msg
+}
+
+// Publisher 返回先经过处理链再发布到 next 的 Publisher
+func (p *Pipeline) Publisher(next uni.Publisher) uni.Publisher {
+	return uni.PublisherFunc(func(msg *uni.UniMessage) {
+		if msg = p.Process(msg); msg != nil {
+			next.Publish(msg)
+		}
+	})
+}
@@ -0,0 +1,151 @@
+package pipeline
+
+import (
+	uni "UniBarrage/universal"
+	"gopkg.in/yaml.v3"
+	"testing"
+	"time"
+)
+
+func chat(rid string, name string, content string) *uni.UniMessage {
+	msg, _ := uni.CreateUniMessage(rid, uni.BiliBili, uni.ChatMessageType, &uni.ChatMessage{Name: name, Content: content})
+	return msg
+}
+
+func gift(rid string, price float64) *uni.UniMessage {
+	return gifts(rid, 1, price)
+}
+
+// price 为本条消息的总价，不随数量再相乘
+func gifts(rid string, num int, price float64) *uni.UniMessage {
+	msg, _ := uni.CreateUniMessage(rid, uni.BiliBili, uni.GiftMessageType, &uni.GiftMessage{Name: "a", Item: "x", Num: num, Price: price})
+	return msg
+}
+
+const testConfig = `
+global:
+  - type: keywords
+    words: [Spam]
+  - type: blacklist
+    users: [bot]
+  - type: dedupe
+    window: 1m
+rooms:
+  - platform: bilibili
+    rid: "1"
+    stages:
+      - type: minGift
+        minValue: 10
+`
+
+func TestLoadAndProcess(t *testing.T) {
+	var cfg Config
+	if err := yaml.Unmarshal([]byte(testConfig), &cfg); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Global[2].Window != time.Minute {
+		t.Fatalf("window=%v", cfg.Global[2].Window)
+	}
+
+	p := New()
+	if err := p.Load(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name string
+		msg  *uni.UniMessage
+		keep bool
+	}{
+		{"plain chat", chat("1", "a", "hello"), true},
+		{"keyword", chat("1", "a", "buy SPAM now"), false},
+		{"blacklisted user", chat("1", "bot", "hi"), false},
+		{"duplicate chat", chat("1", "a", "hello"), false},
+		{"same text other user", chat("1", "b", "hello"), true},
+		{"cheap gift in room 1", gift("1", 1), false},
+		{"cheap gift in room 2", gift("2", 1), true},
+		{"valuable gift", gift("1", 30), true},
+		{"combo total above threshold", gifts("1", 100, 10), true},
+		{"combo total below threshold", gifts("1", 100, 9.9), false},
+		{"unknown price", gift("1", 0), false},
+		{"repeated gift not deduped", gift("1", 30), true},
+	}
+	for _, c := range cases {
+		if got := p.Process(c.msg) != nil; got != c.keep {
+			t.Errorf("%s: kept=%v, want %v", c.name, got, c.keep)
+		}
+	}
+}
+
+func TestLoadUnknownStageKeepsPrevious(t *testing.T) {
+	p := New()
+	if err := p.Load(Config{Global: []StageConfig{{Type: "keywords", Words: []string{"x"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Load(Config{Global: []StageConfig{{Type: "nope"}}}); err == nil {
+		t.Fatal("expected error for unknown stage")
+	}
+	if p.Process(chat("1", "a", "x")) != nil {
+		t.Fatal("previous config should still apply")
+	}
+}
+
+func TestRateLimitPerRoom(t *testing.T) {
+	limiter := &rateLimiter{rate: 1, capacity: 2, buckets: make(map[string]*bucket)}
+	now := time.Now()
+	allowed := 0
+	for i := 0; i < 5; i++ {
+		if limiter.allow("a", now) {
+			allowed++
+		}
+	}
+	if allowed != 2 {
+		t.Fatalf("allowed=%d, want 2", allowed)
+	}
+	if !limiter.allow("b", now) {
+		t.Fatal("other room should have its own bucket")
+	}
+	if !limiter.allow("a", now.Add(time.Second)) {
+		t.Fatal("token should refill after 1s")
+	}
+}
+
+func TestCustomStage(t *testing.T) {
+	p := New()
+	p.UseRoom(uni.BiliBili, "1", StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		msg.Data.(*uni.ChatMessage).Content += "!"
+		return msg
+	}))
+	if err := p.Load(Config{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Process(chat("1", "a", "hi")).Data.(*uni.ChatMessage).Content; got != "hi!" {
+		t.Fatalf("content=%q", got)
+	}
+	if got := p.Process(chat("2", "a", "hi")).Data.(*uni.ChatMessage).Content; got != "hi" {
+		t.Fatalf("other room content=%q", got)
+	}
+}
+
+func TestFinalStageRunsAfterRoomStages(t *testing.T) {
+	p := New()
+	var seen []string
+	p.UseFinal(StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		seen = append(seen, msg.Data.(*uni.ChatMessage).Content)
+		return msg
+	}))
+	// 在 UseFinal 之后添加的全局阶段同样先于最终阶段执行
+	p.Use(StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		msg.Data.(*uni.ChatMessage).Content += "!"
+		return msg
+	}))
+	if err := p.Load(Config{Rooms: []RoomConfig{{Platform: "bilibili", RoomID: "1", Stages: []StageConfig{{Type: "blacklist", Users: []string{"bot"}}}}}}); err != nil {
+		t.Fatal(err)
+	}
+
+	p.Process(chat("1", "bot", "!vote"))
+	p.Process(chat("1", "a", "!vote"))
+	if len(seen) != 1 || seen[0] != "!vote!" {
+		t.Fatalf("final stage saw %v", seen)
+	}
+}
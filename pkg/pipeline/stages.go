@@ -0,0 +1,223 @@
+package pipeline
+
+import (
+	uni "UniBarrage/universal"
+	"errors"
+	"fmt"
+	"github.com/hashicorp/golang-lru/v2/expirable"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultDedupeWindow = 10 * time.Second // 默认去重时间窗口
+	dedupeSize          = 4096             // 去重记录的最大条数
+)
+
+func init() {
+	RegisterStage("keywords", func(cfg StageConfig) (Stage, error) {
+		if len(cfg.Words) == 0 {
+			return nil, errors.New("words 不能为空")
+		}
+		return Keywords(cfg.Words...), nil
+	})
+	RegisterStage("blacklist", func(cfg StageConfig) (Stage, error) {
+		if len(cfg.Users) == 0 {
+			return nil, errors.New("users 不能为空")
+		}
+		return Blacklist(cfg.Users...), nil
+	})
+	RegisterStage("dedupe", func(cfg StageConfig) (Stage, error) {
+		stage := Dedupe(cfg.Window)
+		if len(cfg.Types) == 0 {
+			// 礼物等消息重复出现是正常的，默认只对弹幕和进场去重
+			stage = OnlyTypes(stage, uni.ChatMessageType, uni.EnterRoomMessageType)
+		}
+		return stage, nil
+	})
+	RegisterStage("minGift", func(cfg StageConfig) (Stage, error) {
+		if cfg.MinValue <= 0 {
+			return nil, errors.New("minValue 必须大于 0")
+		}
+		return MinGift(cfg.MinValue), nil
+	})
+	RegisterStage("rateLimit", func(cfg StageConfig) (Stage, error) {
+		if cfg.Rate <= 0 {
+			return nil, errors.New("rate 必须大于 0")
+		}
+		stage := RateLimit(cfg.Rate, cfg.Burst)
+		if len(cfg.Types) == 0 {
+			// 默认不限制礼物、订阅等有价值的消息
+			stage = OnlyTypes(stage, uni.ChatMessageType, uni.LikeMessageType, uni.EnterRoomMessageType)
+		}
+		return stage, nil
+	})
+}
+
+// Keywords 丢弃内容包含任一屏蔽词的弹幕和醒目留言，不区分大小写
+func Keywords(words ...string) Stage {
+	lower := make([]string, 0, len(words))
+	for _, word := range words {
+		if word = strings.ToLower(strings.TrimSpace(word)); word != "" {
+			lower = append(lower, word)
+		}
+	}
+	return StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		content := strings.ToLower(Content(msg))
+		if content == "" {
+			return msg
+		}
+		for _, word := range lower {
+			if strings.Contains(content, word) {
+				return nil
+			}
+		}
+		return msg
+	})
+}
+
+// Blacklist 丢弃指定用户的所有消息
+func Blacklist(users ...string) Stage {
+	blocked := make(map[string]bool, len(users))
+	for _, user := range users {
+		blocked[strings.TrimSpace(user)] = true
+	}
+	return StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		if name := UserName(msg); name != "" && blocked[name] {
+			return nil
+		}
+		return msg
+	})
+}
+
+// Dedupe 丢弃 window 内同一房间同一用户的重复消息，window 为 0 时使用默认值 10s
+func Dedupe(window time.Duration) Stage {
+	if window <= 0 {
+		window = defaultDedupeWindow
+	}
+	seen := expirable.NewLRU[string, struct{}](dedupeSize, nil, window)
+	var mu sync.Mutex
+	return StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		key := dedupeKey(msg)
+		mu.Lock()
+		defer mu.Unlock()
+		if seen.Contains(key) {
+			return nil
+		}
+		seen.Add(key, struct{}{})
+		return msg
+	})
+}
+
+// 去重使用的消息标识，不包含原始数据
+func dedupeKey(msg *uni.UniMessage) string {
+	key := fmt.Sprintf("%s|%s|%s|%s|%s", msg.Platform, msg.RID, msg.Type, UserName(msg), Content(msg))
+	if gift, ok := msg.Data.(*uni.GiftMessage); ok {
+		key += fmt.Sprintf("|%s|%d", gift.Item, gift.Num)
+	}
+	return key
+}
+
+// MinGift 丢弃总价值低于 minValue（人民币元）的礼物消息。比较的是各平台已换算为人民币的
+// 本条消息总价 GiftMessage.Price，价格未知的礼物（Price 为 0，如斗鱼、小红书）同样会被丢弃
+func MinGift(minValue float64) Stage {
+	return StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		if gift, ok := msg.Data.(*uni.GiftMessage); ok && gift.Price < minValue {
+			return nil
+		}
+		return msg
+	})
+}
+
+// RateLimit 按房间限制每秒消息数，超出的消息被丢弃；burst 不大于 0 时与 rate 相同
+func RateLimit(rate float64, burst int) Stage {
+	capacity := float64(burst)
+	if capacity <= 0 {
+		capacity = rate
+	}
+	if capacity < 1 {
+		capacity = 1
+	}
+	limiter := &rateLimiter{
+		rate:     rate,
+		capacity: capacity,
+		buckets:  make(map[string]*bucket),
+	}
+	return StageFunc(func(msg *uni.UniMessage) *uni.UniMessage {
+		if !limiter.allow(roomKey(msg.Platform, msg.RID), time.Now()) {
+			return nil
+		}
+		return msg
+	})
+}
+
+// 令牌桶限流器
+type rateLimiter struct {
+	mu       sync.Mutex
+	rate     float64 // 每秒补充的令牌数
+	capacity float64 // 桶容量
+	buckets  map[string]*bucket
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// 取出一个令牌，令牌不足时返回 false
+func (l *rateLimiter) allow(key string, now time.Time) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	b, ok := l.buckets[key]
+	if !ok {
+		b = &bucket{tokens: l.capacity, last: now}
+		l.buckets[key] = b
+	}
+	b.tokens += now.Sub(b.last).Seconds() * l.rate
+	if b.tokens > l.capacity {
+		b.tokens = l.capacity
+	}
+	b.last = now
+
+	if b.tokens < 1 {
+		return false
+	}
+	b.tokens--
+	return true
+}
+
+// UserName 获取消息发送者的名称，结束直播等消息返回空字符串
+func UserName(msg *uni.UniMessage) string {
+	switch data := msg.Data.(type) {
+	case *uni.ChatMessage:
+		return data.Name
+	case *uni.GiftMessage:
+		return data.Name
+	case *uni.SubscribeMessage:
+		return data.Name
+	case *uni.SuperChatMessage:
+		return data.Name
+	case *uni.LikeMessage:
+		return data.Name
+	case *uni.EnterRoomMessage:
+		return data.Name
+	case *uni.CommandMessage:
+		return data.Name
+	default:
+		return ""
+	}
+}
+
+// Content 获取弹幕或醒目留言的内容，其他消息返回空字符串
+func Content(msg *uni.UniMessage) string {
+	switch data := msg.Data.(type) {
+	case *uni.ChatMessage:
+		return data.Content
+	case *uni.SuperChatMessage:
+		return data.Content
+	default:
+		return ""
+	}
+}
@@ -0,0 +1,440 @@
+// Package script 使用 goja 运行消息处理脚本。脚本目录中的每个 .js 文件运行在独立的沙箱中（无文件、网络访问），
+// 需定义 onMessage(msg) 函数（或通过 command(name, fn) 注册命令处理函数）：返回修改后的消息、返回 null 丢弃消息、返回数组输出多条消息，不返回则保持原消息
+package script
+
+import (
+	uni "UniBarrage/universal"
+	log "UniBarrage/utils/trace"
+	"errors"
+	"fmt"
+	"github.com/dop251/goja"
+	"github.com/goccy/go-json"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// 默认的单次调用超时
+const defaultTimeout = 100 * time.Millisecond
+
+// 调用超时时中断脚本的原因
+var errTimeout = errors.New("脚本执行超时")
+
+// Options 脚本运行选项
+type Options struct {
+	Dir     string        // 脚本目录
+	Timeout time.Duration // 单次调用超时，默认 100ms
+	Out     uni.Publisher // 脚本额外输出的消息发布到此处，不再经过处理链
+	Store   *Store        // 计数器存储，为空时自动创建
+}
+
+// Runner 按文件名顺序执行脚本目录中的所有脚本，实现 pipeline.Stage
+type Runner struct {
+	opts    Options
+	store   *Store
+	mu      sync.RWMutex
+	scripts []*Script
+}
+
+// Script 单个脚本及其运行时，goja 运行时不是并发安全的，调用时需加锁
+type Script struct {
+	name      string
+	runner    *Runner
+	mu        sync.Mutex
+	vm        *goja.Runtime
+	onMessage goja.Callable
+	commands  map[string]goja.Callable // 通过 command(name, fn) 注册的命令处理函数
+	current   *uni.UniMessage          // 当前处理的消息，决定 store 的房间作用域
+	extras    []*uni.UniMessage        // 本次调用中通过 emit 输出的消息
+}
+
+// NewRunner 创建脚本运行器，需调用 Load 加载脚本
+func NewRunner(opts Options) *Runner {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultTimeout
+	}
+	store := opts.Store
+	if store == nil {
+		store = NewStore()
+	}
+	return &Runner{opts: opts, store: store}
+}
+
+// Store 获取脚本共享的计数器存储
+func (r *Runner) Store() *Store {
+	return r.store
+}
+
+// Load 重新加载脚本目录中的所有 .js 文件，单个脚本加载失败时记录日志并跳过；
+// 重新加载后脚本中的全局变量会被重置，计数器存储保留
+func (r *Runner) Load() error {
+	if _, err := os.Stat(r.opts.Dir); err != nil {
+		return fmt.Errorf("读取脚本目录失败: %w", err)
+	}
+	files, err := filepath.Glob(filepath.Join(r.opts.Dir, "*.js"))
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+
+	scripts := make([]*Script, 0, len(files))
+	for _, file := range files {
+		s, err := r.load(file)
+		if err != nil {
+			log.Printf("ERROR", "加载脚本 %s 失败: %v", filepath.Base(file), err)
+			continue
+		}
+		scripts = append(scripts, s)
+	}
+
+	r.mu.Lock()
+	r.scripts = scripts
+	r.mu.Unlock()
+
+	log.Printf("INFO", "已加载 %d 个消息脚本", len(scripts))
+	return nil
+}
+
+// WatchReload 收到 SIGHUP 时重新加载脚本目录，与是否使用配置文件无关；加载失败时保留已加载的脚本
+func (r *Runner) WatchReload() {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	go func() {
+		for range sigChan {
+			if err := r.Load(); err != nil {
+				log.Printf("ERROR", "重新加载消息脚本失败: %v", err)
+			}
+		}
+	}()
+}
+
+// Scripts 获取已加载的脚本名称
+func (r *Runner) Scripts() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.scripts))
+	for _, s := range r.scripts {
+		names = append(names, s.name)
+	}
+	return names
+}
+
+// 编译并运行脚本文件，导出 onMessage
+func (r *Runner) load(file string) (*Script, error) {
+	source, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &Script{name: filepath.Base(file), runner: r, vm: goja.New(), commands: make(map[string]goja.Callable)}
+	if err := s.install(); err != nil {
+		return nil, err
+	}
+
+	if err := s.run(func() error {
+		_, err := s.vm.RunScript(s.name, string(source))
+		return err
+	}); err != nil {
+		return nil, err
+	}
+
+	// 仅注册命令处理函数的脚本可以不定义 onMessage
+	onMessage, ok := goja.AssertFunction(s.vm.Get("onMessage"))
+	if !ok && len(s.commands) == 0 {
+		return nil, errors.New("未定义 onMessage 函数")
+	}
+	s.onMessage = onMessage
+	return s, nil
+}
+
+// 向运行时注入 store、emit、command 和 console
+func (s *Script) install() error {
+	store := s.vm.NewObject()
+	room := func() string {
+		if s.current == nil {
+			return ""
+		}
+		return fmt.Sprintf("%s_%s", s.current.Platform, s.current.RID)
+	}
+	_ = store.Set("get", func(key string) float64 {
+		return s.runner.store.Get(room(), key)
+	})
+	_ = store.Set("set", func(key string, value float64) {
+		s.runner.store.Set(room(), key, value)
+	})
+	_ = store.Set("incr", func(call goja.FunctionCall) goja.Value {
+		delta := 1.0
+		if len(call.Arguments) > 1 {
+			delta = call.Argument(1).ToFloat()
+		}
+		return s.vm.ToValue(s.runner.store.Incr(room(), call.Argument(0).String(), delta))
+	})
+	_ = store.Set("del", func(key string) {
+		s.runner.store.Delete(room(), key)
+	})
+
+	console := s.vm.NewObject()
+	_ = console.Set("log", func(call goja.FunctionCall) goja.Value {
+		args := make([]string, 0, len(call.Arguments))
+		for _, arg := range call.Arguments {
+			args = append(args, arg.String())
+		}
+		log.Printf("INFO", "[%s] %s", s.name, strings.Join(args, " "))
+		return goja.Undefined()
+	})
+
+	emit := func(value goja.Value) {
+		msg, err := s.toMessage(value)
+		if err != nil {
+			panic(s.vm.NewTypeError(err.Error()))
+		}
+		if msg != nil {
+			s.extras = append(s.extras, msg)
+		}
+	}
+
+	command := func(name string, value goja.Value) {
+		handler, ok := goja.AssertFunction(value)
+		if !ok {
+			panic(s.vm.NewTypeError("command 的处理函数必须是函数"))
+		}
+		s.commands[strings.ToLower(strings.TrimSpace(name))] = handler
+	}
+
+	for name, value := range map[string]interface{}{"store": store, "console": console, "emit": emit, "command": command} {
+		if err := s.vm.Set(name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// 在超时限制内执行 fn，超时后中断脚本
+func (s *Script) run(fn func() error) error {
+	var mu sync.Mutex
+	finished := false
+	timer := time.AfterFunc(s.runner.opts.Timeout, func() {
+		mu.Lock()
+		defer mu.Unlock()
+		if !finished {
+			s.vm.Interrupt(errTimeout)
+		}
+	})
+
+	err := fn()
+
+	mu.Lock()
+	finished = true
+	mu.Unlock()
+	timer.Stop()
+	s.vm.ClearInterrupt()
+	return err
+}
+
+// 调用 onMessage，返回替换后的消息（nil 表示丢弃）和额外输出的消息
+func (s *Script) call(msg *uni.UniMessage) (*uni.UniMessage, []*uni.UniMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	defer s.reset()
+
+	result, extras, err := s.invoke(s.onMessage, msg)
+	if err != nil {
+		return msg, nil, err
+	}
+	switch {
+	case result == nil || goja.IsUndefined(result):
+		return msg, extras, nil
+	case goja.IsNull(result):
+		return nil, extras, nil
+	}
+
+	// 数组中的第一条消息替换原消息，其余作为额外输出
+	items, err := s.toMessages(result)
+	if err != nil {
+		return msg, nil, err
+	}
+	if len(items) == 0 {
+		return nil, extras, nil
+	}
+	return items[0], appendMessages(extras, items[1:]...), nil
+}
+
+// 调用 command 注册的命令处理函数，返回值和 emit 输出的消息均作为响应
+func (s *Script) handle(cmd *uni.UniMessage, data *uni.CommandMessage) ([]*uni.UniMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	handler, ok := s.commands[data.Command]
+	if !ok {
+		return nil, nil
+	}
+	defer s.reset()
+	result, extras, err := s.invoke(handler, cmd)
+	if err != nil {
+		return nil, err
+	}
+	if result == nil || goja.IsUndefined(result) || goja.IsNull(result) {
+		return extras, nil
+	}
+	items, err := s.toMessages(result)
+	if err != nil {
+		return nil, err
+	}
+	return appendMessages(extras, items...), nil
+}
+
+// 以消息为参数在超时限制内调用脚本函数，需持有 s.mu；返回值转换完成前需保留 current，由调用方调用 reset
+func (s *Script) invoke(fn goja.Callable, msg *uni.UniMessage) (goja.Value, []*uni.UniMessage, error) {
+	arg, err := toValue(s.vm, msg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	s.current = msg
+	s.extras = nil
+
+	var result goja.Value
+	if err := s.run(func() error {
+		result, err = fn(goja.Undefined(), arg)
+		return err
+	}); err != nil {
+		return nil, nil, err
+	}
+	return result, s.extras, nil
+}
+
+// 清除本次调用的状态
+func (s *Script) reset() {
+	s.current = nil
+	s.extras = nil
+}
+
+// 将脚本返回的对象或数组转换为消息列表，数组中的 null 项保留为 nil
+func (s *Script) toMessages(result goja.Value) ([]*uni.UniMessage, error) {
+	if _, ok := result.Export().([]interface{}); !ok {
+		msg, err := s.toMessage(result)
+		if err != nil {
+			return nil, err
+		}
+		return []*uni.UniMessage{msg}, nil
+	}
+
+	obj := result.ToObject(s.vm)
+	length := int(obj.Get("length").ToInteger())
+	msgs := make([]*uni.UniMessage, 0, length)
+	for i := 0; i < length; i++ {
+		msg, err := s.toMessage(obj.Get(fmt.Sprint(i)))
+		if err != nil {
+			return nil, err
+		}
+		msgs = append(msgs, msg)
+	}
+	return msgs, nil
+}
+
+// 追加非空消息
+func appendMessages(dst []*uni.UniMessage, msgs ...*uni.UniMessage) []*uni.UniMessage {
+	for _, msg := range msgs {
+		if msg != nil {
+			dst = append(dst, msg)
+		}
+	}
+	return dst
+}
+
+// 将脚本返回的对象转换为消息，未填写的平台和房间沿用当前消息
+func (s *Script) toMessage(value goja.Value) (*uni.UniMessage, error) {
+	if value == nil || goja.IsUndefined(value) || goja.IsNull(value) {
+		return nil, nil
+	}
+	data, err := json.Marshal(value.Export())
+	if err != nil {
+		return nil, err
+	}
+
+	var msg uni.UniMessage
+	if err := json.Unmarshal(data, &msg); err != nil {
+		return nil, fmt.Errorf("无效的消息: %w", err)
+	}
+	if s.current != nil {
+		if msg.Platform == "" {
+			msg.Platform = s.current.Platform
+		}
+		if msg.RID == "" {
+			msg.RID = s.current.RID
+		}
+	}
+	if !uni.IsValidPlatform(msg.Platform) {
+		return nil, fmt.Errorf("无效的平台: %s", msg.Platform)
+	}
+	return &msg, nil
+}
+
+// 将消息转换为普通的 JavaScript 对象
+func toValue(vm *goja.Runtime, msg *uni.UniMessage) (goja.Value, error) {
+	data, err := json.Marshal(msg)
+	if err != nil {
+		return nil, err
+	}
+	var obj map[string]interface{}
+	if err := json.Unmarshal(data, &obj); err != nil {
+		return nil, err
+	}
+	return vm.ToValue(obj), nil
+}
+
+// Process 依次执行所有脚本，脚本出错或超时时记录日志并保留原消息
+func (r *Runner) Process(msg *uni.UniMessage) *uni.UniMessage {
+	r.mu.RLock()
+	scripts := r.scripts
+	r.mu.RUnlock()
+
+	for _, s := range scripts {
+		if s.onMessage == nil {
+			continue
+		}
+		next, extras, err := s.call(msg)
+		if err != nil {
+			log.Printf("WARN", "脚本 %s 执行失败: %v", s.name, err)
+			continue
+		}
+		if r.opts.Out != nil {
+			for _, extra := range extras {
+				r.opts.Out.Publish(extra)
+			}
+		}
+		if msg = next; msg == nil {
+			return nil
+		}
+	}
+	return msg
+}
+
+// Handle 调用所有脚本中为该命令注册的处理函数，实现 command.Handler
+func (r *Runner) Handle(cmd *uni.UniMessage) []*uni.UniMessage {
+	data, ok := cmd.Data.(*uni.CommandMessage)
+	if !ok {
+		return nil
+	}
+
+	r.mu.RLock()
+	scripts := r.scripts
+	r.mu.RUnlock()
+
+	var responses []*uni.UniMessage
+	for _, s := range scripts {
+		msgs, err := s.handle(cmd, data)
+		if err != nil {
+			log.Printf("WARN", "脚本 %s 处理命令 %s 失败: %v", s.name, data.Command, err)
+			continue
+		}
+		responses = append(responses, msgs...)
+	}
+	return responses
+}
@@ -0,0 +1,156 @@
+package script
+
+import (
+	uni "UniBarrage/universal"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+)
+
+const pointsScript = `
+function onMessage(msg) {
+	if (msg.type !== "Chat") return;
+	var content = msg.data.content;
+	if (content === "bad") return null;
+	if (content === "!points") {
+		var points = store.incr("points:" + msg.data.name);
+		emit({type: "Chat", data: {name: "bot", content: msg.data.name + " has " + points}});
+		return;
+	}
+	msg.data.content = content.toUpperCase();
+	return msg;
+}
+`
+
+func newRunner(t *testing.T, scripts map[string]string, out uni.Publisher) *Runner {
+	t.Helper()
+	dir := t.TempDir()
+	for name, source := range scripts {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(source), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	r := NewRunner(Options{Dir: dir, Timeout: 50 * time.Millisecond, Out: out})
+	if err := r.Load(); err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+func chat(rid string, name string, content string) *uni.UniMessage {
+	msg, _ := uni.CreateUniMessage(rid, uni.BiliBili, uni.ChatMessageType, &uni.ChatMessage{Name: name, Content: content})
+	return msg
+}
+
+func TestRunnerTransformsDropsAndEmits(t *testing.T) {
+	var emitted []*uni.UniMessage
+	r := newRunner(t, map[string]string{"points.js": pointsScript}, uni.PublisherFunc(func(msg *uni.UniMessage) {
+		emitted = append(emitted, msg)
+	}))
+
+	if got := r.Process(chat("1", "a", "hello")); got == nil || got.Data.(*uni.ChatMessage).Content != "HELLO" {
+		t.Fatalf("transform: got %+v", got)
+	}
+	if got := r.Process(chat("1", "a", "bad")); got != nil {
+		t.Fatal("null should drop the message")
+	}
+
+	r.Process(chat("1", "a", "!points"))
+	r.Process(chat("1", "a", "!points"))
+	r.Process(chat("2", "a", "!points"))
+	if len(emitted) != 3 {
+		t.Fatalf("emitted %d messages", len(emitted))
+	}
+	if got := emitted[1].Data.(*uni.ChatMessage).Content; got != "a has 2" {
+		t.Errorf("second !points: %q", got)
+	}
+	// 计数器按房间隔离，未填写的房间沿用当前消息
+	if got := emitted[2]; got.RID != "2" || got.Data.(*uni.ChatMessage).Content != "a has 1" {
+		t.Errorf("other room: rid=%s content=%q", got.RID, got.Data.(*uni.ChatMessage).Content)
+	}
+}
+
+func TestRunnerTimeoutKeepsMessage(t *testing.T) {
+	r := newRunner(t, map[string]string{
+		"a_loop.js":  "function onMessage(msg) { while (true) {} }",
+		"b_upper.js": "function onMessage(msg) { msg.data.content += '!'; return msg; }",
+		"c_bad.js":   "var x = ;",
+	}, nil)
+
+	if names := r.Scripts(); len(names) != 2 {
+		t.Fatalf("loaded %v, want 2 scripts", names)
+	}
+
+	start := time.Now()
+	got := r.Process(chat("1", "a", "hi"))
+	if time.Since(start) > time.Second {
+		t.Fatal("timeout not enforced")
+	}
+	if got == nil || got.Data.(*uni.ChatMessage).Content != "hi!" {
+		t.Fatalf("got %+v", got)
+	}
+	// 超时后运行时仍可继续使用
+	if got := r.Process(chat("1", "a", "again")); got == nil || got.Data.(*uni.ChatMessage).Content != "again!" {
+		t.Fatalf("after timeout: got %+v", got)
+	}
+}
+
+func TestRunnerCommandHandlers(t *testing.T) {
+	runner := newRunner(t, map[string]string{"vote.js": `
+command("Vote", function (cmd) {
+	var total = store.incr("votes:" + cmd.data.args[0]);
+	return {type: "Chat", data: {name: "bot", content: cmd.data.args[0] + "=" + total}};
+});
+`}, nil)
+	if len(runner.Scripts()) != 1 {
+		t.Fatal("script with only command handlers should load")
+	}
+
+	cmd, _ := uni.CreateUniMessage("1", uni.DouYin, uni.CommandMessageType, &uni.CommandMessage{Name: "a", Command: "vote", Args: []string{"2"}})
+	runner.Handle(cmd)
+	responses := runner.Handle(cmd)
+	if len(responses) != 1 || responses[0].Platform != uni.DouYin {
+		t.Fatalf("responses=%+v", responses)
+	}
+	if got := responses[0].Data.(*uni.ChatMessage).Content; got != "2=2" {
+		t.Fatalf("content=%q", got)
+	}
+
+	other, _ := uni.CreateUniMessage("1", uni.DouYin, uni.CommandMessageType, &uni.CommandMessage{Command: "other"})
+	if responses := runner.Handle(other); len(responses) != 0 {
+		t.Fatalf("unregistered command responses=%+v", responses)
+	}
+	if runner.Process(chat("1", "a", "hi")) == nil {
+		t.Fatal("script without onMessage should keep messages")
+	}
+}
+
+// 收到 SIGHUP 时重新加载脚本目录，不依赖配置文件
+func TestRunnerReloadsOnSIGHUP(t *testing.T) {
+	r := newRunner(t, map[string]string{"a.js": "function onMessage(msg) { return msg; }"}, nil)
+	r.WatchReload()
+
+	if err := os.WriteFile(filepath.Join(r.opts.Dir, "b.js"), []byte("function onMessage(msg) { return null; }"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	p, err := os.FindProcess(os.Getpid())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Signal(syscall.SIGHUP); err != nil {
+		t.Skipf("send SIGHUP: %v", err)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(r.Scripts()) != 2 {
+		if time.Now().After(deadline) {
+			t.Fatalf("scripts not reloaded: %v", r.Scripts())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if got := r.Process(chat("1", "a", "hi")); got != nil {
+		t.Fatal("reloaded script should drop the message")
+	}
+}
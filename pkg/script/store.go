@@ -0,0 +1,51 @@
+package script
+
+import (
+	"sync"
+)
+
+// Store 按房间隔离的计数器存储，所有脚本共享，仅保存在内存中
+type Store struct {
+	mu     sync.Mutex
+	values map[string]map[string]float64 // 房间标识 -> 键 -> 值
+}
+
+// NewStore 创建计数器存储
+func NewStore() *Store {
+	return &Store{values: make(map[string]map[string]float64)}
+}
+
+// Get 获取房间中的计数，不存在时返回 0
+func (s *Store) Get(room string, key string) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.values[room][key]
+}
+
+// Set 设置房间中的计数
+func (s *Store) Set(room string, key string, value float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values[room] == nil {
+		s.values[room] = make(map[string]float64)
+	}
+	s.values[room][key] = value
+}
+
+// Incr 增加房间中的计数并返回新值
+func (s *Store) Incr(room string, key string, delta float64) float64 {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.values[room] == nil {
+		s.values[room] = make(map[string]float64)
+	}
+	s.values[room][key] += delta
+	return s.values[room][key]
+}
+
+// Delete 删除房间中的计数
+func (s *Store) Delete(room string, key string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.values[room], key)
+}
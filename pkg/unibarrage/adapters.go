@@ -0,0 +1,71 @@
+package unibarrage
+
+import (
+	"UniBarrage/bilibili"
+	"UniBarrage/douyin"
+	"UniBarrage/douyu"
+	"UniBarrage/huya"
+	"UniBarrage/kuaishou"
+	uni "UniBarrage/universal"
+	"UniBarrage/xiaohongshu"
+	"errors"
+	"strconv"
+)
+
+// ErrInvalidRoomID 房间 ID 格式错误
+var ErrInvalidRoomID = errors.New("房间 ID 格式错误，必须为整数")
+
+// ListenFunc 阻塞监听直到 stopChan 关闭，监听失败时由适配器关闭 stopChan；
+// pub 由引擎为每个房间创建，消息和抓包、在线人数等房间状态只进入该引擎
+type ListenFunc func(stopChan chan struct{}, pub uni.Sink)
+
+// Adapter 校验房间参数并返回监听函数
+type Adapter func(rid string, cookie string) (ListenFunc, error)
+
+// 内置平台适配器
+var builtinAdapters = map[uni.Platform]Adapter{
+	uni.DouYin: func(rid string, cookie string) (ListenFunc, error) {
+		room, err := strconv.Atoi(rid)
+		if err != nil {
+			return nil, ErrInvalidRoomID
+		}
+		return func(stopChan chan struct{}, pub uni.Sink) {
+			douyin.StartListen(room, stopChan, pub)
+		}, nil
+	},
+	uni.BiliBili: func(rid string, cookie string) (ListenFunc, error) {
+		room, err := strconv.Atoi(rid)
+		if err != nil {
+			return nil, ErrInvalidRoomID
+		}
+		return func(stopChan chan struct{}, pub uni.Sink) {
+			bilibili.StartListen(room, cookie, stopChan, pub)
+		}, nil
+	},
+	uni.KuaiShou: func(rid string, cookie string) (ListenFunc, error) {
+		// 快手使用直播间链接中的字符串 ID
+		return func(stopChan chan struct{}, pub uni.Sink) {
+			kuaishou.StartListen(rid, cookie, stopChan, pub)
+		}, nil
+	},
+	uni.DouYu: func(rid string, cookie string) (ListenFunc, error) {
+		room, err := strconv.Atoi(rid)
+		if err != nil {
+			return nil, ErrInvalidRoomID
+		}
+		return func(stopChan chan struct{}, pub uni.Sink) {
+			douyu.StartListen(room, stopChan, pub)
+		}, nil
+	},
+	uni.HuYa: func(rid string, cookie string) (ListenFunc, error) {
+		return func(stopChan chan struct{}, pub uni.Sink) {
+			huya.StartListen(rid, stopChan, pub)
+		}, nil
+	},
+	uni.XiaoHongShu: func(rid string, cookie string) (ListenFunc, error) {
+		// 小红书直播间 ID 为长整型字符串
+		return func(stopChan chan struct{}, pub uni.Sink) {
+			xiaohongshu.StartListen(rid, cookie, stopChan, pub)
+		}, nil
+	},
+}
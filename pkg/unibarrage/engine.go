@@ -0,0 +1,355 @@
+// Package unibarrage 可嵌入的弹幕采集引擎，无需启动 API 和 WebSocket 服务即可在进程内接收统一消息。
+// 抓包、房间统计、WebSocket 连接、图片代理缓存和房间日志输出等状态由引擎持有，同一进程中可以同时运行多个互不影响的引擎。
+package unibarrage
+
+import (
+	"UniBarrage/services/proxy"
+	ws "UniBarrage/services/websockets"
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/capture"
+	"UniBarrage/utils/stats"
+	log "UniBarrage/utils/trace"
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 默认的房间消息通道缓冲大小
+const defaultBuffer = 1024
+
+var (
+	// ErrRoomExists 房间已在监听中
+	ErrRoomExists = errors.New("房间已在监听中")
+	// ErrUnsupportedPlatform 不支持的平台
+	ErrUnsupportedPlatform = errors.New("不支持的平台")
+	// ErrEngineClosed 引擎已关闭
+	ErrEngineClosed = errors.New("引擎已关闭")
+)
+
+// Options 引擎选项
+type Options struct {
+	Buffer     int                      // 每个房间消息通道的缓冲大小，默认 1024，通道已满时丢弃新消息
+	Adapters   map[uni.Platform]Adapter // 覆盖或补充内置适配器
+	CaptureDir string                   // 抓包文件目录，默认为系统临时目录下的 UniBarrageCapture
+	Logger     *slog.Logger             // 房间日志的输出，默认使用 trace 的全局日志
+}
+
+// RoomOptions 启动房间的选项
+type RoomOptions struct {
+	Cookie string // 登录 cookie (可选)
+	Debug  bool   // 是否开启上游帧抓包
+}
+
+// RoomInfo 房间运行信息
+type RoomInfo struct {
+	Platform uni.Platform
+	RoomID   string
+	Started  time.Time // 启动时间
+	Dropped  uint64    // 因通道已满丢弃的消息数
+}
+
+// Engine 弹幕采集引擎
+type Engine struct {
+	mu       sync.RWMutex
+	rooms    map[string]*room
+	adapters map[uni.Platform]Adapter
+	buffer   int
+	closed   bool           // 是否已关闭，由 mu 保护
+	wg       sync.WaitGroup // 运行中的监听函数
+	capture  *capture.Store // 抓包和未映射消息统计
+	stats    *stats.Store   // 房间统计
+	hub      *ws.Hub        // WebSocket 客户端连接
+	proxy    *proxy.Proxy   // 图片代理，未启用时不改写消息
+	logger   *slog.Logger   // 房间日志的输出，为空时使用全局日志
+}
+
+// 单个房间的运行状态，同时作为适配器的 uni.Sink
+type room struct {
+	engine   *Engine
+	platform uni.Platform
+	rid      string
+	started  time.Time
+	stopChan chan struct{}
+	dropped  atomic.Uint64
+
+	mu       sync.Mutex // 保护 messages 的发送与关闭
+	closed   bool
+	messages chan *uni.UniMessage
+}
+
+// New 创建引擎
+func New(opts Options) (*Engine, error) {
+	adapters := make(map[uni.Platform]Adapter, len(builtinAdapters)+len(opts.Adapters))
+	for platform, adapter := range builtinAdapters {
+		adapters[platform] = adapter
+	}
+	for platform, adapter := range opts.Adapters {
+		adapters[platform] = adapter
+	}
+
+	buffer := opts.Buffer
+	if buffer <= 0 {
+		buffer = defaultBuffer
+	}
+
+	return &Engine{
+		rooms:    make(map[string]*room),
+		adapters: adapters,
+		buffer:   buffer,
+		capture:  capture.NewStore(opts.CaptureDir),
+		stats:    stats.NewStore(),
+		hub:      ws.NewHub(),
+		proxy:    proxy.New(),
+		logger:   opts.Logger,
+	}, nil
+}
+
+// 生成房间唯一标识
+func roomKey(platform uni.Platform, rid string) string {
+	return fmt.Sprintf("%s_%s", platform, rid)
+}
+
+// Start 启动房间监听，返回该房间的消息通道；房间停止或监听失败后通道关闭
+func (e *Engine) Start(platform uni.Platform, rid string, opts RoomOptions) (<-chan *uni.UniMessage, error) {
+	if rid == "" {
+		return nil, fmt.Errorf("房间 ID 不能为空")
+	}
+	adapter, ok := e.adapters[platform]
+	if !ok {
+		return nil, ErrUnsupportedPlatform
+	}
+	if opts.Debug && !capture.Supported(platform) {
+		return nil, fmt.Errorf("%w: %s", capture.ErrUnsupported, platform)
+	}
+	listen, err := adapter(rid, opts.Cookie)
+	if err != nil {
+		return nil, err
+	}
+
+	key := roomKey(platform, rid)
+	r := &room{
+		engine:   e,
+		platform: platform,
+		rid:      rid,
+		started:  time.Now(),
+		stopChan: make(chan struct{}),
+		messages: make(chan *uni.UniMessage, e.buffer),
+	}
+
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil, ErrEngineClosed
+	}
+	if _, exists := e.rooms[key]; exists {
+		e.mu.Unlock()
+		return nil, fmt.Errorf("%w: %s (%s)", ErrRoomExists, platform, rid)
+	}
+	e.rooms[key] = r
+	e.wg.Add(1)
+	e.mu.Unlock()
+
+	// 先登记统计和抓包，避免遗漏连接建立初期的帧
+	e.stats.Track(platform, rid)
+	e.capture.Register(platform, rid)
+	if opts.Debug {
+		e.capture.Enable(platform, rid)
+	}
+
+	// 监听函数返回即视为房间停止，适配器未关闭停止通道时由引擎关闭
+	go func() {
+		defer e.wg.Done()
+		defer r.stop()
+		listen(r.stopChan, r)
+	}()
+
+	// 停止或监听失败后移除房间并关闭消息通道
+	go func() {
+		<-r.stopChan
+		// 持锁清理，避免清除同一房间重新启动后登记的状态
+		e.mu.Lock()
+		if e.rooms[key] == r {
+			delete(e.rooms, key)
+		}
+		e.capture.Remove(platform, rid)
+		e.stats.Remove(platform, rid)
+		e.mu.Unlock()
+		r.close()
+	}()
+
+	return r.messages, nil
+}
+
+// Stop 停止房间监听，房间不存在时返回 false
+func (e *Engine) Stop(platform uni.Platform, rid string) bool {
+	e.mu.RLock()
+	r, exists := e.rooms[roomKey(platform, rid)]
+	e.mu.RUnlock()
+	if !exists {
+		return false
+	}
+	r.stop()
+	return true
+}
+
+// StopAll 停止所有房间并等待监听函数返回（包括 Node 子进程退出），超时后返回 ctx 的错误
+func (e *Engine) StopAll(ctx context.Context) error {
+	e.mu.RLock()
+	rooms := make([]*room, 0, len(e.rooms))
+	for _, r := range e.rooms {
+		rooms = append(rooms, r)
+	}
+	e.mu.RUnlock()
+
+	for _, r := range rooms {
+		r.stop()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		e.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Close 依次停止接受 WebSocket 连接、停止所有房间、关闭图片代理、断开 WebSocket 客户端，
+// 等待超时时仍会关闭引擎并返回 ctx 的错误
+func (e *Engine) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if e.closed {
+		e.mu.Unlock()
+		return nil
+	}
+	e.closed = true
+	e.mu.Unlock()
+
+	return errors.Join(
+		e.hub.Shutdown(ctx),
+		e.StopAll(ctx),
+		e.proxy.Shutdown(ctx),
+		e.hub.CloseClients(ctx),
+	)
+}
+
+// Rooms 获取运行中的房间
+func (e *Engine) Rooms() []RoomInfo {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	rooms := make([]RoomInfo, 0, len(e.rooms))
+	for _, r := range e.rooms {
+		rooms = append(rooms, RoomInfo{
+			Platform: r.platform,
+			RoomID:   r.rid,
+			Started:  r.started,
+			Dropped:  r.dropped.Load(),
+		})
+	}
+	return rooms
+}
+
+// Capture 获取引擎的抓包状态，用于开关抓包和查询未映射消息
+func (e *Engine) Capture() *capture.Store {
+	return e.capture
+}
+
+// Stats 获取引擎的房间统计
+func (e *Engine) Stats() *stats.Store {
+	return e.stats
+}
+
+// WebSocket 获取引擎的 WebSocket 连接管理，用于启动服务和广播消息
+func (e *Engine) WebSocket() *ws.Hub {
+	return e.hub
+}
+
+// Proxy 获取引擎的图片代理，配置后调用 Enable 或 StartServer 启用
+func (e *Engine) Proxy() *proxy.Proxy {
+	return e.proxy
+}
+
+// 关闭停止通道，通道已被适配器关闭时不再重复关闭
+func (r *room) stop() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	select {
+	case <-r.stopChan:
+	default:
+		close(r.stopChan)
+	}
+}
+
+// Publish 统计消息并发送到房间通道，通道已关闭时忽略，已满时丢弃
+func (r *room) Publish(msg *uni.UniMessage) {
+	if msg == nil {
+		return
+	}
+	r.engine.stats.Observe(msg)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closed {
+		return
+	}
+	select {
+	case r.messages <- msg:
+	default:
+		r.dropped.Add(1)
+	}
+}
+
+// 关闭消息通道
+func (r *room) close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if !r.closed {
+		r.closed = true
+		close(r.messages)
+	}
+}
+
+// Raw 记录上游原始帧
+func (r *room) Raw(frame []byte) {
+	r.engine.capture.Raw(r.platform, r.rid, frame)
+}
+
+// Decoded 记录解码后的上游消息
+func (r *room) Decoded(kind string, v interface{}) {
+	r.engine.capture.Decoded(r.platform, r.rid, kind, v)
+}
+
+// Unhandled 记录未映射的上游消息类型
+func (r *room) Unhandled(kind string, v interface{}) {
+	r.engine.capture.Unhandled(r.platform, r.rid, kind, v)
+}
+
+// Touch 记录收到上游事件
+func (r *room) Touch() {
+	r.engine.capture.Touch(r.platform, r.rid)
+}
+
+// Capturing 是否开启了抓包
+func (r *room) Capturing() bool {
+	return r.engine.capture.Enabled(r.platform, r.rid)
+}
+
+// Viewers 记录平台推送的在线人数
+func (r *room) Viewers(count int64) {
+	r.engine.stats.Viewers(r.platform, r.rid, count)
+}
+
+// Log 输出到引擎的日志
+func (r *room) Log(level string, msg string, attrs ...slog.Attr) {
+	log.LogTo(r.engine.logger, level, msg, attrs...)
+}
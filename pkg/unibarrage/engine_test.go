@@ -0,0 +1,263 @@
+package unibarrage
+
+import (
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/capture"
+	log "UniBarrage/utils/trace"
+	"bytes"
+	"context"
+	"errors"
+	"log/slog"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 测试用适配器：启动后输出一条日志，以 cookie 为类型记录一条未映射消息，并发布一条聊天消息；
+// 房间 ID 为 "fail" 时模拟监听失败
+func fakeAdapter(rid string, cookie string) (ListenFunc, error) {
+	return func(stopChan chan struct{}, pub uni.Sink) {
+		if rid == "fail" {
+			close(stopChan)
+			return
+		}
+		log.For(pub).Log("BILIBILI", "已启动 "+cookie, log.RID(rid))
+		pub.Unhandled(cookie, nil)
+		pub.Viewers(int64(len(cookie)))
+		msg, _ := uni.CreateUniMessage(rid, uni.BiliBili, uni.ChatMessageType, &uni.ChatMessage{Content: cookie})
+		pub.Publish(msg)
+		<-stopChan
+	}, nil
+}
+
+// 创建引擎，测试结束时关闭
+func newEngine(t *testing.T, opts Options) *Engine {
+	t.Helper()
+	e, err := New(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = e.Close(context.Background()) })
+	return e
+}
+
+func newTestEngine(t *testing.T) *Engine {
+	return newEngine(t, Options{Adapters: map[uni.Platform]Adapter{uni.BiliBili: fakeAdapter}})
+}
+
+func receive(t *testing.T, messages <-chan *uni.UniMessage) *uni.UniMessage {
+	t.Helper()
+	select {
+	case msg := <-messages:
+		return msg
+	case <-time.After(time.Second):
+		t.Fatal("未收到消息")
+		return nil
+	}
+}
+
+// 两个引擎同时监听同一房间，消息、抓包和统计互不影响
+func TestEnginesAreIsolated(t *testing.T) {
+	a := newTestEngine(t)
+	b := newTestEngine(t)
+
+	msgA, err := a.Start(uni.BiliBili, "1", RoomOptions{Cookie: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgB, err := b.Start(uni.BiliBili, "1", RoomOptions{Cookie: "bb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := receive(t, msgA).Data.(*uni.ChatMessage).Content; got != "a" {
+		t.Errorf("engine a got %q", got)
+	}
+	if got := receive(t, msgB).Data.(*uni.ChatMessage).Content; got != "bb" {
+		t.Errorf("engine b got %q", got)
+	}
+	if _, err := a.Start(uni.BiliBili, "1", RoomOptions{}); !errors.Is(err, ErrRoomExists) {
+		t.Errorf("duplicate start: got %v, want ErrRoomExists", err)
+	}
+
+	if got := a.Capture().Summary(uni.BiliBili, "1"); len(got) != 1 || got[0].Kind != "a" {
+		t.Errorf("engine a unhandled kinds: %+v", got)
+	}
+	if got := b.Capture().Summary(uni.BiliBili, "1"); len(got) != 1 || got[0].Kind != "bb" {
+		t.Errorf("engine b unhandled kinds: %+v", got)
+	}
+	sa, okA := a.Stats().Get(uni.BiliBili, "1")
+	sb, okB := b.Stats().Get(uni.BiliBili, "1")
+	if !okA || !okB || sa.Chats != 1 || sb.Chats != 1 || *sa.Viewers != 1 || *sb.Viewers != 2 {
+		t.Fatalf("stats a=%+v b=%+v", sa, sb)
+	}
+
+	// 图片代理和 WebSocket 连接同样由各自的引擎持有
+	a.Proxy().SetSigning("secret", 0)
+	if b.Proxy().SigningEnabled() {
+		t.Error("proxy signing leaked to engine b")
+	}
+	if a.WebSocket() == b.WebSocket() {
+		t.Error("engines share a websocket hub")
+	}
+
+	// 关闭一个引擎不影响另一个引擎的同名房间
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := a.Close(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-msgA; ok {
+		t.Error("channel still open after Close")
+	}
+	if _, err := a.Start(uni.BiliBili, "2", RoomOptions{}); !errors.Is(err, ErrEngineClosed) {
+		t.Errorf("start after Close: got %v, want ErrEngineClosed", err)
+	}
+	if rooms := b.Rooms(); len(rooms) != 1 {
+		t.Fatalf("engine b rooms after closing a: %+v", rooms)
+	}
+	if _, ok := b.Stats().Get(uni.BiliBili, "1"); !ok {
+		t.Error("engine b stats removed by closing a")
+	}
+	if got := b.Capture().Summary(uni.BiliBili, "1"); len(got) != 1 {
+		t.Errorf("engine b capture state removed by closing a: %+v", got)
+	}
+}
+
+// 房间日志输出到所属引擎的日志实例
+func TestRoomLogsUseEngineLogger(t *testing.T) {
+	var bufA, bufB bytes.Buffer
+	newLogged := func(buf *bytes.Buffer) *Engine {
+		return newEngine(t, Options{
+			Adapters: map[uni.Platform]Adapter{uni.BiliBili: fakeAdapter},
+			Logger:   slog.New(slog.NewJSONHandler(buf, nil)),
+		})
+	}
+	a, b := newLogged(&bufA), newLogged(&bufB)
+
+	msgA, err := a.Start(uni.BiliBili, "1", RoomOptions{Cookie: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgB, err := b.Start(uni.BiliBili, "1", RoomOptions{Cookie: "bb"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, msgA)
+	receive(t, msgB)
+
+	if got := bufA.String(); !strings.Contains(got, "已启动 a") || strings.Contains(got, "已启动 bb") || !strings.Contains(got, `"platform":"bilibili"`) {
+		t.Errorf("engine a log: %s", got)
+	}
+	if got := bufB.String(); !strings.Contains(got, "已启动 bb") || strings.Contains(got, "已启动 a\"") {
+		t.Errorf("engine b log: %s", got)
+	}
+}
+
+func TestStopAndStopAll(t *testing.T) {
+	e := newTestEngine(t)
+
+	msg1, err := e.Start(uni.BiliBili, "1", RoomOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg2, err := e.Start(uni.BiliBili, "2", RoomOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receive(t, msg1)
+	receive(t, msg2)
+
+	if !e.Stop(uni.BiliBili, "1") {
+		t.Fatal("Stop returned false")
+	}
+	if _, ok := <-msg1; ok {
+		t.Error("channel still open after Stop")
+	}
+	if e.Stop(uni.BiliBili, "missing") {
+		t.Error("Stop of unknown room returned true")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if err := e.StopAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := <-msg2; ok {
+		t.Error("channel still open after StopAll")
+	}
+}
+
+func TestEngineStartErrors(t *testing.T) {
+	e := newTestEngine(t)
+
+	if _, err := e.Start(uni.DouYin, "abc", RoomOptions{}); !errors.Is(err, ErrInvalidRoomID) {
+		t.Errorf("got %v, want ErrInvalidRoomID", err)
+	}
+	if _, err := e.Start("unknown", "1", RoomOptions{}); !errors.Is(err, ErrUnsupportedPlatform) {
+		t.Errorf("got %v, want ErrUnsupportedPlatform", err)
+	}
+
+	// 适配器监听失败后通道关闭，房间被移除
+	messages, err := e.Start(uni.BiliBili, "fail", RoomOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Error("unexpected message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after adapter failure")
+	}
+	if rooms := e.Rooms(); len(rooms) != 0 {
+		t.Errorf("got %d rooms, want 0", len(rooms))
+	}
+}
+
+func TestDebugRejectedForUnsupportedPlatform(t *testing.T) {
+	e := newTestEngine(t)
+	if _, err := e.Start(uni.BiliBili, "1", RoomOptions{Debug: true}); !errors.Is(err, capture.ErrUnsupported) {
+		t.Fatalf("got %v, want ErrUnsupported", err)
+	}
+	if rooms := e.Rooms(); len(rooms) != 0 {
+		t.Errorf("rejected room was registered: %v", rooms)
+	}
+}
+
+func TestListenerReturnWithoutClosingStops(t *testing.T) {
+	e := newEngine(t, Options{Adapters: map[uni.Platform]Adapter{
+		uni.BiliBili: func(rid string, cookie string) (ListenFunc, error) {
+			// 监听函数直接返回，不关闭停止通道
+			return func(stopChan chan struct{}, pub uni.Sink) {
+				msg, _ := uni.CreateUniMessage(rid, uni.BiliBili, uni.ChatMessageType, &uni.ChatMessage{Content: "bye"})
+				pub.Publish(msg)
+			}, nil
+		},
+	}})
+
+	messages, err := e.Start(uni.BiliBili, "1", RoomOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 已发布的消息仍会送达，随后通道关闭
+	if got := receive(t, messages).Data.(*uni.ChatMessage).Content; got != "bye" {
+		t.Errorf("got %q", got)
+	}
+	select {
+	case _, ok := <-messages:
+		if ok {
+			t.Error("unexpected message")
+		}
+	case <-time.After(time.Second):
+		t.Fatal("channel not closed after listener returned")
+	}
+	if rooms := e.Rooms(); len(rooms) != 0 {
+		t.Errorf("got %d rooms, want 0", len(rooms))
+	}
+
+	// 房间移除后可以重新启动
+	if _, err := e.Start(uni.BiliBili, "1", RoomOptions{}); err != nil {
+		t.Fatal(err)
+	}
+}
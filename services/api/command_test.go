@@ -0,0 +1,68 @@
+package api
+
+import (
+	"UniBarrage/pkg/command"
+	"UniBarrage/pkg/pipeline"
+	uni "UniBarrage/universal"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// 命令在房间阶段之后解析，被房间黑名单过滤的用户不能触发命令
+func TestCommandsRespectRoomStages(t *testing.T) {
+	s := newTestServer(t)
+	if err := s.pipeline.Load(pipeline.Config{Rooms: []pipeline.RoomConfig{{
+		Platform: string(uni.BiliBili),
+		RoomID:   "1",
+		Stages:   []pipeline.StageConfig{{Type: "blacklist", Users: []string{"bot"}}},
+	}}}); err != nil {
+		t.Fatal(err)
+	}
+	s.commands.Load(command.Config{Prefix: "!"})
+	t.Cleanup(func() {
+		_ = s.pipeline.Load(pipeline.Config{})
+		s.commands.Load(command.Config{})
+	})
+
+	var published []string
+	unsubscribe := s.bus.Subscribe(uni.PublisherFunc(func(msg *uni.UniMessage) {
+		switch data := msg.Data.(type) {
+		case *uni.ChatMessage:
+			published = append(published, "chat:"+data.Name)
+		case *uni.CommandMessage:
+			published = append(published, "command:"+data.User)
+		}
+	}))
+	defer unsubscribe()
+
+	for i, name := range []string{"bot", "alice"} {
+		msg, _ := uni.CreateUniMessage("1", uni.BiliBili, uni.ChatMessageType, &uni.ChatMessage{Name: name, UID: strconv.Itoa(i), Content: "!vote 1"})
+		s.process(msg)
+	}
+	// 原弹幕先于命令消息发布
+	if want := []string{"chat:alice", "command:bilibili:1"}; !reflect.DeepEqual(published, want) {
+		t.Fatalf("published %v, want %v", published, want)
+	}
+}
+
+// 两个 API 实例的命令配置和消息总线互不影响
+func TestServersAreIsolated(t *testing.T) {
+	a, b := newTestServer(t), newTestServer(t)
+	a.Commands().Load(command.Config{Prefix: "!"})
+
+	var got []*uni.UniMessage
+	b.Bus().Subscribe(uni.PublisherFunc(func(msg *uni.UniMessage) { got = append(got, msg) }))
+
+	msg, _ := uni.CreateUniMessage("1", uni.BiliBili, uni.ChatMessageType, &uni.ChatMessage{Name: "alice", Content: "!vote 1"})
+	a.process(msg)
+	if len(got) != 0 {
+		t.Fatalf("server b received %d messages from server a", len(got))
+	}
+
+	msg, _ = uni.CreateUniMessage("1", uni.BiliBili, uni.ChatMessageType, &uni.ChatMessage{Name: "alice", Content: "!vote 1"})
+	b.process(msg)
+	if len(got) != 1 || got[0].Type != uni.ChatMessageType {
+		t.Fatalf("server b without a command prefix published %+v", got)
+	}
+}
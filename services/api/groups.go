@@ -0,0 +1,82 @@
+package api
+
+import (
+	"UniBarrage/pkg/group"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
+	"net/http"
+	"time"
+)
+
+// Groups 获取房间组路由，用于加载配置或作为消息总线的订阅者
+func (s *Server) Groups() *group.Router {
+	return s.groups
+}
+
+// ListGroups 获取所有房间组及其汇总统计
+func (s *Server) ListGroups(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, "获取成功", s.groups.List())
+}
+
+// GetGroup 获取单个房间组及其汇总统计
+func (s *Server) GetGroup(w http.ResponseWriter, r *http.Request) {
+	info, ok := s.groups.Get(chi.URLParam(r, "groupId"))
+	if !ok {
+		jsonError(w, http.StatusNotFound, "房间组未找到")
+		return
+	}
+	jsonResponse(w, http.StatusOK, "获取成功", info)
+}
+
+// SetGroup 添加或替换房间组，重新加载配置文件时保留，ID 相同时优先于配置中的房间组
+func (s *Server) SetGroup(w http.ResponseWriter, r *http.Request) {
+	var req struct {
+		Members            []group.Member `json:"members"`
+		SuppressDuplicates bool           `json:"suppressDuplicates"`
+		Window             string         `json:"window,omitempty"` // 去重窗口，如 10m
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	cfg := group.Config{
+		ID:                 chi.URLParam(r, "groupId"),
+		Members:            req.Members,
+		SuppressDuplicates: req.SuppressDuplicates,
+	}
+	if req.Window != "" {
+		window, err := time.ParseDuration(req.Window)
+		if err != nil {
+			jsonError(w, http.StatusBadRequest, "无效的去重窗口")
+			return
+		}
+		cfg.Window = window
+	}
+
+	if err := s.groups.Set(cfg); err != nil {
+		if errors.Is(err, group.ErrInvalidGroup) {
+			jsonError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+		jsonError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	info, _ := s.groups.Get(cfg.ID)
+	jsonResponse(w, http.StatusOK, "设置成功", info)
+}
+
+// DeleteGroup 删除房间组，成员房间的监听服务不受影响
+func (s *Server) DeleteGroup(w http.ResponseWriter, r *http.Request) {
+	id := chi.URLParam(r, "groupId")
+	if !s.groups.Delete(id) {
+		jsonError(w, http.StatusNotFound, "房间组未找到")
+		return
+	}
+	jsonResponse(w, http.StatusOK, "房间组已删除", map[string]string{"id": id})
+}
@@ -0,0 +1,127 @@
+package api
+
+import (
+	"UniBarrage/douyin/jsScript"
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/node"
+	"github.com/goccy/go-json"
+	"net/http"
+	"time"
+)
+
+// 默认超过该时长未收到上游帧的服务视为不健康
+const defaultStaleAfter = 60 * time.Second
+
+// SetStaleAfter 设置服务判定为不健康的无上游帧时长
+func (s *Server) SetStaleAfter(d time.Duration) {
+	if d > 0 {
+		s.services.staleAfter.Store(int64(d))
+	}
+}
+
+// 依赖检查结果
+const (
+	checkOK      = "ok"
+	checkFailed  = "failed"
+	checkSkipped = "skipped" // 当前配置下无需检查
+)
+
+// LastFrame 最近一次收到上游帧的时间，尚未收到时为零值
+func (s *ServiceStatus) LastFrame() time.Time {
+	if s.frames == nil {
+		return time.Time{}
+	}
+	return s.frames.LastFrame(uni.Platform(s.Platform), s.RoomID)
+}
+
+// Healthy 服务运行中且在 staleAfter 内收到过上游帧（启动后的首个周期以启动时间计）；
+// staleAfter 在服务管理器返回副本时写入
+func (s *ServiceStatus) Healthy() bool {
+	if s.State != StateRunning {
+		return false
+	}
+	last := s.LastFrame()
+	if last.Before(s.Started) {
+		last = s.Started
+	}
+	return time.Since(last) < s.staleAfter
+}
+
+// 序列化时使用的别名类型，避免 MarshalJSON 递归
+type serviceStatusJSON ServiceStatus
+
+// MarshalJSON 序列化时附带健康状态和最近一次上游帧时间；应序列化服务管理器返回的副本，运行中的服务可能被并发修改
+func (s *ServiceStatus) MarshalJSON() ([]byte, error) {
+	var lastFrame *time.Time
+	if last := s.LastFrame(); !last.IsZero() {
+		lastFrame = &last
+	}
+	return json.Marshal(struct {
+		*serviceStatusJSON
+		Healthy   bool       `json:"healthy"`
+		LastFrame *time.Time `json:"lastFrame,omitempty"`
+	}{
+		serviceStatusJSON: (*serviceStatusJSON)(s),
+		Healthy:           s.Healthy(),
+		LastFrame:         lastFrame,
+	})
+}
+
+// Healthz 存活检查，进程能响应即返回 200
+func Healthz(w http.ResponseWriter, r *http.Request) {
+	jsonResponse(w, http.StatusOK, "ok", nil)
+}
+
+// Readyz 就绪检查：WebSocket 已绑定端口（单端口模式下随 API 服务提供）、启用代理时 BadgerDB 已打开、
+// 存在斗鱼或虎牙房间时 Node.js 可用、存在抖音房间时签名脚本已加载
+func (s *Server) Readyz(w http.ResponseWriter, r *http.Request) {
+	platforms := make(map[uni.Platform]bool)
+	unhealthy := make([]*ServiceStatus, 0)
+	for _, status := range s.services.GetAllServices() {
+		if status.State == StateRunning {
+			platforms[uni.Platform(status.Platform)] = true
+		}
+		if !status.Healthy() {
+			unhealthy = append(unhealthy, status)
+		}
+	}
+
+	checks := map[string]string{
+		"websocket": checkResult(true, s.singlePort || s.engine.WebSocket().Listening()),
+		"badger":    checkResult(s.engine.Proxy().Enabled(), s.engine.Proxy().CacheReady()),
+		"node":      checkSkipped,
+		"goja":      checkSkipped,
+	}
+	if platforms[uni.DouYu] || platforms[uni.HuYa] {
+		checks["node"] = checkResult(true, node.Available())
+	}
+	if platforms[uni.DouYin] {
+		checks["goja"] = checkResult(true, jsScript.Loaded())
+	}
+
+	code, message := http.StatusOK, "ready"
+	for _, result := range checks {
+		if result == checkFailed {
+			code, message = http.StatusServiceUnavailable, "not ready"
+			break
+		}
+	}
+
+	// 单个服务不健康不影响就绪状态，仅在结果中列出
+	jsonResponse(w, code, message, map[string]interface{}{
+		"checks":    checks,
+		"unhealthy": unhealthy,
+	})
+}
+
+// 根据是否需要检查及检查结果返回状态
+func checkResult(required bool, ok bool) string {
+	switch {
+	case !required:
+		return checkSkipped
+	case ok:
+		return checkOK
+	default:
+		return checkFailed
+	}
+}
@@ -0,0 +1,18 @@
+package api
+
+import (
+	_ "embed"
+	"net/http"
+)
+
+// OpenAPI 3 文档，与 newRouter 中的路由保持一致
+//
+//go:embed openapi.json
+var openAPISpec []byte
+
+// ServeOpenAPI 提供嵌入的 OpenAPI 文档
+func ServeOpenAPI(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(openAPISpec)
+}
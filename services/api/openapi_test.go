@@ -0,0 +1,62 @@
+package api
+
+import (
+	"net/http"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/goccy/go-json"
+)
+
+// 路由与 openapi.json 中的 paths 必须一一对应，包括单端口模式下挂载的 /ws 和 /image
+func TestOpenAPICoversRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.SetSinglePort(true)
+
+	var spec struct {
+		Paths map[string]map[string]json.RawMessage `json:"paths"`
+	}
+	if err := json.Unmarshal(openAPISpec, &spec); err != nil {
+		t.Fatalf("parse openapi.json: %v", err)
+	}
+
+	documented := make(map[string]bool)
+	for path, item := range spec.Paths {
+		for method := range item {
+			if method != "parameters" {
+				documented[strings.ToUpper(method)+" "+path] = true
+			}
+		}
+	}
+
+	routes := make(map[string]bool)
+	err := chi.Walk(s.newRouter([]string{"*"}), func(method string, route string, _ http.Handler, _ ...func(http.Handler) http.Handler) error {
+		if route == "/" {
+			return nil // Dashboard 页面
+		}
+		routes[method+" "+route] = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var missing, stale []string
+	for route := range routes {
+		if !documented[route] {
+			missing = append(missing, route)
+		}
+	}
+	for route := range documented {
+		if !routes[route] {
+			stale = append(stale, route)
+		}
+	}
+	sort.Strings(missing)
+	sort.Strings(stale)
+	if len(missing) > 0 || len(stale) > 0 {
+		t.Fatalf("undocumented routes: %v, documented but not routed: %v", missing, stale)
+	}
+}
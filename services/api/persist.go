@@ -0,0 +1,249 @@
+package api
+
+import (
+	log "UniBarrage/utils/trace"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"github.com/goccy/go-json"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// 持久化的服务期望状态，cookie 使用 AES-GCM 加密后保存
+type persistedService struct {
+	Platform string `json:"platform"`
+	RoomID   string `json:"rid"`
+	Cookie   string `json:"cookie,omitempty"`
+	Debug    bool   `json:"debug,omitempty"`
+}
+
+// 服务期望状态存储
+type stateStore struct {
+	mu       sync.Mutex
+	path     string
+	aead     cipher.AEAD
+	services map[string]persistedService
+}
+
+// EnablePersistence 启用服务持久化，secret 为空时在状态文件旁生成随机密钥
+func (s *Server) EnablePersistence(path string, secret string) error {
+	key, err := loadStateKey(path, secret)
+	if err != nil {
+		return err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return err
+	}
+
+	store := &stateStore{path: path, aead: aead, services: make(map[string]persistedService)}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("读取状态文件失败: %w", err)
+	}
+	if len(data) > 0 {
+		var services []persistedService
+		if err := json.Unmarshal(data, &services); err != nil {
+			return fmt.Errorf("解析状态文件失败: %w", err)
+		}
+		for _, service := range services {
+			store.services[generateServiceKey(service.Platform, service.RoomID)] = service
+		}
+	}
+
+	s.store = store
+	return nil
+}
+
+// RestoreServices 恢复上次运行时持久化的服务，失败的服务可通过状态接口查看；
+// skip 中的服务（如配置文件中的房间，key 为 platform_rid）不恢复，由调用方启动和管理
+func (s *Server) RestoreServices(skip ...string) {
+	if s.store == nil {
+		return
+	}
+	skipped := make(map[string]bool, len(skip))
+	for _, key := range skip {
+		skipped[key] = true
+	}
+
+	s.store.mu.Lock()
+	services := make([]persistedService, 0, len(s.store.services))
+	for _, service := range s.store.services {
+		services = append(services, service)
+	}
+	s.store.mu.Unlock()
+
+	for _, service := range services {
+		serviceKey := generateServiceKey(service.Platform, service.RoomID)
+		if skipped[serviceKey] {
+			log.Log("INFO", fmt.Sprintf("服务 %s 由配置文件启动，跳过恢复", serviceKey),
+				log.Platform(service.Platform), log.RID(service.RoomID), log.Event("service_restore"))
+			continue
+		}
+		cookie, err := s.store.decrypt(service.Cookie)
+		if err == nil {
+			err = s.StartRoom(service.Platform, service.RoomID, RoomOptions{
+				Cookie:  cookie,
+				Debug:   service.Debug,
+				Persist: true,
+			})
+		}
+		if err != nil {
+			log.Log("WARN", fmt.Sprintf("恢复服务 %s 失败", serviceKey),
+				log.Platform(service.Platform), log.RID(service.RoomID), log.Event("service_restore"), log.Err(err))
+			s.services.AddFailure(serviceKey, &ServiceStatus{
+				Platform: service.Platform,
+				RoomID:   service.RoomID,
+				Error:    err.Error(),
+				Debug:    service.Debug,
+				Persist:  true,
+			})
+			continue
+		}
+		log.Log("INFO", fmt.Sprintf("已恢复服务 %s", serviceKey),
+			log.Platform(service.Platform), log.RID(service.RoomID), log.Event("service_restore"))
+	}
+}
+
+// 记录服务期望状态
+func (s *Server) saveDesiredState(platform string, roomID string, opts RoomOptions) {
+	if s.store == nil {
+		return
+	}
+
+	cookie, err := s.store.encrypt(opts.Cookie)
+	if err != nil {
+		log.Printf("ERROR", "加密 cookie 失败: %v", err)
+		return
+	}
+
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	s.store.services[generateServiceKey(platform, roomID)] = persistedService{
+		Platform: platform,
+		RoomID:   roomID,
+		Cookie:   cookie,
+		Debug:    opts.Debug,
+	}
+	if err := s.store.save(); err != nil {
+		log.Printf("ERROR", "保存服务状态失败: %v", err)
+	}
+}
+
+// 移除服务期望状态
+func (s *Server) removeDesiredState(platform string, roomID string) {
+	if s.store == nil {
+		return
+	}
+
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	serviceKey := generateServiceKey(platform, roomID)
+	if _, exists := s.store.services[serviceKey]; !exists {
+		return
+	}
+	delete(s.store.services, serviceKey)
+	if err := s.store.save(); err != nil {
+		log.Printf("ERROR", "保存服务状态失败: %v", err)
+	}
+}
+
+// 写入状态文件，先写临时文件再重命名，避免中途退出导致文件损坏
+func (s *stateStore) save() error {
+	services := make([]persistedService, 0, len(s.services))
+	for _, service := range s.services {
+		services = append(services, service)
+	}
+	sort.Slice(services, func(i, j int) bool {
+		return generateServiceKey(services[i].Platform, services[i].RoomID) <
+			generateServiceKey(services[j].Platform, services[j].RoomID)
+	})
+
+	data, err := json.MarshalIndent(services, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return err
+	}
+
+	tmp := s.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.path)
+}
+
+// 加密 cookie，结果为 base64(nonce + 密文)
+func (s *stateStore) encrypt(plain string) (string, error) {
+	if plain == "" {
+		return "", nil
+	}
+	nonce := make([]byte, s.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := s.aead.Seal(nonce, nonce, []byte(plain), nil)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// 解密 cookie
+func (s *stateStore) decrypt(encoded string) (string, error) {
+	if encoded == "" {
+		return "", nil
+	}
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(data) < s.aead.NonceSize() {
+		return "", fmt.Errorf("cookie 格式错误")
+	}
+	nonce, sealed := data[:s.aead.NonceSize()], data[s.aead.NonceSize():]
+	plain, err := s.aead.Open(nil, nonce, sealed, nil)
+	if err != nil {
+		return "", fmt.Errorf("cookie 解密失败，请检查状态密钥")
+	}
+	return string(plain), nil
+}
+
+// 获取加密密钥：优先使用传入的 secret，否则读取或生成 {path}.key
+func loadStateKey(path string, secret string) ([]byte, error) {
+	if secret != "" {
+		sum := sha256.Sum256([]byte(secret))
+		return sum[:], nil
+	}
+
+	keyFile := path + ".key"
+	if data, err := os.ReadFile(keyFile); err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != 32 {
+			return nil, fmt.Errorf("密钥文件格式错误: %s", keyFile)
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("读取密钥文件失败: %w", err)
+	}
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(keyFile), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(keyFile, []byte(hex.EncodeToString(key)), 0600); err != nil {
+		return nil, fmt.Errorf("写入密钥文件失败: %w", err)
+	}
+	return key, nil
+}
@@ -0,0 +1,282 @@
+package api
+
+import (
+	"UniBarrage/pkg/unibarrage"
+	"context"
+	"github.com/goccy/go-json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// 使用新的采集引擎创建 API 服务实例，测试结束后关闭引擎
+func newTestServer(t *testing.T) *Server {
+	t.Helper()
+	engine, err := unibarrage.New(unibarrage.Options{CaptureDir: t.TempDir()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = engine.Close(context.Background()) })
+	return New(engine)
+}
+
+// 读取状态文件中保存的服务
+func persisted(t *testing.T, s *Server, platform string, roomID string) persistedService {
+	t.Helper()
+	s.store.mu.Lock()
+	defer s.store.mu.Unlock()
+	service, ok := s.store.services[generateServiceKey(platform, roomID)]
+	if !ok {
+		t.Fatalf("service %s_%s not persisted", platform, roomID)
+	}
+	return service
+}
+
+func TestPersistenceRoundTrip(t *testing.T) {
+	s := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "state", "services.json")
+	if err := s.EnablePersistence(path, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	s.saveDesiredState("douyin", "1", RoomOptions{Cookie: "SESSDATA=secret", Debug: true})
+	s.saveDesiredState("huya", "2", RoomOptions{})
+
+	// cookie 加密保存，密钥文件仅当前用户可读
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "SESSDATA") {
+		t.Fatalf("cookie stored in plain text: %s", data)
+	}
+	info, err := os.Stat(path + ".key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("key file mode = %v, want 0600", info.Mode().Perm())
+	}
+
+	// 重新加载后使用同一密钥解密
+	if err := s.EnablePersistence(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	service := persisted(t, s, "douyin", "1")
+	cookie, err := s.store.decrypt(service.Cookie)
+	if err != nil || cookie != "SESSDATA=secret" || !service.Debug {
+		t.Fatalf("restored %+v cookie %q err %v", service, cookie, err)
+	}
+	if service := persisted(t, s, "huya", "2"); service.Cookie != "" {
+		t.Errorf("empty cookie saved as %q", service.Cookie)
+	}
+
+	s.removeDesiredState("huya", "2")
+	if err := s.EnablePersistence(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.store.services) != 1 {
+		t.Errorf("expected 1 service after removal, got %d", len(s.store.services))
+	}
+}
+
+func TestPersistenceWrongKey(t *testing.T) {
+	s := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "services.json")
+	if err := s.EnablePersistence(path, "first"); err != nil {
+		t.Fatal(err)
+	}
+	s.saveDesiredState("douyin", "1", RoomOptions{Cookie: "SESSDATA=secret"})
+
+	// 更换密钥后无法解密
+	if err := s.EnablePersistence(path, "second"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.decrypt(persisted(t, s, "douyin", "1").Cookie); err == nil {
+		t.Fatal("decrypt with wrong key should fail")
+	}
+
+	// 恢复时解密失败的服务记录为失败，不会启动
+	s.RestoreServices()
+	key := generateServiceKey("douyin", "1")
+	t.Cleanup(func() { s.services.RemoveFailure(key) })
+	failure, ok := s.services.GetFailure(key)
+	if !ok || failure.Error == "" || !failure.Persist {
+		t.Fatalf("expected restore failure, got %+v", failure)
+	}
+	if _, running := s.services.GetService(key); running {
+		t.Error("service started with undecryptable cookie")
+	}
+}
+
+func TestPersistenceMissingKeyFile(t *testing.T) {
+	s := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "services.json")
+	if err := s.EnablePersistence(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.saveDesiredState("douyin", "1", RoomOptions{Cookie: "SESSDATA=secret"})
+
+	// 密钥文件丢失时生成新密钥，已保存的 cookie 无法解密
+	if err := os.Remove(path + ".key"); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePersistence(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.decrypt(persisted(t, s, "douyin", "1").Cookie); err == nil {
+		t.Fatal("decrypt with regenerated key should fail")
+	}
+
+	// 密钥文件格式错误时拒绝启用
+	if err := os.WriteFile(path+".key", []byte("not hex"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePersistence(path, ""); err == nil {
+		t.Fatal("expected error for malformed key file")
+	}
+}
+
+func TestPersistenceCorruptFile(t *testing.T) {
+	s := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "services.json")
+	if err := os.WriteFile(path, []byte("{not json"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePersistence(path, "secret"); err == nil {
+		t.Fatal("expected error for corrupt state file")
+	}
+	if s.store != nil {
+		t.Error("store enabled despite corrupt state file")
+	}
+
+	// cookie 被篡改时解密失败
+	if err := os.WriteFile(path, []byte(`[{"platform":"douyin","rid":"1","cookie":"!!"}]`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePersistence(path, "secret"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.store.decrypt(persisted(t, s, "douyin", "1").Cookie); err == nil {
+		t.Fatal("decrypt of malformed cookie should fail")
+	}
+}
+
+// 修改抓包状态时更新持久化状态，并与状态查询并发安全
+func TestSetDebugCapturePersists(t *testing.T) {
+	s := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "services.json")
+	if err := s.EnablePersistence(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	s.saveDesiredState("douyin", "1", RoomOptions{Cookie: "SESSDATA=secret", Persist: true})
+
+	// 不启动监听，只登记服务
+	key := generateServiceKey("douyin", "1")
+	status := &ServiceStatus{Platform: "douyin", RoomID: "1", Persist: true, cookie: "SESSDATA=secret"}
+	if err := s.services.AddService(key, status); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		status.stopped.Store(true)
+		s.services.RemoveService(key, status)
+	})
+
+	r := s.newRouter(nil)
+	setDebug := func(enabled string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, httptest.NewRequest(http.MethodPut, "/api/v1/douyin/1/debug", strings.NewReader(`{"enabled":`+enabled+`}`)))
+		return rec
+	}
+	t.Cleanup(func() { setDebug("false") })
+
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 20; i++ {
+			r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/api/v1/all", nil))
+		}
+	}()
+	rec := setDebug("true")
+	wg.Wait()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("set debug: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	r.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/douyin/1", nil))
+	var resp struct {
+		Data struct {
+			Debug bool `json:"debug"`
+		} `json:"data"`
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); err != nil || !resp.Data.Debug {
+		t.Fatalf("service detail: %s", rec.Body.String())
+	}
+
+	// 重新加载后抓包状态和 cookie 均保留
+	if err := s.EnablePersistence(path, ""); err != nil {
+		t.Fatal(err)
+	}
+	service := persisted(t, s, "douyin", "1")
+	cookie, err := s.store.decrypt(service.Cookie)
+	if err != nil || cookie != "SESSDATA=secret" || !service.Debug {
+		t.Fatalf("persisted %+v cookie %q err %v", service, cookie, err)
+	}
+}
+
+// 服务状态的序列化不依赖服务管理器的锁，持锁时也能完成
+func TestServiceStatusMarshalWithoutManagerLock(t *testing.T) {
+	s := newTestServer(t)
+	key := generateServiceKey("douyin", "2")
+	status := &ServiceStatus{Platform: "douyin", RoomID: "2", Debug: true}
+	if err := s.services.AddService(key, status); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.services.RemoveService(key, status) })
+
+	snapshot, ok := s.services.GetService(key)
+	if !ok || snapshot == status {
+		t.Fatal("GetService should return a copy")
+	}
+
+	s.services.rwMutex.Lock()
+	defer s.services.rwMutex.Unlock()
+	data, err := json.Marshal(snapshot)
+	if err != nil || !strings.Contains(string(data), `"debug":true`) {
+		t.Fatalf("marshal: %s %v", data, err)
+	}
+}
+
+// 跳过的服务（配置文件中的房间）不恢复，也不记录为失败
+func TestRestoreSkipsConfigRooms(t *testing.T) {
+	s := newTestServer(t)
+	path := filepath.Join(t.TempDir(), "services.json")
+	state := `[{"platform":"douyin","rid":"1","cookie":"!!"},{"platform":"douyin","rid":"2","cookie":"!!"}]`
+	if err := os.WriteFile(path, []byte(state), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.EnablePersistence(path, "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	skipped, restored := generateServiceKey("douyin", "1"), generateServiceKey("douyin", "2")
+	s.RestoreServices(skipped)
+	t.Cleanup(func() { s.services.RemoveFailure(restored) })
+
+	if _, ok := s.services.GetFailure(skipped); ok {
+		t.Error("skipped service was restored")
+	}
+	if _, ok := s.services.GetService(skipped); ok {
+		t.Error("skipped service is running")
+	}
+	// 未跳过的服务照常恢复（cookie 无法解密，记录为失败）
+	if _, ok := s.services.GetFailure(restored); !ok {
+		t.Error("service not in skip list was not restored")
+	}
+}
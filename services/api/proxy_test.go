@@ -0,0 +1,39 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 启用签名时生成代理 URL 需要 token，未设置 token 时接口禁用
+func TestProxyURLRequiresTokenWhenSigning(t *testing.T) {
+	s := newTestServer(t)
+	r := s.newRouter(nil)
+	get := func(token string) int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/proxy/url?url=https://i0.hdslb.com/a.png&w=64", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := get(""); code != http.StatusOK {
+		t.Fatalf("signing disabled: expected 200, got %d", code)
+	}
+
+	s.engine.Proxy().SetSigning("secret", 0)
+	if code := get(""); code != http.StatusForbidden {
+		t.Fatalf("signing without token: expected 403, got %d", code)
+	}
+
+	s.SetAuthTokens([]string{"token"})
+	if code := get(""); code != http.StatusUnauthorized {
+		t.Fatalf("missing token: expected 401, got %d", code)
+	}
+	if code := get("token"); code != http.StatusOK {
+		t.Fatalf("valid token: expected 200, got %d", code)
+	}
+}
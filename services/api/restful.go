@@ -1,35 +1,141 @@
 package api
 
 import (
-	"UniBarrage/bilibili"
-	"UniBarrage/douyin"
-	"UniBarrage/douyu"
-	"UniBarrage/huya"
-	"UniBarrage/kuaishou"
-	"UniBarrage/xiaohongshu"
+	"UniBarrage/pkg/command"
+	"UniBarrage/pkg/group"
+	"UniBarrage/pkg/pipeline"
+	"UniBarrage/pkg/unibarrage"
 	uni "UniBarrage/universal"
+	"UniBarrage/utils/assets"
+	"UniBarrage/utils/capture"
+	"UniBarrage/utils/cors"
+	"UniBarrage/utils/metrics"
 	log "UniBarrage/utils/trace"
 	"UniBarrage/web"
 	"context"
+	"crypto/subtle"
 	"errors"
 	"fmt"
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
-	"github.com/go-chi/cors"
 	"github.com/goccy/go-json"
 	"net/http"
-	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
-// WebSocket configuration
-var wsPort int
+// Server API 服务实例，持有服务管理器、消息处理链、弹幕命令、房间组和消息总线，由 New 使用注入的引擎创建；
+// 同一进程中可以创建多个互不影响的实例
+type Server struct {
+	engine     *unibarrage.Engine
+	services   *ServiceManager
+	bus        *uni.Bus           // 所有房间消息的总线，WebSocket、指标等作为订阅者
+	pipeline   *pipeline.Pipeline // 消息发布到总线前经过的处理链
+	commands   *command.Processor // 弹幕命令处理器，解析通过处理链的弹幕
+	groups     *group.Router      // 房间组，将多个平台的房间合并为一个逻辑频道
+	store      *stateStore        // 服务期望状态存储，未启用持久化时为 nil
+	httpServer atomic.Pointer[http.Server]
+	authTokens atomic.Value // 允许访问 API 的 Bearer Token 列表，为空时不做认证
+	wsPort     int
+	singlePort bool // 是否在 API 端口上同时提供 WebSocket (/ws) 和图片代理 (/image)
+}
+
+// New 使用采集引擎创建 API 服务实例，需在加载房间组、启动 API 服务和房间之前调用
+func New(engine *unibarrage.Engine) *Server {
+	s := &Server{
+		engine:   engine,
+		bus:      uni.NewBus(),
+		pipeline: pipeline.New(),
+		commands: command.New(),
+		groups:   group.New(),
+	}
+	s.services = NewServiceManager(engine, uni.PublisherFunc(s.process))
+	return s
+}
+
+// 房间消息经处理链后发布；命令只从未被关键词、黑名单等阶段过滤的弹幕中解析，
+// 命令消息及其响应在原弹幕之后按顺序发布
+func (s *Server) process(msg *uni.UniMessage) {
+	if msg = s.pipeline.Process(msg); msg == nil {
+		return
+	}
+	s.publish(msg)
+	for _, extra := range s.commands.Process(msg) {
+		s.publish(extra)
+	}
+}
+
+// 替换图片地址后发布到消息总线，上游消息和处理链额外产生的消息都经由此处发布
+func (s *Server) publish(msg *uni.UniMessage) {
+	s.engine.Proxy().RewriteMessage(msg)
+	s.bus.Publish(msg)
+}
+
+// Bus 获取消息总线，用于添加订阅者
+func (s *Server) Bus() *uni.Bus {
+	return s.bus
+}
+
+// Output 获取替换图片地址后发布到消息总线的发布者，用于消息脚本等额外产生的消息
+func (s *Server) Output() uni.Publisher {
+	return uni.PublisherFunc(s.publish)
+}
+
+// Pipeline 获取消息处理链，用于加载配置或添加自定义阶段
+func (s *Server) Pipeline() *pipeline.Pipeline {
+	return s.pipeline
+}
 
-func StartServer(host string, port int, certFile string, keyFile string, expectedToken string, allowedOrigins []string, websocketPort int) {
+// Commands 获取弹幕命令处理器，用于加载配置或注册命令处理函数
+func (s *Server) Commands() *command.Processor {
+	return s.commands
+}
+
+// Services 获取服务管理器
+func (s *Server) Services() *ServiceManager {
+	return s.services
+}
+
+// SetSinglePort 设置是否在 API 端口上挂载 WebSocket 和图片代理，共用同一份证书与跨域配置，需在 StartServer 之前调用
+func (s *Server) SetSinglePort(enabled bool) {
+	s.singlePort = enabled
+}
+
+// StartServer 启动 API 服务，阻塞直到服务关闭
+func (s *Server) StartServer(host string, port int, certFile string, keyFile string, expectedTokens []string, allowedOrigins []string, websocketPort int) {
 	// Store WebSocket port
-	wsPort = websocketPort
+	s.wsPort = websocketPort
+	if s.singlePort {
+		s.wsPort = port
+	}
+	s.SetAuthTokens(expectedTokens)
+	r := s.newRouter(allowedOrigins)
+
+	addr := fmt.Sprintf("%s:%d", host, port)
+	server := &http.Server{Addr: addr, Handler: r}
+	s.httpServer.Store(server)
+
+	if certFile != "" && keyFile != "" {
+		// 启动 HTTPS 服务
+		log.Printf("INFO", "API: https://%s", addr)
+		log.Printf("INFO", "Dashboard: https://%s", addr)
+		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("ERROR", "服务器启动失败: %v", err)
+		}
+	} else {
+		// 启动 HTTP 服务
+		log.Printf("INFO", "API: http://%s", addr)
+		log.Printf("INFO", "Dashboard: http://%s", addr)
+		if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			log.Printf("ERROR", "服务器启动失败: %v", err)
+		}
+	}
+}
+
+// 创建 API 路由，新增路由时需同步更新 openapi.json
+func (s *Server) newRouter(allowedOrigins []string) chi.Router {
 	r := chi.NewRouter()
 
 	// 中间件
@@ -37,64 +143,126 @@ func StartServer(host string, port int, certFile string, keyFile string, expecte
 	r.Use(middleware.Recoverer)
 
 	// 配置 CORS 中间件，使用传入的 allowedOrigins 数组
-	corsOptions := cors.Options{
-		AllowedOrigins:   allowedOrigins, // 使用传入的数组
-		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
-		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
-		ExposedHeaders:   []string{"Link"},
-		AllowCredentials: true,
-		MaxAge:           300, // 缓存预检请求的结果的最大时间（秒）
-	}
-	r.Use(cors.Handler(corsOptions))
+	r.Use(cors.Handler(allowedOrigins))
 
 	// Dashboard 路由（不需要认证）
 	r.Get("/", ServeDashboard)
 
+	// 存活与就绪检查（不需要认证，供容器探针使用）
+	r.Get("/healthz", Healthz)
+	r.Get("/readyz", s.Readyz)
+
+	// Prometheus 指标，配置了 token 时同样需要认证
+	r.With(s.AuthMiddleware()).Method(http.MethodGet, "/metrics", metrics.Handler())
+
+	// OpenAPI 文档（不需要认证）
+	r.Get("/api/v1/openapi.json", ServeOpenAPI)
+
+	// 单端口模式：WebSocket 与图片代理共用 API 的端口、证书和跨域配置
+	if s.singlePort {
+		wsHandler := http.StripPrefix("/ws", s.engine.WebSocket().Handler())
+		r.Method(http.MethodGet, "/ws", wsHandler)
+		r.Method(http.MethodGet, "/ws/{platform}", wsHandler)
+		r.Method(http.MethodGet, "/ws/{platform}/{roomId}", wsHandler)
+
+		images := s.engine.Proxy()
+		r.Get("/image", images.ServeImage)
+		r.With(s.AuthMiddleware()).Get("/image/stats", images.ServeStats)
+		// 清除缓存会让所有客户端重新下载图片，未设置 token 时不开放
+		r.With(s.TokenRequiredMiddleware()).Post("/image/purge", images.ServePurge)
+		r.With(s.TokenRequiredMiddleware()).Delete("/image/purge", images.ServePurge)
+	}
+
 	// API 路由（需要认证）
 	r.Route("/api/v1", func(r chi.Router) {
-		// 仅在指定了 token 时对 API 路由使用 AuthMiddleware
-		if expectedToken != "" {
-			r.Use(AuthMiddleware(expectedToken))
-		}
+		// 未配置 token 时 AuthMiddleware 直接放行，便于重新加载配置时启用认证
+		r.Use(s.AuthMiddleware())
 		// 欢迎信息
-		r.Get("/", Hello)
+		r.Get("/", s.Hello)
 		// 获取 WebSocket 配置
-		r.Get("/config/websocket", GetWebSocketConfig)
+		r.Get("/config/websocket", s.GetWebSocketConfig)
+		// 生成带转换参数的代理图片 URL
+		r.Get("/proxy/url", s.GetProxyImageURL)
 		// 获取所有服务状态
-		r.Get("/all", ListAllServices)
+		r.Get("/all", s.ListAllServices)
+		// 房间组
+		r.Get("/groups", s.ListGroups)
+		r.Get("/groups/{groupId}", s.GetGroup)
+		r.Put("/groups/{groupId}", s.SetGroup)
+		r.Delete("/groups/{groupId}", s.DeleteGroup)
 		// 获取指定平台的所有服务
-		r.Get("/{platform}", ListPlatformServices)
+		r.Get("/{platform}", s.ListPlatformServices)
+		// 获取平台礼物与表情目录
+		r.Get("/{platform}/gifts", s.GetGiftCatalog)
+		r.Get("/{platform}/emotes", s.GetEmoteCatalog)
 		// 获取单个服务状态
-		r.Get("/{platform}/{roomId}", GetServiceDetail)
+		r.Get("/{platform}/{roomId}", s.GetServiceDetail)
 		// 启动服务
-		r.Post("/{platform}", StartService)
+		r.Post("/{platform}", s.StartService)
 		// 停止服务
-		r.Delete("/{platform}/{roomId}", StopService)
+		r.Delete("/{platform}/{roomId}", s.StopService)
+		// 获取房间实时统计
+		r.Get("/{platform}/{roomId}/stats", s.GetRoomStats)
+		// 获取未映射的上游消息类型
+		r.Get("/{platform}/{roomId}/unhandled", s.GetUnhandledKinds)
+		// 开关上游帧抓包
+		r.Put("/{platform}/{roomId}/debug", s.SetDebugCapture)
 	})
 
-	addr := fmt.Sprintf("%s:%d", host, port)
+	return r
+}
 
-	if certFile != "" && keyFile != "" {
-		// 启动 HTTPS 服务
-		log.Printf("INFO", "API: https://%s", addr)
-		log.Printf("INFO", "Dashboard: https://%s", addr)
-		if err := http.ListenAndServeTLS(addr, certFile, keyFile, r); err != nil {
-			log.Printf("ERROR", "服务器启动失败: %v", err)
+// Shutdown 停止接受新的 API 请求
+func (s *Server) Shutdown(ctx context.Context) error {
+	server := s.httpServer.Load()
+	if server == nil {
+		return nil
+	}
+	return server.Shutdown(ctx)
+}
+
+// StopAllRooms 停止所有房间监听服务并等待其退出，持久化状态保持不变
+func (s *Server) StopAllRooms(ctx context.Context) error {
+	return s.services.StopAll(ctx)
+}
+
+// SetAuthTokens 设置允许访问 API 的 Bearer Token 列表，可在运行时替换
+func (s *Server) SetAuthTokens(tokens []string) {
+	valid := make([]string, 0, len(tokens))
+	for _, token := range tokens {
+		if token = strings.TrimSpace(token); token != "" {
+			valid = append(valid, token)
 		}
-	} else {
-		// 启动 HTTP 服务
-		log.Printf("INFO", "API: http://%s", addr)
-		log.Printf("INFO", "Dashboard: http://%s", addr)
-		if err := http.ListenAndServe(addr, r); err != nil {
-			log.Printf("ERROR", "服务器启动失败: %v", err)
+	}
+	s.authTokens.Store(valid)
+}
+
+// 当前允许的 Token 列表
+func (s *Server) tokens() []string {
+	tokens, _ := s.authTokens.Load().([]string)
+	return tokens
+}
+
+// 校验 Token 是否在允许列表中
+func isTokenAllowed(token string, tokens []string) bool {
+	for _, expected := range tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(expected)) == 1 {
+			return true
 		}
 	}
+	return false
 }
 
 // AuthMiddleware 用于验证 Bearer Token 的中间件
-func AuthMiddleware(expectedToken string) func(http.Handler) http.Handler {
+func (s *Server) AuthMiddleware() func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			tokens := s.tokens()
+			if len(tokens) == 0 {
+				next.ServeHTTP(w, r)
+				return
+			}
+
 			// 获取 Authorization 头
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" || !strings.HasPrefix(authHeader, "Bearer ") {
@@ -104,7 +272,7 @@ func AuthMiddleware(expectedToken string) func(http.Handler) http.Handler {
 
 			// 提取 Token 并验证
 			token := strings.TrimPrefix(authHeader, "Bearer ")
-			if token != expectedToken {
+			if !isTokenAllowed(token, tokens) {
 				jsonError(w, http.StatusUnauthorized, "无效的 Token")
 				return
 			}
@@ -115,22 +283,62 @@ func AuthMiddleware(expectedToken string) func(http.Handler) http.Handler {
 	}
 }
 
+// TokenRequiredMiddleware 与 AuthMiddleware 相同，但未配置 token 时拒绝所有请求（403），用于清除缓存等破坏性接口
+func (s *Server) TokenRequiredMiddleware() func(http.Handler) http.Handler {
+	auth := s.AuthMiddleware()
+	return func(next http.Handler) http.Handler {
+		authed := auth(next)
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if len(s.tokens()) == 0 {
+				jsonError(w, http.StatusForbidden, "未设置 authToken，接口已禁用")
+				return
+			}
+			authed.ServeHTTP(w, r)
+		})
+	}
+}
+
+// 服务状态
+const (
+	StateRunning = "running" // 监听中
+	StateFailed  = "failed"  // 恢复失败或异常退出
+)
+
 type ServiceStatus struct {
-	Platform string        `json:"platform"`
-	RoomID   string        `json:"rid"`
-	StopChan chan struct{} `json:"-"`
+	Platform   string         `json:"platform"`
+	RoomID     string         `json:"rid"`
+	State      string         `json:"state"`           // 服务状态
+	Error      string         `json:"error,omitempty"` // 失败原因
+	Debug      bool           `json:"debug"`           // 是否开启上游帧抓包
+	Persist    bool           `json:"persist"`         // 是否持久化，重启后自动恢复
+	Started    time.Time      `json:"startedAt"`       // 启动时间
+	stopped    atomic.Bool    // 是否由用户主动停止
+	cookie     string         // 启动时的 cookie，修改持久化状态时使用
+	frames     *capture.Store // 所属引擎的抓包状态，用于查询最近一次收到上游帧的时间
+	staleAfter time.Duration  // 超过该时长未收到上游帧视为不健康，仅在副本中设置
 }
 
-// ServiceManager 服务管理器
+// ServiceManager 服务管理器，房间由采集引擎运行，消息发布到 out
 type ServiceManager struct {
 	rwMutex  sync.RWMutex
+	engine   *unibarrage.Engine
+	out      uni.Publisher
 	services map[string]*ServiceStatus
+	failures map[string]*ServiceStatus // 恢复失败或异常退出的持久化服务
+	// 超过该时长未收到上游帧的服务视为不健康
+	staleAfter atomic.Int64
 }
 
-func NewServiceManager() *ServiceManager {
-	return &ServiceManager{
+// NewServiceManager 创建服务管理器，房间由 engine 运行，收到的消息发布到 out
+func NewServiceManager(engine *unibarrage.Engine, out uni.Publisher) *ServiceManager {
+	sm := &ServiceManager{
+		engine:   engine,
+		out:      out,
 		services: make(map[string]*ServiceStatus),
+		failures: make(map[string]*ServiceStatus),
 	}
+	sm.staleAfter.Store(int64(defaultStaleAfter))
+	return sm
 }
 
 // AddService 添加服务
@@ -139,197 +347,231 @@ func (sm *ServiceManager) AddService(key string, status *ServiceStatus) error {
 	defer sm.rwMutex.Unlock()
 
 	if _, exists := sm.services[key]; exists {
-		return fmt.Errorf("服务已存在")
+		return fmt.Errorf("%w: %s (%s)", unibarrage.ErrRoomExists, status.Platform, status.RoomID)
 	}
+	status.State = StateRunning
+	status.Started = time.Now()
+	status.frames = sm.engine.Capture()
 	sm.services[key] = status
+	delete(sm.failures, key)
 	return nil
 }
 
-// RemoveService 删除服务
-func (sm *ServiceManager) RemoveService(key string) {
-	sm.rwMutex.Lock()
-	defer sm.rwMutex.Unlock()
-	delete(sm.services, key)
-}
-
-// GetService 获取服务
-func (sm *ServiceManager) GetService(key string) (*ServiceStatus, bool) {
-	sm.rwMutex.RLock()
-	defer sm.rwMutex.RUnlock()
-	status, exists := sm.services[key]
-	return status, exists
-}
-
-// GetAllServices 获取所有服务
-func (sm *ServiceManager) GetAllServices() []*ServiceStatus {
-	sm.rwMutex.RLock()
-	defer sm.rwMutex.RUnlock()
-
-	services := make([]*ServiceStatus, 0, len(sm.services))
-	for _, status := range sm.services {
-		services = append(services, status)
-	}
-	return services
-}
-
-var serviceMap = NewServiceManager()
-
-// 生成服务唯一标识
-func generateServiceKey(platform, roomID string) string {
-	return fmt.Sprintf("%s_%s", platform, roomID)
-}
-
-// DouYinRoom int 抖音房间号
-func startDouyinService(DouYinRoom int, stopChan chan struct{}) error {
-	serviceKey := generateServiceKey("douyin", strconv.Itoa(DouYinRoom))
-
-	status := &ServiceStatus{
-		Platform: "douyin",
-		RoomID:   strconv.Itoa(DouYinRoom),
-		StopChan: stopChan,
+// StartService 添加服务并通过引擎启动房间，房间停止后自动移除
+func (sm *ServiceManager) StartService(status *ServiceStatus, opts unibarrage.RoomOptions) error {
+	key := generateServiceKey(status.Platform, status.RoomID)
+	if err := sm.AddService(key, status); err != nil {
+		return err
 	}
 
-	if err := serviceMap.AddService(serviceKey, status); err != nil {
-		return fmt.Errorf("抖音房间 %d 已在监听中", DouYinRoom)
+	messages, err := sm.engine.Start(uni.Platform(status.Platform), status.RoomID, opts)
+	if err != nil {
+		sm.rwMutex.Lock()
+		delete(sm.services, key)
+		sm.rwMutex.Unlock()
+		return err
 	}
 
+	// 房间统计由引擎在消息进入通道前记录，不受处理链过滤影响
 	go func() {
-		go douyin.StartListen(DouYinRoom, stopChan)
-		<-stopChan
-		serviceMap.RemoveService(serviceKey)
+		for msg := range messages {
+			sm.out.Publish(msg)
+		}
+		metrics.RemoveRoom(uni.Platform(status.Platform), status.RoomID)
+		sm.RemoveService(key, status)
 	}()
-
-	log.Printf("DOUYIN", "提交 DouYin 监听服务 (%d)", DouYinRoom)
 	return nil
 }
 
-// BiliBiliRoom int 哔哩哔哩房间号, cookie string 登录cookie (可选)
-func startBilibiliService(BiliBiliRoom int, cookie string, stopChan chan struct{}) error {
-	serviceKey := generateServiceKey("bilibili", strconv.Itoa(BiliBiliRoom))
+// StopService 主动停止服务
+func (sm *ServiceManager) StopService(key string, status *ServiceStatus) {
+	status.stopped.Store(true)
+	sm.engine.Stop(uni.Platform(status.Platform), status.RoomID)
+	sm.RemoveService(key, status)
+}
 
-	status := &ServiceStatus{
-		Platform: "bilibili",
-		RoomID:   strconv.Itoa(BiliBiliRoom),
-		StopChan: stopChan,
+// RemoveService 删除服务，仅当 key 对应的仍是该服务时删除，避免误删同 key 的新服务；
+// 持久化的服务非主动停止时记录为失败，便于通过状态接口查看
+func (sm *ServiceManager) RemoveService(key string, status *ServiceStatus) {
+	sm.rwMutex.Lock()
+	defer sm.rwMutex.Unlock()
+	if current, exists := sm.services[key]; exists && current == status {
+		delete(sm.services, key)
+		if status.Persist && !status.stopped.Load() {
+			sm.failures[key] = &ServiceStatus{
+				Platform: status.Platform,
+				RoomID:   status.RoomID,
+				State:    StateFailed,
+				Error:    "监听服务异常退出",
+				Persist:  true,
+			}
+		}
 	}
+}
 
-	if err := serviceMap.AddService(serviceKey, status); err != nil {
-		return fmt.Errorf("哔哩哔哩房间 %d 已在监听中", BiliBiliRoom)
+// StopAll 停止所有服务并等待监听函数返回（包括 Node 子进程退出），超时后返回 ctx 的错误；
+// 持久化状态保持不变，以便下次启动时恢复
+func (sm *ServiceManager) StopAll(ctx context.Context) error {
+	sm.rwMutex.RLock()
+	services := make(map[string]*ServiceStatus, len(sm.services))
+	for key, status := range sm.services {
+		services[key] = status
 	}
+	sm.rwMutex.RUnlock()
 
-	go func() {
-		go bilibili.StartListen(BiliBiliRoom, cookie, stopChan)
-		<-stopChan
-		serviceMap.RemoveService(serviceKey)
-	}()
+	for key, status := range services {
+		sm.StopService(key, status)
+	}
+	return sm.engine.StopAll(ctx)
+}
 
-	log.Printf("BILIBILI", "提交 BiliBili 监听服务 (%d)", BiliBiliRoom)
-	return nil
+// AddFailure 记录启动失败的服务
+func (sm *ServiceManager) AddFailure(key string, status *ServiceStatus) {
+	sm.rwMutex.Lock()
+	defer sm.rwMutex.Unlock()
+	status.State = StateFailed
+	sm.failures[key] = status
 }
 
-// KuaiShouRoomLink string 快手房间链接, cookie string 登录cookie (可选)
-func startKuaishouService(KuaiShouRoomLink string, cookie string, stopChan chan struct{}) error {
-	serviceKey := generateServiceKey("kuaishou", KuaiShouRoomLink)
+// RemoveFailure 清除失败记录，记录不存在时返回 false
+func (sm *ServiceManager) RemoveFailure(key string) bool {
+	sm.rwMutex.Lock()
+	defer sm.rwMutex.Unlock()
+	_, exists := sm.failures[key]
+	delete(sm.failures, key)
+	return exists
+}
 
-	status := &ServiceStatus{
-		Platform: "kuaishou",
-		RoomID:   KuaiShouRoomLink,
-		StopChan: stopChan,
+// SetDebug 修改服务的抓包状态，服务不存在时返回 false
+func (sm *ServiceManager) SetDebug(key string, enabled bool) bool {
+	sm.rwMutex.Lock()
+	defer sm.rwMutex.Unlock()
+	status, exists := sm.services[key]
+	if exists {
+		status.Debug = enabled
 	}
+	return exists
+}
 
-	if err := serviceMap.AddService(serviceKey, status); err != nil {
-		return fmt.Errorf("快手房间 %s 已在监听中", KuaiShouRoomLink)
+// 在锁内复制服务状态，返回的副本可以在锁外序列化；运行中的服务只能通过服务管理器修改
+func (sm *ServiceManager) snapshot(s *ServiceStatus) *ServiceStatus {
+	return &ServiceStatus{
+		Platform:   s.Platform,
+		RoomID:     s.RoomID,
+		State:      s.State,
+		Error:      s.Error,
+		Debug:      s.Debug,
+		Persist:    s.Persist,
+		Started:    s.Started,
+		cookie:     s.cookie,
+		frames:     s.frames,
+		staleAfter: time.Duration(sm.staleAfter.Load()),
 	}
-
-	go func() {
-		go kuaishou.StartListen(KuaiShouRoomLink, cookie, stopChan)
-		<-stopChan
-		serviceMap.RemoveService(serviceKey)
-	}()
-
-	log.Printf("KUAISHOU", "提交 KuaiShou 监听服务 (%s)", KuaiShouRoomLink[strings.LastIndex(KuaiShouRoomLink, "/"):])
-	return nil
 }
 
-// DouYuRoom int 斗鱼房间号
-func startDouYuService(DouYuRoom int, stopChan chan struct{}) error {
-	serviceKey := generateServiceKey("douyu", strconv.Itoa(DouYuRoom))
+// 获取运行中的服务本身，用于停止服务
+func (sm *ServiceManager) service(key string) (*ServiceStatus, bool) {
+	sm.rwMutex.RLock()
+	defer sm.rwMutex.RUnlock()
+	status, exists := sm.services[key]
+	return status, exists
+}
 
-	status := &ServiceStatus{
-		Platform: "douyu",
-		RoomID:   strconv.Itoa(DouYuRoom),
-		StopChan: stopChan,
+// GetService 获取服务状态的副本
+func (sm *ServiceManager) GetService(key string) (*ServiceStatus, bool) {
+	sm.rwMutex.RLock()
+	defer sm.rwMutex.RUnlock()
+	status, exists := sm.services[key]
+	if !exists {
+		return nil, false
 	}
+	return sm.snapshot(status), true
+}
 
-	if err := serviceMap.AddService(serviceKey, status); err != nil {
-		return fmt.Errorf("斗鱼房间 %d 已在监听中", DouYuRoom)
+// GetFailure 获取失败记录的副本
+func (sm *ServiceManager) GetFailure(key string) (*ServiceStatus, bool) {
+	sm.rwMutex.RLock()
+	defer sm.rwMutex.RUnlock()
+	status, exists := sm.failures[key]
+	if !exists {
+		return nil, false
 	}
-
-	go func() {
-		go douyu.StartListen(DouYuRoom, stopChan)
-		<-stopChan
-		serviceMap.RemoveService(serviceKey)
-	}()
-
-	log.Printf("DOUYU", "提交 DouYu 监听服务 (%d)", DouYuRoom)
-	return nil
+	return sm.snapshot(status), true
 }
 
-// HuYaRoom string 虎牙房间号
-func startHuYaService(HuYaRoom string, stopChan chan struct{}) error {
-	serviceKey := generateServiceKey("huya", HuYaRoom)
+// GetAllServices 获取所有服务状态的副本，包含失败记录
+func (sm *ServiceManager) GetAllServices() []*ServiceStatus {
+	sm.rwMutex.RLock()
+	defer sm.rwMutex.RUnlock()
 
-	status := &ServiceStatus{
-		Platform: "huya",
-		RoomID:   HuYaRoom,
-		StopChan: stopChan,
+	services := make([]*ServiceStatus, 0, len(sm.services)+len(sm.failures))
+	for _, status := range sm.services {
+		services = append(services, sm.snapshot(status))
 	}
-
-	if err := serviceMap.AddService(serviceKey, status); err != nil {
-		return fmt.Errorf("虎牙房间 %s 已在监听中", HuYaRoom)
+	for _, status := range sm.failures {
+		services = append(services, sm.snapshot(status))
 	}
+	return services
+}
 
-	go func() {
-		go huya.StartListen(HuYaRoom, stopChan)
-		<-stopChan
-		serviceMap.RemoveService(serviceKey)
-	}()
-
-	log.Printf("HUYA", "提交 HuYa 监听服务 (%s)", HuYaRoom)
-	return nil
+// 生成服务唯一标识
+func generateServiceKey(platform, roomID string) string {
+	return fmt.Sprintf("%s_%s", platform, roomID)
 }
 
-// XiaoHongShuRoom string 小红书直播间 ID（/livestream/{id}）, cookie 可选预留
-func startXiaoHongShuService(roomID string, cookie string, stopChan chan struct{}) error {
-	serviceKey := generateServiceKey("xiaohongshu", roomID)
+// RoomOptions 启动房间监听服务的选项
+type RoomOptions struct {
+	Cookie  string // 登录 cookie (可选)
+	Debug   bool   // 是否开启上游帧抓包
+	Persist bool   // 是否持久化，重启后自动恢复
+}
 
+// StartRoom 根据平台启动房间监听服务，供 HTTP 接口和配置文件共用
+func (s *Server) StartRoom(platform string, roomID string, opts RoomOptions) error {
 	status := &ServiceStatus{
-		Platform: "xiaohongshu",
+		Platform: platform,
 		RoomID:   roomID,
-		StopChan: stopChan,
+		Debug:    opts.Debug,
+		Persist:  opts.Persist,
+		cookie:   opts.Cookie,
 	}
-
-	if err := serviceMap.AddService(serviceKey, status); err != nil {
-		return fmt.Errorf("小红书房间 %s 已在监听中", roomID)
+	if err := s.services.StartService(status, unibarrage.RoomOptions{
+		Cookie: opts.Cookie,
+		Debug:  opts.Debug,
+	}); err != nil {
+		return err
 	}
 
-	go func() {
-		go xiaohongshu.StartListen(roomID, cookie, stopChan)
-		<-stopChan
-		serviceMap.RemoveService(serviceKey)
-	}()
-
-	log.Printf("XIAOHONGSHU", "提交 XiaoHongShu 监听服务 (%s)", roomID)
+	log.Log(platform, fmt.Sprintf("提交 (%s) 的监听服务", roomID), log.RID(roomID), log.Event("service_start"))
+	if opts.Persist {
+		s.saveDesiredState(platform, roomID, opts)
+	}
 	return nil
 }
 
+// StopRoom 停止房间监听服务并移出持久化状态，服务不存在时返回 false
+func (s *Server) StopRoom(platform string, roomID string) bool {
+	serviceKey := generateServiceKey(platform, roomID)
+	s.removeDesiredState(platform, roomID)
+	status, exists := s.services.service(serviceKey)
+	if !exists {
+		// 失败记录同样可以通过停止接口清除
+		return s.services.RemoveFailure(serviceKey)
+	}
+	s.services.StopService(serviceKey, status)
+	log.Log(platform, fmt.Sprintf("已停止 (%s) 的监听服务", roomID), log.RID(roomID), log.Event("service_stop"))
+	return true
+}
+
 // StartService HTTP 处理函数
-func StartService(w http.ResponseWriter, r *http.Request) {
+func (s *Server) StartService(w http.ResponseWriter, r *http.Request) {
 	platform := chi.URLParam(r, "platform")
 
 	var req struct {
-		RoomID string `json:"rid"`
-		Cookie string `json:"cookie,omitempty"`
+		RoomID  string `json:"rid"`
+		Cookie  string `json:"cookie,omitempty"`
+		Debug   bool   `json:"debug,omitempty"`   // 是否开启上游帧抓包
+		Persist *bool  `json:"persist,omitempty"` // 是否持久化，默认开启
 	}
 
 	defer r.Body.Close() // 确保请求体关闭，避免资源泄露
@@ -350,58 +592,15 @@ func StartService(w http.ResponseWriter, r *http.Request) {
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
-	stopChan := make(chan struct{})
 	var startErr error
 
 	// 根据平台启动服务
 	go func() {
-		switch uni.Platform(platform) {
-		case uni.DouYin:
-			// 需要将 RoomID 转为整数
-			roomID, err := strconv.Atoi(req.RoomID)
-			if err != nil {
-				startErr = fmt.Errorf("房间 ID 格式错误，必须为整数")
-				cancel()
-				return
-			}
-			startErr = startDouyinService(roomID, stopChan)
-
-		case uni.BiliBili:
-			// 需要将 RoomID 转为整数
-			roomID, err := strconv.Atoi(req.RoomID)
-			if err != nil {
-				startErr = fmt.Errorf("房间 ID 格式错误，必须为整数")
-				cancel()
-				return
-			}
-			startErr = startBilibiliService(roomID, req.Cookie, stopChan)
-
-		case uni.KuaiShou:
-			// KuaiShou 使用字符串 RoomID
-			startErr = startKuaishouService(req.RoomID, req.Cookie, stopChan)
-
-		case uni.DouYu:
-			// 需要将 RoomID 转为整数
-			roomID, err := strconv.Atoi(req.RoomID)
-			if err != nil {
-				startErr = fmt.Errorf("房间 ID 格式错误，必须为整数")
-				cancel()
-				return
-			}
-			startErr = startDouYuService(roomID, stopChan)
-
-		case uni.HuYa:
-			// HuYa 使用字符串 RoomID
-			startErr = startHuYaService(req.RoomID, stopChan)
-
-		case uni.XiaoHongShu:
-			// 小红书直播间 ID 为长整型字符串
-			startErr = startXiaoHongShuService(req.RoomID, req.Cookie, stopChan)
-
-		default:
-			// 不支持的平台
-			startErr = fmt.Errorf("不支持的平台")
-		}
+		startErr = s.StartRoom(platform, req.RoomID, RoomOptions{
+			Cookie:  req.Cookie,
+			Debug:   req.Debug,
+			Persist: req.Persist == nil || *req.Persist,
+		})
 		cancel() // 服务启动完成后，取消上下文
 	}()
 
@@ -427,47 +626,184 @@ func StartService(w http.ResponseWriter, r *http.Request) {
 	})
 }
 
-func StopService(w http.ResponseWriter, r *http.Request) {
+func (s *Server) StopService(w http.ResponseWriter, r *http.Request) {
 	platform := chi.URLParam(r, "platform")
 	roomID := chi.URLParam(r, "roomId")
 
-	serviceKey := generateServiceKey(platform, roomID)
-	if status, exists := serviceMap.GetService(serviceKey); exists {
-		close(status.StopChan)
-		//serviceMap.RemoveService(serviceKey) // 直接移除服务
+	if s.StopRoom(platform, roomID) {
 		jsonResponse(w, http.StatusOK, "服务已停止", map[string]string{
 			"platform": platform,
 			"rid":      roomID,
 		})
-		log.Printf(platform, "已停止 (%s) 的监听服务", roomID)
 		return
 	}
 
 	jsonError(w, http.StatusNotFound, "服务未找到")
 }
 
-func Hello(w http.ResponseWriter, r *http.Request) {
+// GetRoomStats 获取指定服务的实时统计
+func (s *Server) GetRoomStats(w http.ResponseWriter, r *http.Request) {
+	platform := chi.URLParam(r, "platform")
+	roomID := chi.URLParam(r, "roomId")
+
+	data, ok := s.engine.Stats().Get(uni.Platform(platform), roomID)
+	if !ok {
+		jsonError(w, http.StatusNotFound, "服务未找到")
+		return
+	}
+	jsonResponse(w, http.StatusOK, "获取成功", data)
+}
+
+// GetGiftCatalog 获取平台礼物目录
+func (s *Server) GetGiftCatalog(w http.ResponseWriter, r *http.Request) {
+	s.serveCatalog(w, r, assets.Gifts)
+}
+
+// GetEmoteCatalog 获取平台表情目录
+func (s *Server) GetEmoteCatalog(w http.ResponseWriter, r *http.Request) {
+	s.serveCatalog(w, r, assets.Emotes)
+}
+
+// GetProxyImageURL 生成带转换参数的代理图片 URL；设置了签名密钥时转换参数一并签名，客户端无法自行追加。
+// 签名 URL 可以请求任意允许的上游和转换，启用签名但未设置 token 时不开放
+func (s *Server) GetProxyImageURL(w http.ResponseWriter, r *http.Request) {
+	if len(s.tokens()) == 0 && s.engine.Proxy().SigningEnabled() {
+		jsonError(w, http.StatusForbidden, "已启用代理 URL 签名但未设置 authToken，接口已禁用")
+		return
+	}
+	query := r.URL.Query()
+	imageURL := query.Get("url")
+	if imageURL == "" {
+		jsonError(w, http.StatusBadRequest, "缺少 url 参数")
+		return
+	}
+	generated, err := s.engine.Proxy().GenerateImageVariantURL(imageURL, query)
+	if err != nil {
+		jsonError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	jsonResponse(w, http.StatusOK, "获取成功", map[string]string{"url": generated})
+}
+
+// 返回平台目录，启用代理时图片地址改写为代理 URL
+func (s *Server) serveCatalog(w http.ResponseWriter, r *http.Request, kind assets.Kind) {
+	platform := uni.Platform(chi.URLParam(r, "platform"))
+	if !uni.IsValidPlatform(platform) {
+		jsonError(w, http.StatusBadRequest, "无效的平台")
+		return
+	}
+
+	catalog, ok := assets.Get(platform, kind)
+	if !ok {
+		jsonError(w, http.StatusNotFound, "该平台没有此类目录")
+		return
+	}
+	images := s.engine.Proxy()
+	for i := range catalog.Assets {
+		urls := &catalog.Assets[i].Images
+		for _, u := range []*string{&urls.Icon, &urls.Dynamic, &urls.Gif, &urls.Webp} {
+			if *u != "" {
+				*u, _ = images.GenerateImageURL(*u)
+			}
+		}
+	}
+	jsonResponse(w, http.StatusOK, "获取成功", catalog)
+}
+
+// GetUnhandledKinds 获取指定服务已收到但未映射的上游消息类型
+func (s *Server) GetUnhandledKinds(w http.ResponseWriter, r *http.Request) {
+	platform := chi.URLParam(r, "platform")
+	roomID := chi.URLParam(r, "roomId")
+
+	serviceKey := generateServiceKey(platform, roomID)
+	if _, exists := s.services.GetService(serviceKey); !exists {
+		jsonError(w, http.StatusNotFound, "服务未找到")
+		return
+	}
+	if !capture.Supported(uni.Platform(platform)) {
+		jsonError(w, http.StatusBadRequest, capture.ErrUnsupported.Error())
+		return
+	}
+
+	jsonResponse(w, http.StatusOK, "获取成功", s.engine.Capture().Summary(uni.Platform(platform), roomID))
+}
+
+// SetDebugCapture 开启或关闭指定服务的上游帧抓包
+func (s *Server) SetDebugCapture(w http.ResponseWriter, r *http.Request) {
+	platform := chi.URLParam(r, "platform")
+	roomID := chi.URLParam(r, "roomId")
+
+	var req struct {
+		Enabled bool `json:"enabled"`
+	}
+
+	defer r.Body.Close()
+
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		jsonError(w, http.StatusBadRequest, "无效的请求参数")
+		return
+	}
+
+	serviceKey := generateServiceKey(platform, roomID)
+	status, exists := s.services.GetService(serviceKey)
+	if !exists {
+		jsonError(w, http.StatusNotFound, "服务未找到")
+		return
+	}
+	if req.Enabled && !capture.Supported(uni.Platform(platform)) {
+		jsonError(w, http.StatusBadRequest, capture.ErrUnsupported.Error())
+		return
+	}
+
+	data := map[string]interface{}{
+		"platform": platform,
+		"rid":      roomID,
+		"enabled":  req.Enabled,
+	}
+	if req.Enabled {
+		data["file"] = s.engine.Capture().Enable(uni.Platform(platform), roomID)
+		log.Printf(platform, "已开启 (%s) 的抓包", roomID)
+	} else {
+		s.engine.Capture().Disable(uni.Platform(platform), roomID)
+		log.Printf(platform, "已关闭 (%s) 的抓包", roomID)
+	}
+	if !s.services.SetDebug(serviceKey, req.Enabled) {
+		jsonError(w, http.StatusNotFound, "服务未找到")
+		return
+	}
+	if status.Persist {
+		s.saveDesiredState(platform, roomID, RoomOptions{Cookie: status.cookie, Debug: req.Enabled, Persist: true})
+	}
+
+	jsonResponse(w, http.StatusOK, "设置成功", data)
+}
+
+func (s *Server) Hello(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, http.StatusOK, "Hello, UniBarrage!", nil)
 }
 
 // GetWebSocketConfig 获取 WebSocket 配置信息
-func GetWebSocketConfig(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetWebSocketConfig(w http.ResponseWriter, r *http.Request) {
 	config := map[string]interface{}{
-		"ws_port": wsPort,
+		"ws_port": s.wsPort,
+		"ws_path": "",
+	}
+	if s.singlePort {
+		config["ws_path"] = "/ws"
 	}
 
 	jsonResponse(w, http.StatusOK, "获取成功", config)
 }
 
-func ListAllServices(w http.ResponseWriter, r *http.Request) {
-	services := serviceMap.GetAllServices()
+func (s *Server) ListAllServices(w http.ResponseWriter, r *http.Request) {
+	services := s.services.GetAllServices()
 	jsonResponse(w, http.StatusOK, "获取成功", services)
 }
 
-func ListPlatformServices(w http.ResponseWriter, r *http.Request) {
+func (s *Server) ListPlatformServices(w http.ResponseWriter, r *http.Request) {
 	platform := chi.URLParam(r, "platform")
 
-	allServices := serviceMap.GetAllServices()
+	allServices := s.services.GetAllServices()
 	platformServices := make([]*ServiceStatus, 0)
 
 	for _, service := range allServices {
@@ -479,12 +815,16 @@ func ListPlatformServices(w http.ResponseWriter, r *http.Request) {
 	jsonResponse(w, http.StatusOK, "获取成功", platformServices)
 }
 
-func GetServiceDetail(w http.ResponseWriter, r *http.Request) {
+func (s *Server) GetServiceDetail(w http.ResponseWriter, r *http.Request) {
 	platform := chi.URLParam(r, "platform")
 	roomID := chi.URLParam(r, "roomId")
 
 	serviceKey := generateServiceKey(platform, roomID)
-	if status, exists := serviceMap.GetService(serviceKey); exists {
+	if status, exists := s.services.GetService(serviceKey); exists {
+		jsonResponse(w, http.StatusOK, "获取成功", status)
+		return
+	}
+	if status, exists := s.services.GetFailure(serviceKey); exists {
 		jsonResponse(w, http.StatusOK, "获取成功", status)
 		return
 	}
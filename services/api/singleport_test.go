@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// 单端口模式下 /ws 和 /image 由 API 路由处理，并使用 API 的跨域配置
+func TestSinglePortRoutes(t *testing.T) {
+	s := newTestServer(t)
+	s.SetSinglePort(true)
+	r := s.newRouter([]string{"https://overlay.example"})
+
+	get := func(target string, origin string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		if origin != "" {
+			req.Header.Set("Origin", origin)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// 去除 /ws 前缀后按 /{platform}/{id} 解析
+	if rec := get("/ws/unknown/1", ""); rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid platform: expected 400, got %d", rec.Code)
+	}
+
+	// 未启用代理时不提供图片
+	rec := get("/image?url=https://i0.hdslb.com/a.png", "https://overlay.example")
+	if rec.Code != http.StatusNotFound {
+		t.Errorf("proxy disabled: expected 404, got %d", rec.Code)
+	}
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "https://overlay.example" {
+		t.Errorf("allowed origin: got %q", got)
+	}
+	rec = get("/image?url=https://i0.hdslb.com/a.png", "https://other.example")
+	if got := rec.Header().Get("Access-Control-Allow-Origin"); got != "" {
+		t.Errorf("other origin should not be allowed, got %q", got)
+	}
+}
+
+// 清除图片缓存接口只在设置了 token 时开放
+func TestSinglePortPurgeRequiresToken(t *testing.T) {
+	s := newTestServer(t)
+	s.SetSinglePort(true)
+	r := s.newRouter(nil)
+
+	purge := func(token string) int {
+		req := httptest.NewRequest(http.MethodDelete, "/image/purge?all=true", nil)
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		r.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	s.SetAuthTokens(nil)
+	if code := purge(""); code != http.StatusForbidden {
+		t.Errorf("no token configured: expected 403, got %d", code)
+	}
+
+	s.SetAuthTokens([]string{"token-a"})
+	if code := purge(""); code != http.StatusUnauthorized {
+		t.Errorf("missing token: expected 401, got %d", code)
+	}
+	// 未启用代理时认证通过后返回 404
+	if code := purge("token-a"); code != http.StatusNotFound {
+		t.Errorf("valid token: expected 404, got %d", code)
+	}
+}
@@ -0,0 +1,237 @@
+package proxy
+
+import (
+	log "UniBarrage/utils/trace"
+	"errors"
+	"github.com/dgraph-io/badger/v4"
+	"github.com/goccy/go-json"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// 自启动以来的缓存命中计数
+type cacheCounters struct {
+	lruHits      atomic.Int64
+	lruMisses    atomic.Int64
+	badgerHits   atomic.Int64
+	badgerMisses atomic.Int64
+}
+
+// SetAdminMiddleware 设置独立端口模式下 /image/stats、/image/purge 管理接口的认证中间件（如 API 的 Bearer Token 认证），需在 StartServer 之前调用
+func (p *Proxy) SetAdminMiddleware(mw func(http.Handler) http.Handler) {
+	p.adminMiddleware = mw
+}
+
+// SetPurgeMiddleware 设置独立端口模式下 /image/purge 的认证中间件，需在 StartServer 之前调用；未设置时清除接口返回 403
+func (p *Proxy) SetPurgeMiddleware(mw func(http.Handler) http.Handler) {
+	p.purgeMiddleware = mw
+}
+
+// CacheStats 图片缓存统计
+type CacheStats struct {
+	Memory        MemoryStats `json:"memory"`
+	Disk          DiskStats   `json:"disk"`
+	Hits          CacheCounts `json:"hits"`          // 自启动以来的命中次数
+	Misses        CacheCounts `json:"misses"`        // 自启动以来的未命中次数
+	PrefetchQueue int         `json:"prefetchQueue"` // 等待预取的图片数
+}
+
+// MemoryStats 内存缓存统计
+type MemoryStats struct {
+	Entries    int   `json:"entries"`    // 缓存项数
+	Bytes      int64 `json:"bytes"`      // 图片数据总字节数
+	MaxEntries int   `json:"maxEntries"` // 缓存项数上限
+	MaxBytes   int64 `json:"maxBytes"`   // 字节数上限，0 表示不限制
+}
+
+// DiskStats BadgerDB 缓存统计
+type DiskStats struct {
+	Ready     bool   `json:"ready"`     // BadgerDB 是否可用
+	Dir       string `json:"dir"`       // 目录
+	TTL       string `json:"ttl"`       // 缓存项保存时长
+	LSMBytes  int64  `json:"lsmBytes"`  // LSM 树占用的字节数
+	VLogBytes int64  `json:"vlogBytes"` // 值日志占用的字节数
+}
+
+// CacheCounts 按缓存层级统计的次数
+type CacheCounts struct {
+	LRU    int64 `json:"lru"`
+	Badger int64 `json:"badger"`
+}
+
+// Stats 获取图片缓存统计
+func (p *Proxy) Stats() CacheStats {
+	stats := CacheStats{
+		Memory: MemoryStats{
+			Bytes:      p.memBytes.Load(),
+			MaxEntries: p.cacheOptions.Entries,
+			MaxBytes:   p.cacheOptions.MaxBytes,
+		},
+		Disk: DiskStats{
+			Ready: p.CacheReady(),
+			Dir:   p.cacheOptions.Dir,
+			TTL:   p.cacheOptions.TTL.String(),
+		},
+		Hits:          CacheCounts{LRU: p.counters.lruHits.Load(), Badger: p.counters.badgerHits.Load()},
+		Misses:        CacheCounts{LRU: p.counters.lruMisses.Load(), Badger: p.counters.badgerMisses.Load()},
+		PrefetchQueue: len(p.prefetchQueue),
+	}
+	if p.cache != nil {
+		stats.Memory.Entries = p.cache.Len()
+	}
+	if stats.Disk.Ready {
+		stats.Disk.LSMBytes, stats.Disk.VLogBytes = p.badgerDB.Size()
+	}
+	return stats
+}
+
+// Purge 删除图片及其所有转换结果的缓存，返回删除的缓存项数
+func (p *Proxy) Purge(imageURL string) (int, error) {
+	keys := map[string]struct{}{imageURL: {}}
+	prefix := imageURL + transformKeySep
+	if p.cache != nil {
+		for _, key := range p.cache.Keys() {
+			if strings.HasPrefix(key, prefix) {
+				keys[key] = struct{}{}
+			}
+		}
+	}
+
+	p.memMu.Lock()
+	removed := make(map[string]struct{})
+	for key := range keys {
+		if p.cache != nil && p.cache.Remove(key) {
+			removed[key] = struct{}{}
+		}
+	}
+	p.memMu.Unlock()
+
+	if p.CacheReady() {
+		err := p.badgerDB.Update(func(txn *badger.Txn) error {
+			if _, err := txn.Get([]byte(imageURL)); err == nil {
+				removed[imageURL] = struct{}{}
+				if err := txn.Delete([]byte(imageURL)); err != nil {
+					return err
+				}
+			} else if !errors.Is(err, badger.ErrKeyNotFound) {
+				return err
+			}
+
+			opts := badger.DefaultIteratorOptions
+			opts.PrefetchValues = false
+			opts.Prefix = []byte(prefix)
+			it := txn.NewIterator(opts)
+			defer it.Close()
+			for it.Rewind(); it.Valid(); it.Next() {
+				key := it.Item().KeyCopy(nil)
+				if err := txn.Delete(key); err != nil {
+					return err
+				}
+				removed[string(key)] = struct{}{}
+			}
+			return nil
+		})
+		if err != nil {
+			return len(removed), err
+		}
+	}
+	return len(removed), nil
+}
+
+// PurgeAll 清空内存缓存和 BadgerDB
+func (p *Proxy) PurgeAll() error {
+	p.memMu.Lock()
+	if p.cache != nil {
+		p.cache.Purge()
+	}
+	p.memMu.Unlock()
+	if p.CacheReady() {
+		return p.badgerDB.DropAll()
+	}
+	return nil
+}
+
+// 注册管理接口
+func (p *Proxy) handleAdmin(mux *http.ServeMux) {
+	wrap := func(h http.HandlerFunc) http.Handler {
+		if p.adminMiddleware != nil {
+			return p.adminMiddleware(h)
+		}
+		return h
+	}
+	mux.Handle("/image/stats", wrap(p.serveStats))
+	if p.purgeMiddleware != nil {
+		mux.Handle("/image/purge", p.purgeMiddleware(http.HandlerFunc(p.servePurge)))
+	} else {
+		mux.HandleFunc("/image/purge", func(w http.ResponseWriter, r *http.Request) {
+			writeJSON(w, http.StatusForbidden, map[string]string{"error": "清除缓存接口未启用"})
+		})
+	}
+}
+
+// ServeStats 处理 /image/stats 请求，供挂载到 API 服务时使用，认证由外层中间件负责
+func (p *Proxy) ServeStats(w http.ResponseWriter, r *http.Request) {
+	if !p.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	p.serveStats(w, r)
+}
+
+// ServePurge 处理 /image/purge 请求，供挂载到 API 服务时使用，认证由外层中间件负责
+func (p *Proxy) ServePurge(w http.ResponseWriter, r *http.Request) {
+	if !p.Enabled() {
+		http.NotFound(w, r)
+		return
+	}
+	p.servePurge(w, r)
+}
+
+// 处理缓存统计请求
+func (p *Proxy) serveStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "仅支持 GET"})
+		return
+	}
+	writeJSON(w, http.StatusOK, p.Stats())
+}
+
+// 处理缓存清除请求，指定 url 时只清除该图片及其转换结果，指定 all=true 时清空全部缓存
+func (p *Proxy) servePurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		writeJSON(w, http.StatusMethodNotAllowed, map[string]string{"error": "仅支持 POST 或 DELETE"})
+		return
+	}
+
+	query := r.URL.Query()
+	imageURL := query.Get("url")
+	if imageURL == "" {
+		if query.Get("all") != "true" {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "缺少 url 参数，清空全部缓存需指定 all=true"})
+			return
+		}
+		if err := p.PurgeAll(); err != nil {
+			log.Printf("ERROR", "清空图片缓存失败: %v", err)
+			writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "清空缓存失败"})
+			return
+		}
+		log.Printf("INFO", "已清空图片缓存")
+		writeJSON(w, http.StatusOK, map[string]any{"all": true})
+		return
+	}
+
+	n, err := p.Purge(imageURL)
+	if err != nil {
+		log.Printf("ERROR", "清除图片缓存失败: %v", err)
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "清除缓存失败"})
+		return
+	}
+	writeJSON(w, http.StatusOK, map[string]any{"url": imageURL, "purged": n})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,160 @@
+package proxy
+
+import (
+	"bytes"
+	"github.com/goccy/go-json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+)
+
+func TestMemoryByteBudget(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	p.SetCacheOptions(CacheOptions{MaxBytes: 100})
+
+	item := func(n int) *ImageCacheItem {
+		return &ImageCacheItem{Data: bytes.Repeat([]byte{1}, n), Timestamp: time.Now()}
+	}
+	p.addToMemory("a", item(40))
+	p.addToMemory("b", item(40))
+	if p.memBytes.Load() != 80 || p.cache.Len() != 2 {
+		t.Fatalf("bytes=%d entries=%d", p.memBytes.Load(), p.cache.Len())
+	}
+
+	p.addToMemory("c", item(40))
+	if p.cache.Contains("a") || p.memBytes.Load() != 80 {
+		t.Fatalf("oldest entry should be evicted, bytes=%d keys=%v", p.memBytes.Load(), p.cache.Keys())
+	}
+
+	// 替换已有的键按新大小计算
+	p.addToMemory("c", item(10))
+	if p.memBytes.Load() != 50 {
+		t.Fatalf("replaced entry: bytes=%d", p.memBytes.Load())
+	}
+
+	// 超过上限的单项不进入内存缓存
+	p.addToMemory("huge", item(200))
+	if p.cache.Contains("huge") || p.memBytes.Load() != 50 {
+		t.Fatalf("oversized entry: bytes=%d keys=%v", p.memBytes.Load(), p.cache.Keys())
+	}
+
+	p.cache.Purge()
+	if p.memBytes.Load() != 0 {
+		t.Fatalf("purged cache: bytes=%d", p.memBytes.Load())
+	}
+}
+
+func TestPurge(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+
+	imageURL := "https://i0.hdslb.com/a.png"
+	item := &ImageCacheItem{Data: []byte("png"), ContentType: "image/png", Timestamp: time.Now()}
+	p.saveToCache(imageURL, item)
+	p.saveToCache(imageURL+transformKeySep+"w=64", item)
+	p.saveToBadger(imageURL+transformKeySep+"w=32", item) // 只在磁盘中
+	p.saveToCache("https://i0.hdslb.com/a.png.bak", item)
+
+	n, err := p.Purge(imageURL)
+	if err != nil || n != 3 {
+		t.Fatalf("purged %d, %v", n, err)
+	}
+	for _, key := range []string{imageURL, imageURL + transformKeySep + "w=64", imageURL + transformKeySep + "w=32"} {
+		if p.cache.Contains(key) {
+			t.Errorf("%s still in memory", key)
+		}
+		if _, ok := p.getFromBadger(key); ok {
+			t.Errorf("%s still on disk", key)
+		}
+	}
+	if _, ok := p.getFromCache("https://i0.hdslb.com/a.png.bak"); !ok {
+		t.Error("other images should be kept")
+	}
+
+	if err := p.PurgeAll(); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := p.getFromCache("https://i0.hdslb.com/a.png.bak"); ok || p.memBytes.Load() != 0 {
+		t.Error("all entries should be purged")
+	}
+}
+
+func TestAdminEndpoints(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	auth := func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Header.Get("Authorization") != "Bearer token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+	p.SetAdminMiddleware(auth)
+
+	// 未设置清除接口的中间件时拒绝清除
+	p.SetPurgeMiddleware(nil)
+	mux := http.NewServeMux()
+	p.handleAdmin(mux)
+	req := httptest.NewRequest(http.MethodDelete, "/image/purge?all=true", nil)
+	req.Header.Set("Authorization", "Bearer token")
+	rec := httptest.NewRecorder()
+	mux.ServeHTTP(rec, req)
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("purge without middleware: %d", rec.Code)
+	}
+
+	p.SetPurgeMiddleware(auth)
+	mux = http.NewServeMux()
+	p.handleAdmin(mux)
+
+	p.saveToCache("https://i0.hdslb.com/a.png", &ImageCacheItem{Data: []byte("png"), Timestamp: time.Now()})
+
+	do := func(method string, target string, authorized bool) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, nil)
+		if authorized {
+			req.Header.Set("Authorization", "Bearer token")
+		}
+		rec := httptest.NewRecorder()
+		mux.ServeHTTP(rec, req)
+		return rec
+	}
+
+	if rec := do(http.MethodGet, "/image/stats", false); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthorized stats: %d", rec.Code)
+	}
+	rec = do(http.MethodGet, "/image/stats", true)
+	var stats CacheStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("stats: %d %s", rec.Code, rec.Body.String())
+	}
+	if stats.Memory.Entries != 1 || stats.Memory.Bytes != 3 || !stats.Disk.Ready {
+		t.Fatalf("stats: %+v", stats)
+	}
+
+	if rec := do(http.MethodGet, "/image/purge", true); rec.Code != http.StatusMethodNotAllowed {
+		t.Fatalf("purge with GET: %d", rec.Code)
+	}
+	rec = do(http.MethodPost, "/image/purge?url="+url.QueryEscape("https://i0.hdslb.com/a.png"), true)
+	if rec.Code != http.StatusOK || !bytes.Contains(rec.Body.Bytes(), []byte(`"purged":1`)) {
+		t.Fatalf("purge: %d %s", rec.Code, rec.Body.String())
+	}
+	if rec := do(http.MethodPost, "/image/purge", false); rec.Code != http.StatusUnauthorized {
+		t.Fatalf("unauthorized purge: %d", rec.Code)
+	}
+	// 缺少 url 时不会清空全部缓存
+	p.saveToCache("https://i0.hdslb.com/b.png", &ImageCacheItem{Data: []byte("png"), Timestamp: time.Now()})
+	if rec := do(http.MethodDelete, "/image/purge", true); rec.Code != http.StatusBadRequest {
+		t.Fatalf("purge without url: %d", rec.Code)
+	}
+	if !p.cache.Contains("https://i0.hdslb.com/b.png") {
+		t.Fatal("purge without url cleared the cache")
+	}
+	if rec := do(http.MethodDelete, "/image/purge?all=true", true); rec.Code != http.StatusOK {
+		t.Fatalf("purge all: %d", rec.Code)
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"github.com/dgraph-io/badger/v4"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// 使用临时目录中的 BadgerDB 和新的内存缓存，测试结束后关闭代理
+func useTempCache(t *testing.T, p *Proxy) {
+	t.Helper()
+	db, err := openBadger(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	mem, err := p.newMemoryCache(16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.badgerDB, p.cache = db, mem
+	t.Cleanup(func() { _ = p.Shutdown(context.Background()) })
+}
+
+func TestBadgerRoundTrip(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+
+	item := &ImageCacheItem{
+		Data:         []byte{0x89, 'P', 'N', 'G', 0, 1, 2},
+		ContentType:  "image/png",
+		ETag:         `"abc"`,
+		LastModified: "Mon, 02 Jan 2006 15:04:05 GMT",
+		Timestamp:    time.Unix(1700000000, 0).UTC(),
+		Profile:      "bilibili",
+		Hash:         "stored",
+	}
+	p.saveToBadger("https://example.com/a.png", item)
+
+	got, ok := p.getFromBadger("https://example.com/a.png")
+	if !ok {
+		t.Fatal("entry not found")
+	}
+	if !bytes.Equal(got.Data, item.Data) || got.ContentType != item.ContentType || got.ETag != item.ETag ||
+		got.LastModified != item.LastModified || !got.Timestamp.Equal(item.Timestamp) || got.Profile != item.Profile ||
+		got.Hash != item.Hash {
+		t.Fatalf("round trip mismatch: %+v", got)
+	}
+
+	if _, ok := p.getFromBadger("https://example.com/missing.png"); ok {
+		t.Fatal("missing key should not be found")
+	}
+}
+
+func TestLegacyAndCorruptRecordsAreMisses(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+
+	record, err := encodeRecord(&ImageCacheItem{Data: []byte("image"), ContentType: "image/gif"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	values := map[string][]byte{
+		"legacy":    []byte("\x89PNG raw bytes from the old format"),
+		"truncated": record[:len(record)-1],
+		"version":   append(append([]byte(nil), recordMagic...), append([]byte{99}, record[len(recordMagic)+1:]...)...),
+	}
+	for key, value := range values {
+		err := p.badgerDB.Update(func(txn *badger.Txn) error {
+			return txn.Set([]byte(key), value)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, ok := p.getFromBadger(key); ok {
+			t.Errorf("%s record should be treated as a miss", key)
+		}
+	}
+}
+
+func TestServeImageRevalidatesStaleEntries(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	allowTestUpstream(p)
+
+	var requests, notModified atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			notModified.Add(1)
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("png-data"))
+	}))
+	defer upstream.Close()
+	imageURL := upstream.URL + "/a.png"
+
+	get := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.serveImage(rec, httptest.NewRequest(http.MethodGet, "/image?url="+imageURL, nil))
+		return rec
+	}
+
+	if rec := get(); rec.Body.String() != "png-data" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("first response: %q %q", rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+	get()
+	if requests.Load() != 1 {
+		t.Fatalf("fresh entry should be served from cache, upstream requests=%d", requests.Load())
+	}
+
+	// 使缓存项过期，并清空内存缓存以从 BadgerDB 读取
+	stale, _ := p.getFromBadger(imageURL)
+	stale.Timestamp = time.Now().Add(-2 * cacheFreshFor)
+	p.saveToBadger(imageURL, stale)
+	p.cache.Purge()
+
+	rec := get()
+	if rec.Body.String() != "png-data" || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("revalidated response: %q %q", rec.Body.String(), rec.Header().Get("Content-Type"))
+	}
+	if notModified.Load() != 1 {
+		t.Fatalf("stale entry should be revalidated with If-None-Match, 304 count=%d", notModified.Load())
+	}
+	if item, _ := p.getFromBadger(imageURL); item.stale(time.Now()) {
+		t.Fatal("revalidated entry should be fresh again")
+	}
+
+	// 上游不可用时使用过期缓存
+	stale.Timestamp = time.Now().Add(-2 * cacheFreshFor)
+	p.saveToBadger(imageURL, stale)
+	p.cache.Purge()
+	upstream.Close()
+	if rec := get(); rec.Code != http.StatusOK || rec.Body.String() != "png-data" {
+		t.Fatalf("stale fallback: %d %q", rec.Code, rec.Body.String())
+	}
+}
@@ -0,0 +1,69 @@
+package proxy
+
+import (
+	"net/http"
+	"strings"
+)
+
+// 未被请求头方案覆盖时使用的浏览器 User-Agent
+const defaultUserAgent = "Mozilla/5.0 (Windows NT 10.0; Win64; x64) AppleWebKit/537.36 (KHTML, like Gecko) Chrome/136.0.0.0 Safari/537.36"
+
+// HeaderProfile 按上游域名设置的请求头方案，部分 CDN 会拒绝或限速缺少对应 Referer 或浏览器 User-Agent 的请求
+type HeaderProfile struct {
+	Name    string            // 方案名称，记录在缓存中
+	Hosts   []string          // 匹配的上游域名，同时匹配子域名
+	Headers map[string]string // 请求头，如 Referer、User-Agent
+}
+
+// DefaultHeaderProfiles 各平台图片 CDN 的内置请求头方案
+var DefaultHeaderProfiles = []HeaderProfile{
+	{Name: "bilibili", Hosts: []string{"hdslb.com", "biliimg.com"}, Headers: map[string]string{"Referer": "https://live.bilibili.com/"}},
+	{Name: "douyin", Hosts: []string{"douyinpic.com", "douyinstatic.com", "byteimg.com"}, Headers: map[string]string{"Referer": "https://live.douyin.com/"}},
+	{Name: "kuaishou", Hosts: []string{"kwimgs.com", "yximgs.com", "kwaicdn.com"}, Headers: map[string]string{"Referer": "https://live.kuaishou.com/"}},
+	{Name: "douyu", Hosts: []string{"douyucdn.cn", "douyucdn2.cn"}, Headers: map[string]string{"Referer": "https://www.douyu.com/"}},
+	{Name: "huya", Hosts: []string{"huya.com", "msstatic.com"}, Headers: map[string]string{"Referer": "https://www.huya.com/"}},
+	{Name: "xiaohongshu", Hosts: []string{"xhscdn.com", "xhscdn.net"}, Headers: map[string]string{"Referer": "https://www.xiaohongshu.com/"}},
+}
+
+// SetHeaderProfiles 设置自定义请求头方案，按顺序匹配且优先于内置方案，需在 StartServer 之前调用
+func (p *Proxy) SetHeaderProfiles(profiles []HeaderProfile) {
+	p.headerProfiles = make([]HeaderProfile, 0, len(profiles))
+	for _, profile := range profiles {
+		hosts := make([]string, 0, len(profile.Hosts))
+		for _, h := range profile.Hosts {
+			if h = strings.Trim(strings.ToLower(strings.TrimSpace(h)), "."); h != "" {
+				hosts = append(hosts, strings.TrimPrefix(h, "*."))
+			}
+		}
+		profile.Hosts = hosts
+		p.headerProfiles = append(p.headerProfiles, profile)
+	}
+}
+
+// 查找上游域名对应的请求头方案，先匹配自定义方案再匹配内置方案
+func (p *Proxy) profileFor(host string) (HeaderProfile, bool) {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, profiles := range [][]HeaderProfile{p.headerProfiles, DefaultHeaderProfiles} {
+		for _, profile := range profiles {
+			for _, pattern := range profile.Hosts {
+				if host == pattern || strings.HasSuffix(host, "."+pattern) {
+					return profile, true
+				}
+			}
+		}
+	}
+	return HeaderProfile{}, false
+}
+
+// 为上游请求设置请求头，返回使用的方案名称，未匹配时为空
+func (p *Proxy) applyProfile(req *http.Request) string {
+	req.Header.Set("User-Agent", defaultUserAgent)
+	profile, ok := p.profileFor(req.URL.Hostname())
+	if !ok {
+		return ""
+	}
+	for key, value := range profile.Headers {
+		req.Header.Set(key, value)
+	}
+	return profile.Name
+}
@@ -0,0 +1,64 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestProfileFor(t *testing.T) {
+	p := New()
+	p.SetHeaderProfiles([]HeaderProfile{
+		{Name: "custom-bili", Hosts: []string{"*.I0.HDSLB.com"}, Headers: map[string]string{"Referer": "https://example.com/"}},
+	})
+
+	cases := map[string]string{
+		"i0.hdslb.com":             "custom-bili",
+		"a.i0.hdslb.com":           "custom-bili",
+		"i1.hdslb.com":             "bilibili",
+		"p3-webcast.douyinpic.com": "douyin",
+		"sns-avatar.xhscdn.com":    "xiaohongshu",
+		"example.com":              "",
+	}
+	for host, want := range cases {
+		profile, _ := p.profileFor(host)
+		if profile.Name != want {
+			t.Errorf("%s: got profile %q, want %q", host, profile.Name, want)
+		}
+	}
+}
+
+func TestFetchImageUsesHeaderProfile(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	allowTestUpstream(p)
+	p.SetHeaderProfiles([]HeaderProfile{
+		{Name: "local", Hosts: []string{"127.0.0.1"}, Headers: map[string]string{"Referer": "https://live.example.com/", "X-Test": "1"}},
+	})
+
+	var got http.Header
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got = r.Header.Clone()
+		w.Header().Set("Content-Type", "image/png")
+		w.Header().Set("ETag", `"v1"`)
+		_, _ = w.Write([]byte("png-data"))
+	}))
+	defer upstream.Close()
+
+	item, err := p.fetchImage(upstream.URL+"/a.png", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.Get("Referer") != "https://live.example.com/" || got.Get("X-Test") != "1" || got.Get("User-Agent") != defaultUserAgent {
+		t.Fatalf("request headers: %v", got)
+	}
+	if item.Profile != "local" {
+		t.Fatalf("item profile: %q", item.Profile)
+	}
+
+	p.saveToCache(upstream.URL+"/a.png", item)
+	p.cache.Purge()
+	if cached, ok := p.getFromCache(upstream.URL + "/a.png"); !ok || cached.Profile != "local" {
+		t.Fatalf("cached profile: %+v", cached)
+	}
+}
@@ -1,87 +1,242 @@
 package proxy
 
 import (
+	"UniBarrage/utils/cors"
+	"UniBarrage/utils/metrics"
 	log "UniBarrage/utils/trace"
+	"context"
+	"errors"
 	"fmt"
 	"github.com/dgraph-io/badger/v4"
 	lru "github.com/hashicorp/golang-lru/v2"
+	"golang.org/x/sync/singleflight"
 	"io"
 	"net/http"
 	"net/url"
 	"os"
+	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
 // ImageCacheItem 表示一个缓存项
 type ImageCacheItem struct {
-	Data        []byte
-	ContentType string
-	Timestamp   time.Time
-}
-
-var (
-	host       string                              // 运行主机
-	port       int                                 // 运行端口
-	cache      *lru.Cache[string, *ImageCacheItem] // 内存缓存
-	useProxy   = false                             // 是否使用代理
-	useHttps   = false                             // 是否使用 Https
-	badgerDB   *badger.DB                          // BadgerDB 实例
-	cacheLimit = 1000                              // LRU 缓存大小限制
-)
+	Data         []byte
+	ContentType  string
+	ETag         string    // 上游返回的 ETag
+	LastModified string    // 上游返回的 Last-Modified
+	Timestamp    time.Time // 最近一次从上游获取或验证的时间
+	Profile      string    // 获取时使用的请求头方案
+	Hash         string    // 图片数据的 SHA-1，创建缓存项时计算，用于生成转换结果的 ETag
+}
+
+const cacheFreshFor = time.Hour // 缓存项在该时长内直接使用，超过后向上游验证
+
+// 判断缓存项是否需要向上游验证
+func (item *ImageCacheItem) stale(now time.Time) bool {
+	return now.Sub(item.Timestamp) > cacheFreshFor
+}
+
+// Proxy 图片代理，持有缓存、上游请求限制、签名配置和预取队列；由采集引擎创建，各引擎的代理互不影响
+type Proxy struct {
+	host         string                              // 运行主机
+	port         int                                 // 运行端口
+	cache        *lru.Cache[string, *ImageCacheItem] // 内存缓存
+	useProxy     bool                                // 是否使用代理
+	useHttps     bool                                // 是否使用 Https
+	badgerDB     *badger.DB                          // BadgerDB 实例
+	server       atomic.Pointer[http.Server]         // 代理服务实例，用于优雅退出
+	inflight     singleflight.Group                  // 合并同一图片的并发下载和转换
+	memMu        sync.Mutex                          // 保证内存缓存写入与按字节数淘汰的原子性
+	memBytes     atomic.Int64                        // 内存缓存中图片数据的总字节数
+	stopGC       func()                              // 停止 BadgerDB 值日志 GC 并等待其退出
+	cacheOptions CacheOptions                        // 图片缓存配置
+	counters     cacheCounters                       // 自启动以来的缓存命中计数
+
+	adminMiddleware func(http.Handler) http.Handler // 管理接口的认证中间件，为空时不做认证
+	purgeMiddleware func(http.Handler) http.Handler // 清除缓存接口的认证中间件，为空时拒绝所有清除请求
+	headerProfiles  []HeaderProfile                 // 配置的请求头方案，优先于内置方案
+
+	signSecret []byte        // 签名密钥，为空时不签名也不校验
+	signTTL    time.Duration // 签名有效期，为 0 时不过期
+	publicURL  string        // 生成代理 URL 时使用的外部地址，如 https://example.com/unibarrage
+
+	upstreamPolicy UpstreamPolicy // 上游请求限制
+	upstreamClient *http.Client   // 按上游请求限制创建的客户端
+
+	prefetchMu     sync.Mutex
+	prefetchQueue  chan string         // 待预取的图片 URL
+	prefetchQueued map[string]struct{} // 已在队列中的 URL，避免重复排队
+	prefetchOnce   sync.Once
+	stopOnce       sync.Once
+	done           chan struct{} // Shutdown 后关闭，通知预取协程退出
+}
+
+// New 创建未启用的图片代理，配置完成后调用 Enable 或 StartServer 启用
+func New() *Proxy {
+	policy := normalizePolicy(UpstreamPolicy{})
+	return &Proxy{
+		cacheOptions:   normalizeCacheOptions(CacheOptions{}),
+		upstreamPolicy: policy,
+		upstreamClient: newUpstreamClient(policy),
+		prefetchQueue:  make(chan string, prefetchQueueSize),
+		prefetchQueued: make(map[string]struct{}),
+		done:           make(chan struct{}),
+	}
+}
+
+// CacheOptions 图片缓存配置，零值字段使用默认值
+type CacheOptions struct {
+	Dir      string        // BadgerDB 目录，默认为系统临时目录下的 CacheBadger
+	Entries  int           // 内存缓存条目数，默认 1000
+	MaxBytes int64         // 内存缓存图片数据的总字节数上限，为 0 时只按条目数限制
+	TTL      time.Duration // BadgerDB 中缓存项的保存时长，默认 24h
+}
+
+// BadgerDB 值日志 GC 间隔
+const valueLogGCInterval = 10 * time.Minute
+
+// SetCacheOptions 设置图片缓存配置，需在 StartServer 之前调用
+func (p *Proxy) SetCacheOptions(opts CacheOptions) {
+	p.cacheOptions = normalizeCacheOptions(opts)
+}
+
+func normalizeCacheOptions(opts CacheOptions) CacheOptions {
+	if opts.Dir == "" {
+		opts.Dir = filepath.Join(os.TempDir(), "CacheBadger")
+	}
+	if opts.Entries <= 0 {
+		opts.Entries = 1000
+	}
+	if opts.MaxBytes < 0 {
+		opts.MaxBytes = 0
+	}
+	if opts.TTL <= 0 {
+		opts.TTL = 24 * time.Hour
+	}
+	return opts
+}
 
 // 初始化缓存和 BadgerDB
-func initCache() {
+func (p *Proxy) initCache() {
 	var err error
 
 	// 初始化内存缓存
-	cache, err = lru.New[string, *ImageCacheItem](cacheLimit)
+	p.cache, err = p.newMemoryCache(p.cacheOptions.Entries)
 	if err != nil {
 		log.Printf("WARN", "创建 LRU 缓存失败: %v", err)
 		return
 	}
 
 	// 初始化 BadgerDB
-	tempDir := os.TempDir()
-	dbPath := fmt.Sprintf("%s/CacheBadger", tempDir)
-	opts := badger.DefaultOptions(dbPath).WithLoggingLevel(badger.WARNING)
-
-	badgerDB, err = badger.Open(opts)
+	p.badgerDB, err = openBadger(p.cacheOptions.Dir)
 	if err != nil {
 		log.Printf("ERROR", "连接 BadgerDB 数据库失败: %v", err)
 		return
 	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	db := p.badgerDB
+	go func() {
+		defer close(done)
+		runValueLogGC(ctx, db)
+	}()
+	p.stopGC = func() {
+		cancel()
+		<-done
+	}
 }
 
-// 从 BadgerDB 获取缓存项
-func getFromBadger(url string) (*ImageCacheItem, bool) {
-	var item ImageCacheItem
-	err := badgerDB.View(func(txn *badger.Txn) error {
-		entry, err := txn.Get([]byte(url))
-		if err != nil {
-			return err
+// 创建内存缓存，淘汰时扣减字节数
+func (p *Proxy) newMemoryCache(entries int) (*lru.Cache[string, *ImageCacheItem], error) {
+	p.memBytes.Store(0)
+	return lru.NewWithEvict(entries, func(_ string, item *ImageCacheItem) {
+		p.memBytes.Add(-int64(len(item.Data)))
+	})
+}
+
+// 写入内存缓存，超过字节数上限时淘汰最久未使用的缓存项；单项超过上限时只保存在 BadgerDB
+func (p *Proxy) addToMemory(key string, item *ImageCacheItem) {
+	p.memMu.Lock()
+	defer p.memMu.Unlock()
+
+	// 直接替换已有的键不会触发淘汰回调，先移除以扣减原有的字节数
+	p.cache.Remove(key)
+	maxBytes := p.cacheOptions.MaxBytes
+	if maxBytes > 0 && int64(len(item.Data)) > maxBytes {
+		return
+	}
+	p.cache.Add(key, item)
+	p.memBytes.Add(int64(len(item.Data)))
+	for maxBytes > 0 && p.memBytes.Load() > maxBytes && p.cache.Len() > 1 {
+		p.cache.RemoveOldest()
+	}
+}
+
+// 定期回收 BadgerDB 值日志中过期和被覆盖的数据，直到 ctx 取消
+func runValueLogGC(ctx context.Context, db *badger.DB) {
+	ticker := time.NewTicker(valueLogGCInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			// 每次回收一个文件，直到没有可回收的文件
+			for db.RunValueLogGC(0.5) == nil {
+				if ctx.Err() != nil {
+					return
+				}
+			}
 		}
-		item.Data, err = entry.ValueCopy(nil)
+	}
+}
+
+// 打开 BadgerDB
+func openBadger(dir string) (*badger.DB, error) {
+	return badger.Open(badger.DefaultOptions(dir).WithLoggingLevel(badger.WARNING))
+}
+
+// 从 BadgerDB 获取缓存项，无法解析的记录视为未命中，随后会被新记录覆盖
+func (p *Proxy) getFromBadger(url string) (*ImageCacheItem, bool) {
+	if p.badgerDB == nil {
+		return nil, false
+	}
+	var item *ImageCacheItem
+	err := p.badgerDB.View(func(txn *badger.Txn) error {
+		entry, err := txn.Get([]byte(url))
 		if err != nil {
 			return err
 		}
-		contentType, err := entry.ValueCopy(nil)
-		if err != nil {
+		return entry.Value(func(val []byte) error {
+			item, err = decodeRecord(val)
 			return err
-		}
-		item.ContentType = string(contentType)
-		return nil
+		})
 	})
 	if err != nil {
+		if !errors.Is(err, badger.ErrKeyNotFound) {
+			log.Printf("DEBUG", "读取 BadgerDB 缓存失败: %v", err)
+		}
 		return nil, false
 	}
-	return &item, true
+	return item, true
 }
 
 // 将缓存项保存到 BadgerDB
-func saveToBadger(url string, item *ImageCacheItem) {
-	err := badgerDB.Update(func(txn *badger.Txn) error {
-		e := badger.NewEntry([]byte(url), item.Data).WithTTL(time.Hour * 24)
+func (p *Proxy) saveToBadger(url string, item *ImageCacheItem) {
+	if p.badgerDB == nil {
+		return
+	}
+	record, err := encodeRecord(item)
+	if err != nil {
+		log.Printf("ERROR", "编码缓存记录失败: %v", err)
+		return
+	}
+	err = p.badgerDB.Update(func(txn *badger.Txn) error {
+		e := badger.NewEntry([]byte(url), record).WithTTL(p.cacheOptions.TTL)
 		return txn.SetEntry(e)
 	})
 	if err != nil {
@@ -89,159 +244,322 @@ func saveToBadger(url string, item *ImageCacheItem) {
 	}
 }
 
-// 从缓存中获取图片数据
-func getFromCache(url string) ([]byte, string, bool) {
-	if item, found := cache.Get(url); found {
-		return item.Data, item.ContentType, true
+// 从缓存中获取缓存项，先查内存缓存再查 BadgerDB
+func (p *Proxy) getFromCache(url string) (*ImageCacheItem, bool) {
+	if item, found := p.cache.Get(url); found {
+		metrics.ProxyCacheHits.WithLabelValues("lru").Inc()
+		p.counters.lruHits.Add(1)
+		return item, true
 	}
-	if item, found := getFromBadger(url); found {
-		cache.Add(url, item) // 加载到内存缓存
-		return item.Data, item.ContentType, true
+	metrics.ProxyCacheMisses.WithLabelValues("lru").Inc()
+	p.counters.lruMisses.Add(1)
+	if item, found := p.getFromBadger(url); found {
+		metrics.ProxyCacheHits.WithLabelValues("badger").Inc()
+		p.counters.badgerHits.Add(1)
+		p.addToMemory(url, item) // 加载到内存缓存
+		return item, true
 	}
-	return nil, "", false
+	metrics.ProxyCacheMisses.WithLabelValues("badger").Inc()
+	p.counters.badgerMisses.Add(1)
+	return nil, false
 }
 
-// 将图片数据保存到缓存
-func saveToCache(url string, data []byte, contentType string) {
-	item := &ImageCacheItem{
-		Data:        data,
-		ContentType: contentType,
-		Timestamp:   time.Now(),
-	}
-	cache.Add(url, item)
-	saveToBadger(url, item) // 同时保存到 BadgerDB
+// 将缓存项保存到内存缓存和 BadgerDB
+func (p *Proxy) saveToCache(url string, item *ImageCacheItem) {
+	p.addToMemory(url, item)
+	p.saveToBadger(url, item)
 }
 
-// 下载图片
-func downloadImage(url string) ([]byte, string, error) {
-	resp, err := http.Get(url)
+// 从上游获取图片，按上游域名附带请求头方案；cached 不为空时带上 If-None-Match / If-Modified-Since 进行条件请求，
+// 上游返回 304 时沿用缓存的数据并更新验证时间
+func (p *Proxy) fetchImage(url string, cached *ImageCacheItem) (*ImageCacheItem, error) {
+	policy, client := p.upstreamPolicy, p.upstreamClient
+	req, err := http.NewRequest(http.MethodGet, url, nil)
 	if err != nil {
-		return nil, "", fmt.Errorf("下载图片失败: %w", err)
+		return nil, fmt.Errorf("下载图片失败: %w", err)
+	}
+	profile := p.applyProfile(req)
+	if cached != nil {
+		if cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("下载图片失败: %w", err)
 	}
 	defer resp.Body.Close()
 
+	if resp.StatusCode == http.StatusNotModified && cached != nil {
+		revalidated := *cached
+		revalidated.Timestamp = time.Now()
+		revalidated.Profile = profile
+		if etag := resp.Header.Get("ETag"); etag != "" {
+			revalidated.ETag = etag
+		}
+		if lastModified := resp.Header.Get("Last-Modified"); lastModified != "" {
+			revalidated.LastModified = lastModified
+		}
+		return &revalidated, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("下载图片失败: 上游返回 %s", resp.Status)
+	}
+
 	contentType := resp.Header.Get("Content-Type")
 	if !strings.HasPrefix(contentType, "image") {
-		return nil, "", fmt.Errorf("无效的 Content-Type: %s", contentType)
+		return nil, fmt.Errorf("无效的 Content-Type: %s", contentType)
 	}
 
-	data, err := io.ReadAll(resp.Body)
+	if resp.ContentLength > policy.MaxSize {
+		return nil, fmt.Errorf("%w: %d 字节", errImageTooLarge, resp.ContentLength)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, policy.MaxSize+1))
 	if err != nil {
-		return nil, "", fmt.Errorf("读取图片数据失败: %w", err)
+		return nil, fmt.Errorf("读取图片数据失败: %w", err)
+	}
+	if int64(len(data)) > policy.MaxSize {
+		return nil, fmt.Errorf("%w: 超过 %d 字节", errImageTooLarge, policy.MaxSize)
 	}
 
-	return data, contentType, nil
-}
-
-// 设置 CORS 头部
-func setCORS(w http.ResponseWriter) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Access-Control-Allow-Methods", "GET, OPTIONS")
-	w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+	return &ImageCacheItem{
+		Data:         data,
+		ContentType:  contentType,
+		ETag:         resp.Header.Get("ETag"),
+		LastModified: resp.Header.Get("Last-Modified"),
+		Timestamp:    time.Now(),
+		Profile:      profile,
+		Hash:         hashData(data),
+	}, nil
 }
 
-// 处理图片代理请求
-func serveImage(w http.ResponseWriter, r *http.Request) {
-	setCORS(w)
-
-	if r.Method == http.MethodOptions {
-		w.WriteHeader(http.StatusOK)
+// ServeImage 处理 /image 请求，供挂载到 API 服务时使用；跨域头由外层中间件设置，代理未启用时返回 404
+func (p *Proxy) ServeImage(w http.ResponseWriter, r *http.Request) {
+	if !p.Enabled() {
+		http.NotFound(w, r)
 		return
 	}
+	p.serveImage(w, r)
+}
 
+// 处理图片代理请求
+func (p *Proxy) serveImage(w http.ResponseWriter, r *http.Request) {
 	imageURL := r.URL.Query().Get("url")
 	if imageURL == "" {
 		http.Error(w, "缺少 'url' 参数", http.StatusBadRequest)
 		return
 	}
-
-	if data, contentType, found := getFromCache(imageURL); found {
-		w.Header().Set("Content-Type", contentType)
-		w.Write(data)
+	if err := p.verifyQuery(r.URL.Query(), time.Now()); err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	t, err := parseTransform(r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
 
-	data, contentType, err := downloadImage(imageURL)
+	item, err := p.loadImage(imageURL)
+	if errors.Is(err, errForbiddenUpstream) {
+		log.Printf("WARN", "拒绝代理图片: %v", err)
+		http.Error(w, "不允许代理该地址", http.StatusForbidden)
+		return
+	}
 	if err != nil {
 		log.Printf("WARN", "下载图片出错: %v", err)
 		http.Error(w, "图片下载失败", http.StatusInternalServerError)
 		return
 	}
+	if t.empty() {
+		writeImage(w, item)
+		return
+	}
 
-	saveToCache(imageURL, data, contentType)
-
-	w.Header().Set("Content-Type", contentType)
-	w.Write(data)
+	transformed, err := p.transformImage(imageURL, item, t)
+	if err != nil {
+		log.Printf("WARN", "转换图片出错: %v", err)
+		http.Error(w, "图片转换失败", http.StatusUnprocessableEntity)
+		return
+	}
+	writeImage(w, transformed)
 }
 
-// StartServer 启动图片代理服务器，自动判断是否使用 HTTPS
-func StartServer(_host string, _port int, certFile string, keyFile string, allowedOrigins []string) {
-	host = _host
-	port = _port
+// 获取原图：缓存未过期时直接使用，否则向上游获取或验证；上游不可用时继续使用过期的缓存。
+// 地址不在允许列表中时返回 errForbiddenUpstream
+func (p *Proxy) loadImage(imageURL string) (*ImageCacheItem, error) {
+	// 先检查地址，允许列表收紧后不再返回已缓存的图片
+	u, err := url.Parse(imageURL)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", errForbiddenUpstream, err)
+	}
+	if err := p.upstreamPolicy.check(u); err != nil {
+		return nil, err
+	}
 
-	// 初始化缓存逻辑
-	initCache()
+	cached, found := p.getFromCache(imageURL)
+	if found && !cached.stale(time.Now()) {
+		return cached, nil
+	}
 
-	mux := http.NewServeMux()
-	mux.HandleFunc("/image", func(w http.ResponseWriter, r *http.Request) {
-		// 设置 CORS 头
-		origin := r.Header.Get("Origin")
-		if isOriginAllowed(origin, allowedOrigins) {
-			w.Header().Set("Access-Control-Allow-Origin", origin)
-			w.Header().Set("Access-Control-Allow-Credentials", "true")
+	// 同一图片的并发请求只向上游下载一次
+	v, err, _ := p.inflight.Do(imageURL, func() (any, error) {
+		start := time.Now()
+		item, err := p.fetchImage(imageURL, cached)
+		metrics.Since(metrics.ProxyDownloadDuration, start)
+		if err != nil {
+			return nil, err
 		}
+		p.saveToCache(imageURL, item)
+		return item, nil
+	})
+	if err != nil {
+		if found {
+			log.Printf("WARN", "验证缓存图片出错，使用过期缓存: %v", err)
+			return cached, nil
+		}
+		return nil, err
+	}
+	return v.(*ImageCacheItem), nil
+}
+
+// 获取转换后的图片，转换结果以原图 URL 加转换参数为键缓存，原图数据变化后重新转换
+func (p *Proxy) transformImage(imageURL string, source *ImageCacheItem, t transform) (*ImageCacheItem, error) {
+	key := t.cacheKey(imageURL)
+	etag := t.etag(source)
+	if cached, found := p.getFromCache(key); found && cached.ETag == etag {
+		return cached, nil
+	}
 
-		serveImage(w, r)
+	v, err, _ := p.inflight.Do(key, func() (any, error) {
+		item, err := t.apply(source)
+		if err != nil {
+			return nil, err
+		}
+		p.saveToCache(key, item)
+		return item, nil
 	})
+	if err != nil {
+		return nil, err
+	}
+	return v.(*ImageCacheItem), nil
+}
 
-	server := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", host, port),
-		Handler: mux,
+// 写出缓存项
+func writeImage(w http.ResponseWriter, item *ImageCacheItem) {
+	w.Header().Set("Content-Type", item.ContentType)
+	if item.ETag != "" {
+		w.Header().Set("ETag", item.ETag)
+	}
+	if item.LastModified != "" {
+		w.Header().Set("Last-Modified", item.LastModified)
 	}
+	_, _ = w.Write(item.Data)
+}
 
-	useProxy = true
+// Enable 初始化缓存并启用图片代理，生成的代理 URL 指向 _host:_port；
+// 挂载到 API 服务时传入 API 的地址，由 API 服务处理 /image 请求
+func (p *Proxy) Enable(host string, port int, https bool) {
+	p.host = host
+	p.port = port
+	p.useHttps = https
 
-	// 判断是否启用 HTTPS
-	if certFile != "" && keyFile != "" {
-		useHttps = true
-		log.Printf("INFO", "启动 本地图片代理 (%s:%d/image)", host, port)
-		if err := server.ListenAndServeTLS(certFile, keyFile); err != nil {
-			log.Printf("ERROR", "启动服务器失败: %v", err)
-		}
+	// 初始化缓存逻辑
+	p.initCache()
+	p.startPrefetch()
+
+	p.useProxy = true
+}
+
+// StartServer 在独立端口上启动图片代理服务器，自动判断是否使用 HTTPS，跨域策略与 API 服务一致
+func (p *Proxy) StartServer(host string, port int, certFile string, keyFile string, allowedOrigins []string) {
+	p.Enable(host, port, certFile != "" && keyFile != "")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/image", p.serveImage)
+	p.handleAdmin(mux)
+
+	srv := &http.Server{
+		Addr:    fmt.Sprintf("%s:%d", host, port),
+		Handler: cors.Handler(allowedOrigins)(mux),
+	}
+	p.server.Store(srv)
+
+	log.Printf("INFO", "启动 本地图片代理 (%s:%d/image)", host, port)
+	var err error
+	if p.useHttps {
+		err = srv.ListenAndServeTLS(certFile, keyFile)
 	} else {
-		log.Printf("INFO", "启动 本地图片代理 (%s:%d/image)", host, port)
-		if err := server.ListenAndServe(); err != nil {
-			log.Printf("ERROR", "启动服务器失败: %v", err)
-		}
+		err = srv.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Printf("ERROR", "启动服务器失败: %v", err)
 	}
 }
 
-// 检查请求来源是否在允许的来源列表中
-func isOriginAllowed(origin string, allowedOrigins []string) bool {
-	if len(allowedOrigins) == 1 && allowedOrigins[0] == "*" {
-		return true // 如果允许所有来源
+// Enabled 判断是否启用了图片代理
+func (p *Proxy) Enabled() bool {
+	return p.useProxy
+}
+
+// CacheReady 判断 BadgerDB 是否已打开
+func (p *Proxy) CacheReady() bool {
+	return p.badgerDB != nil && !p.badgerDB.IsClosed()
+}
+
+// Shutdown 停止接受新的图片请求和预取，等待进行中的请求完成后关闭 BadgerDB；
+// 挂载到 API 服务时没有独立的服务实例，应在 API 服务关闭后调用
+func (p *Proxy) Shutdown(ctx context.Context) error {
+	var err error
+	if srv := p.server.Load(); srv != nil {
+		err = srv.Shutdown(ctx)
 	}
-	for _, allowed := range allowedOrigins {
-		if origin == allowed {
-			return true
+	p.stopOnce.Do(func() { close(p.done) })
+	if p.stopGC != nil {
+		p.stopGC()
+		p.stopGC = nil
+	}
+	if p.badgerDB != nil && !p.badgerDB.IsClosed() {
+		if closeErr := p.badgerDB.Close(); closeErr != nil {
+			log.Printf("ERROR", "关闭 BadgerDB 失败: %v", closeErr)
 		}
 	}
-	return false
+	return err
+}
+
+// GenerateImageURL 转换原始图片 URL 为代理 URL，设置了外部地址时使用外部地址，设置了密钥时附带签名
+func (p *Proxy) GenerateImageURL(originalURL string) (string, error) {
+	return p.GenerateImageVariantURL(originalURL, nil)
 }
 
-// GenerateImageURL 转换原始图片 URL 为代理 URL
-func GenerateImageURL(originalURL string) (string, error) {
-	if useProxy {
-		// 对 URL 进行编码处理
-		encodedURL := url.QueryEscape(originalURL)
-		// 拼接代理 URL
+// GenerateImageVariantURL 生成带转换参数（w、h、fit、crop、fmt）的代理 URL，设置了密钥时转换参数一并签名；
+// 转换参数无效时返回错误，未启用代理时返回原始 URL
+func (p *Proxy) GenerateImageVariantURL(originalURL string, params url.Values) (string, error) {
+	if !p.useProxy {
+		return originalURL, nil
+	}
+
+	query := url.Values{"url": {originalURL}}
+	for _, name := range transformParams {
+		if value := params.Get(name); value != "" {
+			query.Set(name, value)
+		}
+	}
+	if _, err := parseTransform(query); err != nil {
+		return "", err
+	}
+
+	base := p.publicURL
+	if base == "" {
 		protocol := "http"
-		if useHttps {
+		if p.useHttps {
 			protocol = "https"
 		}
-		proxyURL := fmt.Sprintf("%s://%s:%d/image?url=%s", protocol, host, port, encodedURL)
-		return proxyURL, nil
-	} else {
-		return originalURL, nil
+		base = fmt.Sprintf("%s://%s:%d", protocol, p.host, p.port)
 	}
+	p.signQuery(query, originalURL, time.Now())
+	return base + "/image?" + query.Encode(), nil
 }
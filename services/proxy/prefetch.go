@@ -0,0 +1,79 @@
+package proxy
+
+import (
+	"UniBarrage/utils/assets"
+	log "UniBarrage/utils/trace"
+)
+
+const (
+	prefetchQueueSize = 1024 // 预取队列长度，队列满时丢弃新的预取请求
+	prefetchWorkers   = 4    // 预取并发数
+)
+
+// 启动预取协程，由 Enable 在缓存初始化后调用，Shutdown 后退出
+func (p *Proxy) startPrefetch() {
+	p.prefetchOnce.Do(func() {
+		for i := 0; i < prefetchWorkers; i++ {
+			go p.prefetchWorker()
+		}
+	})
+}
+
+// PrefetchCatalog 预取目录中的礼物和表情图标，只处理消息中会被改写为代理 URL 的平台；
+// 由 assets.OnRefresh 在目录加载成功后调用
+func (p *Proxy) PrefetchCatalog(c assets.Catalog) {
+	if !proxiedPlatforms[c.Platform] {
+		return
+	}
+	icons := make([]string, 0, len(c.Assets))
+	for _, a := range c.Assets {
+		icons = append(icons, a.Images.Icon)
+	}
+	p.prefetch(icons...)
+}
+
+// 将图片加入预取队列，提前下载到缓存中，使客户端首次加载时直接命中；
+// 未启用代理、URL 为空或已在队列中时忽略，队列满时丢弃
+func (p *Proxy) prefetch(urls ...string) {
+	if !p.useProxy {
+		return
+	}
+	p.prefetchMu.Lock()
+	defer p.prefetchMu.Unlock()
+	for _, u := range urls {
+		if u == "" {
+			continue
+		}
+		if _, ok := p.prefetchQueued[u]; ok {
+			continue
+		}
+		select {
+		case p.prefetchQueue <- u:
+			p.prefetchQueued[u] = struct{}{}
+		default:
+			log.Printf("DEBUG", "图片预取队列已满，丢弃: %s", u)
+			return
+		}
+	}
+}
+
+func (p *Proxy) prefetchWorker() {
+	for {
+		var u string
+		select {
+		case <-p.done:
+			return
+		case u = <-p.prefetchQueue:
+		}
+		p.prefetchMu.Lock()
+		delete(p.prefetchQueued, u)
+		p.prefetchMu.Unlock()
+
+		if p.cache.Contains(u) {
+			continue
+		}
+		if _, err := p.loadImage(u); err != nil {
+			log.Printf("DEBUG", "预取图片失败: %v", err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package proxy
+
+import (
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/assets"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestConcurrentRequestsAreCoalesced(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	allowTestUpstream(p)
+
+	var requests atomic.Int32
+	release := make(chan struct{})
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		<-release
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("gift-icon"))
+	}))
+	defer upstream.Close()
+	target := "/image?url=" + url.QueryEscape(upstream.URL+"/gift.png")
+
+	var wg sync.WaitGroup
+	codes := make([]int, 20)
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			rec := httptest.NewRecorder()
+			p.serveImage(rec, httptest.NewRequest(http.MethodGet, target, nil))
+			codes[i] = rec.Code
+		}(i)
+	}
+	time.Sleep(100 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("concurrent requests should be coalesced, upstream requests=%d", n)
+	}
+	for i, code := range codes {
+		if code != http.StatusOK {
+			t.Fatalf("request %d: %d", i, code)
+		}
+	}
+}
+
+func TestPrefetch(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	allowTestUpstream(p)
+	useSigning(p, "", 0)
+	p.startPrefetch()
+
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("avatar"))
+	}))
+	defer upstream.Close()
+	imageURL := upstream.URL + "/avatar.png"
+
+	p.prefetch("", imageURL, imageURL)
+	deadline := time.Now().Add(2 * time.Second)
+	for !p.cache.Contains(imageURL) {
+		if time.Now().After(deadline) {
+			t.Fatal("image was not prefetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	rec := httptest.NewRecorder()
+	p.serveImage(rec, httptest.NewRequest(http.MethodGet, "/image?url="+url.QueryEscape(imageURL), nil))
+	if rec.Body.String() != "avatar" {
+		t.Fatalf("response: %q", rec.Body.String())
+	}
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("prefetched image should be served from cache, upstream requests=%d", n)
+	}
+}
+
+// 未启用代理时不加入预取队列，也不会请求上游
+func TestPrefetchDisabledProxy(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	allowTestUpstream(p)
+	p.startPrefetch()
+
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("avatar"))
+	}))
+	defer upstream.Close()
+
+	p.prefetch(upstream.URL + "/disabled.png")
+	time.Sleep(200 * time.Millisecond)
+	if n := requests.Load(); n != 0 {
+		t.Fatalf("prefetched while proxy disabled, upstream requests=%d", n)
+	}
+}
+
+// 只预取消息会被改写为代理 URL 的平台的目录图标
+func TestPrefetchCatalog(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	allowTestUpstream(p)
+	useSigning(p, "", 0)
+	p.startPrefetch()
+
+	var requests atomic.Int32
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("icon"))
+	}))
+	defer upstream.Close()
+
+	p.PrefetchCatalog(assets.Catalog{Platform: uni.DouYu, Assets: []assets.Asset{{Images: assets.Images{Icon: upstream.URL + "/douyu.png"}}}})
+	icon := upstream.URL + "/bilibili.png"
+	p.PrefetchCatalog(assets.Catalog{Platform: uni.BiliBili, Assets: []assets.Asset{{Images: assets.Images{Icon: icon}}}})
+
+	deadline := time.Now().Add(2 * time.Second)
+	for !p.cache.Contains(icon) {
+		if time.Now().After(deadline) {
+			t.Fatal("catalog icon was not prefetched")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if n := requests.Load(); n != 1 {
+		t.Fatalf("only proxied platforms should be prefetched, upstream requests=%d", n)
+	}
+}
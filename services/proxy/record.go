@@ -0,0 +1,102 @@
+package proxy
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"time"
+)
+
+// BadgerDB 中缓存记录的格式：
+//
+//	magic (4 字节 "UBIC") | 版本 (1 字节) | 头部长度 (4 字节大端) | 头部 JSON | 图片数据
+//
+// 头部使用 JSON 以便后续版本追加字段；无法识别的记录（包括旧版本只保存图片数据的记录）视为未命中
+const recordVersion = 1
+
+var recordMagic = []byte("UBIC")
+
+// 记录头部长度上限，防止损坏的数据导致大量分配
+const maxRecordHeader = 64 << 10
+
+var errInvalidRecord = errors.New("无效的缓存记录")
+
+// 记录头部
+type recordHeader struct {
+	ContentType  string    `json:"contentType"`            // 上游返回的 Content-Type
+	ETag         string    `json:"etag,omitempty"`         // 上游返回的 ETag，用于条件请求
+	LastModified string    `json:"lastModified,omitempty"` // 上游返回的 Last-Modified，用于条件请求
+	FetchedAt    time.Time `json:"fetchedAt"`              // 最近一次从上游获取或验证的时间
+	Size         int       `json:"size"`                   // 图片数据长度，用于校验记录是否完整
+	Profile      string    `json:"profile,omitempty"`      // 获取时使用的请求头方案
+	Hash         string    `json:"hash,omitempty"`         // 图片数据的 SHA-1
+}
+
+// 将缓存项编码为记录
+func encodeRecord(item *ImageCacheItem) ([]byte, error) {
+	header, err := json.Marshal(recordHeader{
+		ContentType:  item.ContentType,
+		ETag:         item.ETag,
+		LastModified: item.LastModified,
+		FetchedAt:    item.Timestamp,
+		Size:         len(item.Data),
+		Profile:      item.Profile,
+		Hash:         item.Hash,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(len(recordMagic) + 5 + len(header) + len(item.Data))
+	buf.Write(recordMagic)
+	buf.WriteByte(recordVersion)
+	_ = binary.Write(&buf, binary.BigEndian, uint32(len(header)))
+	buf.Write(header)
+	buf.Write(item.Data)
+	return buf.Bytes(), nil
+}
+
+// 解码记录，data 会被复制，调用方可以复用 value 的内存
+func decodeRecord(value []byte) (*ImageCacheItem, error) {
+	prefix := len(recordMagic) + 5
+	if len(value) < prefix || !bytes.Equal(value[:len(recordMagic)], recordMagic) {
+		return nil, errInvalidRecord
+	}
+	if version := value[len(recordMagic)]; version != recordVersion {
+		return nil, fmt.Errorf("%w: 不支持的版本 %d", errInvalidRecord, version)
+	}
+
+	headerLen := int(binary.BigEndian.Uint32(value[len(recordMagic)+1 : prefix]))
+	if headerLen > maxRecordHeader || len(value) < prefix+headerLen {
+		return nil, fmt.Errorf("%w: 头部长度 %d", errInvalidRecord, headerLen)
+	}
+
+	var header recordHeader
+	if err := json.Unmarshal(value[prefix:prefix+headerLen], &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", errInvalidRecord, err)
+	}
+
+	data := value[prefix+headerLen:]
+	if len(data) != header.Size {
+		return nil, fmt.Errorf("%w: 数据长度 %d，应为 %d", errInvalidRecord, len(data), header.Size)
+	}
+
+	// 未保存哈希的记录在加载时计算一次
+	hash := header.Hash
+	if hash == "" {
+		hash = hashData(data)
+	}
+
+	return &ImageCacheItem{
+		Data:         append([]byte(nil), data...),
+		ContentType:  header.ContentType,
+		ETag:         header.ETag,
+		LastModified: header.LastModified,
+		Timestamp:    header.FetchedAt,
+		Profile:      header.Profile,
+		Hash:         hash,
+	}, nil
+}
@@ -0,0 +1,58 @@
+package proxy
+
+import (
+	uni "UniBarrage/universal"
+)
+
+// 需要通过代理加载图片的平台，B 站图片有防盗链
+var proxiedPlatforms = map[uni.Platform]bool{
+	uni.BiliBili: true,
+}
+
+// RewriteMessage 将消息中的头像、礼物图标和表情替换为代理 URL，未启用代理时不做修改
+func (p *Proxy) RewriteMessage(msg *uni.UniMessage) {
+	if !p.useProxy || !proxiedPlatforms[msg.Platform] {
+		return
+	}
+
+	switch data := msg.Data.(type) {
+	case *uni.ChatMessage:
+		data.Avatar = p.rewriteURL(data.Avatar)
+		for i, emoticon := range data.Emoticon {
+			data.Emoticon[i] = p.rewriteURL(emoticon)
+		}
+	case *uni.GiftMessage:
+		data.Avatar = p.rewriteURL(data.Avatar)
+		data.GiftIcon = p.rewriteURL(data.GiftIcon)
+		// 动画体积较大，只在客户端请求时下载
+		data.GiftIconDynamic = p.proxyURL(data.GiftIconDynamic)
+		data.GiftIconGif = p.proxyURL(data.GiftIconGif)
+		data.GiftIconWebp = p.proxyURL(data.GiftIconWebp)
+	case *uni.SubscribeMessage:
+		data.Avatar = p.rewriteURL(data.Avatar)
+	case *uni.SuperChatMessage:
+		data.Avatar = p.rewriteURL(data.Avatar)
+	case *uni.LikeMessage:
+		data.Avatar = p.rewriteURL(data.Avatar)
+	case *uni.EnterRoomMessage:
+		data.Avatar = p.rewriteURL(data.Avatar)
+	}
+}
+
+// 转换单个图片 URL 并预取到缓存，空 URL 保持不变
+func (p *Proxy) rewriteURL(originalURL string) string {
+	if originalURL == "" {
+		return originalURL
+	}
+	p.prefetch(originalURL)
+	return p.proxyURL(originalURL)
+}
+
+// 转换单个图片 URL，不预取，空 URL 保持不变
+func (p *Proxy) proxyURL(originalURL string) string {
+	if originalURL == "" {
+		return originalURL
+	}
+	generated, _ := p.GenerateImageURL(originalURL)
+	return generated
+}
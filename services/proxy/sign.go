@@ -0,0 +1,119 @@
+package proxy
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	errUnsignedURL  = errors.New("缺少签名")
+	errBadSignature = errors.New("签名无效")
+	errURLExpired   = errors.New("链接已过期")
+)
+
+// 参与签名的转换参数，按固定顺序规范化，防止借用一个签名 URL 请求任意尺寸和格式的变体
+var transformParams = []string{"w", "h", "fit", "crop", "fmt"}
+
+// SetSigning 设置代理 URL 的 HMAC 签名密钥和有效期，需在 StartServer 之前调用；
+// 设置密钥后代理拒绝未签名或签名无效的请求
+func (p *Proxy) SetSigning(secret string, ttl time.Duration) {
+	p.signSecret = nil
+	if secret != "" {
+		p.signSecret = []byte(secret)
+	}
+	p.signTTL = max(ttl, 0)
+}
+
+// SigningEnabled 判断是否设置了签名密钥
+func (p *Proxy) SigningEnabled() bool {
+	return p.signSecret != nil
+}
+
+// SetPublicURL 设置生成代理 URL 时使用的外部地址，为空时使用代理的监听地址
+func (p *Proxy) SetPublicURL(base string) error {
+	base = strings.TrimRight(strings.TrimSpace(base), "/")
+	if base == "" {
+		p.publicURL = ""
+		return nil
+	}
+	u, err := url.Parse(base)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" || u.RawQuery != "" {
+		return fmt.Errorf("无效的代理外部地址: %s", base)
+	}
+	p.publicURL = base
+	return nil
+}
+
+// 生成签名参数；有效期按 signTTL 对齐，同一图片在一段时间内得到相同的 URL，便于客户端缓存
+func (p *Proxy) signQuery(query url.Values, imageURL string, now time.Time) {
+	if p.signSecret == nil {
+		return
+	}
+	var expires string
+	if p.signTTL > 0 {
+		step := int64(p.signTTL / time.Second)
+		if step <= 0 {
+			step = 1
+		}
+		expires = strconv.FormatInt((now.Unix()/step+2)*step, 10)
+		query.Set("exp", expires)
+	}
+	query.Set("sig", p.signature(imageURL, canonicalTransform(query), expires))
+}
+
+// 校验请求的签名和有效期
+func (p *Proxy) verifyQuery(query url.Values, now time.Time) error {
+	if p.signSecret == nil {
+		return nil
+	}
+	sig := query.Get("sig")
+	if sig == "" {
+		return errUnsignedURL
+	}
+	expires := query.Get("exp")
+	if !hmac.Equal([]byte(sig), []byte(p.signature(query.Get("url"), canonicalTransform(query), expires))) {
+		return errBadSignature
+	}
+	if expires != "" {
+		exp, err := strconv.ParseInt(expires, 10, 64)
+		if err != nil {
+			return errBadSignature
+		}
+		if now.Unix() > exp {
+			return errURLExpired
+		}
+	}
+	return nil
+}
+
+// 规范化转换参数：按固定顺序拼接非空的参数，如 w=64&fmt=png
+func canonicalTransform(query url.Values) string {
+	var b strings.Builder
+	for _, name := range transformParams {
+		if value := query.Get(name); value != "" {
+			if b.Len() > 0 {
+				b.WriteByte('&')
+			}
+			b.WriteString(name + "=" + url.QueryEscape(value))
+		}
+	}
+	return b.String()
+}
+
+// 签名覆盖原图 URL、转换参数和过期时间
+func (p *Proxy) signature(imageURL string, transform string, expires string) string {
+	mac := hmac.New(sha256.New, p.signSecret)
+	mac.Write([]byte(imageURL))
+	mac.Write([]byte{0})
+	mac.Write([]byte(transform))
+	mac.Write([]byte{0})
+	mac.Write([]byte(expires))
+	return hex.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+// 设置签名密钥，并以 0.0.0.0:8888 启用代理 URL 生成，不初始化缓存
+func useSigning(p *Proxy, secret string, ttl time.Duration) {
+	p.SetSigning(secret, ttl)
+	p.useProxy, p.host, p.port = true, "0.0.0.0", 8888
+}
+
+func TestSignAndVerify(t *testing.T) {
+	p := New()
+	useSigning(p, "secret", time.Hour)
+	now := time.Unix(1700000000, 0)
+	imageURL := "https://i0.hdslb.com/bfs/face/a.jpg"
+
+	query := url.Values{"url": {imageURL}}
+	p.signQuery(query, imageURL, now)
+	if query.Get("sig") == "" || query.Get("exp") == "" {
+		t.Fatalf("signed query: %v", query)
+	}
+	if err := p.verifyQuery(query, now); err != nil {
+		t.Fatalf("valid signature: %v", err)
+	}
+
+	// 同一时间段内生成的 URL 相同
+	again := url.Values{"url": {imageURL}}
+	p.signQuery(again, imageURL, now.Add(time.Minute))
+	if again.Encode() != query.Encode() {
+		t.Errorf("urls within the same period should be stable: %s != %s", again.Encode(), query.Encode())
+	}
+
+	if err := p.verifyQuery(query, now.Add(3*time.Hour)); !errors.Is(err, errURLExpired) {
+		t.Errorf("expected expired, got %v", err)
+	}
+
+	tampered := url.Values{"url": {"https://i0.hdslb.com/other.jpg"}, "exp": query["exp"], "sig": query["sig"]}
+	if err := p.verifyQuery(tampered, now); !errors.Is(err, errBadSignature) {
+		t.Errorf("tampered url: expected bad signature, got %v", err)
+	}
+	extended := url.Values{"url": query["url"], "exp": {"9999999999"}, "sig": query["sig"]}
+	if err := p.verifyQuery(extended, now); !errors.Is(err, errBadSignature) {
+		t.Errorf("tampered expiry: expected bad signature, got %v", err)
+	}
+	if err := p.verifyQuery(url.Values{"url": {imageURL}}, now); !errors.Is(err, errUnsignedURL) {
+		t.Errorf("expected unsigned, got %v", err)
+	}
+
+	// 未设置有效期时不过期
+	p.SetSigning("secret", 0)
+	forever := url.Values{"url": {imageURL}}
+	p.signQuery(forever, imageURL, now)
+	if forever.Has("exp") {
+		t.Errorf("no expiry expected: %v", forever)
+	}
+	if err := p.verifyQuery(forever, now.Add(24*365*time.Hour)); err != nil {
+		t.Errorf("signature without expiry: %v", err)
+	}
+}
+
+func TestGenerateImageURL(t *testing.T) {
+	p := New()
+	useSigning(p, "", 0)
+	imageURL := "https://i0.hdslb.com/bfs/face/a.jpg?x=1&y=2"
+
+	generated, _ := p.GenerateImageURL(imageURL)
+	if generated != "http://0.0.0.0:8888/image?url="+url.QueryEscape(imageURL) {
+		t.Errorf("unsigned url: %s", generated)
+	}
+
+	if err := p.SetPublicURL("ftp://example.com"); err == nil {
+		t.Error("non-http public url should be rejected")
+	}
+	if err := p.SetPublicURL("https://example.com/unibarrage/"); err != nil {
+		t.Fatal(err)
+	}
+	p.SetSigning("secret", time.Hour)
+	generated, _ = p.GenerateImageURL(imageURL)
+	u, err := url.Parse(generated)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(generated, "https://example.com/unibarrage/image?") || u.Query().Get("url") != imageURL {
+		t.Errorf("public url: %s", generated)
+	}
+	if err := p.verifyQuery(u.Query(), time.Now()); err != nil {
+		t.Errorf("generated url should verify: %v", err)
+	}
+}
+
+func TestServeImageRequiresSignature(t *testing.T) {
+	p := New()
+	useSigning(p, "secret", 0)
+	useTempCache(t, p)
+	allowTestUpstream(p)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png-data"))
+	}))
+	defer upstream.Close()
+	imageURL := upstream.URL + "/a.png"
+
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.serveImage(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	if rec := get("/image?url=" + url.QueryEscape(imageURL)); rec.Code != http.StatusForbidden {
+		t.Fatalf("unsigned request: expected 403, got %d", rec.Code)
+	}
+
+	generated, _ := p.GenerateImageURL(imageURL)
+	u, _ := url.Parse(generated)
+	if rec := get(u.RequestURI()); rec.Code != http.StatusOK || rec.Body.String() != "png-data" {
+		t.Fatalf("signed request: %d %q", rec.Code, rec.Body.String())
+	}
+	// 转换参数参与签名，不能在签名 URL 上自行追加
+	if rec := get(u.RequestURI() + "&w=2000"); rec.Code != http.StatusForbidden {
+		t.Fatalf("signed request with appended transform: expected 403, got %d", rec.Code)
+	}
+}
+
+func TestSignedTransformVariants(t *testing.T) {
+	p := New()
+	useSigning(p, "secret", 0)
+	imageURL := "https://i0.hdslb.com/bfs/face/a.jpg"
+
+	generated, err := p.GenerateImageVariantURL(imageURL, url.Values{"w": {"64"}, "fmt": {"webp"}, "other": {"x"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(generated)
+	query := u.Query()
+	if query.Get("w") != "64" || query.Get("fmt") != "webp" || query.Has("other") {
+		t.Fatalf("variant url: %s", generated)
+	}
+	if err := p.verifyQuery(query, time.Now()); err != nil {
+		t.Fatalf("signed variant should verify: %v", err)
+	}
+
+	// 修改或去掉转换参数后签名失效
+	for name, value := range map[string]string{"w": "1024", "h": "64", "fmt": "png"} {
+		tampered := url.Values{}
+		for k, v := range query {
+			tampered[k] = v
+		}
+		tampered.Set(name, value)
+		if err := p.verifyQuery(tampered, time.Now()); !errors.Is(err, errBadSignature) {
+			t.Errorf("tampered %s: expected bad signature, got %v", name, err)
+		}
+	}
+	stripped := url.Values{"url": query["url"], "sig": query["sig"]}
+	if err := p.verifyQuery(stripped, time.Now()); !errors.Is(err, errBadSignature) {
+		t.Errorf("stripped transform: expected bad signature, got %v", err)
+	}
+
+	if _, err := p.GenerateImageVariantURL(imageURL, url.Values{"w": {"0"}}); !errors.Is(err, errInvalidTransform) {
+		t.Errorf("invalid transform: %v", err)
+	}
+}
+
+func TestServeImageRejectsTamperedWidth(t *testing.T) {
+	p := New()
+	useSigning(p, "secret", 0)
+	useTempCache(t, p)
+	allowTestUpstream(p)
+
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write(encodePNG(t, testImage(8, 8)))
+	}))
+	defer upstream.Close()
+
+	generated, err := p.GenerateImageVariantURL(upstream.URL+"/a.png", url.Values{"w": {"4"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	u, _ := url.Parse(generated)
+
+	rec := httptest.NewRecorder()
+	p.serveImage(rec, httptest.NewRequest(http.MethodGet, u.RequestURI(), nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("signed variant: %d %s", rec.Code, rec.Body.String())
+	}
+
+	rec = httptest.NewRecorder()
+	p.serveImage(rec, httptest.NewRequest(http.MethodGet, strings.Replace(u.RequestURI(), "w=4", "w=8", 1), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("tampered w: expected 403, got %d", rec.Code)
+	}
+}
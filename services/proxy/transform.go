@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/draw"
+	_ "golang.org/x/image/webp" // 解码 WebP 图片
+	"image"
+	_ "image/gif" // 缩放或裁剪动图时只取第一帧
+	"image/jpeg"
+	"image/png"
+	"math"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	maxTransformSize = 1024        // 输出图片的最大宽高
+	maxSourcePixels  = 4096 * 4096 // 允许转换的源图片最大像素数，防止解码超大图片
+	jpegQuality      = 90          // JPEG 输出质量
+	transformKeySep  = "#"         // 缓存键中原始 URL 与转换参数的分隔符
+	fitCover         = "cover"     // 等比缩放并居中裁剪，填满目标尺寸
+	fitContain       = "contain"   // 等比缩放至目标尺寸以内，不裁剪
+	fitFill          = "fill"      // 拉伸至目标尺寸
+	cropSquare       = "square"    // 居中裁剪为正方形
+	cropCircle       = "circle"    // 居中裁剪为圆形，圆外透明
+	formatPNG        = "png"       // PNG 输出
+	formatJPEG       = "jpeg"      // JPEG 输出
+	formatWebP       = "webp"      // WebP 输出（无损）
+	defaultFit       = fitContain  // 同时指定宽高时的默认缩放方式
+	defaultFormat    = formatPNG   // 需要转换时的默认输出格式
+)
+
+var errInvalidTransform = errors.New("无效的转换参数")
+
+// 图片转换参数，零值表示原样返回
+type transform struct {
+	Width  int    // 目标宽度，0 表示按比例或沿用原图
+	Height int    // 目标高度，0 表示按比例或沿用原图
+	Fit    string // 缩放方式
+	Crop   string // 裁剪形状
+	Format string // 输出格式
+}
+
+// 从查询参数解析转换参数：w、h、fit、crop、fmt
+func parseTransform(query url.Values) (transform, error) {
+	var t transform
+	var err error
+	if t.Width, err = parseDimension(query.Get("w")); err != nil {
+		return t, fmt.Errorf("%w: w %v", errInvalidTransform, err)
+	}
+	if t.Height, err = parseDimension(query.Get("h")); err != nil {
+		return t, fmt.Errorf("%w: h %v", errInvalidTransform, err)
+	}
+
+	t.Fit = strings.ToLower(query.Get("fit"))
+	switch t.Fit {
+	case "", fitCover, fitContain, fitFill:
+	default:
+		return t, fmt.Errorf("%w: 不支持的 fit %q", errInvalidTransform, t.Fit)
+	}
+
+	t.Crop = strings.ToLower(query.Get("crop"))
+	switch t.Crop {
+	case "", cropSquare, cropCircle:
+	default:
+		return t, fmt.Errorf("%w: 不支持的 crop %q", errInvalidTransform, t.Crop)
+	}
+
+	t.Format = strings.ToLower(query.Get("fmt"))
+	switch t.Format {
+	case "", formatPNG:
+	case "jpg", formatJPEG:
+		t.Format = formatJPEG
+	case formatWebP:
+	default:
+		return t, fmt.Errorf("%w: 不支持的 fmt %q，仅支持 png、jpeg、webp", errInvalidTransform, t.Format)
+	}
+
+	if t.empty() {
+		return t, nil
+	}
+	if t.Format == "" {
+		t.Format = defaultFormat
+	}
+	if t.Crop == cropCircle && t.Format == formatJPEG {
+		return t, fmt.Errorf("%w: JPEG 不支持透明，circle 裁剪请使用 png 或 webp", errInvalidTransform)
+	}
+	if t.Crop != "" {
+		t.Fit = fitCover
+	} else if t.Fit == "" && t.Width > 0 && t.Height > 0 {
+		t.Fit = defaultFit
+	}
+	return t, nil
+}
+
+func parseDimension(value string) (int, error) {
+	if value == "" {
+		return 0, nil
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return 0, err
+	}
+	if n <= 0 || n > maxTransformSize {
+		return 0, fmt.Errorf("应在 1 到 %d 之间", maxTransformSize)
+	}
+	return n, nil
+}
+
+// 是否需要转换
+func (t transform) empty() bool {
+	return t == transform{}
+}
+
+// 转换参数的规范表示，用于缓存键
+func (t transform) String() string {
+	return fmt.Sprintf("w=%d&h=%d&fit=%s&crop=%s&fmt=%s", t.Width, t.Height, t.Fit, t.Crop, t.Format)
+}
+
+// 转换结果的缓存键，与原图分开缓存
+func (t transform) cacheKey(imageURL string) string {
+	return imageURL + transformKeySep + t.String()
+}
+
+// 转换结果的 ETag，由原图数据的哈希和转换参数决定，原图更新后缓存的转换结果随之失效
+func (t transform) etag(source *ImageCacheItem) string {
+	return `"` + hashData([]byte(source.Hash+transformKeySep+t.String())) + `"`
+}
+
+// 计算图片数据的 SHA-1
+func hashData(data []byte) string {
+	sum := sha1.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// 是否只转换格式，不缩放也不裁剪
+func (t transform) formatOnly() bool {
+	return t.Width == 0 && t.Height == 0 && t.Crop == ""
+}
+
+// 对原图执行转换。GIF 动图只保留第一帧，动画 WebP 无法解码；只指定与原图相同的格式时原样返回，保留动画
+func (t transform) apply(source *ImageCacheItem) (*ImageCacheItem, error) {
+	cfg, format, err := image.DecodeConfig(bytes.NewReader(source.Data))
+	if err != nil {
+		return nil, fmt.Errorf("无法识别的图片格式 %s: %w", source.ContentType, err)
+	}
+	if t.formatOnly() && format == t.Format {
+		item := *source
+		item.ETag = t.etag(source)
+		return &item, nil
+	}
+	if cfg.Width <= 0 || cfg.Height <= 0 || cfg.Width*cfg.Height > maxSourcePixels {
+		return nil, fmt.Errorf("图片尺寸 %dx%d 超出限制", cfg.Width, cfg.Height)
+	}
+	src, _, err := image.Decode(bytes.NewReader(source.Data))
+	if err != nil {
+		return nil, fmt.Errorf("解码图片失败: %w", err)
+	}
+
+	dst := t.resize(src)
+	if t.Crop == cropCircle {
+		maskCircle(dst)
+	}
+
+	var buf bytes.Buffer
+	contentType := "image/png"
+	switch t.Format {
+	case formatJPEG:
+		contentType = "image/jpeg"
+		err = jpeg.Encode(&buf, dst, &jpeg.Options{Quality: jpegQuality})
+	case formatWebP:
+		contentType = "image/webp"
+		err = nativewebp.Encode(&buf, dst, nil)
+	default:
+		err = png.Encode(&buf, dst)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("编码图片失败: %w", err)
+	}
+
+	return &ImageCacheItem{
+		Data:         buf.Bytes(),
+		ContentType:  contentType,
+		ETag:         t.etag(source),
+		LastModified: source.LastModified,
+		Timestamp:    source.Timestamp,
+		Profile:      source.Profile,
+		Hash:         hashData(buf.Bytes()),
+	}, nil
+}
+
+// 按缩放方式和裁剪形状计算目标尺寸与源区域并缩放
+func (t transform) resize(src image.Image) *image.NRGBA {
+	sb := src.Bounds()
+	sw, sh := sb.Dx(), sb.Dy()
+	w, h := t.Width, t.Height
+
+	if t.Crop != "" {
+		// 正方形和圆形以较短边为边长
+		side := min(sw, sh)
+		switch {
+		case w > 0 && h > 0:
+			side = min(w, h)
+		case w > 0:
+			side = w
+		case h > 0:
+			side = h
+		}
+		w, h = side, side
+	}
+
+	switch {
+	case w == 0 && h == 0:
+		w, h = sw, sh
+	case h == 0:
+		h = max(1, int(math.Round(float64(sh)*float64(w)/float64(sw))))
+	case w == 0:
+		w = max(1, int(math.Round(float64(sw)*float64(h)/float64(sh))))
+	}
+
+	srcRect := sb
+	switch t.Fit {
+	case fitCover:
+		srcRect = coverRect(sb, w, h)
+	case fitContain:
+		scale := math.Min(float64(w)/float64(sw), float64(h)/float64(sh))
+		w = max(1, int(math.Round(float64(sw)*scale)))
+		h = max(1, int(math.Round(float64(sh)*scale)))
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	draw.CatmullRom.Scale(dst, dst.Bounds(), src, srcRect, draw.Src, nil)
+	return dst
+}
+
+// 计算与目标宽高比一致、居中的最大源区域
+func coverRect(sb image.Rectangle, w int, h int) image.Rectangle {
+	sw, sh := sb.Dx(), sb.Dy()
+	cw, ch := sw, int(math.Round(float64(sw)*float64(h)/float64(w)))
+	if ch > sh {
+		cw, ch = int(math.Round(float64(sh)*float64(w)/float64(h))), sh
+	}
+	cw, ch = max(1, cw), max(1, ch)
+	x0 := sb.Min.X + (sw-cw)/2
+	y0 := sb.Min.Y + (sh-ch)/2
+	return image.Rect(x0, y0, x0+cw, y0+ch)
+}
+
+// 将内切圆以外的像素设为透明，边缘按覆盖比例做抗锯齿
+func maskCircle(img *image.NRGBA) {
+	b := img.Bounds()
+	cx := float64(b.Min.X) + float64(b.Dx())/2
+	cy := float64(b.Min.Y) + float64(b.Dy())/2
+	r := math.Min(float64(b.Dx()), float64(b.Dy())) / 2
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			d := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			coverage := math.Max(0, math.Min(1, r-d+0.5))
+			if coverage >= 1 {
+				continue
+			}
+			i := img.PixOffset(x, y) + 3
+			img.Pix[i] = uint8(float64(img.Pix[i]) * coverage)
+		}
+	}
+}
@@ -0,0 +1,256 @@
+package proxy
+
+import (
+	"bytes"
+	"errors"
+	"github.com/HugoSmits86/nativewebp"
+	"golang.org/x/image/webp"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"image/png"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync/atomic"
+	"testing"
+)
+
+// 生成左半红色、右半蓝色的图片
+func testImage(w int, h int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			c := color.NRGBA{R: 255, A: 255}
+			if x >= w/2 {
+				c = color.NRGBA{B: 255, A: 255}
+			}
+			img.SetNRGBA(x, y, c)
+		}
+	}
+	return img
+}
+
+func encodePNG(t *testing.T, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestParseTransform(t *testing.T) {
+	cases := []struct {
+		query string
+		want  transform
+		err   bool
+	}{
+		{query: "", want: transform{}},
+		{query: "w=64&h=64", want: transform{Width: 64, Height: 64, Fit: fitContain, Format: formatPNG}},
+		{query: "w=64&h=64&fit=cover&fmt=PNG", want: transform{Width: 64, Height: 64, Fit: fitCover, Format: formatPNG}},
+		{query: "w=32&crop=circle", want: transform{Width: 32, Fit: fitCover, Crop: cropCircle, Format: formatPNG}},
+		{query: "fmt=jpg", want: transform{Format: formatJPEG}},
+		{query: "w=64&fmt=WebP", want: transform{Width: 64, Format: formatWebP}},
+		{query: "w=32&crop=circle&fmt=webp", want: transform{Width: 32, Fit: fitCover, Crop: cropCircle, Format: formatWebP}},
+		{query: "w=0", err: true},
+		{query: "h=4096", err: true},
+		{query: "w=abc", err: true},
+		{query: "fit=tile", err: true},
+		{query: "crop=star", err: true},
+		{query: "fmt=bmp", err: true},
+		{query: "crop=circle&fmt=jpeg", err: true},
+	}
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		got, err := parseTransform(query)
+		if c.err {
+			if !errors.Is(err, errInvalidTransform) {
+				t.Errorf("%q: expected errInvalidTransform, got %v", c.query, err)
+			}
+			continue
+		}
+		if err != nil || got != c.want {
+			t.Errorf("%q: got %+v, %v; want %+v", c.query, got, err, c.want)
+		}
+	}
+}
+
+func TestTransformSizes(t *testing.T) {
+	source := &ImageCacheItem{Data: encodePNG(t, testImage(200, 100)), ContentType: "image/png"}
+	cases := []struct {
+		query string
+		w, h  int
+	}{
+		{query: "w=64&h=64&fit=cover", w: 64, h: 64},
+		{query: "w=64&h=64&fit=contain", w: 64, h: 32},
+		{query: "w=64&h=64&fit=fill", w: 64, h: 64},
+		{query: "w=50", w: 50, h: 25},
+		{query: "h=50", w: 100, h: 50},
+		{query: "crop=square", w: 100, h: 100},
+		{query: "w=64&h=48&crop=circle", w: 48, h: 48},
+	}
+	for _, c := range cases {
+		query, _ := url.ParseQuery(c.query)
+		tr, err := parseTransform(query)
+		if err != nil {
+			t.Fatal(err)
+		}
+		item, err := tr.apply(source)
+		if err != nil {
+			t.Fatalf("%q: %v", c.query, err)
+		}
+		img, err := png.Decode(bytes.NewReader(item.Data))
+		if err != nil {
+			t.Fatalf("%q: output is not png: %v", c.query, err)
+		}
+		if b := img.Bounds(); b.Dx() != c.w || b.Dy() != c.h {
+			t.Errorf("%q: got %dx%d, want %dx%d", c.query, b.Dx(), b.Dy(), c.w, c.h)
+		}
+	}
+}
+
+func TestTransformCircleAndJPEG(t *testing.T) {
+	source := &ImageCacheItem{Data: encodePNG(t, testImage(100, 100)), ContentType: "image/png"}
+
+	circle, err := transform{Width: 40, Height: 40, Fit: fitCover, Crop: cropCircle, Format: formatPNG}.apply(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	img, _ := png.Decode(bytes.NewReader(circle.Data))
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("corner should be transparent, alpha=%d", a)
+	}
+	if _, _, _, a := img.At(10, 20).RGBA(); a != 0xffff {
+		t.Errorf("inside of circle should be opaque, alpha=%d", a)
+	}
+
+	jpg, err := transform{Width: 20, Format: formatJPEG}.apply(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if jpg.ContentType != "image/jpeg" {
+		t.Errorf("content type %q", jpg.ContentType)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(jpg.Data)); err != nil {
+		t.Errorf("output is not jpeg: %v", err)
+	}
+
+	if _, err := (transform{Width: 20, Format: formatPNG}).apply(&ImageCacheItem{Data: []byte("not an image")}); err == nil {
+		t.Error("invalid source should fail")
+	}
+}
+
+func TestTransformWebP(t *testing.T) {
+	source := &ImageCacheItem{Data: encodePNG(t, testImage(100, 100)), ContentType: "image/png"}
+
+	item, err := transform{Width: 40, Height: 40, Fit: fitCover, Crop: cropCircle, Format: formatWebP}.apply(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if item.ContentType != "image/webp" {
+		t.Errorf("content type %q", item.ContentType)
+	}
+	img, err := webp.Decode(bytes.NewReader(item.Data))
+	if err != nil {
+		t.Fatalf("output is not webp: %v", err)
+	}
+	if b := img.Bounds(); b.Dx() != 40 || b.Dy() != 40 {
+		t.Errorf("got %dx%d, want 40x40", b.Dx(), b.Dy())
+	}
+	if _, _, _, a := img.At(0, 0).RGBA(); a != 0 {
+		t.Errorf("corner should be transparent, alpha=%d", a)
+	}
+}
+
+// 只指定与原图相同的格式时原样返回，动画 WebP 不会被压成一帧
+func TestTransformKeepsAnimation(t *testing.T) {
+	var buf bytes.Buffer
+	err := nativewebp.EncodeAll(&buf, &nativewebp.Animation{
+		Images:    []image.Image{testImage(20, 20), testImage(20, 20)},
+		Durations: []uint{100, 100},
+		Disposals: []uint{0, 0},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	source := &ImageCacheItem{Data: buf.Bytes(), ContentType: "image/webp", Hash: hashData(buf.Bytes())}
+
+	tr := transform{Format: formatWebP}
+	item, err := tr.apply(source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(item.Data, source.Data) || item.ContentType != "image/webp" || item.ETag != tr.etag(source) {
+		t.Fatalf("animated webp should pass through unchanged: %+v", item)
+	}
+
+	if _, err := (transform{Width: 10, Format: formatWebP}).apply(source); err == nil {
+		t.Error("resizing animated webp should fail")
+	}
+}
+
+func TestServeImageTransformCache(t *testing.T) {
+	p := New()
+	useTempCache(t, p)
+	allowTestUpstream(p)
+
+	version := atomic.Int32{}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		size := 100
+		if version.Load() > 0 {
+			size = 80
+		}
+		_, _ = w.Write(encodePNG(t, testImage(size, size)))
+	}))
+	defer upstream.Close()
+	imageURL := upstream.URL + "/avatar.png"
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		p.serveImage(rec, httptest.NewRequest(http.MethodGet, "/image?url="+url.QueryEscape(imageURL)+"&"+query, nil))
+		return rec
+	}
+
+	rec := get("w=64&h=64&fit=cover&crop=circle")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/png" {
+		t.Fatalf("transform response: %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	etag := rec.Header().Get("ETag")
+	if original, ok := p.cache.Get(imageURL); !ok || original.Hash != hashData(original.Data) {
+		t.Fatal("source hash should be computed once and cached with the image")
+	}
+
+	tr := transform{Width: 64, Height: 64, Fit: fitCover, Crop: cropCircle, Format: formatPNG}
+	if _, ok := p.cache.Get(tr.cacheKey(imageURL)); !ok {
+		t.Fatal("transformed image should be cached under its own key")
+	}
+	if original, ok := p.cache.Get(imageURL); !ok || original.ContentType != "image/png" {
+		t.Fatal("original image should be cached under the url")
+	}
+	if rec := get("w=32"); rec.Header().Get("ETag") == etag {
+		t.Fatal("different transforms should produce different results")
+	}
+	// 同样的尺寸输出 WebP 时单独缓存
+	rec = get("w=64&h=64&fit=cover&crop=circle&fmt=webp")
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "image/webp" || rec.Header().Get("ETag") == etag {
+		t.Fatalf("webp response: %d %q", rec.Code, rec.Header().Get("Content-Type"))
+	}
+	tr.Format = formatWebP
+	if _, ok := p.cache.Get(tr.cacheKey(imageURL)); !ok {
+		t.Fatal("webp variant should be cached under its own key")
+	}
+
+	// 原图变化后重新转换
+	version.Store(1)
+	p.cache.Remove(imageURL)
+	_ = p.badgerDB.DropAll()
+	if rec := get("w=64&h=64&fit=cover&crop=circle"); rec.Header().Get("ETag") == etag {
+		t.Fatal("transformed image should be regenerated after the source changes")
+	}
+
+	if rec := get("w=2000"); rec.Code != http.StatusBadRequest {
+		t.Fatalf("invalid transform should be rejected, got %d", rec.Code)
+	}
+}
@@ -0,0 +1,149 @@
+package proxy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	defaultMaxImageSize = 5 << 20          // 默认单张图片最大字节数
+	defaultFetchTimeout = 10 * time.Second // 默认单次下载超时
+	maxRedirects        = 5                // 最多跟随的重定向次数
+)
+
+// DefaultAllowedHosts 默认允许代理的各平台图片 CDN 域名，同时匹配其子域名
+var DefaultAllowedHosts = []string{
+	"hdslb.com", "biliimg.com", // 哔哩哔哩
+	"douyinpic.com", "douyinstatic.com", "byteimg.com", // 抖音
+	"kwimgs.com", "yximgs.com", "kwaicdn.com", // 快手
+	"douyucdn.cn", "douyucdn2.cn", // 斗鱼
+	"huya.com", "msstatic.com", // 虎牙
+	"xhscdn.com", "xhscdn.net", // 小红书
+}
+
+var (
+	errForbiddenUpstream = errors.New("不允许代理的地址")
+	errImageTooLarge     = errors.New("图片超过大小限制")
+)
+
+// 除 netip 已区分的内网、回环、链路本地地址外，同样不允许访问的地址段
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),     // 本网络
+	netip.MustParsePrefix("100.64.0.0/10"), // 运营商级 NAT
+	netip.MustParsePrefix("192.0.0.0/24"),  // IETF 协议分配
+	netip.MustParsePrefix("198.18.0.0/15"), // 基准测试
+	netip.MustParsePrefix("64:ff9b::/96"),  // NAT64，可映射到内网 IPv4
+}
+
+// UpstreamPolicy 上游图片请求的限制，零值字段使用默认值
+type UpstreamPolicy struct {
+	AllowedHosts []string      // 允许的上游域名，"hdslb.com" 同时匹配其子域名，"*" 允许所有域名；为空时使用 DefaultAllowedHosts
+	AllowPrivate bool          // 是否允许访问内网、回环和链路本地地址
+	MaxSize      int64         // 单张图片最大字节数
+	Timeout      time.Duration // 单次下载超时，包含重定向
+}
+
+// SetUpstreamPolicy 设置上游请求限制，需在 StartServer 之前调用
+func (p *Proxy) SetUpstreamPolicy(policy UpstreamPolicy) {
+	p.upstreamPolicy = normalizePolicy(policy)
+	p.upstreamClient = newUpstreamClient(p.upstreamPolicy)
+}
+
+func normalizePolicy(policy UpstreamPolicy) UpstreamPolicy {
+	hosts := make([]string, 0, len(policy.AllowedHosts))
+	for _, h := range policy.AllowedHosts {
+		if h = strings.Trim(strings.ToLower(strings.TrimSpace(h)), "."); h != "" {
+			hosts = append(hosts, strings.TrimPrefix(h, "*."))
+		}
+	}
+	if len(hosts) == 0 {
+		hosts = DefaultAllowedHosts
+	}
+	policy.AllowedHosts = hosts
+	if policy.MaxSize <= 0 {
+		policy.MaxSize = defaultMaxImageSize
+	}
+	if policy.Timeout <= 0 {
+		policy.Timeout = defaultFetchTimeout
+	}
+	return policy
+}
+
+// 创建上游请求客户端：连接前检查解析后的 IP，重定向时重新检查目标地址；不使用环境变量中的代理，避免绕过 IP 检查
+func newUpstreamClient(policy UpstreamPolicy) *http.Client {
+	dialer := &net.Dialer{Timeout: policy.Timeout}
+	if !policy.AllowPrivate {
+		dialer.Control = func(network string, address string, _ syscall.RawConn) error {
+			addr, err := netip.ParseAddrPort(address)
+			if err != nil {
+				return fmt.Errorf("%w: %s", errForbiddenUpstream, address)
+			}
+			if blockedAddr(addr.Addr()) {
+				return fmt.Errorf("%w: %s", errForbiddenUpstream, addr.Addr())
+			}
+			return nil
+		}
+	}
+
+	return &http.Client{
+		Timeout: policy.Timeout,
+		Transport: &http.Transport{
+			DialContext:         dialer.DialContext,
+			ForceAttemptHTTP2:   true,
+			MaxIdleConns:        100,
+			IdleConnTimeout:     90 * time.Second,
+			TLSHandshakeTimeout: 10 * time.Second,
+		},
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			if len(via) >= maxRedirects {
+				return fmt.Errorf("重定向次数超过 %d 次", maxRedirects)
+			}
+			return policy.check(req.URL)
+		},
+	}
+}
+
+// 检查上游 URL 的协议和域名，IP 在连接时检查
+func (p UpstreamPolicy) check(u *url.URL) error {
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return fmt.Errorf("%w: 不支持的协议 %q", errForbiddenUpstream, u.Scheme)
+	}
+	if !p.hostAllowed(u.Hostname()) {
+		return fmt.Errorf("%w: %s 不在允许的域名列表中", errForbiddenUpstream, u.Hostname())
+	}
+	return nil
+}
+
+func (p UpstreamPolicy) hostAllowed(host string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	if host == "" {
+		return false
+	}
+	for _, pattern := range p.AllowedHosts {
+		if pattern == "*" || host == pattern || strings.HasSuffix(host, "."+pattern) {
+			return true
+		}
+	}
+	return false
+}
+
+// 判断是否为不允许访问的地址
+func blockedAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
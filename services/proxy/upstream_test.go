@@ -0,0 +1,127 @@
+package proxy
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+// 允许访问 httptest 的本地上游
+func allowTestUpstream(p *Proxy) {
+	p.SetUpstreamPolicy(UpstreamPolicy{AllowedHosts: []string{"*"}, AllowPrivate: true})
+}
+
+func TestHostAllowed(t *testing.T) {
+	policy := normalizePolicy(UpstreamPolicy{AllowedHosts: []string{"hdslb.com", "*.douyinpic.com", " XHSCDN.com. "}})
+	cases := map[string]bool{
+		"hdslb.com":              true,
+		"i0.hdslb.com":           true,
+		"I0.HDSLB.COM.":          true,
+		"p3.douyinpic.com":       true,
+		"douyinpic.com":          true,
+		"sns-avatar.xhscdn.com":  true,
+		"evilhdslb.com":          false,
+		"hdslb.com.evil.example": false,
+		"127.0.0.1":              false,
+		"":                       false,
+	}
+	for host, want := range cases {
+		if got := policy.hostAllowed(host); got != want {
+			t.Errorf("%q: got %v, want %v", host, got, want)
+		}
+	}
+
+	if !normalizePolicy(UpstreamPolicy{}).hostAllowed("i0.hdslb.com") {
+		t.Error("default policy should allow platform CDNs")
+	}
+	if !normalizePolicy(UpstreamPolicy{AllowedHosts: []string{"*"}}).hostAllowed("example.com") {
+		t.Error("wildcard should allow any host")
+	}
+
+	for _, raw := range []string{"file:///etc/passwd", "gopher://hdslb.com/", "//hdslb.com/a.png"} {
+		u, _ := url.Parse(raw)
+		if err := policy.check(u); !errors.Is(err, errForbiddenUpstream) {
+			t.Errorf("%q should be forbidden, got %v", raw, err)
+		}
+	}
+}
+
+func TestBlockedAddr(t *testing.T) {
+	cases := map[string]bool{
+		"127.0.0.1":        true,
+		"10.1.2.3":         true,
+		"172.16.0.1":       true,
+		"192.168.1.1":      true,
+		"169.254.169.254":  true,
+		"100.64.0.1":       true,
+		"0.0.0.0":          true,
+		"224.0.0.1":        true,
+		"::1":              true,
+		"fe80::1":          true,
+		"fd00::1":          true,
+		"::ffff:127.0.0.1": true,
+		"64:ff9b::a00:1":   true,
+		"8.8.8.8":          false,
+		"2001:4860::8888":  false,
+	}
+	for addr, want := range cases {
+		if got := blockedAddr(netip.MustParseAddr(addr)); got != want {
+			t.Errorf("%s: got %v, want %v", addr, got, want)
+		}
+	}
+}
+
+func TestFetchImageRejectsPrivateAddresses(t *testing.T) {
+	p := New()
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte("png-data"))
+	}))
+	defer upstream.Close()
+
+	// 域名允许但解析到回环地址
+	p.SetUpstreamPolicy(UpstreamPolicy{AllowedHosts: []string{"*"}})
+	if _, err := p.fetchImage(upstream.URL+"/a.png", nil); !errors.Is(err, errForbiddenUpstream) {
+		t.Fatalf("loopback upstream should be forbidden, got %v", err)
+	}
+
+	useTempCache(t, p)
+	rec := httptest.NewRecorder()
+	p.serveImage(rec, httptest.NewRequest(http.MethodGet, "/image?url="+url.QueryEscape(upstream.URL), nil))
+	if rec.Code != http.StatusForbidden {
+		t.Fatalf("expected 403, got %d", rec.Code)
+	}
+}
+
+func TestFetchImageRedirectsAndLimits(t *testing.T) {
+	p := New()
+	target := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "image/png")
+		_, _ = w.Write([]byte(strings.Repeat("x", 64)))
+	}))
+	defer target.Close()
+	redirect := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, strings.Replace(target.URL, "127.0.0.1", "localhost", 1)+r.URL.Path, http.StatusFound)
+	}))
+	defer redirect.Close()
+
+	// 重定向到不在允许列表中的域名
+	p.SetUpstreamPolicy(UpstreamPolicy{AllowedHosts: []string{"127.0.0.1"}, AllowPrivate: true})
+	if _, err := p.fetchImage(redirect.URL+"/a.png", nil); !errors.Is(err, errForbiddenUpstream) {
+		t.Fatalf("redirect to a disallowed host should be forbidden, got %v", err)
+	}
+
+	p.SetUpstreamPolicy(UpstreamPolicy{AllowedHosts: []string{"127.0.0.1", "localhost"}, AllowPrivate: true})
+	if item, err := p.fetchImage(redirect.URL+"/a.png", nil); err != nil || len(item.Data) != 64 {
+		t.Fatalf("allowed redirect: %v", err)
+	}
+
+	p.SetUpstreamPolicy(UpstreamPolicy{AllowedHosts: []string{"127.0.0.1"}, AllowPrivate: true, MaxSize: 32})
+	if _, err := p.fetchImage(target.URL+"/a.png", nil); !errors.Is(err, errImageTooLarge) {
+		t.Fatalf("oversized image should be rejected, got %v", err)
+	}
+}
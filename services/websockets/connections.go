@@ -2,12 +2,14 @@ package websockets
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
 	"net/http"
 	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 
 	"github.com/gobwas/ws"
 	"github.com/gobwas/ws/wsutil"
@@ -15,6 +17,7 @@ import (
 	"github.com/smallnest/chanx"
 
 	uni "UniBarrage/universal"
+	"UniBarrage/utils/metrics"
 	"UniBarrage/utils/ports"
 	log "UniBarrage/utils/trace"
 )
@@ -23,21 +26,33 @@ import (
 type Connection struct {
 	Conn     net.Conn
 	writeCh  *chanx.UnboundedChan[[]byte]
-	platform uni.Platform // 连接时的过滤条件：平台
-	id       string       // 连接时的过滤条件：ID
+	platform uni.Platform  // 连接时的过滤条件：平台
+	id       string        // 连接时的过滤条件：ID
+	group    string        // 连接的房间组 ID，非空时只接收该房间组的消息
+	scope    string        // 订阅范围 (all / group / 平台名)，用作指标标签
+	done     chan struct{} // 写入协程退出后关闭
+	closed   atomic.Bool   // 写入协程是否已退出
 }
 
-// 使用 map 搭配 sync.RWMutex 储存客户端连接
-var (
-	agentList = make(map[string]*Connection)
+// Hub 管理 WebSocket 客户端连接并向其广播消息；由采集引擎创建，各引擎的连接互不影响
+type Hub struct {
+	agentList map[string]*Connection // 使用 map 搭配 sync.RWMutex 储存客户端连接
 	mu        sync.RWMutex
-)
+	server    *http.Server // WebSocket 服务实例，用于优雅退出
+	listening atomic.Bool  // 是否已绑定端口
+}
+
+// NewHub 创建没有客户端连接的 Hub
+func NewHub() *Hub {
+	return &Hub{agentList: make(map[string]*Connection)}
+}
 
 // StartServer 启动 WebSocket 服务端，根据是否提供证书决定是启动 ws 还是 wss
-func StartServer(host string, port int, certFile string, keyFile string, allowedOrigins []string) {
+func (h *Hub) StartServer(host string, port int, certFile string, keyFile string, allowedOrigins []string) {
 	_ = ports.FreePort(port)
 
-	http.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
 		// 设置 CORS 头
 		origin := r.Header.Get("Origin")
 		if isOriginAllowed(origin, allowedOrigins) {
@@ -45,19 +60,29 @@ func StartServer(host string, port int, certFile string, keyFile string, allowed
 			w.Header().Set("Access-Control-Allow-Credentials", "true")
 		}
 
-		serveWs(w, r)
+		h.serveWs(w, r)
 	})
 
 	if isPortAvailable(host, port) {
+		server := &http.Server{Addr: host + ":" + strconv.Itoa(port), Handler: mux}
+		ln, err := net.Listen("tcp", server.Addr)
+		if err != nil {
+			log.Printf("ERROR", "服务器启动失败: %v", err)
+			return
+		}
+		h.server = server
+		h.listening.Store(true)
+
 		go func() {
+			defer h.listening.Store(false)
 			if certFile != "" && keyFile != "" {
 				log.Printf("INFO", "WebSocket (wss://%s:%d)", host, port)
-				if err := http.ListenAndServeTLS(host+":"+strconv.Itoa(port), certFile, keyFile, nil); err != nil {
+				if err := server.ServeTLS(ln, certFile, keyFile); err != nil && !errors.Is(err, http.ErrServerClosed) {
 					log.Printf("ERROR", "服务器启动失败: %v", err)
 				}
 			} else {
 				log.Printf("INFO", "WebSocket (ws://%s:%d)", host, port)
-				if err := http.ListenAndServe(host+":"+strconv.Itoa(port), nil); err != nil {
+				if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
 					log.Printf("ERROR", "服务器启动失败: %v", err)
 				}
 			}
@@ -65,6 +90,42 @@ func StartServer(host string, port int, certFile string, keyFile string, allowed
 	}
 }
 
+// Handler 返回 WebSocket 请求处理器，用于挂载到 API 服务；挂载前缀需先去除，使路径为 /{platform}/{id} 或 /group/{groupId}
+func (h *Hub) Handler() http.Handler {
+	return http.HandlerFunc(h.serveWs)
+}
+
+// Listening 判断 WebSocket 服务是否已绑定端口
+func (h *Hub) Listening() bool {
+	return h.listening.Load()
+}
+
+// Shutdown 停止接受新连接
+func (h *Hub) Shutdown(ctx context.Context) error {
+	if h.server == nil {
+		return nil
+	}
+	return h.server.Shutdown(ctx)
+}
+
+// CloseClients 发送完已缓冲的消息后，向所有客户端发送 1001 (Going Away) 关闭帧并断开连接
+func (h *Hub) CloseClients(ctx context.Context) error {
+	connections := h.connections()
+
+	for _, conn := range connections {
+		conn.writeMessage(nil)
+	}
+
+	for _, conn := range connections {
+		select {
+		case <-conn.done:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
 // 检查请求来源是否在允许的来源列表中
 func isOriginAllowed(origin string, allowedOrigins []string) bool {
 	if len(allowedOrigins) == 1 && allowedOrigins[0] == "*" {
@@ -88,13 +149,14 @@ func isPortAvailable(host string, port int) bool {
 	return false
 }
 
-// serveWs 处理 WebSocket 请求
-func serveWs(w http.ResponseWriter, r *http.Request) {
+// serveWs 处理 WebSocket 请求，路径为 /{platform}/{id} 或 /group/{groupId}
+func (h *Hub) serveWs(w http.ResponseWriter, r *http.Request) {
 	path := strings.TrimPrefix(r.URL.Path, "/")
 	parts := strings.Split(path, "/")
 
 	var platform uni.Platform
 	var id string
+	var group string
 
 	if len(parts) > 0 && parts[0] != "" {
 		platform = uni.Platform(parts[0])
@@ -103,13 +165,21 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
 		id = parts[1]
 	}
 
+	if platform == "group" {
+		if id == "" {
+			http.Error(w, "Missing group id", http.StatusBadRequest)
+			return
+		}
+		platform, id, group = "", "", id
+	}
+
 	if platform != "" && !uni.IsValidPlatform(platform) {
 		log.Printf("WARN", "无效的平台: %s", platform)
 		http.Error(w, "Invalid platform", http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("INFO", "%s 建立连接 (Total:%d)", r.RemoteAddr, getConnectionCount()+1)
+	log.Printf("INFO", "%s 建立连接 (Total:%d)", r.RemoteAddr, h.getConnectionCount()+1)
 
 	conn, _, _, err := ws.UpgradeHTTP(r, w)
 	if err != nil {
@@ -119,16 +189,16 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
 	defer conn.Close()
 
 	sec := r.Header.Get("Sec-WebSocket-Key")
-	connection := newConnection(conn, platform, id)
-	storeConnection(sec, connection)
-	defer deleteConnection(sec)
+	connection := newConnection(conn, platform, id, group)
+	h.storeConnection(sec, connection)
+	defer h.deleteConnection(sec)
 
 	go connection.startWriter()
 
 	for {
 		msg, _, err := wsutil.ReadClientData(conn)
 		if err != nil {
-			log.Printf("WARN", "%s 断开连接 (Total:%d)", r.RemoteAddr, getConnectionCount()-1)
+			log.Printf("WARN", "%s 断开连接 (Total:%d)", r.RemoteAddr, h.getConnectionCount()-1)
 			break
 		}
 		log.Printf("INFO", "%s", msg)
@@ -140,77 +210,118 @@ func serveWs(w http.ResponseWriter, r *http.Request) {
 }
 
 // 创建新连接时初始化通道和写入 goroutine
-func newConnection(conn net.Conn, platform uni.Platform, id string) *Connection {
+func newConnection(conn net.Conn, platform uni.Platform, id string, group string) *Connection {
 	c := &Connection{
 		Conn:     conn,
 		writeCh:  chanx.NewUnboundedChan[[]byte](context.Background(), 256),
 		platform: platform,
 		id:       id,
+		group:    group,
+		scope:    subscriptionScope(platform, group),
+		done:     make(chan struct{}),
 	}
 	return c
 }
 
-// 启动用于发送消息的 goroutine
+// 连接的订阅范围，取值有限，避免以客户端地址作为标签时每次重连都产生新的指标序列
+func subscriptionScope(platform uni.Platform, group string) string {
+	switch {
+	case group != "":
+		return "group"
+	case platform != "":
+		return string(platform)
+	default:
+		return "all"
+	}
+}
+
+// 启动用于发送消息的 goroutine，收到 nil 消息时发送关闭帧并断开连接
 func (c *Connection) startWriter() {
+	defer close(c.done)
+	defer c.closed.Store(true)
 	for msg := range c.writeCh.Out {
+		if msg == nil {
+			body := ws.NewCloseFrameBody(ws.StatusGoingAway, "server shutting down")
+			_ = wsutil.WriteServerMessage(c.Conn, ws.OpClose, body)
+			_ = c.Conn.Close()
+			return
+		}
 		if err := wsutil.WriteServerMessage(c.Conn, ws.OpText, msg); err != nil {
 			log.Printf("WARN", "发送消息失败: %v", err)
+			metrics.MessagesDropped.WithLabelValues(c.scope).Inc()
 			break
 		}
+		metrics.MessagesBroadcast.WithLabelValues(c.scope).Inc()
 	}
 }
 
-// 写入数据到通道，而不是直接写入连接
+// 写入数据到通道，而不是直接写入连接；写入协程已退出时直接忽略
 func (c *Connection) writeMessage(message []byte) {
+	if c.closed.Load() {
+		return
+	}
 	c.writeCh.In <- message
 }
 
 // 储存 WebSocket 客户端连接
-func storeConnection(agentID string, conn *Connection) {
-	mu.Lock()
-	defer mu.Unlock()
-	agentList[agentID] = conn
-}
-
-// 获取 WebSocket 客户端连接
-func getConnection(agentID string) (*Connection, bool) {
-	mu.RLock()
-	defer mu.RUnlock()
-	conn, ok := agentList[agentID]
-	return conn, ok
+func (h *Hub) storeConnection(agentID string, conn *Connection) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.agentList[agentID] = conn
+	metrics.WebSocketConnections.Add(1)
 }
 
 // 删除 WebSocket 客户端连接
-func deleteConnection(agentID string) {
-	mu.Lock()
-	defer mu.Unlock()
-	delete(agentList, agentID)
+func (h *Hub) deleteConnection(agentID string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if _, ok := h.agentList[agentID]; ok {
+		delete(h.agentList, agentID)
+		metrics.WebSocketConnections.Sub(1)
+	}
 }
 
 // 获取当前连接数
-func getConnectionCount() int {
-	mu.RLock()
-	defer mu.RUnlock()
-	return len(agentList)
+func (h *Hub) getConnectionCount() int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.agentList)
 }
 
-// BroadcastToClients 广播消息到所有客户端
-func BroadcastToClients(message *uni.UniMessage) {
-	mu.RLock()
-	connections := make([]*Connection, len(agentList))
-	i := 0
-	for _, conn := range agentList {
-		connections[i] = conn
-		i++
+// 复制当前的连接列表
+func (h *Hub) connections() []*Connection {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	connections := make([]*Connection, 0, len(h.agentList))
+	for _, conn := range h.agentList {
+		connections = append(connections, conn)
 	}
-	mu.RUnlock()
+	return connections
+}
 
-	for _, conn := range connections {
+// BroadcastToClients 广播消息到所有客户端，作为消息总线的订阅者；房间组连接不接收
+func (h *Hub) BroadcastToClients(message *uni.UniMessage) {
+	h.broadcast(message, func(c *Connection) bool {
+		return c.group == "" && shouldSendMessage(c, message)
+	})
+}
+
+// BroadcastToGroup 广播房间组消息到连接了 /group/{groupId} 的客户端
+func (h *Hub) BroadcastToGroup(groupID string, message *uni.UniMessage) {
+	h.broadcast(message, func(c *Connection) bool {
+		return c.group == groupID
+	})
+}
+
+// 将消息发送给满足条件的连接
+func (h *Hub) broadcast(message *uni.UniMessage, match func(c *Connection) bool) {
+	for _, conn := range h.connections() {
 		go func(c *Connection) {
-			if shouldSendMessage(c, message) {
+			if match(c) {
 				msgToSend, err := formatMessage(message)
 				if err != nil {
 					log.Printf("WARN", "消息格式化失败: %v", err)
+					metrics.MessagesDropped.WithLabelValues(c.scope).Inc()
 					return
 				}
 
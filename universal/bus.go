@@ -0,0 +1,57 @@
+package universal
+
+import "sync"
+
+// Bus 消息总线，将发布的消息按订阅顺序同步分发给所有订阅者；
+// 订阅者不应阻塞，耗时操作需自行放入协程
+type Bus struct {
+	mu          sync.RWMutex
+	subscribers []*subscriber
+}
+
+type subscriber struct {
+	pub Publisher
+}
+
+// NewBus 创建消息总线
+func NewBus() *Bus {
+	return &Bus{}
+}
+
+// Subscribe 添加订阅者，返回取消订阅的函数
+func (b *Bus) Subscribe(pub Publisher) func() {
+	s := &subscriber{pub: pub}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	// 写时复制，Publish 无需在分发期间持有锁
+	subscribers := make([]*subscriber, len(b.subscribers), len(b.subscribers)+1)
+	copy(subscribers, b.subscribers)
+	b.subscribers = append(subscribers, s)
+
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		subscribers := make([]*subscriber, 0, len(b.subscribers))
+		for _, current := range b.subscribers {
+			if current != s {
+				subscribers = append(subscribers, current)
+			}
+		}
+		b.subscribers = subscribers
+	}
+}
+
+// Publish 分发消息给所有订阅者
+func (b *Bus) Publish(msg *UniMessage) {
+	if msg == nil {
+		return
+	}
+	b.mu.RLock()
+	subscribers := b.subscribers
+	b.mu.RUnlock()
+
+	for _, s := range subscribers {
+		s.pub.Publish(msg)
+	}
+}
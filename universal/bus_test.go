@@ -0,0 +1,20 @@
+package universal
+
+import "testing"
+
+func TestBusPublishOrderAndUnsubscribe(t *testing.T) {
+	bus := NewBus()
+	var calls []string
+	bus.Subscribe(PublisherFunc(func(*UniMessage) { calls = append(calls, "a") }))
+	unsubscribe := bus.Subscribe(PublisherFunc(func(*UniMessage) { calls = append(calls, "b") }))
+
+	msg, _ := CreateUniMessage("1", BiliBili, ChatMessageType, &ChatMessage{})
+	bus.Publish(msg)
+	unsubscribe()
+	bus.Publish(msg)
+	bus.Publish(nil)
+
+	if len(calls) != 3 || calls[0] != "a" || calls[1] != "b" || calls[2] != "a" {
+		t.Fatalf("calls=%v", calls)
+	}
+}
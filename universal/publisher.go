@@ -0,0 +1,42 @@
+package universal
+
+// Publisher 接收适配器产生的统一消息
+type Publisher interface {
+	Publish(msg *UniMessage)
+}
+
+// PublisherFunc 将函数适配为 Publisher
+type PublisherFunc func(msg *UniMessage)
+
+// Publish 调用函数本身
+func (f PublisherFunc) Publish(msg *UniMessage) {
+	f(msg)
+}
+
+// Sink 单个房间的输出，由引擎为每个房间创建；除统一消息外，还接收抓包帧和在线人数等房间状态；
+// 房间日志通过 trace.For 从 Sink 取得
+type Sink interface {
+	Publisher
+	Raw(frame []byte)                     // 记录上游原始帧，同时视为收到上游帧
+	Decoded(kind string, v interface{})   // 记录解码后的上游消息（仅在开启抓包时写入）
+	Unhandled(kind string, v interface{}) // 记录未映射的上游消息类型
+	Touch()                               // 记录收到上游事件，用于不暴露原始帧的平台
+	Capturing() bool                      // 是否开启了抓包，可跳过只在抓包时需要的编码
+	Viewers(count int64)                  // 记录平台推送的在线人数
+}
+
+// PublishOnly 将 Publisher 包装为忽略房间状态的 Sink，用于测试或只需要统一消息的场景
+func PublishOnly(pub Publisher) Sink {
+	return publishOnly{pub}
+}
+
+type publishOnly struct {
+	Publisher
+}
+
+func (publishOnly) Raw([]byte)                    {}
+func (publishOnly) Decoded(string, interface{})   {}
+func (publishOnly) Unhandled(string, interface{}) {}
+func (publishOnly) Touch()                        {}
+func (publishOnly) Capturing() bool               { return false }
+func (publishOnly) Viewers(int64)                 {}
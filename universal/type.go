@@ -4,6 +4,8 @@ import (
 	"errors"
 	"fmt"
 	"github.com/goccy/go-json"
+	"strings"
+	"time"
 )
 
 // Platform 定义平台类型
@@ -29,6 +31,8 @@ const (
 	LikeMessageType      MessageType = "Like"      // 点赞消息
 	EnterRoomMessageType MessageType = "EnterRoom" // 进入房间消息
 	EndLiveMessageType   MessageType = "EndLive"   // 结束直播消息
+	CommandMessageType   MessageType = "Command"   // 弹幕命令消息
+	StatsMessageType     MessageType = "Stats"     // 房间统计消息
 )
 
 // UniMessage 结构体，表示统一的消息结构
@@ -48,11 +52,12 @@ type MessageData interface {
 
 // ChatMessage 表示聊天消息
 type ChatMessage struct {
-	Name     string      `json:"name"`     // 发送者的名称
-	Avatar   string      `json:"avatar"`   // 发送者的头像
-	Content  string      `json:"content"`  // 消息内容
-	Emoticon []string    `json:"emoticon"` // 表情包列表
-	Raw      interface{} `json:"raw"`      // 原始数据
+	Name     string      `json:"name"`          // 发送者的名称
+	Avatar   string      `json:"avatar"`        // 发送者的头像
+	UID      string      `json:"uid,omitempty"` // 发送者的平台用户 ID，平台未提供时为空
+	Content  string      `json:"content"`       // 消息内容
+	Emoticon []string    `json:"emoticon"`      // 表情包列表
+	Raw      interface{} `json:"raw"`           // 原始数据
 }
 
 func (*ChatMessage) IsMessageData() {}
@@ -69,13 +74,18 @@ func (m *ChatMessage) MarshalJSON() ([]byte, error) {
 
 // GiftMessage 表示礼物消息
 type GiftMessage struct {
-	Name     string      `json:"name"`     // 送礼者的名称
-	Avatar   string      `json:"avatar"`   // 送礼者的头像
-	Item     string      `json:"item"`     // 礼物名称
-	Num      int         `json:"num"`      // 礼物数量
-	Price    float64     `json:"price"`    // 礼物价格
-	GiftIcon string      `json:"giftIcon"` // 礼物图标
-	Raw      interface{} `json:"raw"`      // 原始数据
+	Name     string      `json:"name"`          // 送礼者的名称
+	Avatar   string      `json:"avatar"`        // 送礼者的头像
+	UID      string      `json:"uid,omitempty"` // 送礼者的平台用户 ID，平台未提供时为空
+	Item     string      `json:"item"`          // 礼物名称
+	Num      int         `json:"num"`           // 礼物数量
+	Price    float64     `json:"price"`         // 本条消息中礼物的总价值（人民币元，已乘以数量），价格未知或无法换算时为 0
+	GiftIcon string      `json:"giftIcon"`      // 礼物图标
+	Raw      interface{} `json:"raw"`           // 原始数据
+
+	GiftIconDynamic string `json:"giftIconDynamic,omitempty"` // 礼物动态图标，目前仅哔哩哔哩提供
+	GiftIconGif     string `json:"giftIconGif,omitempty"`     // 礼物 GIF 动画，目前仅哔哩哔哩提供
+	GiftIconWebp    string `json:"giftIconWebp,omitempty"`    // 礼物 WebP 动画，目前仅哔哩哔哩提供
 }
 
 func (*GiftMessage) IsMessageData() {}
@@ -114,11 +124,12 @@ func (m *SubscribeMessage) MarshalJSON() ([]byte, error) {
 
 // SuperChatMessage 表示超级聊天消息
 type SuperChatMessage struct {
-	Name    string      `json:"name"`    // 发送者的名称
-	Avatar  string      `json:"avatar"`  // 发送者的头像
-	Content string      `json:"content"` // 消息内容
-	Price   float64     `json:"price"`   // 超级聊天金额
-	Raw     interface{} `json:"raw"`     // 原始数据
+	Name    string      `json:"name"`          // 发送者的名称
+	Avatar  string      `json:"avatar"`        // 发送者的头像
+	UID     string      `json:"uid,omitempty"` // 发送者的平台用户 ID，平台未提供时为空
+	Content string      `json:"content"`       // 消息内容
+	Price   float64     `json:"price"`         // 超级聊天金额（人民币元）
+	Raw     interface{} `json:"raw"`           // 原始数据
 }
 
 func (*SuperChatMessage) IsMessageData() {}
@@ -189,6 +200,62 @@ func (m *EndLiveMessage) MarshalJSON() ([]byte, error) {
 	return json.Marshal((*Alias)(m))
 }
 
+// CommandMessage 表示以命令前缀开头的弹幕解析出的命令
+type CommandMessage struct {
+	Name    string      `json:"name"`    // 发送者的名称
+	Avatar  string      `json:"avatar"`  // 发送者的头像
+	User    string      `json:"user"`    // 用户标识，见 UserKey，用于冷却等按用户的处理
+	Command string      `json:"command"` // 命令名称，不含前缀，小写
+	Args    []string    `json:"args"`    // 命令参数
+	Content string      `json:"content"` // 原始弹幕内容
+	Raw     interface{} `json:"raw"`     // 原始数据
+}
+
+func (*CommandMessage) IsMessageData() {}
+
+func (m *CommandMessage) MarshalJSON() ([]byte, error) {
+	type Alias CommandMessage
+	raw, err := handleRawField(m.Raw)
+	if err != nil {
+		return nil, err
+	}
+	m.Raw = raw
+	return json.Marshal((*Alias)(m))
+}
+
+// StatsMessage 表示房间的实时统计，自开始监听起累计，定期推送
+type StatsMessage struct {
+	Since          time.Time              `json:"since"`          // 开始统计的时间
+	Chats          int64                  `json:"chats"`          // 弹幕总数
+	ChatsPerMinute int64                  `json:"chatsPerMinute"` // 最近一分钟的弹幕数
+	UniqueChatters int                    `json:"uniqueChatters"` // 发过弹幕的用户数
+	GiftValue      float64                `json:"giftValue"`      // 礼物与醒目留言的总价值
+	Gifts          map[string]StatsGift   `json:"gifts"`          // 按礼物名称汇总
+	Gifters        map[string]StatsGifter `json:"gifters"`        // 按用户汇总的礼物与醒目留言价值，键为 UserKey
+	TopGifters     []StatsGifter          `json:"topGifters"`     // 价值最高的送礼用户
+	SuperChats     int64                  `json:"superChats"`     // 醒目留言数
+	SuperChatValue float64                `json:"superChatValue"` // 醒目留言总价值
+	Likes          int64                  `json:"likes"`          // 点赞总数
+	Enters         int64                  `json:"enters"`         // 进入房间次数
+	Viewers        *int64                 `json:"viewers"`        // 当前在线人数，平台未提供时为 null
+	PeakViewers    *int64                 `json:"peakViewers"`    // 在线人数峰值，平台未提供时为 null
+}
+
+// StatsGift 单个礼物的汇总
+type StatsGift struct {
+	Count int64   `json:"count"` // 礼物数量
+	Value float64 `json:"value"` // 总价值
+}
+
+// StatsGifter 送礼用户及其送出的总价值
+type StatsGifter struct {
+	User  string  `json:"user"`  // 用户标识，见 UserKey
+	Name  string  `json:"name"`  // 用户名称，用户改名时为最近一次的名称
+	Value float64 `json:"value"` // 总价值（人民币元）
+}
+
+func (*StatsMessage) IsMessageData() {}
+
 // 通用的处理 Raw 字段的函数，用于处理消息中的 Raw 字段，确保其以 JSON 格式保存
 func handleRawField(raw interface{}) (json.RawMessage, error) {
 	switch rawField := raw.(type) {
@@ -213,6 +280,15 @@ func IsValidPlatform(platform Platform) bool {
 	}
 }
 
+// UserKey 返回跨平台唯一的用户标识 "平台:用户 ID"，如 "bilibili:12345"；
+// 平台未提供用户 ID 时使用去除多余空白并转为小写的名称
+func UserKey(platform Platform, uid string, name string) string {
+	if uid == "" {
+		uid = strings.ToLower(strings.Join(strings.Fields(name), " "))
+	}
+	return string(platform) + ":" + uid
+}
+
 // CreateUniMessage 创建 UniMessage 的工厂函数
 func CreateUniMessage(rid string, platform Platform, msgType MessageType, data MessageData) (*UniMessage, error) {
 	if !IsValidPlatform(platform) {
@@ -220,7 +296,7 @@ func CreateUniMessage(rid string, platform Platform, msgType MessageType, data M
 	}
 
 	switch msgType {
-	case ChatMessageType, GiftMessageType, SubscribeMessageType, SuperChatMessageType, LikeMessageType, EnterRoomMessageType, EndLiveMessageType:
+	case ChatMessageType, GiftMessageType, SubscribeMessageType, SuperChatMessageType, LikeMessageType, EnterRoomMessageType, EndLiveMessageType, CommandMessageType, StatsMessageType:
 		return &UniMessage{
 			RID:      rid,
 			Platform: platform,
@@ -231,3 +307,58 @@ func CreateUniMessage(rid string, platform Platform, msgType MessageType, data M
 		return nil, fmt.Errorf("无效的消息类型: %s", msgType)
 	}
 }
+
+// NewMessageData 根据消息类型创建空的消息数据，用于反序列化
+func NewMessageData(msgType MessageType) (MessageData, error) {
+	switch msgType {
+	case ChatMessageType:
+		return &ChatMessage{}, nil
+	case GiftMessageType:
+		return &GiftMessage{}, nil
+	case SubscribeMessageType:
+		return &SubscribeMessage{}, nil
+	case SuperChatMessageType:
+		return &SuperChatMessage{}, nil
+	case LikeMessageType:
+		return &LikeMessage{}, nil
+	case EnterRoomMessageType:
+		return &EnterRoomMessage{}, nil
+	case EndLiveMessageType:
+		return &EndLiveMessage{}, nil
+	case CommandMessageType:
+		return &CommandMessage{}, nil
+	case StatsMessageType:
+		return &StatsMessage{}, nil
+	default:
+		return nil, fmt.Errorf("无效的消息类型: %s", msgType)
+	}
+}
+
+// UnmarshalJSON 根据 type 字段将 data 解析为对应的消息类型
+func (m *UniMessage) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		RID      string          `json:"rid"`
+		Platform Platform        `json:"platform"`
+		Type     MessageType     `json:"type"`
+		Data     json.RawMessage `json:"data"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	msgData, err := NewMessageData(raw.Type)
+	if err != nil {
+		return err
+	}
+	if len(raw.Data) > 0 && string(raw.Data) != "null" {
+		if err := json.Unmarshal(raw.Data, msgData); err != nil {
+			return err
+		}
+	}
+
+	m.RID = raw.RID
+	m.Platform = raw.Platform
+	m.Type = raw.Type
+	m.Data = msgData
+	return nil
+}
@@ -0,0 +1,183 @@
+// Package assets 汇总各平台的礼物和表情目录；各平台在 init 中注册加载函数，由 Run 启动时加载并按计划刷新
+package assets
+
+import (
+	uni "UniBarrage/universal"
+	log "UniBarrage/utils/trace"
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Kind 目录类型
+type Kind string
+
+const (
+	Gifts  Kind = "gifts"  // 礼物
+	Emotes Kind = "emotes" // 表情
+)
+
+// 价格单位
+const (
+	UnitCNY    = "CNY"    // 人民币元
+	UnitSilver = "silver" // 哔哩哔哩银瓜子
+	UnitKuaiBi = "kuaibi" // 快币
+)
+
+// Asset 礼物或表情
+type Asset struct {
+	ID     string  `json:"id"`
+	Name   string  `json:"name"`
+	Price  float64 `json:"price"` // 单价，未知时为 0
+	Unit   string  `json:"unit"`  // 价格单位，如 CNY、silver、kuaibi，未知时为空
+	Images Images  `json:"images"`
+}
+
+// Images 礼物或表情的图片地址
+type Images struct {
+	Icon    string `json:"icon"`              // 静态图标
+	Dynamic string `json:"dynamic,omitempty"` // 动态图标
+	Gif     string `json:"gif,omitempty"`     // GIF 动画
+	Webp    string `json:"webp,omitempty"`    // WebP 动画
+}
+
+// Loader 从上游加载完整目录并按展示顺序返回，平台包同时用加载结果更新自身用于补全消息的查找表
+type Loader func() ([]Asset, error)
+
+// Catalog 某个平台的一类目录
+type Catalog struct {
+	Platform  uni.Platform `json:"platform"`
+	Kind      Kind         `json:"kind"`
+	UpdatedAt time.Time    `json:"updatedAt"`       // 最近一次成功加载的时间，尚未加载时为零值
+	Error     string       `json:"error,omitempty"` // 最近一次加载失败的原因，成功后清空
+	Assets    []Asset      `json:"assets"`
+}
+
+type entry struct {
+	load    Loader
+	catalog Catalog
+}
+
+var (
+	mu      sync.RWMutex
+	entries = make(map[string]*entry)
+	hooks   []func(Catalog) // 目录加载成功后调用
+)
+
+// 生成目录唯一标识
+func key(platform uni.Platform, kind Kind) string {
+	return fmt.Sprintf("%s_%s", platform, kind)
+}
+
+// Register 注册平台目录的加载函数，重复注册时替换加载函数并保留已加载的目录
+func Register(platform uni.Platform, kind Kind, load Loader) {
+	mu.Lock()
+	defer mu.Unlock()
+	if e, ok := entries[key(platform, kind)]; ok {
+		e.load = load
+		return
+	}
+	entries[key(platform, kind)] = &entry{
+		load:    load,
+		catalog: Catalog{Platform: platform, Kind: kind, Assets: []Asset{}},
+	}
+}
+
+// OnRefresh 注册目录加载成功后的回调，回调收到目录副本，需在 Run 之前注册
+func OnRefresh(hook func(Catalog)) {
+	mu.Lock()
+	defer mu.Unlock()
+	hooks = append(hooks, hook)
+}
+
+// Get 获取平台目录的副本，未注册时返回 false
+func Get(platform uni.Platform, kind Kind) (Catalog, bool) {
+	mu.RLock()
+	defer mu.RUnlock()
+	e, ok := entries[key(platform, kind)]
+	if !ok {
+		return Catalog{}, false
+	}
+	c := e.catalog
+	c.Assets = make([]Asset, len(e.catalog.Assets))
+	copy(c.Assets, e.catalog.Assets)
+	return c, true
+}
+
+// Refresh 重新加载平台目录，失败时保留上次加载的结果
+func Refresh(platform uni.Platform, kind Kind) error {
+	mu.RLock()
+	e, ok := entries[key(platform, kind)]
+	var load Loader
+	if ok {
+		load = e.load
+	}
+	mu.RUnlock()
+	if !ok {
+		return fmt.Errorf("%s 未注册 %s 目录", platform, kind)
+	}
+
+	list, err := load()
+
+	mu.Lock()
+	if err != nil {
+		e.catalog.Error = err.Error()
+		mu.Unlock()
+		return err
+	}
+	e.catalog.Assets = list
+	e.catalog.UpdatedAt = time.Now()
+	e.catalog.Error = ""
+	c := e.catalog
+	c.Assets = append([]Asset(nil), list...)
+	callbacks := hooks
+	mu.Unlock()
+
+	// 回调在锁外执行，避免回调中读取目录时死锁
+	for _, hook := range callbacks {
+		hook(c)
+	}
+	return nil
+}
+
+// RefreshAll 并发重新加载所有已注册的目录
+func RefreshAll() {
+	mu.RLock()
+	catalogs := make([]Catalog, 0, len(entries))
+	for _, e := range entries {
+		catalogs = append(catalogs, e.catalog)
+	}
+	mu.RUnlock()
+
+	var wg sync.WaitGroup
+	for _, c := range catalogs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := Refresh(c.Platform, c.Kind); err != nil {
+				log.Printf("WARN", "刷新 %s %s 目录失败: %v", c.Platform, c.Kind, err)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// Run 立即加载所有目录，之后每隔 interval 刷新一次，直到 ctx 取消；interval 为 0 时只加载一次
+func Run(ctx context.Context, interval time.Duration) {
+	RefreshAll()
+	if interval <= 0 {
+		return
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			RefreshAll()
+		}
+	}
+}
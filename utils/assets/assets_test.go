@@ -0,0 +1,102 @@
+package assets
+
+import (
+	uni "UniBarrage/universal"
+	"context"
+	"errors"
+	"testing"
+)
+
+func TestRefreshKeepsLastCatalog(t *testing.T) {
+	var fail bool
+	calls := 0
+	Register(uni.HuYa, Gifts, func() ([]Asset, error) {
+		calls++
+		if fail {
+			return nil, errors.New("upstream down")
+		}
+		return []Asset{{ID: "1", Name: "虎粮", Images: Images{Icon: "https://huyaimg.msstatic.com/1.png"}}}, nil
+	})
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(entries, key(uni.HuYa, Gifts))
+		mu.Unlock()
+	})
+
+	c, ok := Get(uni.HuYa, Gifts)
+	if !ok || c.Assets == nil || len(c.Assets) != 0 || !c.UpdatedAt.IsZero() {
+		t.Fatalf("before first load: %+v %v", c, ok)
+	}
+
+	Run(context.Background(), 0)
+	c, _ = Get(uni.HuYa, Gifts)
+	if calls != 1 || len(c.Assets) != 1 || c.Assets[0].Name != "虎粮" || c.UpdatedAt.IsZero() {
+		t.Fatalf("after load: %+v", c)
+	}
+
+	// 返回的是副本
+	c.Assets[0].Name = "changed"
+	if again, _ := Get(uni.HuYa, Gifts); again.Assets[0].Name != "虎粮" {
+		t.Error("Get should return a copy")
+	}
+
+	// 加载失败时保留上次的目录并记录原因
+	fail = true
+	if err := Refresh(uni.HuYa, Gifts); err == nil {
+		t.Fatal("expected refresh error")
+	}
+	c, _ = Get(uni.HuYa, Gifts)
+	if len(c.Assets) != 1 || c.Error != "upstream down" {
+		t.Fatalf("after failed refresh: %+v", c)
+	}
+
+	fail = false
+	if err := Refresh(uni.HuYa, Gifts); err != nil {
+		t.Fatal(err)
+	}
+	if c, _ = Get(uni.HuYa, Gifts); c.Error != "" {
+		t.Errorf("error should be cleared: %q", c.Error)
+	}
+
+	if _, ok := Get(uni.HuYa, Emotes); ok {
+		t.Error("unregistered catalog")
+	}
+	if err := Refresh(uni.HuYa, Emotes); err == nil {
+		t.Error("refreshing unregistered catalog should fail")
+	}
+}
+
+// 加载成功后调用回调，失败时不调用
+func TestOnRefresh(t *testing.T) {
+	var fail bool
+	Register(uni.DouYu, Emotes, func() ([]Asset, error) {
+		if fail {
+			return nil, errors.New("upstream down")
+		}
+		return []Asset{{ID: "1", Name: "666"}}, nil
+	})
+	var got []Catalog
+	mu.Lock()
+	prev := hooks
+	mu.Unlock()
+	OnRefresh(func(c Catalog) { got = append(got, c) })
+	t.Cleanup(func() {
+		mu.Lock()
+		delete(entries, key(uni.DouYu, Emotes))
+		hooks = prev
+		mu.Unlock()
+	})
+
+	if err := Refresh(uni.DouYu, Emotes); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 1 || got[0].Platform != uni.DouYu || len(got[0].Assets) != 1 {
+		t.Fatalf("hook calls: %+v", got)
+	}
+
+	fail = true
+	_ = Refresh(uni.DouYu, Emotes)
+	if len(got) != 1 {
+		t.Errorf("hook called after failed refresh: %d", len(got))
+	}
+}
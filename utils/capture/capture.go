@@ -0,0 +1,262 @@
+package capture
+
+import (
+	uni "UniBarrage/universal"
+	"UniBarrage/utils/rotate"
+	"errors"
+	"fmt"
+	"github.com/goccy/go-json"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	maxFileSize    = 10 << 20 // 单个抓包文件最大 10MB
+	maxFileBackups = 5        // 保留的历史抓包文件数量
+)
+
+// UnhandledKind 表示一种已收到但未映射为 UniMessage 的上游消息类型
+type UnhandledKind struct {
+	Kind      string    `json:"kind"`      // 上游消息类型
+	Count     int       `json:"count"`     // 出现次数
+	FirstSeen time.Time `json:"firstSeen"` // 首次出现时间
+	LastSeen  time.Time `json:"lastSeen"`  // 最近出现时间
+}
+
+// 抓包记录，每行一条 JSON
+type record struct {
+	Time time.Time   `json:"time"`
+	Kind string      `json:"kind"`           // raw / decoded / unhandled
+	Type string      `json:"type,omitempty"` // 上游消息类型
+	Data interface{} `json:"data,omitempty"` // 帧内容
+}
+
+// 单个服务的抓包状态
+type session struct {
+	mu        sync.Mutex
+	file      *rotate.File
+	unhandled map[string]*UnhandledKind
+	lastFrame time.Time // 最近一次收到上游帧的时间
+}
+
+// Store 按平台和房间 ID 保存抓包和未映射消息统计的状态，每个引擎持有一个
+type Store struct {
+	mu       sync.RWMutex
+	dir      string // 抓包文件目录
+	sessions map[string]*session
+}
+
+// NewStore 创建抓包状态，dir 为空时使用系统临时目录下的 UniBarrageCapture
+func NewStore(dir string) *Store {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "UniBarrageCapture")
+	}
+	return &Store{dir: dir, sessions: make(map[string]*session)}
+}
+
+// ErrUnsupported 平台不支持抓包
+var ErrUnsupported = errors.New("该平台不支持抓包")
+
+// 上游客户端不暴露原始帧的平台：哔哩哔哩通过 blivedm 只能收到已解析的事件，未知命令在库内丢弃，
+// 既无法写入原始帧，也无法统计未映射的消息
+var unsupported = map[uni.Platform]bool{
+	uni.BiliBili: true,
+}
+
+// Supported 判断平台是否支持抓包和未映射消息统计
+func Supported(platform uni.Platform) bool {
+	return !unsupported[platform]
+}
+
+// 生成服务唯一标识
+func key(platform uni.Platform, rid string) string {
+	return fmt.Sprintf("%s_%s", platform, rid)
+}
+
+// 获取服务的抓包状态，不存在时按需创建
+func (st *Store) getSession(platform uni.Platform, rid string, create bool) *session {
+	k := key(platform, rid)
+
+	st.mu.RLock()
+	s, ok := st.sessions[k]
+	st.mu.RUnlock()
+	if ok || !create {
+		return s
+	}
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if s, ok = st.sessions[k]; ok {
+		return s
+	}
+	s = &session{unhandled: make(map[string]*UnhandledKind)}
+	st.sessions[k] = s
+	return s
+}
+
+// Register 服务启动时登记抓包状态，未登记的服务不统计未映射消息
+func (st *Store) Register(platform uni.Platform, rid string) {
+	st.getSession(platform, rid, true)
+}
+
+// Enable 为指定服务开启抓包，帧数据写入 {dir}/{platform}_{rid}.jsonl
+func (st *Store) Enable(platform uni.Platform, rid string) string {
+	s := st.getSession(platform, rid, true)
+	path := filepath.Join(st.dir, key(platform, rid)+".jsonl")
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		s.file = rotate.NewFile(path, maxFileSize, maxFileBackups)
+	}
+	return s.file.Path
+}
+
+// Disable 关闭指定服务的抓包，保留未处理消息统计
+func (st *Store) Disable(platform uni.Platform, rid string) {
+	s := st.getSession(platform, rid, false)
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file != nil {
+		_ = s.file.Close()
+		s.file = nil
+	}
+}
+
+// Enabled 判断指定服务是否开启了抓包
+func (st *Store) Enabled(platform uni.Platform, rid string) bool {
+	s := st.getSession(platform, rid, false)
+	return s != nil && s.enabled()
+}
+
+// Remove 服务停止时清理抓包状态
+func (st *Store) Remove(platform uni.Platform, rid string) {
+	st.Disable(platform, rid)
+
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.sessions, key(platform, rid))
+}
+
+// Touch 记录收到上游帧的时间，用于判断服务是否健康；未登记的服务忽略
+func (st *Store) Touch(platform uni.Platform, rid string) {
+	s := st.getSession(platform, rid, false)
+	if s == nil {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.lastFrame = time.Now()
+}
+
+// LastFrame 返回最近一次收到上游帧的时间，尚未收到或服务未登记时返回零值
+func (st *Store) LastFrame(platform uni.Platform, rid string) time.Time {
+	s := st.getSession(platform, rid, false)
+	if s == nil {
+		return time.Time{}
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.lastFrame
+}
+
+// Raw 记录上游原始帧（仅在开启抓包时写入）
+func (st *Store) Raw(platform uni.Platform, rid string, frame []byte) {
+	st.Touch(platform, rid)
+	s := st.getSession(platform, rid, false)
+	if s == nil || !s.enabled() {
+		return
+	}
+
+	var data interface{} = frame // 二进制帧以 base64 保存
+	if utf8.Valid(frame) && json.Valid(frame) {
+		data = json.RawMessage(frame)
+	} else if utf8.Valid(frame) {
+		data = string(frame)
+	}
+	s.write(record{Kind: "raw", Data: data})
+}
+
+// Decoded 记录解码后的上游消息（仅在开启抓包时写入）
+func (st *Store) Decoded(platform uni.Platform, rid string, kind string, v interface{}) {
+	s := st.getSession(platform, rid, false)
+	if s == nil || !s.enabled() {
+		return
+	}
+	s.write(record{Kind: "decoded", Type: kind, Data: v})
+}
+
+// Unhandled 记录未映射的上游消息类型，统计始终开启，帧内容仅在开启抓包时写入；
+// 未登记的服务（如已停止的服务在退出前收到的帧）忽略
+func (st *Store) Unhandled(platform uni.Platform, rid string, kind string, v interface{}) {
+	s := st.getSession(platform, rid, false)
+	if s == nil {
+		return
+	}
+	now := time.Now()
+
+	s.mu.Lock()
+	if u, ok := s.unhandled[kind]; ok {
+		u.Count++
+		u.LastSeen = now
+	} else {
+		s.unhandled[kind] = &UnhandledKind{Kind: kind, Count: 1, FirstSeen: now, LastSeen: now}
+	}
+	s.mu.Unlock()
+
+	s.write(record{Kind: "unhandled", Type: kind, Data: v})
+}
+
+// Summary 返回指定服务未映射的上游消息类型，按出现次数降序
+func (st *Store) Summary(platform uni.Platform, rid string) []UnhandledKind {
+	result := make([]UnhandledKind, 0)
+	s := st.getSession(platform, rid, false)
+	if s == nil {
+		return result
+	}
+
+	s.mu.Lock()
+	for _, u := range s.unhandled {
+		result = append(result, *u)
+	}
+	s.mu.Unlock()
+
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Kind < result[j].Kind
+	})
+	return result
+}
+
+// 是否开启了抓包
+func (s *session) enabled() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.file != nil
+}
+
+// 写入一条抓包记录
+func (s *session) write(r record) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return
+	}
+
+	r.Time = time.Now()
+	line, err := json.Marshal(r)
+	if err != nil {
+		line, _ = json.Marshal(record{Time: r.Time, Kind: r.Kind, Type: r.Type, Data: fmt.Sprintf("%v", r.Data)})
+	}
+	_, _ = s.file.Write(append(line, '\n'))
+}
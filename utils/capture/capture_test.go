@@ -0,0 +1,55 @@
+package capture
+
+import (
+	uni "UniBarrage/universal"
+	"testing"
+)
+
+func TestUnhandledIgnoresUnregisteredRoom(t *testing.T) {
+	st := NewStore(t.TempDir())
+	st.Register(uni.DouYin, "1")
+	st.Unhandled(uni.DouYin, "1", "WebcastFooMessage", nil)
+	st.Touch(uni.DouYin, "1")
+	if got := st.Summary(uni.DouYin, "1"); len(got) != 1 || got[0].Kind != "WebcastFooMessage" {
+		t.Fatalf("got %+v", got)
+	}
+	if st.LastFrame(uni.DouYin, "1").IsZero() {
+		t.Error("LastFrame not recorded")
+	}
+
+	// 停止中的适配器在 Remove 之后仍可能收到帧，不能重新创建状态
+	st.Remove(uni.DouYin, "1")
+	st.Unhandled(uni.DouYin, "1", "WebcastFooMessage", nil)
+	st.Touch(uni.DouYin, "1")
+
+	st.mu.RLock()
+	n := len(st.sessions)
+	st.mu.RUnlock()
+	if n != 0 {
+		t.Errorf("got %d sessions after Remove, want 0", n)
+	}
+	if !st.LastFrame(uni.DouYin, "1").IsZero() {
+		t.Error("LastFrame kept after Remove")
+	}
+}
+
+// 不同的 Store 互不影响，同一房间可以分别统计
+func TestStoresAreIsolated(t *testing.T) {
+	a, b := NewStore(t.TempDir()), NewStore(t.TempDir())
+	a.Register(uni.DouYu, "1")
+	b.Register(uni.DouYu, "1")
+
+	a.Unhandled(uni.DouYu, "1", "foo", nil)
+	path := b.Enable(uni.DouYu, "1")
+	if got := b.Summary(uni.DouYu, "1"); len(got) != 0 {
+		t.Errorf("b saw a's unhandled kinds: %+v", got)
+	}
+	if a.Enabled(uni.DouYu, "1") || !b.Enabled(uni.DouYu, "1") {
+		t.Error("capture state leaked between stores")
+	}
+	if path == a.Enable(uni.DouYu, "1") {
+		t.Error("stores share a capture file")
+	}
+	a.Remove(uni.DouYu, "1")
+	b.Remove(uni.DouYu, "1")
+}
@@ -0,0 +1,162 @@
+package config
+
+import (
+	"UniBarrage/pkg/command"
+	"UniBarrage/pkg/group"
+	"UniBarrage/pkg/pipeline"
+	log "UniBarrage/utils/trace"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Config 配置文件结构，未填写的字段沿用命令行参数或其默认值
+type Config struct {
+	WebSocket  ServerConfig    `yaml:"websocket"`  // WebSocket 服务
+	API        ServerConfig    `yaml:"api"`        // API 服务
+	SinglePort *bool           `yaml:"singlePort"` // 是否在 API 端口上提供 WebSocket 和图片代理
+	Proxy      ProxyConfig     `yaml:"proxy"`      // 图片代理
+	TLS        TLSConfig       `yaml:"tls"`        // 证书配置
+	CORS       CORSConfig      `yaml:"cors"`       // 跨域配置
+	Auth       AuthConfig      `yaml:"auth"`       // 认证配置
+	Log        LogConfig       `yaml:"log"`        // 日志配置
+	State      StateConfig     `yaml:"state"`      // 服务持久化
+	DebugDir   string          `yaml:"debugDir"`   // 上游帧抓包文件目录
+	Rooms      []Room          `yaml:"rooms"`      // 启动后自动监听的房间
+	Pipeline   pipeline.Config `yaml:"pipeline"`   // 消息处理链
+	Scripts    ScriptsConfig   `yaml:"scripts"`    // 消息脚本
+	Commands   command.Config  `yaml:"commands"`   // 弹幕命令
+	Groups     []group.Config  `yaml:"groups"`     // 房间组
+}
+
+// ServerConfig 服务监听地址
+type ServerConfig struct {
+	Host string `yaml:"host"`
+	Port int    `yaml:"port"`
+}
+
+// ProxyConfig 图片代理配置
+type ProxyConfig struct {
+	Enabled   *bool  `yaml:"enabled"`   // 是否启用代理
+	Host      string `yaml:"host"`      // 代理主机地址
+	Port      int    `yaml:"port"`      // 代理端口
+	CacheSize int    `yaml:"cacheSize"` // 内存缓存条目数
+
+	CacheDir   string        `yaml:"cacheDir"`   // BadgerDB 缓存目录
+	CacheBytes int64         `yaml:"cacheBytes"` // 内存缓存图片总字节数上限
+	CacheTTL   time.Duration `yaml:"cacheTTL"`   // 磁盘缓存保存时长
+
+	AllowedHosts []string      `yaml:"allowedHosts"` // 允许的上游图片域名，同时匹配子域名
+	AllowPrivate bool          `yaml:"allowPrivate"` // 是否允许访问内网、回环和链路本地地址
+	MaxSize      int64         `yaml:"maxSize"`      // 单张图片最大字节数
+	Timeout      time.Duration `yaml:"timeout"`      // 单次下载超时
+
+	PublicURL string        `yaml:"publicURL"` // 生成代理 URL 时使用的外部地址
+	Secret    string        `yaml:"secret"`    // 代理 URL 的 HMAC 签名密钥
+	URLTTL    time.Duration `yaml:"urlTTL"`    // 签名代理 URL 的有效期
+
+	Headers []HeaderProfile `yaml:"headers"` // 按上游域名设置的请求头方案，优先于内置方案
+}
+
+// HeaderProfile 按上游域名设置的图片代理请求头方案
+type HeaderProfile struct {
+	Name    string            `yaml:"name"`    // 方案名称
+	Hosts   []string          `yaml:"hosts"`   // 匹配的上游域名，同时匹配子域名
+	Headers map[string]string `yaml:"headers"` // 请求头，如 Referer、User-Agent
+}
+
+// TLSConfig 证书配置
+type TLSConfig struct {
+	CertFile string `yaml:"certFile"`
+	KeyFile  string `yaml:"keyFile"`
+}
+
+// CORSConfig 跨域配置
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowedOrigins"`
+}
+
+// AuthConfig 认证配置，任一 Token 均可通过验证
+type AuthConfig struct {
+	Tokens []string `yaml:"tokens"`
+}
+
+// LogConfig 日志配置
+type LogConfig struct {
+	Level      *int              `yaml:"level"`      // 日志等级 (0: 默认, 1: 简洁, 2: 静默)
+	Format     string            `yaml:"format"`     // 输出格式 (tui / text / json)
+	File       string            `yaml:"file"`       // 日志文件路径
+	MaxSize    int               `yaml:"maxSize"`    // 单个日志文件最大 MB
+	MaxBackups int               `yaml:"maxBackups"` // 保留的历史日志文件数量
+	Platforms  map[string]string `yaml:"platforms"`  // 按平台覆盖日志等级，如 douyin: debug
+}
+
+// StateConfig 服务持久化配置
+type StateConfig struct {
+	File string `yaml:"file"` // 状态文件路径
+	Key  string `yaml:"key"`  // cookie 加密密钥
+}
+
+// ScriptsConfig 消息脚本配置
+type ScriptsConfig struct {
+	Dir     string        `yaml:"dir"`     // 脚本目录
+	Timeout time.Duration `yaml:"timeout"` // 单次调用超时
+}
+
+// Room 自动监听的房间
+type Room struct {
+	Platform string `yaml:"platform"`         // 平台
+	RoomID   string `yaml:"rid"`              // 房间 ID
+	Cookie   string `yaml:"cookie,omitempty"` // 登录 cookie (可选)
+	Debug    bool   `yaml:"debug,omitempty"`  // 是否开启上游帧抓包
+}
+
+// Key 房间唯一标识，与服务管理器中的 key 保持一致
+func (r Room) Key() string {
+	return fmt.Sprintf("%s_%s", r.Platform, r.RoomID)
+}
+
+// Load 读取并解析配置文件
+func Load(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("读取配置文件失败: %w", err)
+	}
+
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("解析配置文件失败: %w", err)
+	}
+
+	for i := range cfg.Rooms {
+		cfg.Rooms[i].Platform = strings.ToLower(strings.TrimSpace(cfg.Rooms[i].Platform))
+		cfg.Rooms[i].RoomID = strings.TrimSpace(cfg.Rooms[i].RoomID)
+		if cfg.Rooms[i].Platform == "" || cfg.Rooms[i].RoomID == "" {
+			return nil, fmt.Errorf("第 %d 个房间缺少 platform 或 rid", i+1)
+		}
+	}
+
+	return &cfg, nil
+}
+
+// WatchReload 收到 SIGHUP 时重新读取配置文件并回调，解析失败时保留旧配置
+func WatchReload(path string, onReload func(*Config)) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, syscall.SIGHUP)
+
+	go func() {
+		for range sigChan {
+			cfg, err := Load(path)
+			if err != nil {
+				log.Printf("ERROR", "重新加载配置失败: %v", err)
+				continue
+			}
+			log.Printf("INFO", "已重新加载配置: %s", path)
+			onReload(cfg)
+		}
+	}()
+}
@@ -0,0 +1,19 @@
+package cors
+
+import (
+	"net/http"
+
+	chicors "github.com/go-chi/cors"
+)
+
+// Handler 返回跨域中间件，API、图片代理等 HTTP 服务共用同一套跨域策略
+func Handler(allowedOrigins []string) func(http.Handler) http.Handler {
+	return chicors.Handler(chicors.Options{
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"GET", "POST", "PUT", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Accept", "Authorization", "Content-Type", "X-CSRF-Token"},
+		ExposedHeaders:   []string{"Link"},
+		AllowCredentials: true,
+		MaxAge:           300, // 缓存预检请求的结果的最大时间（秒）
+	})
+}
@@ -0,0 +1,126 @@
+package metrics
+
+import (
+	uni "UniBarrage/universal"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"net/http"
+	"time"
+)
+
+const namespace = "unibarrage"
+
+var (
+	// MessagesReceived 收到的消息数，按平台、房间和消息类型统计
+	MessagesReceived = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_received_total",
+		Help:      "Messages received from upstream by platform, room and type.",
+	}, []string{"platform", "rid", "type"})
+
+	// MessagesBroadcast 成功发送给客户端的消息数，按客户端的订阅范围 (all / group / 平台名) 汇总
+	MessagesBroadcast = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_broadcast_total",
+		Help:      "Messages written to WebSocket clients by subscription scope.",
+	}, []string{"scope"})
+
+	// MessagesDropped 未能发送给客户端的消息数，按客户端的订阅范围汇总
+	MessagesDropped = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "messages_dropped_total",
+		Help:      "Messages that could not be delivered to WebSocket clients by subscription scope.",
+	}, []string{"scope"})
+
+	// WebSocketConnections 当前 WebSocket 连接数
+	WebSocketConnections = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "websocket_connections",
+		Help:      "Active WebSocket client connections.",
+	})
+
+	// UpstreamReconnects 上游连接重连次数
+	UpstreamReconnects = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "upstream_reconnects_total",
+		Help:      "Reconnect attempts to upstream live servers.",
+	}, []string{"platform", "rid"})
+
+	// ProxyCacheHits 图片代理缓存命中次数，cache 为 lru 或 badger
+	ProxyCacheHits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_cache_hits_total",
+		Help:      "Image proxy cache hits by cache layer.",
+	}, []string{"cache"})
+
+	// ProxyCacheMisses 图片代理缓存未命中次数，cache 为 lru 或 badger
+	ProxyCacheMisses = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "proxy_cache_misses_total",
+		Help:      "Image proxy cache misses by cache layer.",
+	}, []string{"cache"})
+
+	// ProxyDownloadDuration 图片代理下载耗时
+	ProxyDownloadDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "proxy_download_duration_seconds",
+		Help:      "Time spent downloading images from origin.",
+		Buckets:   prometheus.DefBuckets,
+	})
+
+	// NodeSidecarExits Node.js 子进程意外退出次数，退出后房间停止
+	NodeSidecarExits = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "node_sidecar_exits_total",
+		Help:      "Unexpected exits of Node.js sidecar processes.",
+	}, []string{"platform", "rid"})
+
+	// SignatureDuration 抖音 goja 签名耗时
+	SignatureDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "goja_signature_duration_seconds",
+		Help:      "Time spent generating Douyin signatures in goja.",
+		Buckets:   []float64{.001, .005, .01, .025, .05, .1, .25, .5, 1},
+	})
+)
+
+func init() {
+	prometheus.MustRegister(
+		MessagesReceived,
+		MessagesBroadcast,
+		MessagesDropped,
+		WebSocketConnections,
+		UpstreamReconnects,
+		ProxyCacheHits,
+		ProxyCacheMisses,
+		ProxyDownloadDuration,
+		NodeSidecarExits,
+		SignatureDuration,
+	)
+}
+
+// Handler 返回 /metrics 处理器
+func Handler() http.Handler {
+	return promhttp.Handler()
+}
+
+// Since 记录自 start 起的耗时到直方图
+func Since(h prometheus.Observer, start time.Time) {
+	h.Observe(time.Since(start).Seconds())
+}
+
+// ObserveMessage 统计发布到消息总线的消息，作为总线的订阅者使用：被处理链过滤的消息不计入，命令和脚本产生的消息计入；定期推送的统计消息不计入
+func ObserveMessage(msg *uni.UniMessage) {
+	if msg.Type == uni.StatsMessageType {
+		return
+	}
+	MessagesReceived.WithLabelValues(string(msg.Platform), msg.RID, string(msg.Type)).Inc()
+}
+
+// RemoveRoom 房间停止后删除其标签，避免指标随房间启停无限增长
+func RemoveRoom(platform uni.Platform, rid string) {
+	labels := prometheus.Labels{"platform": string(platform), "rid": rid}
+	MessagesReceived.DeletePartialMatch(labels)
+	UpstreamReconnects.Delete(labels)
+	NodeSidecarExits.Delete(labels)
+}
@@ -15,6 +15,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"strings"
+	"sync/atomic"
 )
 
 type NodeRelease struct {
@@ -65,6 +66,21 @@ func EnsureNodeInstalled(destinationDir string) string {
 	return ""
 }
 
+// 最近一次找到的 node 可执行文件路径
+var resolvedPath atomic.Value
+
+// Available 判断 Node.js 是否可用（不会触发安装），找到后缓存路径
+func Available() bool {
+	if path, ok := resolvedPath.Load().(string); ok && path != "" {
+		if _, err := os.Stat(path); err == nil {
+			return true
+		}
+	}
+	path := findNodePath(os.TempDir())
+	resolvedPath.Store(path)
+	return path != ""
+}
+
 // 查找 Node.js 的可执行文件路径
 func findNodePath(path string) string {
 	// 检查全局安装的 Node.js
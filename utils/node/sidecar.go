@@ -0,0 +1,42 @@
+package node
+
+import (
+	"UniBarrage/utils/metrics"
+	"UniBarrage/utils/ports"
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"runtime"
+)
+
+// ErrSidecarExited Node.js 子进程在 ctx 取消前退出
+var ErrSidecarExited = errors.New("Node.js 进程意外退出")
+
+// RunSidecar 在新分配的端口上运行 Node.js 子进程并调用 onStart，直到进程退出；
+// ctx 取消时返回 nil，进程自行退出时记录指标并返回 ErrSidecarExited
+func RunSidecar(ctx context.Context, platform string, rid string, nodePath string, args func(port int) []string, onStart func(port int)) error {
+	port, err := ports.GetAvailablePort()
+	if err != nil {
+		return fmt.Errorf("获取可用端口失败: %w", err)
+	}
+
+	var cmd *exec.Cmd
+	if runtime.GOOS == "windows" {
+		cmd = exec.CommandContext(ctx, "cmd", append([]string{"/C", nodePath}, args(port)...)...)
+	} else {
+		cmd = exec.CommandContext(ctx, nodePath, args(port)...)
+	}
+
+	if err := cmd.Start(); err != nil {
+		return fmt.Errorf("启动 Node.js 进程失败: %w", err)
+	}
+	onStart(port)
+
+	_ = cmd.Wait()
+	if ctx.Err() != nil {
+		return nil
+	}
+	metrics.NodeSidecarExits.WithLabelValues(platform, rid).Inc()
+	return ErrSidecarExited
+}
@@ -0,0 +1,106 @@
+package rotate
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// File 按大小滚动的文件写入器，超过 MaxSize 后将当前文件重命名为 .1、.2 ... 并新建文件
+type File struct {
+	Path       string // 文件路径
+	MaxSize    int64  // 单个文件最大字节数
+	MaxBackups int    // 保留的历史文件数量
+
+	mu   sync.Mutex
+	file *os.File
+	size int64
+}
+
+// NewFile 创建滚动文件写入器
+func NewFile(path string, maxSize int64, maxBackups int) *File {
+	return &File{
+		Path:       path,
+		MaxSize:    maxSize,
+		MaxBackups: maxBackups,
+	}
+}
+
+// Write 实现 io.Writer，写入前按需滚动文件
+func (f *File) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+
+	if f.MaxSize > 0 && f.size+int64(len(p)) > f.MaxSize && f.size > 0 {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := f.file.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// Close 关闭当前文件
+func (f *File) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.file == nil {
+		return nil
+	}
+	err := f.file.Close()
+	f.file = nil
+	f.size = 0
+	return err
+}
+
+// 打开（或追加）当前文件
+func (f *File) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.Path), 0755); err != nil {
+		return fmt.Errorf("创建目录失败: %w", err)
+	}
+
+	file, err := os.OpenFile(f.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("打开文件失败: %w", err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return err
+	}
+
+	f.file = file
+	f.size = info.Size()
+	return nil
+}
+
+// 滚动文件：path.(n-1) -> path.n，path -> path.1
+func (f *File) rotate() error {
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	f.file = nil
+
+	if f.MaxBackups > 0 {
+		_ = os.Remove(fmt.Sprintf("%s.%d", f.Path, f.MaxBackups))
+		for i := f.MaxBackups - 1; i >= 1; i-- {
+			_ = os.Rename(fmt.Sprintf("%s.%d", f.Path, i), fmt.Sprintf("%s.%d", f.Path, i+1))
+		}
+		_ = os.Rename(f.Path, f.Path+".1")
+	} else {
+		_ = os.Remove(f.Path)
+	}
+
+	return f.open()
+}
@@ -0,0 +1,308 @@
+// Package stats 按房间统计弹幕、礼物、点赞、进场和在线人数，统计在房间开始监听时创建、停止时清除
+package stats
+
+import (
+	uni "UniBarrage/universal"
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	topGifters = 10 // 统计中保留的送礼用户排行数量
+	chatWindow = 60 // 计算每分钟弹幕数的窗口（秒）
+)
+
+// 单个房间的统计
+type room struct {
+	platform       uni.Platform
+	rid            string
+	mu             sync.Mutex
+	since          time.Time
+	chats          int64
+	buckets        [chatWindow]int64   // 每秒的弹幕数，按 unix 秒取模
+	bucketSecs     [chatWindow]int64   // 各桶对应的 unix 秒
+	chatters       map[string]struct{} // 发过弹幕的用户，键为 uni.UserKey
+	gifts          map[string]uni.StatsGift
+	gifters        map[string]uni.StatsGifter // 键为 uni.UserKey
+	giftValue      float64
+	superChats     int64
+	superChatValue float64
+	likes          int64
+	enters         int64
+	viewers        int64
+	peakViewers    int64
+	hasViewers     bool
+}
+
+// Store 按平台和房间 ID 保存房间统计，每个引擎持有一个
+type Store struct {
+	mu    sync.RWMutex
+	rooms map[string]*room
+}
+
+// NewStore 创建房间统计
+func NewStore() *Store {
+	return &Store{rooms: make(map[string]*room)}
+}
+
+// 生成房间唯一标识
+func key(platform uni.Platform, rid string) string {
+	return fmt.Sprintf("%s_%s", platform, rid)
+}
+
+// Track 开始统计房间，已有的统计会被重置
+func (st *Store) Track(platform uni.Platform, rid string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.rooms[key(platform, rid)] = &room{
+		platform: platform,
+		rid:      rid,
+		since:    time.Now(),
+		chatters: make(map[string]struct{}),
+		gifts:    make(map[string]uni.StatsGift),
+		gifters:  make(map[string]uni.StatsGifter),
+	}
+}
+
+// Remove 房间停止时清除统计
+func (st *Store) Remove(platform uni.Platform, rid string) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	delete(st.rooms, key(platform, rid))
+}
+
+func (st *Store) getRoom(platform uni.Platform, rid string) *room {
+	st.mu.RLock()
+	defer st.mu.RUnlock()
+	return st.rooms[key(platform, rid)]
+}
+
+// Observe 统计一条上游消息，未开始统计的房间忽略
+func (st *Store) Observe(msg *uni.UniMessage) {
+	if msg == nil {
+		return
+	}
+	if r := st.getRoom(msg.Platform, msg.RID); r != nil {
+		r.observe(msg, time.Now())
+	}
+}
+
+// Viewers 记录平台推送的在线人数，由提供在线人数的平台调用
+func (st *Store) Viewers(platform uni.Platform, rid string, count int64) {
+	r := st.getRoom(platform, rid)
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.viewers = count
+	r.hasViewers = true
+	if count > r.peakViewers {
+		r.peakViewers = count
+	}
+}
+
+// Get 获取房间统计，未开始统计时返回 false
+func (st *Store) Get(platform uni.Platform, rid string) (*uni.StatsMessage, bool) {
+	r := st.getRoom(platform, rid)
+	if r == nil {
+		return nil, false
+	}
+	return r.snapshot(time.Now()), true
+}
+
+// Run 每隔 interval 将所有房间的统计作为 Stats 消息发布，直到 ctx 取消；
+// after 在每轮发布完成后调用，可为 nil
+func (st *Store) Run(ctx context.Context, interval time.Duration, pub uni.Publisher, after func()) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			st.Publish(pub)
+			if after != nil {
+				after()
+			}
+		}
+	}
+}
+
+// Publish 将所有房间的统计作为 Stats 消息发布一次
+func (st *Store) Publish(pub uni.Publisher) {
+	st.mu.RLock()
+	list := make([]*room, 0, len(st.rooms))
+	for _, r := range st.rooms {
+		list = append(list, r)
+	}
+	st.mu.RUnlock()
+
+	now := time.Now()
+	for _, r := range list {
+		msg, err := uni.CreateUniMessage(r.rid, r.platform, uni.StatsMessageType, r.snapshot(now))
+		if err != nil {
+			continue
+		}
+		pub.Publish(msg)
+	}
+}
+
+// Merge 合并多个房间的统计，用于房间组；去重用户数和在线人数峰值按各房间相加，为近似值
+func Merge(list ...*uni.StatsMessage) *uni.StatsMessage {
+	s := &uni.StatsMessage{
+		Gifts:   make(map[string]uni.StatsGift),
+		Gifters: make(map[string]uni.StatsGifter),
+	}
+	for _, m := range list {
+		if s.Since.IsZero() || m.Since.Before(s.Since) {
+			s.Since = m.Since
+		}
+		s.Chats += m.Chats
+		s.ChatsPerMinute += m.ChatsPerMinute
+		s.UniqueChatters += m.UniqueChatters
+		s.GiftValue += m.GiftValue
+		for name, gift := range m.Gifts {
+			merged := s.Gifts[name]
+			merged.Count += gift.Count
+			merged.Value += gift.Value
+			s.Gifts[name] = merged
+		}
+		for user, gifter := range m.Gifters {
+			merged := s.Gifters[user]
+			merged.User, merged.Name = user, gifter.Name
+			merged.Value += gifter.Value
+			s.Gifters[user] = merged
+		}
+		s.SuperChats += m.SuperChats
+		s.SuperChatValue += m.SuperChatValue
+		s.Likes += m.Likes
+		s.Enters += m.Enters
+		if m.Viewers != nil {
+			viewers := *m.Viewers
+			if s.Viewers != nil {
+				viewers += *s.Viewers
+			}
+			s.Viewers = &viewers
+		}
+		if m.PeakViewers != nil {
+			peak := *m.PeakViewers
+			if s.PeakViewers != nil {
+				peak += *s.PeakViewers
+			}
+			s.PeakViewers = &peak
+		}
+	}
+	s.TopGifters = rank(s.Gifters)
+	return s
+}
+
+// 按价值降序排列送礼用户，保留前 topGifters 名
+func rank(gifters map[string]uni.StatsGifter) []uni.StatsGifter {
+	top := make([]uni.StatsGifter, 0, len(gifters))
+	for _, gifter := range gifters {
+		top = append(top, gifter)
+	}
+	sort.Slice(top, func(i, j int) bool {
+		if top[i].Value != top[j].Value {
+			return top[i].Value > top[j].Value
+		}
+		return top[i].User < top[j].User
+	})
+	if len(top) > topGifters {
+		top = top[:topGifters]
+	}
+	return top
+}
+
+func (r *room) observe(msg *uni.UniMessage, now time.Time) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	switch data := msg.Data.(type) {
+	case *uni.ChatMessage:
+		r.chats++
+		sec := now.Unix()
+		i := sec % chatWindow
+		if r.bucketSecs[i] != sec {
+			r.bucketSecs[i] = sec
+			r.buckets[i] = 0
+		}
+		r.buckets[i]++
+		if data.UID != "" || data.Name != "" {
+			r.chatters[uni.UserKey(msg.Platform, data.UID, data.Name)] = struct{}{}
+		}
+	case *uni.GiftMessage:
+		gift := r.gifts[data.Item]
+		gift.Count += int64(data.Num)
+		gift.Value += data.Price
+		r.gifts[data.Item] = gift
+		r.giftValue += data.Price
+		r.addGifter(msg.Platform, data.UID, data.Name, data.Price)
+	case *uni.SuperChatMessage:
+		r.superChats++
+		r.superChatValue += data.Price
+		r.giftValue += data.Price
+		r.addGifter(msg.Platform, data.UID, data.Name, data.Price)
+	case *uni.LikeMessage:
+		if data.Count > 0 {
+			r.likes += int64(data.Count)
+		} else {
+			r.likes++
+		}
+	case *uni.EnterRoomMessage:
+		r.enters++
+	}
+}
+
+// 按平台用户 ID 累计送礼价值，名称取最近一次的名称；需持有 r.mu
+func (r *room) addGifter(platform uni.Platform, uid string, name string, value float64) {
+	user := uni.UserKey(platform, uid, name)
+	gifter := r.gifters[user]
+	gifter.User = user
+	if name != "" {
+		gifter.Name = name
+	}
+	gifter.Value += value
+	r.gifters[user] = gifter
+}
+
+func (r *room) snapshot(now time.Time) *uni.StatsMessage {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	s := &uni.StatsMessage{
+		Since:          r.since,
+		Chats:          r.chats,
+		UniqueChatters: len(r.chatters),
+		GiftValue:      r.giftValue,
+		Gifts:          make(map[string]uni.StatsGift, len(r.gifts)),
+		Gifters:        make(map[string]uni.StatsGifter, len(r.gifters)),
+		SuperChats:     r.superChats,
+		SuperChatValue: r.superChatValue,
+		Likes:          r.likes,
+		Enters:         r.enters,
+	}
+
+	sec := now.Unix()
+	for i := range r.buckets {
+		if sec-r.bucketSecs[i] < chatWindow {
+			s.ChatsPerMinute += r.buckets[i]
+		}
+	}
+	for name, gift := range r.gifts {
+		s.Gifts[name] = gift
+	}
+	for user, gifter := range r.gifters {
+		s.Gifters[user] = gifter
+	}
+	s.TopGifters = rank(r.gifters)
+	if r.hasViewers {
+		viewers, peak := r.viewers, r.peakViewers
+		s.Viewers, s.PeakViewers = &viewers, &peak
+	}
+	return s
+}
@@ -0,0 +1,121 @@
+package stats
+
+import (
+	uni "UniBarrage/universal"
+	"testing"
+	"time"
+)
+
+func message(msgType uni.MessageType, data uni.MessageData) *uni.UniMessage {
+	msg, _ := uni.CreateUniMessage("1", uni.BiliBili, msgType, data)
+	return msg
+}
+
+func TestAggregates(t *testing.T) {
+	st := NewStore()
+	st.Track(uni.BiliBili, "1")
+	defer st.Remove(uni.BiliBili, "1")
+
+	for _, msg := range []*uni.UniMessage{
+		message(uni.ChatMessageType, &uni.ChatMessage{Name: "a", UID: "1", Content: "hi"}),
+		message(uni.ChatMessageType, &uni.ChatMessage{Name: "a2", UID: "1", Content: "renamed"}),
+		message(uni.ChatMessageType, &uni.ChatMessage{Name: "a", UID: "3", Content: "same name"}),
+		message(uni.GiftMessageType, &uni.GiftMessage{Name: "a", UID: "1", Item: "rose", Num: 2, Price: 2}),
+		message(uni.GiftMessageType, &uni.GiftMessage{Name: "b", UID: "2", Item: "rocket", Num: 1, Price: 50}),
+		message(uni.SuperChatMessageType, &uni.SuperChatMessage{Name: "a2", UID: "1", Content: "sc", Price: 30}),
+		// 未提供用户 ID 时按名称区分
+		message(uni.ChatMessageType, &uni.ChatMessage{Name: "c", Content: "no uid"}),
+		message(uni.LikeMessageType, &uni.LikeMessage{Name: "c", Count: 5}),
+		message(uni.LikeMessageType, &uni.LikeMessage{Name: "c"}),
+		message(uni.EnterRoomMessageType, &uni.EnterRoomMessage{Name: "d"}),
+	} {
+		st.Observe(msg)
+	}
+	st.Observe(&uni.UniMessage{RID: "2", Platform: uni.BiliBili, Type: uni.ChatMessageType, Data: &uni.ChatMessage{Name: "x"}})
+
+	s, ok := st.Get(uni.BiliBili, "1")
+	if !ok {
+		t.Fatal("room not tracked")
+	}
+	// 同一用户改名后仍计为一人，同名的不同用户分别计数
+	if s.Chats != 4 || s.ChatsPerMinute != 4 || s.UniqueChatters != 3 {
+		t.Fatalf("chat stats: %+v", s)
+	}
+	if a := s.Gifters["bilibili:1"]; s.GiftValue != 82 || s.Gifts["rose"].Count != 2 || a.Value != 32 || a.Name != "a2" || s.SuperChatValue != 30 {
+		t.Fatalf("gift stats: %+v", s)
+	}
+	if len(s.TopGifters) != 2 || s.TopGifters[0].Name != "b" || s.TopGifters[0].User != "bilibili:2" {
+		t.Fatalf("top gifters: %+v", s.TopGifters)
+	}
+	if s.Likes != 6 || s.Enters != 1 || s.Viewers != nil {
+		t.Fatalf("likes=%d enters=%d viewers=%v", s.Likes, s.Enters, s.Viewers)
+	}
+
+	st.Viewers(uni.BiliBili, "1", 100)
+	st.Viewers(uni.BiliBili, "1", 40)
+	if s, _ = st.Get(uni.BiliBili, "1"); *s.Viewers != 40 || *s.PeakViewers != 100 {
+		t.Fatalf("viewers=%d peak=%d", *s.Viewers, *s.PeakViewers)
+	}
+
+	var frames []*uni.UniMessage
+	st.Publish(uni.PublisherFunc(func(msg *uni.UniMessage) { frames = append(frames, msg) }))
+	if len(frames) != 1 || frames[0].Type != uni.StatsMessageType || frames[0].RID != "1" {
+		t.Fatalf("frames=%+v", frames)
+	}
+	if _, ok := st.Get(uni.BiliBili, "2"); ok {
+		t.Fatal("untracked room should have no stats")
+	}
+}
+
+func TestChatsPerMinuteWindow(t *testing.T) {
+	r := &room{chatters: make(map[string]struct{})}
+	now := time.Unix(1000, 0)
+	chat := message(uni.ChatMessageType, &uni.ChatMessage{Name: "a"})
+	r.observe(chat, now)
+	r.observe(chat, now.Add(40*time.Second))
+	r.observe(chat, now.Add(90*time.Second))
+
+	if got := r.snapshot(now.Add(90 * time.Second)).ChatsPerMinute; got != 2 {
+		t.Fatalf("chatsPerMinute=%d, want 2", got)
+	}
+	if got := r.snapshot(now.Add(200 * time.Second)).ChatsPerMinute; got != 0 {
+		t.Fatalf("chatsPerMinute=%d, want 0", got)
+	}
+}
+
+func TestMerge(t *testing.T) {
+	early, late := time.Unix(100, 0), time.Unix(200, 0)
+	viewers, peak := int64(5), int64(8)
+	merged := Merge(
+		&uni.StatsMessage{
+			Since: late, Chats: 2, GiftValue: 10, SuperChats: 1, SuperChatValue: 10,
+			Gifts:   map[string]uni.StatsGift{"花": {Count: 1, Value: 1}},
+			Gifters: map[string]uni.StatsGifter{"bilibili:1": {User: "bilibili:1", Name: "a", Value: 10}},
+		},
+		&uni.StatsMessage{
+			Since: early, Chats: 3, GiftValue: 4, Likes: 7,
+			Gifts: map[string]uni.StatsGift{"花": {Count: 2, Value: 2}},
+			Gifters: map[string]uni.StatsGifter{
+				"bilibili:1": {User: "bilibili:1", Name: "a", Value: 1},
+				"bilibili:2": {User: "bilibili:2", Name: "b", Value: 3},
+			},
+			Viewers: &viewers, PeakViewers: &peak,
+		},
+	)
+
+	if !merged.Since.Equal(early) || merged.Chats != 5 || merged.GiftValue != 14 || merged.SuperChatValue != 10 || merged.Likes != 7 {
+		t.Fatalf("unexpected merged stats: %+v", merged)
+	}
+	if g := merged.Gifts["花"]; g.Count != 3 || g.Value != 3 {
+		t.Errorf("gifts=%+v", merged.Gifts)
+	}
+	if len(merged.TopGifters) != 2 || merged.TopGifters[0].Name != "a" || merged.TopGifters[0].Value != 11 {
+		t.Errorf("topGifters=%+v", merged.TopGifters)
+	}
+	if merged.Viewers == nil || *merged.Viewers != 5 || *merged.PeakViewers != 8 {
+		t.Errorf("viewers=%v peak=%v", merged.Viewers, merged.PeakViewers)
+	}
+	if empty := Merge(); empty.Viewers != nil || empty.TopGifters == nil {
+		t.Errorf("empty merge: %+v", empty)
+	}
+}
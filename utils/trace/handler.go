@@ -0,0 +1,371 @@
+package trace
+
+import (
+	"UniBarrage/utils/rotate"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// 输出格式
+const (
+	FormatTUI  = "tui"  // 全屏终端界面
+	FormatText = "text" // 纯文本，每条一行
+	FormatJSON = "json" // JSON，每条一行
+)
+
+// Options 日志初始化选项
+type Options struct {
+	Level          int                   // 日志等级 (0: 默认, 1: 简洁, 2: 静默)
+	Format         string                // 输出格式，为空时等级 0 使用 tui，其余使用 text
+	File           string                // 日志文件路径 (JSON 格式)，为空时不写文件
+	MaxSize        int64                 // 单个日志文件最大字节数
+	MaxBackups     int                   // 保留的历史日志文件数量
+	PlatformLevels map[string]slog.Level // 按平台覆盖最低日志等级
+}
+
+// 平台标签：Print 的 level 为平台名时按 INFO 输出，并附带 platform 字段
+var platformTags = map[string]bool{
+	"DOUYIN":      true,
+	"BILIBILI":    true,
+	"KUAISHOU":    true,
+	"HUYA":        true,
+	"DOUYU":       true,
+	"XIAOHONGSHU": true,
+}
+
+var (
+	logger  = slog.New(newFilterHandler(newConsoleHandler(FormatText), slog.LevelInfo, nil))
+	logFile *rotate.File // 日志文件，未开启时为 nil
+	tui     bool         // 是否使用全屏终端界面
+)
+
+// Init 按选项初始化日志输出，同时接管 slog 与标准库 log 的默认输出
+func Init(opts Options) error {
+	format := strings.ToLower(opts.Format)
+	if format == "" {
+		format = FormatText
+		if opts.Level == 0 {
+			format = FormatTUI
+		}
+	}
+
+	var console slog.Handler
+	switch format {
+	case FormatTUI, FormatText:
+		console = newConsoleHandler(format)
+	case FormatJSON:
+		console = slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{Level: slog.LevelDebug})
+	default:
+		return fmt.Errorf("不支持的日志格式: %s", opts.Format)
+	}
+
+	level := slog.LevelInfo
+	if opts.Level >= 2 {
+		level = slog.LevelWarn
+	}
+
+	handlers := []slog.Handler{console}
+	if opts.File != "" {
+		logFile = rotate.NewFile(opts.File, opts.MaxSize, opts.MaxBackups)
+		handlers = append(handlers, slog.NewJSONHandler(logFile, &slog.HandlerOptions{Level: slog.LevelDebug}))
+	}
+
+	var handler slog.Handler = handlers[0]
+	if len(handlers) > 1 {
+		handler = multiHandler(handlers)
+	}
+
+	logger = slog.New(newFilterHandler(handler, level, opts.PlatformLevels))
+	slog.SetDefault(logger)
+
+	if format == FormatTUI {
+		tui = true
+		printHeader()
+	}
+	return nil
+}
+
+// Close 关闭日志文件
+func Close() error {
+	if logFile == nil {
+		return nil
+	}
+	return logFile.Close()
+}
+
+// Logger 返回当前的结构化日志实例
+func Logger() *slog.Logger {
+	return logger
+}
+
+// Log 输出结构化日志，level 与 Print 相同，可附带 Platform、RID、Event、Err 等字段
+func Log(level string, msg string, attrs ...slog.Attr) {
+	LogTo(nil, level, msg, attrs...)
+}
+
+// RoomLogger 输出单个房间的日志，level 与 Log 相同；嵌入的引擎为每个房间的输出提供独立的实现
+type RoomLogger interface {
+	Log(level string, msg string, attrs ...slog.Attr)
+}
+
+type globalLogger struct{}
+
+// Log 输出到全局日志
+func (globalLogger) Log(level string, msg string, attrs ...slog.Attr) {
+	Log(level, msg, attrs...)
+}
+
+// For 返回 v 实现的 RoomLogger，未实现时返回全局日志
+func For(v any) RoomLogger {
+	if l, ok := v.(RoomLogger); ok {
+		return l
+	}
+	return globalLogger{}
+}
+
+// LogTo 与 Log 相同，但输出到指定的日志实例，用于嵌入的引擎使用独立的日志；l 为空时使用当前的全局日志
+func LogTo(l *slog.Logger, level string, msg string, attrs ...slog.Attr) {
+	if l == nil {
+		l = logger
+	}
+	level = strings.ToUpper(level)
+
+	var lvl slog.Level
+	switch level {
+	case "DEBUG":
+		lvl = slog.LevelDebug
+	case "WARN":
+		lvl = slog.LevelWarn
+	case "ERROR":
+		lvl = slog.LevelError
+	default:
+		lvl = slog.LevelInfo
+		if platformTags[level] {
+			attrs = append([]slog.Attr{Platform(level)}, attrs...)
+		}
+	}
+
+	l.LogAttrs(context.Background(), lvl, msg, attrs...)
+}
+
+// Platform 平台字段
+func Platform(platform string) slog.Attr {
+	return slog.String("platform", strings.ToLower(platform))
+}
+
+// RID 房间 ID 字段
+func RID(rid string) slog.Attr {
+	return slog.String("rid", rid)
+}
+
+// Event 事件名字段
+func Event(event string) slog.Attr {
+	return slog.String("event", event)
+}
+
+// Err 错误字段
+func Err(err error) slog.Attr {
+	if err == nil {
+		return slog.Attr{}
+	}
+	return slog.String("error", err.Error())
+}
+
+// ParseLevel 解析日志等级名称 (debug / info / warn / error)
+func ParseLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(strings.TrimSpace(s))); err != nil {
+		return 0, fmt.Errorf("无效的日志等级: %s", s)
+	}
+	return level, nil
+}
+
+// ParsePlatformLevels 解析按平台覆盖的日志等级，格式为 douyin=debug,bilibili=warn
+func ParsePlatformLevels(s string) (map[string]slog.Level, error) {
+	levels := make(map[string]slog.Level)
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item == "" {
+			continue
+		}
+		platform, name, ok := strings.Cut(item, "=")
+		if !ok {
+			return nil, fmt.Errorf("无效的平台日志等级: %s", item)
+		}
+		level, err := ParseLevel(name)
+		if err != nil {
+			return nil, err
+		}
+		levels[strings.ToLower(strings.TrimSpace(platform))] = level
+	}
+	return levels, nil
+}
+
+// filterHandler 按最低等级过滤日志，带 platform 字段的日志优先使用该平台的等级
+type filterHandler struct {
+	next      slog.Handler
+	level     slog.Level
+	platforms map[string]slog.Level
+	platform  string // 通过 WithAttrs 预设的平台
+}
+
+func newFilterHandler(next slog.Handler, level slog.Level, platforms map[string]slog.Level) *filterHandler {
+	return &filterHandler{next: next, level: level, platforms: platforms}
+}
+
+// Enabled 只要任一平台可能输出即放行，具体等级在 Handle 中判断
+func (h *filterHandler) Enabled(_ context.Context, level slog.Level) bool {
+	min := h.level
+	for _, l := range h.platforms {
+		if l < min {
+			min = l
+		}
+	}
+	return level >= min
+}
+
+func (h *filterHandler) Handle(ctx context.Context, r slog.Record) error {
+	platform := h.platform
+	r.Attrs(func(a slog.Attr) bool {
+		if a.Key == "platform" {
+			platform = a.Value.String()
+			return false
+		}
+		return true
+	})
+
+	min := h.level
+	if l, ok := h.platforms[platform]; ok {
+		min = l
+	}
+	if r.Level < min {
+		return nil
+	}
+	return h.next.Handle(ctx, r)
+}
+
+func (h *filterHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.next = h.next.WithAttrs(attrs)
+	for _, a := range attrs {
+		if a.Key == "platform" {
+			next.platform = a.Value.String()
+		}
+	}
+	return &next
+}
+
+func (h *filterHandler) WithGroup(name string) slog.Handler {
+	next := *h
+	next.next = h.next.WithGroup(name)
+	return &next
+}
+
+// multiHandler 将日志同时写入多个输出
+type multiHandler []slog.Handler
+
+func (m multiHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, h := range m {
+		if h.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (m multiHandler) Handle(ctx context.Context, r slog.Record) error {
+	var errs []error
+	for _, h := range m {
+		if h.Enabled(ctx, r.Level) {
+			errs = append(errs, h.Handle(ctx, r.Clone()))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func (m multiHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := make(multiHandler, len(m))
+	for i, h := range m {
+		next[i] = h.WithAttrs(attrs)
+	}
+	return next
+}
+
+func (m multiHandler) WithGroup(name string) slog.Handler {
+	next := make(multiHandler, len(m))
+	for i, h := range m {
+		next[i] = h.WithGroup(name)
+	}
+	return next
+}
+
+// consoleHandler 终端输出：tui 为全屏界面，text 为每条一行的纯文本
+type consoleHandler struct {
+	mu     *sync.Mutex
+	format string
+	out    io.Writer
+	attrs  []slog.Attr
+}
+
+func newConsoleHandler(format string) *consoleHandler {
+	return &consoleHandler{mu: &sync.Mutex{}, format: format, out: os.Stdout}
+}
+
+func (h *consoleHandler) Enabled(context.Context, slog.Level) bool {
+	return true
+}
+
+func (h *consoleHandler) Handle(_ context.Context, r slog.Record) error {
+	label := r.Level.String()
+	var fields strings.Builder
+
+	appendAttr := func(a slog.Attr) bool {
+		if a.Equal(slog.Attr{}) {
+			return true
+		}
+		// INFO 级别的平台日志沿用平台标签显示
+		if a.Key == "platform" && r.Level == slog.LevelInfo {
+			label = strings.ToUpper(a.Value.String())
+			return true
+		}
+		fmt.Fprintf(&fields, " %s=%v", a.Key, a.Value)
+		return true
+	}
+	for _, a := range h.attrs {
+		appendAttr(a)
+	}
+	r.Attrs(appendAttr)
+
+	msg := r.Message + fields.String()
+
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if h.format == FormatTUI {
+		timestamp := timeStyle.Render(r.Time.Format("15:04:05"))
+		title := titleStyle.Render("[ UniBarrage ]")
+		addLogToBuffer(fmt.Sprintf("%s%s%s%s", title, timestamp, renderLabel(label), messageStyle.Render(msg)))
+		refreshLogArea()
+		return nil
+	}
+
+	_, err := fmt.Fprintf(h.out, "[%s] %s %s\n", label, r.Time.Format(time.TimeOnly), msg)
+	return err
+}
+
+func (h *consoleHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	next := *h
+	next.attrs = append(append([]slog.Attr{}, h.attrs...), attrs...)
+	return &next
+}
+
+// WithGroup 终端输出不区分分组
+func (h *consoleHandler) WithGroup(string) slog.Handler {
+	return h
+}
@@ -1,20 +1,18 @@
 package trace
 
 import (
+	"context"
 	"fmt"
 	"github.com/charmbracelet/lipgloss"
 	"os"
 	"os/signal"
-	"strings"
 	"syscall"
 	"time"
 )
 
-// 日志缓冲区
+// TUI 日志缓冲区
 var logBuffer []string
 
-var logLevel = 0
-
 const maxLogLines = 20 // 固定显示高度
 
 // 样式定义：软件名和版本，日志级别、日期和时间
@@ -45,6 +43,12 @@ var (
 			Padding(0, 1).
 			Bold(true)
 
+	debugStyle = lipgloss.
+			NewStyle().
+			Foreground(lipgloss.Color("#888888")).
+			Padding(0, 1).
+			Bold(true)
+
 	errorStyle = lipgloss.
 			NewStyle().
 			Foreground(lipgloss.Color("#CC3333")).
@@ -129,120 +133,79 @@ func refreshLogArea() {
 	_, _ = fmt.Fprint(os.Stderr, "\033[999;0H") // 将光标移到屏幕外（第 999 行）
 }
 
-// Print 输出函数：根据级别美化日志并输出
-func Print(level, msg string) {
-	level = strings.ToUpper(level)
-
-	if logLevel == 0 {
-		// 使用美化输出
-		now := time.Now()
-		timestamp := timeStyle.Render(now.Format("15:04:05"))
-		title := titleStyle.Render("[ UniBarrage ]")
-		message := messageStyle.Render(msg)
-
-		var levelStyled string
-		switch level {
-		case "INFO":
-			levelStyled = infoStyle.Render(" INFO ")
-		case "WARN":
-			levelStyled = warnStyle.Render(" WARN ")
-		case "ERROR":
-			levelStyled = errorStyle.Render(" ERRO ")
-		case "DOUYIN":
-			levelStyled = douyinStyle.Render(" DOUY ")
-		case "BILIBILI":
-			levelStyled = bilibiliStyle.Render(" BILI ")
-		case "KUAISHOU":
-			levelStyled = kuaishouStyle.Render(" KUAI ")
-		case "HUYA":
-			levelStyled = huyaStyle.Render(" HUYA ")
-		case "DOUYU":
-			levelStyled = douyuStyle.Render(" DOUV ")
-		case "XIAOHONGSHU":
-			levelStyled = xiaohongshuStyle.Render(" XHS  ")
-		default:
-			levelStyled = level
-		}
-
-		finalMessage := fmt.Sprintf("%s%s%s%s", title, timestamp, levelStyled, message)
-		addLogToBuffer(finalMessage)
-		refreshLogArea()
-	} else if logLevel == 1 || (logLevel >= 2 && (level == "WARN" || level == "ERROR")) {
-		// 原始输出格式
-		fmt.Printf("[%s] %s %s\n", level, time.Now().Format("15:04:05"), msg)
+// 渲染 TUI 中的日志等级或平台标签
+func renderLabel(label string) string {
+	switch label {
+	case "DEBUG":
+		return debugStyle.Render(" DEBU ")
+	case "INFO":
+		return infoStyle.Render(" INFO ")
+	case "WARN":
+		return warnStyle.Render(" WARN ")
+	case "ERROR":
+		return errorStyle.Render(" ERRO ")
+	case "DOUYIN":
+		return douyinStyle.Render(" DOUY ")
+	case "BILIBILI":
+		return bilibiliStyle.Render(" BILI ")
+	case "KUAISHOU":
+		return kuaishouStyle.Render(" KUAI ")
+	case "HUYA":
+		return huyaStyle.Render(" HUYA ")
+	case "DOUYU":
+		return douyuStyle.Render(" DOUV ")
+	case "XIAOHONGSHU":
+		return xiaohongshuStyle.Render(" XHS  ")
+	default:
+		return label
 	}
 }
 
-// Printf 根据级别美化日志并输出，支持格式化字符串
+// Print 输出日志，level 为 INFO / WARN / ERROR 或平台标签 (DOUYIN、BILIBILI ...)
+func Print(level, msg string) {
+	Log(level, msg)
+}
+
+// Printf 输出日志，支持格式化字符串
 func Printf(level, format string, a ...interface{}) {
-	level = strings.ToUpper(level)
-
-	if logLevel == 0 {
-		// 使用美化输出
-		now := time.Now()
-		timestamp := timeStyle.Render(now.Format("15:04:05"))
-		title := titleStyle.Render("[ UniBarrage ]")
-		message := messageStyle.Render(fmt.Sprintf(format, a...))
-
-		var levelStyled string
-		switch level {
-		case "INFO":
-			levelStyled = infoStyle.Render(" INFO ")
-		case "WARN":
-			levelStyled = warnStyle.Render(" WARN ")
-		case "ERROR":
-			levelStyled = errorStyle.Render(" ERRO ")
-		case "DOUYIN":
-			levelStyled = douyinStyle.Render(" DOUY ")
-		case "BILIBILI":
-			levelStyled = bilibiliStyle.Render(" BILI ")
-		case "KUAISHOU":
-			levelStyled = kuaishouStyle.Render(" KUAI ")
-		case "HUYA":
-			levelStyled = huyaStyle.Render(" HUYA ")
-		case "DOUYU":
-			levelStyled = douyuStyle.Render(" DOUV ")
-		case "XIAOHONGSHU":
-			levelStyled = xiaohongshuStyle.Render(" XHS  ")
-		default:
-			levelStyled = level
-		}
-
-		finalMessage := fmt.Sprintf("%s%s%s%s", title, timestamp, levelStyled, message)
-		addLogToBuffer(finalMessage)
-		refreshLogArea()
-	} else if logLevel == 1 || (logLevel >= 2 && (level == "WARN" || level == "ERROR")) {
-		// 原始输出格式
-		fmt.Printf("[%s] %s ", level, time.Now().Format("15:04:05"))
-		fmt.Printf(format, a...)
-		fmt.Println()
-	}
+	Log(level, fmt.Sprintf(format, a...))
 }
 
-// HandleSignal 捕获系统信号并处理
-func HandleSignal() {
-	sigChan := make(chan os.Signal, 1)
+// HandleSignal 捕获系统信号，在 timeout 内执行 shutdown 后退出；
+// 再次收到信号或超时时立即退出
+func HandleSignal(timeout time.Duration, shutdown func(ctx context.Context)) {
+	sigChan := make(chan os.Signal, 2)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
 
 	// 等待信号
 	sig := <-sigChan
-	Printf("INFO", "收到信号: %v, 正在终止程序...", sig)
-
-	// 显示光标并终止整个程序
-	_, _ = fmt.Fprint(os.Stderr, "\033[?25h") // 显示光标
-	os.Exit(1)
-}
-
-func Init(level int) {
-	if level == 0 {
-		printHeader()
-	}
-
-	if level == 1 {
-		logLevel = 1
+	Printf("INFO", "收到信号: %v, 正在优雅退出...", sig)
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		shutdown(ctx)
+		close(done)
+	}()
+
+	code := 0
+	select {
+	case <-done:
+		Print("INFO", "已退出")
+	case <-ctx.Done():
+		Printf("WARN", "优雅退出超时 (%v), 强制终止程序", timeout)
+		code = 1
+	case sig = <-sigChan:
+		Printf("WARN", "再次收到信号: %v, 强制终止程序", sig)
+		code = 1
 	}
 
-	if level >= 2 {
-		logLevel = 2
+	// 显示光标并终止整个程序
+	_ = Close()
+	if tui {
+		_, _ = fmt.Fprint(os.Stderr, "\033[?25h") // 显示光标
 	}
+	os.Exit(code)
 }
@@ -0,0 +1,39 @@
+package xiaohongshu
+
+import (
+	"testing"
+
+	uni "UniBarrage/universal"
+)
+
+func TestEmitMessageChat(t *testing.T) {
+	var got []*uni.UniMessage
+	pub := uni.PublishOnly(uni.PublisherFunc(func(msg *uni.UniMessage) { got = append(got, msg) }))
+
+	cd := map[string]any{
+		"type":    "text",
+		"desc":    "hello",
+		"profile": map[string]any{"nickname": "alice", "avatar": "https://example.com/a.png", "user_id": "u1"},
+	}
+	emitMessage("r1", cd, roomMsg{}, pub)
+
+	if len(got) != 1 {
+		t.Fatalf("published %d messages", len(got))
+	}
+	if got[0].RID != "r1" || got[0].Type != uni.ChatMessageType {
+		t.Fatalf("rid=%q type=%q", got[0].RID, got[0].Type)
+	}
+	chat := got[0].Data.(*uni.ChatMessage)
+	if chat.Name != "alice" || chat.Content != "hello" {
+		t.Fatalf("name=%q content=%q", chat.Name, chat.Content)
+	}
+}
+
+func TestEmitMessageEmptyChatSkipped(t *testing.T) {
+	published := false
+	pub := uni.PublishOnly(uni.PublisherFunc(func(*uni.UniMessage) { published = true }))
+	emitMessage("r1", map[string]any{"type": "text"}, roomMsg{}, pub)
+	if published {
+		t.Fatal("empty chat should not be published")
+	}
+}
@@ -10,8 +10,8 @@ import (
 	"sync"
 	"time"
 
-	ws "UniBarrage/services/websockets"
 	uni "UniBarrage/universal"
+	"UniBarrage/utils/metrics"
 	log "UniBarrage/utils/trace"
 
 	"github.com/goccy/go-json"
@@ -26,10 +26,10 @@ const (
 
 // StartListen starts Xiaohongshu live danmaku for roomId (livestream id string).
 // cookie is optional (currently unused for tourist path; reserved for logged-in).
-func StartListen(roomID string, cookie string, stopChan chan struct{}) {
+func StartListen(roomID string, cookie string, stopChan chan struct{}, pub uni.Sink) {
 	roomID = strings.TrimSpace(roomID)
 	if roomID == "" {
-		log.Print("ERROR", "小红书房间 ID 为空")
+		log.For(pub).Log("ERROR", "小红书房间 ID 为空")
 		close(stopChan)
 		return
 	}
@@ -46,33 +46,34 @@ func StartListen(roomID string, cookie string, stopChan chan struct{}) {
 	startedOnce := false
 	for {
 		if ctx.Err() != nil {
-			log.Print("INFO", "已停止小红书直播监听")
+			log.For(pub).Log("INFO", "已停止小红书直播监听")
 			return
 		}
-		err := listenOnce(ctx, roomID, &startedOnce)
+		err := listenOnce(ctx, roomID, &startedOnce, pub)
 		if ctx.Err() != nil {
-			log.Print("INFO", "已停止小红书直播监听")
+			log.For(pub).Log("INFO", "已停止小红书直播监听")
 			return
 		}
 		if err != nil {
 			if pe, ok := err.(*permanentError); ok {
 				// room closed / rejected — one clean line like bilibili, no retry spam
-				log.Print("ERROR", pe.msg)
+				log.For(pub).Log("ERROR", pe.msg)
 				close(stopChan)
 				return
 			}
 			if !startedOnce {
 				// first connect failed for a transient reason — one line, then quiet retry
-				log.Printf("ERROR", "小红书直播监听启动失败: %s", shortErr(err))
+				log.For(pub).Log("ERROR", fmt.Sprintf("小红书直播监听启动失败: %s", shortErr(err)))
 			}
 			// after successful start, drop quiet reconnects (match bilibili)
 		}
 		select {
 		case <-ctx.Done():
-			log.Print("INFO", "已停止小红书直播监听")
+			log.For(pub).Log("INFO", "已停止小红书直播监听")
 			return
 		case <-time.After(backoff):
 		}
+		metrics.UpstreamReconnects.WithLabelValues(string(uni.XiaoHongShu), roomID).Inc()
 		if backoff < 30*time.Second {
 			backoff *= 2
 			if backoff > 30*time.Second {
@@ -82,7 +83,7 @@ func StartListen(roomID string, cookie string, stopChan chan struct{}) {
 	}
 }
 
-func listenOnce(ctx context.Context, roomID string, startedOnce *bool) error {
+func listenOnce(ctx context.Context, roomID string, startedOnce *bool, pub uni.Sink) error {
 	sess, err := CreateGuestSession()
 	if err != nil {
 		return fmt.Errorf("guest session: %w", err)
@@ -115,12 +116,12 @@ func listenOnce(ctx context.Context, roomID string, startedOnce *bool) error {
 		_ = conn.Close()
 	}()
 
-	if err := handshake(conn, &writeMu, sess, roomID); err != nil {
+	if err := handshake(conn, &writeMu, sess, roomID, pub); err != nil {
 		return err
 	}
 	if startedOnce != nil && !*startedOnce {
 		*startedOnce = true
-		log.Print("XIAOHONGSHU", "已启动小红书直播监听")
+		log.For(pub).Log("XIAOHONGSHU", "已启动小红书直播监听")
 	}
 
 	// heartbeat / ping
@@ -147,7 +148,7 @@ func listenOnce(ctx context.Context, roomID string, startedOnce *bool) error {
 			return fmt.Errorf("read: %w", err)
 		}
 		_ = conn.SetReadDeadline(time.Now().Add(90 * time.Second))
-		handleFrame(roomID, data)
+		handleFrame(roomID, data, pub)
 	}
 }
 
@@ -170,7 +171,7 @@ func writeJSON(conn *websocket.Conn, mu *sync.Mutex, v any) error {
 
 // readUntilAck reads frames until a signaling ack (t=2 with b.a) is seen.
 // Interleaved t=4 push frames are dispatched immediately.
-func readUntilAck(conn *websocket.Conn, roomID string, expectMid string, timeout time.Duration) (map[string]any, error) {
+func readUntilAck(conn *websocket.Conn, roomID string, expectMid string, timeout time.Duration, pub uni.Sink) (map[string]any, error) {
 	deadline := time.Now().Add(timeout)
 	for time.Now().Before(deadline) {
 		_ = conn.SetReadDeadline(deadline)
@@ -184,7 +185,7 @@ func readUntilAck(conn *websocket.Conn, roomID string, expectMid string, timeout
 		}
 		t, _ := m["t"].(float64)
 		if int(t) == 4 {
-			handleFrame(roomID, data)
+			handleFrame(roomID, data, pub)
 			continue
 		}
 		if expectMid != "" {
@@ -299,7 +300,7 @@ func ackOK(m map[string]any) bool {
 	}
 }
 
-func handshake(conn *websocket.Conn, mu *sync.Mutex, sess *GuestSession, roomID string) error {
+func handshake(conn *websocket.Conn, mu *sync.Mutex, sess *GuestSession, roomID string, pub uni.Sink) error {
 	fp := fmt.Sprintf("%d", time.Now().UnixMilli())
 	// 1 auth s=0
 	authMid := mid()
@@ -340,7 +341,7 @@ func handshake(conn *websocket.Conn, mu *sync.Mutex, sess *GuestSession, roomID
 	if err := writeJSON(conn, mu, auth); err != nil {
 		return fmt.Errorf("auth send: %w", err)
 	}
-	resp, err := readUntilAck(conn, roomID, authMid, 15*time.Second)
+	resp, err := readUntilAck(conn, roomID, authMid, 15*time.Second, pub)
 	if err != nil {
 		return fmt.Errorf("auth recv: %w", err)
 	}
@@ -363,7 +364,7 @@ func handshake(conn *websocket.Conn, mu *sync.Mutex, sess *GuestSession, roomID
 	if err := writeJSON(conn, mu, reg); err != nil {
 		return fmt.Errorf("register send: %w", err)
 	}
-	resp, err = readUntilAck(conn, roomID, regMid, 15*time.Second)
+	resp, err = readUntilAck(conn, roomID, regMid, 15*time.Second, pub)
 	if err != nil {
 		return fmt.Errorf("register recv: %w", err)
 	}
@@ -389,7 +390,7 @@ func handshake(conn *websocket.Conn, mu *sync.Mutex, sess *GuestSession, roomID
 	if err := writeJSON(conn, mu, join); err != nil {
 		return fmt.Errorf("join send: %w", err)
 	}
-	resp, err = readUntilAck(conn, roomID, joinMid, 15*time.Second)
+	resp, err = readUntilAck(conn, roomID, joinMid, 15*time.Second, pub)
 	if err != nil {
 		return fmt.Errorf("join recv: %w", err)
 	}
@@ -499,7 +500,7 @@ type roomMsg struct {
 	UUID       string          `json:"uuid"`
 }
 
-func handleFrame(roomID string, raw []byte) {
+func handleFrame(roomID string, raw []byte, pub uni.Sink) {
 	var outer rwpOuter
 	if err := json.Unmarshal(raw, &outer); err != nil {
 		return
@@ -507,6 +508,7 @@ func handleFrame(roomID string, raw []byte) {
 	if outer.T != 4 {
 		return
 	}
+	pub.Raw(raw)
 	var pb pushBody
 	if err := json.Unmarshal(outer.B, &pb); err != nil {
 		return
@@ -540,9 +542,11 @@ func handleFrame(roomID string, raw []byte) {
 			}
 		}
 		if cd == nil {
+			pub.Unhandled("roomMsg", json.RawMessage(decoded))
 			continue
 		}
-		emitMessage(roomID, cd, rm)
+		pub.Decoded(strField(cd, "type"), cd)
+		emitMessage(roomID, cd, rm, pub)
 	}
 }
 
@@ -642,11 +646,11 @@ func profileOf(cd map[string]any) (name, avatar, uid string) {
 	return
 }
 
-func emitMessage(roomID string, cd map[string]any, raw roomMsg) {
+func emitMessage(roomID string, cd map[string]any, raw roomMsg, pub uni.Sink) {
 	typ := strField(cd, "type")
 	switch typ {
 	case "text", "text_message":
-		name, avatar, _ := profileOf(cd)
+		name, avatar, uid := profileOf(cd)
 		content := strField(cd, "desc", "content", "text")
 		if content == "" {
 			return
@@ -654,11 +658,12 @@ func emitMessage(roomID string, cd map[string]any, raw roomMsg) {
 		data, _ := uni.CreateUniMessage(roomID, uni.XiaoHongShu, uni.ChatMessageType, &uni.ChatMessage{
 			Name:     name,
 			Avatar:   avatar,
+			UID:      uid,
 			Content:  content,
 			Emoticon: nil,
 			Raw:      cd,
 		})
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 
 	case "audience_join_v2", "fansgroup_join_room_effect":
 		name, avatar, _ := profileOf(cd)
@@ -673,7 +678,7 @@ func emitMessage(roomID string, cd map[string]any, raw roomMsg) {
 			Avatar: avatar,
 			Raw:    cd,
 		})
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 
 	case "praise", "like", "combo_praise", "light", "like_comment", "live_like", "live_common_msg_action":
 		// wire profile often has only user_id — resolve nickname/avatar via otherinfo
@@ -703,7 +708,7 @@ func emitMessage(roomID string, cd map[string]any, raw roomMsg) {
 			Count:  count,
 			Raw:    cd,
 		})
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 
 	case "gift_dock_and_effect", "gift_comment", "gift_settle":
 		su := nest(cd, "send_user_info")
@@ -716,7 +721,6 @@ func emitMessage(roomID string, cd map[string]any, raw roomMsg) {
 		gi := nest(cd, "base_gift_info")
 		item := strField(gi, "name")
 		icon := strField(gi, "icon")
-		coins := floatField(gi, "coins", "price")
 		num := intField(cd, "count", "num")
 		if num <= 0 {
 			num = 1
@@ -724,13 +728,14 @@ func emitMessage(roomID string, cd map[string]any, raw roomMsg) {
 		data, _ := uni.CreateUniMessage(roomID, uni.XiaoHongShu, uni.GiftMessageType, &uni.GiftMessage{
 			Name:     name,
 			Avatar:   avatar,
+			UID:      uid,
 			Item:     item,
 			Num:      num,
-			Price:    coins * float64(num),
+			Price:    0, // 礼物以薯币计价，与人民币的换算比例未知，薯币数量见 raw 中的 base_gift_info.coins
 			GiftIcon: icon,
 			Raw:      cd,
 		})
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 
 	case "follow_emcee":
 		name, avatar, _ := profileOf(cd)
@@ -742,7 +747,7 @@ func emitMessage(roomID string, cd map[string]any, raw roomMsg) {
 			Price:  0,
 			Raw:    cd,
 		})
-		ws.BroadcastToClients(data)
+		pub.Publish(data)
 
 	case "letter_refresh", "viewer_heart", "refresh", "room_func_state_change",
 		"live_banner_resource", "goods_rank_entrance_im", "linkmic_score_change",
@@ -750,7 +755,8 @@ func emitMessage(roomID string, cd map[string]any, raw roomMsg) {
 		// ignore noise
 		return
 	default:
-		// unknown — ignore quietly
+		// unknown — counted for /unhandled, frame kept only when capture is on
+		pub.Unhandled(typ, cd)
 		_ = raw
 		return
 	}